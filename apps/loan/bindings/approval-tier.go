@@ -0,0 +1,42 @@
+package bindings
+
+import (
+	"time"
+
+	"github.com/kifangamukundi/gm/loan/money"
+)
+
+type ApprovalTierRequest struct {
+	Level       int          `json:"Level" binding:"required,gt=0"`
+	Name        string       `json:"Name" binding:"required,min=3,max=100"`
+	AboveAmount money.Amount `json:"AboveAmount" binding:"gte=0"`
+	RoleID      uint         `json:"RoleID" binding:"required"`
+}
+
+type ApprovalTierResponse struct {
+	ID          uint         `json:"ID"`
+	Level       int          `json:"Level"`
+	Name        string       `json:"Name"`
+	AboveAmount money.Amount `json:"AboveAmount"`
+	RoleID      uint         `json:"RoleID"`
+	RoleName    string       `json:"RoleName"`
+	CreatedAt   time.Time    `json:"CreatedAt"`
+	UpdatedAt   time.Time    `json:"UpdatedAt"`
+}
+
+type LoanApprovalResponse struct {
+	Level             int       `json:"Level"`
+	TierName          string    `json:"TierName"`
+	ApproverFirstName string    `json:"ApproverFirstName"`
+	ApproverLastName  string    `json:"ApproverLastName"`
+	CreatedAt         time.Time `json:"CreatedAt"`
+}
+
+type LoanApprovalProgressResponse struct {
+	LoanID       uint                     `json:"LoanID"`
+	Status       string                   `json:"Status"`
+	Complete     bool                     `json:"Complete"`
+	Approvals    []LoanApprovalResponse   `json:"Approvals"`
+	PendingTiers []string                 `json:"PendingTiers"`
+	Disbursement *DisbursementJobResponse `json:"Disbursement"`
+}
@@ -0,0 +1,42 @@
+package bindings
+
+import (
+	"time"
+
+	"github.com/kifangamukundi/gm/loan/deserializers"
+	"github.com/kifangamukundi/gm/loan/money"
+)
+
+type CollateralRequest struct {
+	Type           string                       `json:"Type" binding:"required,oneof=vehicle land equipment household livestock other"`
+	Description    string                       `json:"Description" binding:"required,min=3,max=500"`
+	EstimatedValue money.Amount                 `json:"EstimatedValue" binding:"required,gt=0"`
+	SerialNumber   string                       `json:"SerialNumber" binding:"max=100"` // Serial, registration or title number
+	Images         []deserializers.DefaultImage `json:"Images"`
+}
+
+type CollateralResponse struct {
+	ID                 uint                         `json:"ID"`
+	LoanID             uint                         `json:"LoanID"`
+	Type               string                       `json:"Type"`
+	Description        string                       `json:"Description"`
+	EstimatedValue     money.Amount                 `json:"EstimatedValue"`
+	SerialNumber       string                       `json:"SerialNumber"`
+	Images             []deserializers.DefaultImage `json:"Images"`
+	LienStatus         string                       `json:"LienStatus"`
+	LienRegisteredAt   *time.Time                   `json:"LienRegisteredAt"`
+	LienReleasedAt     *time.Time                   `json:"LienReleasedAt"`
+	CreatedByFirstName string                       `json:"CreatedByFirstName"`
+	CreatedByLastName  string                       `json:"CreatedByLastName"`
+	CreatedAt          time.Time                    `json:"CreatedAt"`
+	UpdatedAt          time.Time                    `json:"UpdatedAt"`
+}
+
+type LoanCollateralResponse struct {
+	LoanID         uint                 `json:"LoanID"`
+	Amount         money.Amount         `json:"Amount"`
+	Value          money.Amount         `json:"Value"`          // Estimated value of the collateral still held
+	MaxLoanToValue float64              `json:"MaxLoanToValue"` // Product limit, 0 when no collateral is required
+	LoanToValue    float64              `json:"LoanToValue"`    // Principal as a percentage of Value
+	Collateral     []CollateralResponse `json:"Collateral"`
+}
@@ -0,0 +1,59 @@
+package bindings
+
+import (
+	"time"
+
+	"github.com/kifangamukundi/gm/loan/money"
+)
+
+type CollectionActionRequest struct {
+	Type           string       `json:"Type" binding:"required,oneof=promise_to_pay visit"`
+	Outcome        string       `json:"Outcome" binding:"required_if=Type visit,omitempty,oneof=paid promised not_home refused relocated other"`
+	InstalmentID   *uint        `json:"InstalmentID" binding:"omitempty,gt=0"`
+	PromisedAmount money.Amount `json:"PromisedAmount" binding:"omitempty,gt=0"`
+	PromisedDate   string       `json:"PromisedDate" binding:"omitempty,datetime=2006-01-02"`
+	Notes          string       `json:"Notes" binding:"omitempty,max=500"`
+}
+
+type CollectionActionResponse struct {
+	ID                  uint         `json:"ID"`
+	LoanID              uint         `json:"LoanID"`
+	InstalmentID        *uint        `json:"InstalmentID"`
+	AgentID             uint         `json:"AgentID"`
+	Type                string       `json:"Type"`
+	Outcome             string       `json:"Outcome"`
+	PromisedAmount      money.Amount `json:"PromisedAmount"`
+	PromisedDate        *time.Time   `json:"PromisedDate"`
+	Notes               string       `json:"Notes"`
+	RecordedByFirstName string       `json:"RecordedByFirstName"`
+	RecordedByLastName  string       `json:"RecordedByLastName"`
+	ContactedAt         time.Time    `json:"ContactedAt"`
+}
+
+type CollectionItemResponse struct {
+	LoanID       uint                      `json:"LoanID"`
+	InstalmentID uint                      `json:"InstalmentID"`
+	Number       int                       `json:"Number"`
+	MemberID     uint                      `json:"MemberID"`
+	FirstName    string                    `json:"FirstName"`
+	LastName     string                    `json:"LastName"`
+	MobileNumber string                    `json:"MobileNumber"`
+	DueDate      time.Time                 `json:"DueDate"`
+	AmountDue    money.Amount              `json:"AmountDue"`
+	DaysPastDue  int                       `json:"DaysPastDue"`
+	LastContact  *CollectionActionResponse `json:"LastContact"`
+}
+
+type CollectionGroupResponse struct {
+	GroupID   uint                     `json:"GroupID"`
+	GroupName string                   `json:"GroupName"`
+	AmountDue money.Amount             `json:"AmountDue"`
+	Items     []CollectionItemResponse `json:"Items"`
+}
+
+type WorklistResponse struct {
+	AgentID   uint                      `json:"AgentID"`
+	Date      time.Time                 `json:"Date"`
+	AmountDue money.Amount              `json:"AmountDue"`
+	Groups    []CollectionGroupResponse `json:"Groups"`
+}
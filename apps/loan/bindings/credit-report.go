@@ -0,0 +1,29 @@
+package bindings
+
+import (
+	"time"
+
+	"github.com/kifangamukundi/gm/loan/money"
+)
+
+type CreditListingResponse struct {
+	Lender      string       `json:"Lender"`
+	Amount      money.Amount `json:"Amount"`
+	DaysPastDue int          `json:"DaysPastDue"`
+	Status      string       `json:"Status"`
+	ListedAt    time.Time    `json:"ListedAt"`
+}
+
+type CreditReportResponse struct {
+	ID                   uint                    `json:"ID"`
+	LoanID               uint                    `json:"LoanID"`
+	MemberID             uint                    `json:"MemberID"`
+	Provider             string                  `json:"Provider"`
+	Reference            string                  `json:"Reference"`
+	ListingStatus        string                  `json:"ListingStatus"`
+	Score                int                     `json:"Score"`
+	Listings             []CreditListingResponse `json:"Listings"`
+	RetrievedAt          time.Time               `json:"RetrievedAt"`
+	RequestedByFirstName string                  `json:"RequestedByFirstName"`
+	RequestedByLastName  string                  `json:"RequestedByLastName"`
+}
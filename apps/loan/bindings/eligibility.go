@@ -0,0 +1,26 @@
+package bindings
+
+import "github.com/kifangamukundi/gm/loan/money"
+
+type CreditScoreResponse struct {
+	MemberID         uint    `json:"MemberID"`
+	Score            int     `json:"Score"`
+	OnTimeRate       float64 `json:"OnTimeRate"`
+	InstalmentsDue   int     `json:"InstalmentsDue"`
+	MaxDaysInArrears int     `json:"MaxDaysInArrears"`
+	CompletedCycles  int     `json:"CompletedCycles"`
+	OpenLoans        int     `json:"OpenLoans"`
+	LoansInArrears   int     `json:"LoansInArrears"`
+}
+
+type EligibilityResponse struct {
+	CreditScoreResponse
+	ProductID      uint         `json:"ProductID"`
+	Amount         money.Amount `json:"Amount"`
+	MaxAmount      money.Amount `json:"MaxAmount"`
+	LadderLimit    money.Amount `json:"LadderLimit"`
+	SavingsBalance money.Amount `json:"SavingsBalance"`
+	SavingsLimit   money.Amount `json:"SavingsLimit"`
+	Eligible       bool         `json:"Eligible"`
+	Reasons        []string     `json:"Reasons"`
+}
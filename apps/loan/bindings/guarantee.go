@@ -0,0 +1,42 @@
+package bindings
+
+import (
+	"time"
+
+	"github.com/kifangamukundi/gm/loan/money"
+)
+
+type AddGuaranteeRequest struct {
+	GuarantorID uint         `json:"GuarantorID" binding:"required,gt=0"` // Member pledging
+	Amount      money.Amount `json:"Amount" binding:"required,gt=0"`
+	PledgeType  string       `json:"PledgeType" binding:"omitempty,oneof=cash savings"`
+}
+
+type RecoverGuaranteeRequest struct {
+	Amount    money.Amount `json:"Amount" binding:"required,gt=0"`
+	Reference string       `json:"Reference" binding:"required,max=100"`
+}
+
+type GuaranteeRecoveryResponse struct {
+	ID          uint         `json:"ID"`
+	Amount      money.Amount `json:"Amount"`
+	PaymentID   uint         `json:"PaymentID"`
+	Reference   string       `json:"Reference"`
+	RecoveredAt time.Time    `json:"RecoveredAt"`
+}
+
+type GuaranteeResponse struct {
+	ID                 uint                        `json:"ID"`
+	LoanID             uint                        `json:"LoanID"`
+	GuarantorID        uint                        `json:"GuarantorID"`
+	GuarantorFirstName string                      `json:"GuarantorFirstName"`
+	GuarantorLastName  string                      `json:"GuarantorLastName"`
+	PledgeType         string                      `json:"PledgeType"`
+	Amount             money.Amount                `json:"Amount"`
+	Status             string                      `json:"Status"`
+	Recovered          money.Amount                `json:"Recovered"`
+	RespondedAt        *time.Time                  `json:"RespondedAt"`
+	DeclineReason      string                      `json:"DeclineReason"`
+	CreatedAt          time.Time                   `json:"CreatedAt"`
+	Recoveries         []GuaranteeRecoveryResponse `json:"Recoveries"`
+}
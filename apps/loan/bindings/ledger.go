@@ -0,0 +1,53 @@
+package bindings
+
+import (
+	"time"
+
+	"github.com/kifangamukundi/gm/loan/money"
+)
+
+type AccountResponse struct {
+	ID       uint   `json:"ID"`
+	Code     string `json:"Code"`
+	Name     string `json:"Name"`
+	Type     string `json:"Type"`
+	IsActive bool   `json:"IsActive"`
+}
+
+type TrialBalanceLine struct {
+	AccountID uint         `json:"AccountID"`
+	Code      string       `json:"Code"`
+	Name      string       `json:"Name"`
+	Type      string       `json:"Type"`
+	Debit     money.Amount `json:"Debit"`
+	Credit    money.Amount `json:"Credit"`
+}
+
+type TrialBalanceResponse struct {
+	AsOf        time.Time          `json:"AsOf"`
+	Accounts    []TrialBalanceLine `json:"Accounts"`
+	TotalDebit  money.Amount       `json:"TotalDebit"`
+	TotalCredit money.Amount       `json:"TotalCredit"`
+	IsBalanced  bool               `json:"IsBalanced"`
+}
+
+type StatementLine struct {
+	EntryDate   time.Time    `json:"EntryDate"`
+	EntryID     uint         `json:"EntryID"`
+	SourceType  string       `json:"SourceType"`
+	LoanID      *uint        `json:"LoanID"`
+	Reference   string       `json:"Reference"`
+	Description string       `json:"Description"`
+	Debit       money.Amount `json:"Debit"`
+	Credit      money.Amount `json:"Credit"`
+	Balance     money.Amount `json:"Balance"`
+}
+
+type AccountStatementResponse struct {
+	Account        AccountResponse `json:"Account"`
+	From           *time.Time      `json:"From"`
+	To             *time.Time      `json:"To"`
+	OpeningBalance money.Amount    `json:"OpeningBalance"`
+	Lines          []StatementLine `json:"Lines"`
+	ClosingBalance money.Amount    `json:"ClosingBalance"`
+}
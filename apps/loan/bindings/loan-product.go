@@ -0,0 +1,104 @@
+package bindings
+
+import (
+	"time"
+
+	"github.com/kifangamukundi/gm/loan/money"
+)
+
+type CreateLoanProductRequest struct {
+	ProductName        string       `json:"ProductName" binding:"required,min=3,max=100"`
+	InterestMethod     string       `json:"InterestMethod" binding:"required,oneof=flat reducing_balance"`
+	InterestRate       float64      `json:"InterestRate" binding:"gte=0,lte=1000"` // Annual percentage, charged pro rata per repayment period
+	MinAmount          money.Amount `json:"MinAmount" binding:"required,gt=0"`
+	MaxAmount          money.Amount `json:"MaxAmount" binding:"required,gtefield=MinAmount"`
+	MinTerm            int          `json:"MinTerm" binding:"required,gt=0"`
+	MaxTerm            int          `json:"MaxTerm" binding:"required,gtefield=MinTerm"`
+	RepaymentFrequency string       `json:"RepaymentFrequency" binding:"required,oneof=daily weekly monthly"`
+	ProcessingFee      money.Amount `json:"ProcessingFee" binding:"gte=0"`
+	FeePercentage      float64      `json:"FeePercentage" binding:"gte=0,lte=100"`
+	GracePeriod        int          `json:"GracePeriod" binding:"gte=0"`
+	AllocationOrder    string       `json:"AllocationOrder" binding:"omitempty,max=100"`
+
+	PenaltyType      string       `json:"PenaltyType" binding:"omitempty,oneof=none flat percent_of_arrears per_day"`
+	PenaltyAmount    money.Amount `json:"PenaltyAmount" binding:"gte=0"`          // Flat fee or daily amount for the flat and per_day types
+	PenaltyPercent   float64      `json:"PenaltyPercent" binding:"gte=0,lte=100"` // Percentage of the arrears for the percent_of_arrears type
+	PenaltyCap       money.Amount `json:"PenaltyCap" binding:"gte=0"`
+	PenaltyGraceDays int          `json:"PenaltyGraceDays" binding:"gte=0"`
+	ArrearsAfterDays *int         `json:"ArrearsAfterDays" binding:"omitempty,gte=0"` // Defaults to 1 when omitted
+
+	SettlementRebate float64 `json:"SettlementRebate" binding:"gte=0,lte=100"`
+
+	WriteOffAfterDays int `json:"WriteOffAfterDays" binding:"gte=0"`
+
+	MaxLoanToValue float64 `json:"MaxLoanToValue" binding:"gte=0,lte=100"`
+
+	LadderStartAmount money.Amount `json:"LadderStartAmount" binding:"gte=0"`
+	LadderStepPercent float64      `json:"LadderStepPercent" binding:"gte=0,lte=1000"`
+	SavingsMultiplier float64      `json:"SavingsMultiplier" binding:"gte=0,lte=100"`
+}
+
+type UpdateLoanProductRequest struct {
+	ProductName        string       `json:"ProductName" binding:"required,min=3,max=100"`
+	InterestMethod     string       `json:"InterestMethod" binding:"required,oneof=flat reducing_balance"`
+	InterestRate       float64      `json:"InterestRate" binding:"gte=0,lte=1000"` // Annual percentage, charged pro rata per repayment period
+	MinAmount          money.Amount `json:"MinAmount" binding:"required,gt=0"`
+	MaxAmount          money.Amount `json:"MaxAmount" binding:"required,gtefield=MinAmount"`
+	MinTerm            int          `json:"MinTerm" binding:"required,gt=0"`
+	MaxTerm            int          `json:"MaxTerm" binding:"required,gtefield=MinTerm"`
+	RepaymentFrequency string       `json:"RepaymentFrequency" binding:"required,oneof=daily weekly monthly"`
+	ProcessingFee      money.Amount `json:"ProcessingFee" binding:"gte=0"`
+	FeePercentage      float64      `json:"FeePercentage" binding:"gte=0,lte=100"`
+	GracePeriod        int          `json:"GracePeriod" binding:"gte=0"`
+	AllocationOrder    string       `json:"AllocationOrder" binding:"omitempty,max=100"`
+	IsActive           *bool        `json:"IsActive"` // Left unchanged when omitted
+
+	PenaltyType      string       `json:"PenaltyType" binding:"omitempty,oneof=none flat percent_of_arrears per_day"`
+	PenaltyAmount    money.Amount `json:"PenaltyAmount" binding:"gte=0"`          // Flat fee or daily amount for the flat and per_day types
+	PenaltyPercent   float64      `json:"PenaltyPercent" binding:"gte=0,lte=100"` // Percentage of the arrears for the percent_of_arrears type
+	PenaltyCap       money.Amount `json:"PenaltyCap" binding:"gte=0"`
+	PenaltyGraceDays int          `json:"PenaltyGraceDays" binding:"gte=0"`
+	ArrearsAfterDays *int         `json:"ArrearsAfterDays" binding:"omitempty,gte=0"` // Defaults to 1 when omitted
+
+	SettlementRebate float64 `json:"SettlementRebate" binding:"gte=0,lte=100"`
+
+	WriteOffAfterDays int `json:"WriteOffAfterDays" binding:"gte=0"`
+
+	MaxLoanToValue float64 `json:"MaxLoanToValue" binding:"gte=0,lte=100"`
+
+	LadderStartAmount money.Amount `json:"LadderStartAmount" binding:"gte=0"`
+	LadderStepPercent float64      `json:"LadderStepPercent" binding:"gte=0,lte=1000"`
+	SavingsMultiplier float64      `json:"SavingsMultiplier" binding:"gte=0,lte=100"`
+}
+
+type LoanProductResponse struct {
+	ID                 uint         `json:"ID"`
+	ProductName        string       `json:"ProductName"`
+	InterestMethod     string       `json:"InterestMethod"`
+	InterestRate       float64      `json:"InterestRate"`
+	RatePerPeriod      bool         `json:"RatePerPeriod"`
+	MinAmount          money.Amount `json:"MinAmount"`
+	MaxAmount          money.Amount `json:"MaxAmount"`
+	MinTerm            int          `json:"MinTerm"`
+	MaxTerm            int          `json:"MaxTerm"`
+	RepaymentFrequency string       `json:"RepaymentFrequency"`
+	ProcessingFee      money.Amount `json:"ProcessingFee"`
+	FeePercentage      float64      `json:"FeePercentage"`
+	GracePeriod        int          `json:"GracePeriod"`
+	IsActive           bool         `json:"IsActive"`
+	AllocationOrder    string       `json:"AllocationOrder"`
+	PenaltyType        string       `json:"PenaltyType"`
+	PenaltyAmount      money.Amount `json:"PenaltyAmount"`
+	PenaltyPercent     float64      `json:"PenaltyPercent"`
+	PenaltyCap         money.Amount `json:"PenaltyCap"`
+	PenaltyGraceDays   int          `json:"PenaltyGraceDays"`
+	ArrearsAfterDays   int          `json:"ArrearsAfterDays"`
+	SettlementRebate   float64      `json:"SettlementRebate"`
+	WriteOffAfterDays  int          `json:"WriteOffAfterDays"`
+	MaxLoanToValue     float64      `json:"MaxLoanToValue"`
+	LadderStartAmount  money.Amount `json:"LadderStartAmount"`
+	LadderStepPercent  float64      `json:"LadderStepPercent"`
+	SavingsMultiplier  float64      `json:"SavingsMultiplier"`
+	CreatedAt          time.Time    `json:"CreatedAt"`
+	UpdatedAt          time.Time    `json:"UpdatedAt"`
+}
@@ -4,35 +4,127 @@ import (
 	"time"
 
 	"github.com/kifangamukundi/gm/loan/deserializers"
+	"github.com/kifangamukundi/gm/loan/money"
 )
 
 type CreateLoanRequest struct {
-	Amount       float64                      `json:"Amount" binding:"required"`
+	Amount       money.Amount                 `json:"Amount" binding:"required"`
 	Term         int                          `json:"Term" binding:"required"`
 	DefaultImage []deserializers.DefaultImage `json:"DefaultImage"`
 	Images       []deserializers.DefaultImage `json:"Images"`
 	LoanPurpose  *string                      `json:"LoanPurpose" binding:"required,min=10"`
 	GroupID      int                          `json:"GroupID" binding:"required"`
 	MemberID     int                          `json:"MemberID" binding:"required"`
+	ProductID    int                          `json:"ProductID" binding:"required"`
 }
 
 type LoanResponse struct {
 	ID               uint                         `json:"ID"`
-	Amount           float64                      `json:"Amount"`
+	Amount           money.Amount                 `json:"Amount"`
 	Interest         float64                      `json:"Interest"`
+	RatePerPeriod    bool                         `json:"RatePerPeriod"`
 	Term             int                          `json:"Term"`
+	InterestMethod   string                       `json:"InterestMethod"`
+	Frequency        string                       `json:"RepaymentFrequency"`
+	ProductName      string                       `json:"ProductName"`
+	Fees             money.Amount                 `json:"Fees"`
 	DefaultImage     []deserializers.DefaultImage `json:"DefaultImage"`
 	Images           []deserializers.DefaultImage `json:"Images"`
 	LoanPurpose      *string                      `json:"LoanPurpose"`
 	Status           string                       `json:"Status"`
 	DueDate          *time.Time                   `json:"DueDate"`
 	LastPaymentDate  *time.Time                   `json:"LastPaymentDate"`
-	RemainingBalance float64                      `json:"RemainingBalance"`
+	RemainingBalance money.Amount                 `json:"RemainingBalance"`
 	AgentFirstName   string                       `json:"AgentFirstName"`
 	AgentLastName    string                       `json:"AgentLastName"`
 	GroupName        string                       `json:"GroupName"`
 	MemberFirstName  string                       `json:"MemberFirstName"`
 	MemberLastName   string                       `json:"MemberLastName"`
+	StatusHistory    []LoanStatusHistoryResponse  `json:"StatusHistory"`
 	CreatedAt        time.Time                    `json:"CreatedAt"`
 	UpdatedAt        time.Time                    `json:"UpdatedAt"`
+
+	RefinancedLoanID *uint        `json:"RefinancedLoanID"`
+	SettlementAmount money.Amount `json:"SettlementAmount"`
+
+	// Latest credit bureau report, empty until the loan is first signed off
+	CreditListingStatus string     `json:"CreditListingStatus"`
+	CreditReportedAt    *time.Time `json:"CreditReportedAt"`
+}
+
+type InstalmentResponse struct {
+	ID             uint         `json:"ID"`
+	Number         int          `json:"Number"`
+	DueDate        time.Time    `json:"DueDate"`
+	Principal      money.Amount `json:"Principal"`
+	Interest       money.Amount `json:"Interest"`
+	Fees           money.Amount `json:"Fees"`
+	TotalDue       money.Amount `json:"TotalDue"`
+	OpeningBalance money.Amount `json:"OpeningBalance"`
+	ClosingBalance money.Amount `json:"ClosingBalance"`
+	Status         string       `json:"Status"`
+
+	Penalty    money.Amount `json:"Penalty"`
+	AmountPaid money.Amount `json:"AmountPaid"`
+	Balance    money.Amount `json:"Balance"`
+	PaidAt     *time.Time   `json:"PaidAt"`
+}
+
+type LoanScheduleResponse struct {
+	LoanID             uint                 `json:"LoanID"`
+	InterestMethod     string               `json:"InterestMethod"`
+	RepaymentFrequency string               `json:"RepaymentFrequency"`
+	DueDate            *time.Time           `json:"DueDate"`
+	RemainingBalance   money.Amount         `json:"RemainingBalance"`
+	Instalments        []InstalmentResponse `json:"Instalments"`
+}
+
+type LoanStatusReasonRequest struct {
+	Reason string `json:"Reason" binding:"max=500"`
+}
+
+type CancelLoanRequest struct {
+	Reason string `json:"Reason" binding:"required,min=5,max=500"`
+}
+
+type RetryDisbursementRequest struct {
+	AbandonPending bool `json:"AbandonPending"` // An officer checked M-Pesa and the pending payout was not paid
+}
+
+type LoanStatusHistoryResponse struct {
+	FromStatus     string    `json:"FromStatus"`
+	ToStatus       string    `json:"ToStatus"`
+	Reason         string    `json:"Reason"`
+	ActorFirstName string    `json:"ActorFirstName"`
+	ActorLastName  string    `json:"ActorLastName"`
+	CreatedAt      time.Time `json:"CreatedAt"`
+}
+
+type RepayLoanRequest struct {
+	Amount      money.Amount `json:"Amount" binding:"required,gt=0"`
+	PhoneNumber string       `json:"PhoneNumber" binding:"omitempty,numeric,min=10,max=12"`
+}
+
+type RepayLoanResponse struct {
+	PaymentID           uint   `json:"PaymentID"`
+	CheckoutRequestID   string `json:"CheckoutRequestID"`
+	ResponseCode        string `json:"ResponseCode"`
+	ResponseDescription string `json:"ResponseDescription"`
+	CustomerMessage     string `json:"CustomerMessage"`
+}
+
+type DisbursementJobResponse struct {
+	ID                 uint         `json:"ID"`
+	LoanID             uint         `json:"LoanID"`
+	Amount             money.Amount `json:"Amount"`
+	Status             string       `json:"Status"`
+	Attempts           int          `json:"Attempts"`
+	MaxAttempts        int          `json:"MaxAttempts"`
+	NextAttemptAt      time.Time    `json:"NextAttemptAt"`
+	LastError          string       `json:"LastError"`
+	DisbursementID     *uint        `json:"DisbursementID"`
+	DisbursementStatus string       `json:"DisbursementStatus"`
+	TransactionID      string       `json:"TransactionID"`
+	CreatedAt          time.Time    `json:"CreatedAt"`
+	UpdatedAt          time.Time    `json:"UpdatedAt"`
 }
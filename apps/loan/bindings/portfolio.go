@@ -0,0 +1,70 @@
+package bindings
+
+import (
+	"time"
+
+	"github.com/kifangamukundi/gm/loan/money"
+)
+
+type PortfolioFilterResponse struct {
+	AgentID   uint       `json:"AgentID,omitempty"`
+	OfficerID uint       `json:"OfficerID,omitempty"`
+	GroupID   uint       `json:"GroupID,omitempty"`
+	RegionID  uint       `json:"RegionID,omitempty"`
+	From      *time.Time `json:"From"`
+	To        *time.Time `json:"To"`
+}
+
+type PortfolioLoanResponse struct {
+	LoanID      uint         `json:"LoanID"`
+	MemberID    uint         `json:"MemberID"`
+	AgentID     uint         `json:"AgentID"`
+	GroupID     uint         `json:"GroupID"`
+	OfficerID   *uint        `json:"OfficerID"`
+	Status      string       `json:"Status"`
+	DisbursedAt *time.Time   `json:"DisbursedAt"`
+	Outstanding money.Amount `json:"Outstanding"`
+	Arrears     money.Amount `json:"Arrears"`
+	DaysPastDue int          `json:"DaysPastDue"`
+	Bucket      string       `json:"Bucket"`
+}
+
+// ParRatioResponse is also the row exported to XLSX and PDF, so its field names are the
+// column headings
+type ParRatioResponse struct {
+	Measure     string       `json:"Measure"`
+	Loans       int          `json:"Loans"`
+	Outstanding money.Amount `json:"Outstanding"`
+	Ratio       float64      `json:"Ratio"`
+}
+
+// AgingBucketResponse is also the row exported to XLSX and PDF, so its field names are the
+// column headings
+type AgingBucketResponse struct {
+	Bucket      string       `json:"Bucket"`
+	MinDays     int          `json:"MinDays"`
+	MaxDays     int          `json:"MaxDays,omitempty"`
+	Loans       int          `json:"Loans"`
+	Outstanding money.Amount `json:"Outstanding"`
+	Arrears     money.Amount `json:"Arrears"`
+	Share       float64      `json:"Share"`
+}
+
+type ParReportResponse struct {
+	AsOf        time.Time               `json:"AsOf"`
+	Filter      PortfolioFilterResponse `json:"Filter"`
+	LoanCount   int                     `json:"LoanCount"`
+	Outstanding money.Amount            `json:"Outstanding"`
+	Arrears     money.Amount            `json:"Arrears"`
+	Par         []ParRatioResponse      `json:"Par"`
+}
+
+type AgingReportResponse struct {
+	AsOf        time.Time               `json:"AsOf"`
+	Filter      PortfolioFilterResponse `json:"Filter"`
+	LoanCount   int                     `json:"LoanCount"`
+	Outstanding money.Amount            `json:"Outstanding"`
+	Arrears     money.Amount            `json:"Arrears"`
+	Buckets     []AgingBucketResponse   `json:"Buckets"`
+	Loans       []PortfolioLoanResponse `json:"Loans"`
+}
@@ -0,0 +1,32 @@
+package bindings
+
+import (
+	"time"
+
+	"github.com/kifangamukundi/gm/loan/money"
+)
+
+type TopUpLoanRequest struct {
+	Amount      money.Amount `json:"Amount" binding:"required,gt=0"`
+	Term        int          `json:"Term" binding:"required"`
+	LoanPurpose *string      `json:"LoanPurpose" binding:"required,min=10"`
+	ProductID   int          `json:"ProductID"` // Defaults to the product of the loan being topped up
+}
+
+type TopUpLoanResponse struct {
+	LoanID             uint         `json:"LoanID"`
+	RefinancedLoanID   uint         `json:"RefinancedLoanID"`
+	Amount             money.Amount `json:"Amount"`
+	OutstandingBalance money.Amount `json:"OutstandingBalance"` // Payoff of the refinanced loan, after its settlement rebate
+	NetDisbursement    money.Amount `json:"NetDisbursement"`
+}
+
+type RefinanceChainLoanResponse struct {
+	LoanID           uint         `json:"LoanID"`
+	RefinancedLoanID *uint        `json:"RefinancedLoanID"`
+	Amount           money.Amount `json:"Amount"`
+	SettlementAmount money.Amount `json:"SettlementAmount"`
+	Status           string       `json:"Status"`
+	DisbursedAt      *time.Time   `json:"DisbursedAt"`
+	CreatedAt        time.Time    `json:"CreatedAt"`
+}
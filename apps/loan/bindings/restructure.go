@@ -0,0 +1,47 @@
+package bindings
+
+import (
+	"time"
+
+	"github.com/kifangamukundi/gm/loan/money"
+)
+
+type RestructureLoanRequest struct {
+	Term             int      `json:"Term" binding:"required,gt=0,lte=360"`
+	InterestRate     *float64 `json:"InterestRate" binding:"required,gte=0,lte=1000"` // Annual percentage
+	GracePeriod      int      `json:"GracePeriod" binding:"gte=0,lte=12"`
+	ArrearsTreatment string   `json:"ArrearsTreatment" binding:"required,oneof=capitalize waive"`
+	Reason           string   `json:"Reason" binding:"required,min=10,max=500"`
+}
+
+type RestructureResponse struct {
+	ID                   uint       `json:"ID"`
+	LoanID               uint       `json:"LoanID"`
+	Status               string     `json:"Status"`
+	ArrearsTreatment     string     `json:"ArrearsTreatment"`
+	Term                 int        `json:"Term"`
+	InterestRate         float64    `json:"InterestRate"`
+	RatePerPeriod        bool       `json:"RatePerPeriod"`
+	GracePeriod          int        `json:"GracePeriod"`
+	Reason               string     `json:"Reason"`
+	RequestedByFirstName string     `json:"RequestedByFirstName"`
+	RequestedByLastName  string     `json:"RequestedByLastName"`
+	DecidedByFirstName   string     `json:"DecidedByFirstName"`
+	DecidedByLastName    string     `json:"DecidedByLastName"`
+	DecisionReason       string     `json:"DecisionReason"`
+	DecidedAt            *time.Time `json:"DecidedAt"`
+	CreatedAt            time.Time  `json:"CreatedAt"`
+
+	PreviousTerm         int                  `json:"PreviousTerm"`
+	PreviousRate         float64              `json:"PreviousRate"`
+	PreviousDueDate      *time.Time           `json:"PreviousDueDate"`
+	OutstandingPrincipal money.Amount         `json:"OutstandingPrincipal"`
+	ArrearsInterest      money.Amount         `json:"ArrearsInterest"`
+	ArrearsFees          money.Amount         `json:"ArrearsFees"`
+	ArrearsPenalty       money.Amount         `json:"ArrearsPenalty"`
+	Capitalized          money.Amount         `json:"Capitalized"`
+	Waived               money.Amount         `json:"Waived"`
+	NewPrincipal         money.Amount         `json:"NewPrincipal"`
+	NewInterest          money.Amount         `json:"NewInterest"`
+	OriginalSchedule     []InstalmentResponse `json:"OriginalSchedule"`
+}
@@ -0,0 +1,88 @@
+package bindings
+
+import (
+	"time"
+
+	"github.com/kifangamukundi/gm/loan/money"
+)
+
+type SavingsDepositRequest struct {
+	Amount              money.Amount `json:"Amount" binding:"required,gt=0"`
+	Channel             string       `json:"Channel" binding:"required,oneof=mpesa cash"`
+	PhoneNumber         string       `json:"PhoneNumber" binding:"omitempty,numeric,min=10,max=12"`
+	Reference           string       `json:"Reference" binding:"required_if=Channel cash,omitempty,max=100"` // Cash receipt number
+	ContributionGroupID *uint        `json:"ContributionGroupID" binding:"omitempty,gt=0"`                   // Counts the deposit towards the group's contribution schedule
+}
+
+type SavingsWithdrawalRequest struct {
+	Amount      money.Amount `json:"Amount" binding:"required,gt=0"`
+	Channel     string       `json:"Channel" binding:"required,oneof=mpesa cash"`
+	PhoneNumber string       `json:"PhoneNumber" binding:"omitempty,numeric,min=10,max=12"`
+	Reference   string       `json:"Reference" binding:"required_if=Channel cash,omitempty,max=100"`
+}
+
+type SavingsTransactionResponse struct {
+	ID                     uint         `json:"ID"`
+	AccountID              uint         `json:"AccountID"`
+	MemberID               uint         `json:"MemberID"`
+	Type                   string       `json:"Type"`
+	Channel                string       `json:"Channel"`
+	Amount                 money.Amount `json:"Amount"`
+	Status                 string       `json:"Status"`
+	Reference              string       `json:"Reference"`
+	PhoneNumber            string       `json:"PhoneNumber"`
+	CheckoutRequestID      string       `json:"CheckoutRequestID,omitempty"`
+	ResultDesc             string       `json:"ResultDesc"`
+	ContributionScheduleID *uint        `json:"ContributionScheduleID"`
+	RecordedByFirstName    string       `json:"RecordedByFirstName"`
+	RecordedByLastName     string       `json:"RecordedByLastName"`
+	CompletedAt            *time.Time   `json:"CompletedAt"`
+	CreatedAt              time.Time    `json:"CreatedAt"`
+}
+
+type SavingsAccountResponse struct {
+	MemberID      uint                         `json:"MemberID"`
+	AccountNumber string                       `json:"AccountNumber"`
+	Balance       money.Amount                 `json:"Balance"`
+	Held          money.Amount                 `json:"Held"`
+	Available     money.Amount                 `json:"Available"`
+	Transactions  []SavingsTransactionResponse `json:"Transactions"`
+}
+
+type ContributionScheduleRequest struct {
+	Amount    money.Amount `json:"Amount" binding:"required,gt=0"`
+	Frequency string       `json:"Frequency" binding:"required,oneof=daily weekly monthly"`
+	StartDate string       `json:"StartDate" binding:"required,datetime=2006-01-02"`
+	IsActive  *bool        `json:"IsActive"`
+}
+
+type ContributionScheduleResponse struct {
+	ID        uint         `json:"ID"`
+	GroupID   uint         `json:"GroupID"`
+	Amount    money.Amount `json:"Amount"`
+	Frequency string       `json:"Frequency"`
+	StartDate time.Time    `json:"StartDate"`
+	IsActive  bool         `json:"IsActive"`
+	CreatedAt time.Time    `json:"CreatedAt"`
+	UpdatedAt time.Time    `json:"UpdatedAt"`
+}
+
+type MemberContributionsResponse struct {
+	MemberID    uint         `json:"MemberID"`
+	FirstName   string       `json:"FirstName"`
+	LastName    string       `json:"LastName"`
+	Expected    money.Amount `json:"Expected"`
+	Contributed money.Amount `json:"Contributed"`
+	Arrears     money.Amount `json:"Arrears"`
+}
+
+type GroupContributionsResponse struct {
+	Schedule    ContributionScheduleResponse  `json:"Schedule"`
+	AsOf        time.Time                     `json:"AsOf"`
+	PeriodsDue  int                           `json:"PeriodsDue"`
+	NextDueDate time.Time                     `json:"NextDueDate"`
+	Expected    money.Amount                  `json:"Expected"`
+	Contributed money.Amount                  `json:"Contributed"`
+	Arrears     money.Amount                  `json:"Arrears"`
+	Members     []MemberContributionsResponse `json:"Members"`
+}
@@ -0,0 +1,30 @@
+package bindings
+
+import (
+	"time"
+
+	"github.com/kifangamukundi/gm/loan/money"
+)
+
+type SettlementQuoteRequest struct {
+	ValidUntil string `json:"ValidUntil" binding:"omitempty,datetime=2006-01-02"` // Defaults to today
+}
+
+type SettlementQuoteResponse struct {
+	ID                uint         `json:"ID"`
+	LoanID            uint         `json:"LoanID"`
+	AsOf              time.Time    `json:"AsOf"`
+	ValidUntil        time.Time    `json:"ValidUntil"`
+	Principal         money.Amount `json:"Principal"`
+	AccruedInterest   money.Amount `json:"AccruedInterest"`
+	UnaccruedInterest money.Amount `json:"UnaccruedInterest"`
+	Fees              money.Amount `json:"Fees"`
+	Penalties         money.Amount `json:"Penalties"`
+	RebateRate        float64      `json:"RebateRate"`
+	Rebate            money.Amount `json:"Rebate"`
+	Total             money.Amount `json:"Total"`
+	Status            string       `json:"Status"`
+	PaymentID         *uint        `json:"PaymentID"`
+	SettledAt         *time.Time   `json:"SettledAt"`
+	CreatedAt         time.Time    `json:"CreatedAt"`
+}
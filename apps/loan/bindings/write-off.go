@@ -0,0 +1,70 @@
+package bindings
+
+import (
+	"time"
+
+	"github.com/kifangamukundi/gm/loan/money"
+)
+
+type WriteOffLoanRequest struct {
+	Reason string `json:"Reason" binding:"required,min=10,max=500"`
+}
+
+type RecordRecoveryRequest struct {
+	Amount    money.Amount `json:"Amount" binding:"required,gt=0"`
+	Reference string       `json:"Reference" binding:"required,max=100"`
+}
+
+type RecoveryResponse struct {
+	ID          uint         `json:"ID"`
+	LoanID      uint         `json:"LoanID"`
+	WriteOffID  uint         `json:"WriteOffID"`
+	Amount      money.Amount `json:"Amount"`
+	PaymentID   *uint        `json:"PaymentID"`
+	Reference   string       `json:"Reference"`
+	RecoveredAt time.Time    `json:"RecoveredAt"`
+}
+
+type WriteOffResponse struct {
+	ID                   uint       `json:"ID"`
+	LoanID               uint       `json:"LoanID"`
+	Status               string     `json:"Status"`
+	DaysPastDue          int        `json:"DaysPastDue"`
+	Reason               string     `json:"Reason"`
+	RequestedByFirstName string     `json:"RequestedByFirstName"`
+	RequestedByLastName  string     `json:"RequestedByLastName"`
+	DecidedByFirstName   string     `json:"DecidedByFirstName"`
+	DecidedByLastName    string     `json:"DecidedByLastName"`
+	DecisionReason       string     `json:"DecisionReason"`
+	DecidedAt            *time.Time `json:"DecidedAt"`
+	CreatedAt            time.Time  `json:"CreatedAt"`
+
+	Principal  money.Amount       `json:"Principal"`
+	Interest   money.Amount       `json:"Interest"`
+	Fees       money.Amount       `json:"Fees"`
+	Penalty    money.Amount       `json:"Penalty"`
+	Amount     money.Amount       `json:"Amount"`
+	Recovered  money.Amount       `json:"Recovered"`
+	Recoveries []RecoveryResponse `json:"Recoveries"`
+}
+
+type WriteOffReportRow struct {
+	AgentID           uint         `json:"AgentID,omitempty"`
+	AgentName         string       `json:"AgentName,omitempty"`
+	RegionID          uint         `json:"RegionID"`
+	RegionName        string       `json:"RegionName"`
+	ActiveLoans       int64        `json:"ActiveLoans"`
+	ActiveOutstanding money.Amount `json:"ActiveOutstanding"`
+	WrittenOffLoans   int64        `json:"WrittenOffLoans"`
+	WrittenOff        money.Amount `json:"WrittenOff"`
+	Recovered         money.Amount `json:"Recovered"`
+	NetLoss           money.Amount `json:"NetLoss"`
+}
+
+type WriteOffReportResponse struct {
+	From    *time.Time          `json:"From"`
+	To      *time.Time          `json:"To"`
+	Agents  []WriteOffReportRow `json:"Agents"`
+	Regions []WriteOffReportRow `json:"Regions"`
+	Totals  WriteOffReportRow   `json:"Totals"`
+}
@@ -0,0 +1,115 @@
+package bureau
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/kifangamukundi/gm/loan/money"
+)
+
+// fileReport is how a subject is stored in the file provider's JSON
+type fileReport struct {
+	Reference string    `json:"reference"`
+	Score     int       `json:"score"`
+	Listings  []Listing `json:"listings"`
+}
+
+// FileBureau is a stand-in for a real bureau for local use. It answers lookups from a JSON
+// file mapping mobile numbers to reports, read on every lookup so it can be edited while the
+// app runs. Subjects missing from the file, or a missing file, come back clear.
+type FileBureau struct {
+	Path string
+}
+
+func NewFileBureau(path string) *FileBureau {
+	return &FileBureau{Path: path}
+}
+
+func (b *FileBureau) Name() string {
+	return "file"
+}
+
+func (b *FileBureau) Lookup(ctx context.Context, subject Subject) (*Report, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	reports := map[string]fileReport{}
+
+	data, err := os.ReadFile(b.Path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read bureau file: %v", err)
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &reports); err != nil {
+			return nil, fmt.Errorf("failed to parse bureau file: %v", err)
+		}
+	}
+
+	now := time.Now()
+	stored := reports[subject.MobileNumber]
+
+	report := &Report{
+		Reference:     stored.Reference,
+		ListingStatus: listingStatus(stored.Listings),
+		Score:         stored.Score,
+		Listings:      stored.Listings,
+		RetrievedAt:   now,
+	}
+	if report.Reference == "" {
+		report.Reference = fmt.Sprintf("FILE-%s-%d", subject.MobileNumber, now.Unix())
+	}
+	if report.Listings == nil {
+		report.Listings = []Listing{}
+	}
+
+	return report, nil
+}
+
+// WriteSubmission writes the batch as pipe delimited records: a header with our institution
+// code, the reporting date and the number of accounts, one D record per account, and a
+// trailer repeating the count with the total balance. Dates are YYYYMMDD.
+func (b *FileBureau) WriteSubmission(w io.Writer, batch Batch) error {
+	out := bufio.NewWriter(w)
+
+	fmt.Fprintf(out, "H|%s|%s|%d\n", field(batch.InstitutionCode), batch.AsOf.Format("20060102"), len(batch.Accounts))
+
+	total := money.Zero
+	for _, account := range batch.Accounts {
+		dueDate := ""
+		if account.DueDate != nil {
+			dueDate = account.DueDate.Format("20060102")
+		}
+
+		fmt.Fprintf(out, "D|%s|%s|%s|%s|%s|%s|%s|%s|%d|%s\n",
+			field(account.AccountNumber),
+			field(account.MobileNumber),
+			field(account.LastName),
+			field(account.FirstName),
+			account.DisbursedAt.Format("20060102"),
+			dueDate,
+			account.Amount,
+			account.Balance,
+			account.DaysPastDue,
+			account.Status,
+		)
+		total += account.Balance
+	}
+
+	fmt.Fprintf(out, "T|%d|%s\n", len(batch.Accounts), total)
+
+	return out.Flush()
+}
+
+// field keeps free text from breaking the record layout
+func field(value string) string {
+	return strings.NewReplacer("|", " ", "\n", " ", "\r", " ").Replace(strings.TrimSpace(value))
+}
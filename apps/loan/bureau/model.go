@@ -0,0 +1,100 @@
+package bureau
+
+import (
+	"context"
+	"io"
+	"log"
+	"time"
+
+	"github.com/kifangamukundi/gm/loan/config"
+	"github.com/kifangamukundi/gm/loan/money"
+)
+
+const (
+	ListingStatusClear  = "clear"  // No unsettled listings
+	ListingStatusListed = "listed" // Listed by at least one lender
+
+	ListingSettled = "settled" // Listing.Status of a debt that has since been paid
+)
+
+// Account statuses reported in performance submissions
+const (
+	AccountStatusPerforming = "performing"
+	AccountStatusArrears    = "arrears"
+	AccountStatusDefault    = "default"
+	AccountStatusClosed     = "closed"
+	AccountStatusWrittenOff = "written_off"
+)
+
+// Subject identifies the person a report is requested for. Members are matched on their
+// mobile number as the app does not record national IDs.
+type Subject struct {
+	MobileNumber string
+	FirstName    string
+	LastName     string
+}
+
+// Listing is a debt another lender has reported against the subject
+type Listing struct {
+	Lender      string       `json:"lender"`
+	Amount      money.Amount `json:"amount"`
+	DaysPastDue int          `json:"days_past_due"`
+	Status      string       `json:"status"`
+	ListedAt    time.Time    `json:"listed_at"`
+}
+
+// Report is what the bureau holds on a subject
+type Report struct {
+	Reference     string
+	ListingStatus string // clear, listed
+	Score         int    // Bureau score, 0 when the bureau does not score
+	Listings      []Listing
+	RetrievedAt   time.Time
+}
+
+// Account is one of our loans in a performance submission
+type Account struct {
+	AccountNumber string
+	MobileNumber  string
+	FirstName     string
+	LastName      string
+	DisbursedAt   time.Time
+	DueDate       *time.Time
+	Amount        money.Amount
+	Balance       money.Amount
+	DaysPastDue   int
+	Status        string // performing, arrears, default, closed, written_off
+}
+
+// Batch is the loan performance we share with the bureau as at AsOf
+type Batch struct {
+	InstitutionCode string
+	AsOf            time.Time
+	Accounts        []Account
+}
+
+// CreditBureau is everything the app needs from a credit reference bureau
+type CreditBureau interface {
+	Name() string
+	Lookup(ctx context.Context, subject Subject) (*Report, error)
+	WriteSubmission(w io.Writer, batch Batch) error
+}
+
+// NewBureau returns the provider selected by CREDIT_BUREAU_PROVIDER
+func NewBureau(cfg *config.BureauConfig) CreditBureau {
+	if cfg.Provider != "file" {
+		log.Printf("Warning: unknown credit bureau provider %q, using file", cfg.Provider)
+	}
+
+	return NewFileBureau(cfg.FilePath)
+}
+
+// listingStatus reports a subject as listed while any of their listings is unsettled
+func listingStatus(listings []Listing) string {
+	for _, listing := range listings {
+		if listing.Status != ListingSettled {
+			return ListingStatusListed
+		}
+	}
+	return ListingStatusClear
+}
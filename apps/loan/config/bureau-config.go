@@ -0,0 +1,29 @@
+package config
+
+import "os"
+
+type BureauConfig struct {
+	Provider        string // file
+	FilePath        string // Reports served by the file provider, keyed by mobile number
+	InstitutionCode string // Our lender code on performance submissions
+}
+
+func GetBureauConfig() *BureauConfig {
+	config := &BureauConfig{
+		Provider:        os.Getenv("CREDIT_BUREAU_PROVIDER"),
+		FilePath:        os.Getenv("CREDIT_BUREAU_FILE"),
+		InstitutionCode: os.Getenv("CREDIT_BUREAU_INSTITUTION_CODE"),
+	}
+
+	if config.Provider == "" {
+		config.Provider = "file"
+	}
+	if config.FilePath == "" {
+		config.FilePath = "credit-bureau.json"
+	}
+	if config.InstitutionCode == "" {
+		config.InstitutionCode = "GMLOAN"
+	}
+
+	return config
+}
@@ -0,0 +1,70 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type MpesaConfig struct {
+	Environment        string // sandbox or production
+	ConsumerKey        string
+	ConsumerSecret     string
+	Passkey            string
+	ShortCode          uint // Paybill that receives STK push repayments
+	B2CShortCode       uint // Shortcode that pays out disbursements
+	InitiatorName      string
+	InitiatorPassword  string
+	SecurityCredential string // Encrypted initiator password, required for reversals
+	CallbackBaseURL    string
+	CallbackToken      string   // Secret carried in every callback URL, callbacks without it are rejected
+	CallbackAllowedIPs []string // Addresses callbacks may come from, any address when empty
+}
+
+func GetMpesaConfig() *MpesaConfig {
+	config := &MpesaConfig{
+		Environment:        os.Getenv("MPESA_ENVIRONMENT"),
+		ConsumerKey:        os.Getenv("MPESA_CONSUMER_KEY"),
+		ConsumerSecret:     os.Getenv("MPESA_CONSUMER_SECRET"),
+		Passkey:            os.Getenv("MPESA_PASSKEY"),
+		ShortCode:          parseShortCode("MPESA_SHORTCODE", 174379),
+		B2CShortCode:       parseShortCode("MPESA_B2C_SHORTCODE", 600999),
+		InitiatorName:      os.Getenv("MPESA_INITIATOR_NAME"),
+		InitiatorPassword:  os.Getenv("MPESA_INITIATOR_PASSWORD"),
+		SecurityCredential: os.Getenv("MPESA_SECURITY_CREDENTIAL"),
+		CallbackBaseURL:    os.Getenv("MPESA_CALLBACK_BASE_URL"),
+		CallbackToken:      os.Getenv("MPESA_CALLBACK_TOKEN"),
+	}
+
+	for _, ip := range strings.Split(os.Getenv("MPESA_CALLBACK_ALLOWED_IPS"), ",") {
+		if ip = strings.TrimSpace(ip); ip != "" {
+			config.CallbackAllowedIPs = append(config.CallbackAllowedIPs, ip)
+		}
+	}
+
+	if config.InitiatorName == "" {
+		config.InitiatorName = "testapi"
+	}
+	if config.CallbackToken == "" {
+		log.Println("Warning: MPESA_CALLBACK_TOKEN is not set, M-Pesa callbacks will be rejected")
+	}
+
+	return config
+}
+
+// parseShortCode reads a shortcode from the environment, falling back to the sandbox default
+func parseShortCode(key string, fallback uint) uint {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	shortCode, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		log.Printf("Warning: invalid %s %q, using %d", key, value, fallback)
+		return fallback
+	}
+
+	return uint(shortCode)
+}
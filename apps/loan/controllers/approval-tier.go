@@ -0,0 +1,138 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/kifangamukundi/gm/libs/binders"
+	"github.com/kifangamukundi/gm/libs/parameters"
+	"github.com/kifangamukundi/gm/loan/bindings"
+	"github.com/kifangamukundi/gm/loan/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ApprovalTierController struct {
+	ApprovalModel *models.ApprovalModel
+	RoleModel     *models.RoleModel
+}
+
+func NewApprovalTierController(approvalModel *models.ApprovalModel, roleModel *models.RoleModel) *ApprovalTierController {
+	return &ApprovalTierController{ApprovalModel: approvalModel, RoleModel: roleModel}
+}
+
+func approvalTierResponse(tier models.ApprovalTier) bindings.ApprovalTierResponse {
+	return bindings.ApprovalTierResponse{
+		ID:          tier.ID,
+		Level:       tier.Level,
+		Name:        tier.Name,
+		AboveAmount: tier.AboveAmount,
+		RoleID:      tier.RoleID,
+		RoleName:    tier.Role.RoleName,
+		CreatedAt:   tier.CreatedAt,
+		UpdatedAt:   tier.UpdatedAt,
+	}
+}
+
+func (ctrl *ApprovalTierController) CreateApprovalTierController(c *gin.Context) {
+	var req bindings.ApprovalTierRequest
+	if !binders.ValidateBindJSONRequest(c, &req) {
+		return
+	}
+
+	role, err := ctrl.RoleModel.GetRoleByField("id", fmt.Sprintf("%d", req.RoleID))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Role not found"})
+		return
+	}
+
+	tier := models.ApprovalTier{
+		Level:       req.Level,
+		Name:        req.Name,
+		AboveAmount: req.AboveAmount,
+		RoleID:      role.ID,
+	}
+
+	if err := ctrl.ApprovalModel.CreateApprovalTier(&tier); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	binders.ReturnJSONCreatedGenericResponse(c)
+}
+
+func (ctrl *ApprovalTierController) GetApprovalTiersController(c *gin.Context) {
+	tiers, err := ctrl.ApprovalModel.GetApprovalTiers()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching approval tiers: " + err.Error()})
+		return
+	}
+
+	items := make([]bindings.ApprovalTierResponse, 0, len(tiers))
+	for _, tier := range tiers {
+		items = append(items, approvalTierResponse(tier))
+	}
+
+	binders.ReturnJSONGeneralResponse(c, items)
+}
+
+func (ctrl *ApprovalTierController) UpdateApprovalTierController(c *gin.Context) {
+	id, valid := parameters.ConvertParamToValidID(c, "id")
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	idUint, err := strconv.ParseUint(string(id), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	var req bindings.ApprovalTierRequest
+	if !binders.ValidateBindJSONRequest(c, &req) {
+		return
+	}
+
+	role, err := ctrl.RoleModel.GetRoleByField("id", fmt.Sprintf("%d", req.RoleID))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Role not found"})
+		return
+	}
+
+	tier, err := ctrl.ApprovalModel.UpdateApprovalTier(uint(idUint), models.ApprovalTier{
+		Level:       req.Level,
+		Name:        req.Name,
+		AboveAmount: req.AboveAmount,
+		RoleID:      role.ID,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating approval tier: " + err.Error()})
+		return
+	}
+
+	tier.Role = *role
+	binders.ReturnJSONGeneralResponse(c, approvalTierResponse(tier))
+}
+
+func (ctrl *ApprovalTierController) DeleteApprovalTierController(c *gin.Context) {
+	id, valid := parameters.ConvertParamToValidID(c, "id")
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	idUint, err := strconv.ParseUint(string(id), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	if err := ctrl.ApprovalModel.DeleteApprovalTier(uint(idUint)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting approval tier: " + err.Error()})
+		return
+	}
+
+	binders.ReturnJSONOkayGenericResponse(c)
+}
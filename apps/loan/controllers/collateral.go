@@ -0,0 +1,246 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/kifangamukundi/gm/libs/binders"
+	"github.com/kifangamukundi/gm/libs/parameters"
+	"github.com/kifangamukundi/gm/loan/bindings"
+	"github.com/kifangamukundi/gm/loan/config"
+	"github.com/kifangamukundi/gm/loan/deserializers"
+	"github.com/kifangamukundi/gm/loan/handlers"
+	"github.com/kifangamukundi/gm/loan/models"
+
+	"github.com/cloudinary/cloudinary-go/v2"
+	"github.com/gin-gonic/gin"
+)
+
+type CollateralController struct {
+	CollateralModel  *models.CollateralModel
+	LoanModel        *models.LoanModel
+	CloudinaryConfig *config.CloudinaryConfig
+	Cloudinary       *cloudinary.Cloudinary
+}
+
+func NewCollateralController(collateralModel *models.CollateralModel, loanModel *models.LoanModel, cloudinaryConfig *config.CloudinaryConfig, cld *cloudinary.Cloudinary) *CollateralController {
+	return &CollateralController{
+		CollateralModel:  collateralModel,
+		LoanModel:        loanModel,
+		CloudinaryConfig: cloudinaryConfig,
+		Cloudinary:       cld,
+	}
+}
+
+// collateralErrorStatus maps collateral errors to the HTTP status the client should see
+func collateralErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, models.ErrCollateralLocked):
+		return http.StatusConflict
+	case errors.Is(err, models.ErrCollateralPledged):
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusInternalServerError
+}
+
+func collateralResponse(collateral models.Collateral) bindings.CollateralResponse {
+	return bindings.CollateralResponse{
+		ID:                 collateral.ID,
+		LoanID:             collateral.LoanID,
+		Type:               collateral.Type,
+		Description:        collateral.Description,
+		EstimatedValue:     collateral.EstimatedValue,
+		SerialNumber:       collateral.SerialNumber,
+		Images:             collateral.Images,
+		LienStatus:         collateral.LienStatus,
+		LienRegisteredAt:   collateral.LienRegisteredAt,
+		LienReleasedAt:     collateral.LienReleasedAt,
+		CreatedByFirstName: collateral.CreatedBy.FirstName,
+		CreatedByLastName:  collateral.CreatedBy.LastName,
+		CreatedAt:          collateral.CreatedAt,
+		UpdatedAt:          collateral.UpdatedAt,
+	}
+}
+
+// removeCollateralImages deletes photos that are no longer attached to any collateral. A
+// failure is only logged, the collateral change has already been saved.
+func (ctrl *CollateralController) removeCollateralImages(collateralId uint, removed []deserializers.DefaultImage) {
+	if len(removed) == 0 {
+		return
+	}
+	if err := handlers.RemoveAllImages(ctrl.CloudinaryConfig, ctrl.Cloudinary, removed); err != nil {
+		log.Printf("Error removing images of collateral %d: %v\n", collateralId, err)
+	}
+}
+
+// AddCollateralController records an asset pledged as security for a pending loan
+func (ctrl *CollateralController) AddCollateralController(c *gin.Context) {
+	id, valid := parameters.ConvertParamToValidID(c, "id")
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	var req bindings.CollateralRequest
+	if !binders.ValidateBindJSONRequest(c, &req) {
+		return
+	}
+
+	loan, err := ctrl.LoanModel.GetLoanByFieldPreloaded("id", string(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Loan not found"})
+		return
+	}
+
+	decodedUser, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+	u := decodedUser.(models.User)
+
+	collateral := models.Collateral{
+		LoanID:         loan.ID,
+		Type:           req.Type,
+		Description:    parameters.SanitizeText(req.Description, false),
+		EstimatedValue: req.EstimatedValue,
+		SerialNumber:   req.SerialNumber,
+		Images:         req.Images,
+		CreatedByID:    u.ID,
+	}
+
+	if err := ctrl.CollateralModel.AddCollateral(&collateral); err != nil {
+		c.JSON(collateralErrorStatus(err), gin.H{"error": "Error adding collateral: " + err.Error()})
+		return
+	}
+
+	collateral.CreatedBy = u
+	binders.ReturnJSONResponse(c, http.StatusCreated, true, gin.H{binders.ItemKey: collateralResponse(collateral)})
+}
+
+// GetLoanCollateralController lists the loan's collateral with how far its principal is
+// covered, next to the product's loan-to-value limit
+func (ctrl *CollateralController) GetLoanCollateralController(c *gin.Context) {
+	id, valid := parameters.ConvertParamToValidID(c, "id")
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	loan, err := ctrl.LoanModel.GetLoanByFieldPreloaded("id", string(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Loan not found"})
+		return
+	}
+
+	collateral, err := ctrl.CollateralModel.GetLoanCollateral(loan.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching collateral: " + err.Error()})
+		return
+	}
+
+	response := bindings.LoanCollateralResponse{
+		LoanID:     loan.ID,
+		Amount:     loan.Amount,
+		Collateral: make([]bindings.CollateralResponse, 0, len(collateral)),
+	}
+	if loan.Product != nil {
+		response.MaxLoanToValue = loan.Product.MaxLoanToValue
+	}
+
+	for _, item := range collateral {
+		if item.LienStatus != models.LienStatusReleased {
+			response.Value += item.EstimatedValue
+		}
+		response.Collateral = append(response.Collateral, collateralResponse(item))
+	}
+	if response.Value > 0 {
+		response.LoanToValue = float64(loan.Amount) / float64(response.Value) * 100
+	}
+
+	binders.ReturnJSONGeneralResponse(c, response)
+}
+
+func (ctrl *CollateralController) GetCollateralController(c *gin.Context) {
+	id, valid := parameters.ConvertParamToValidID(c, "id")
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	collateral, err := ctrl.CollateralModel.GetCollateralByField("id", string(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Collateral not found"})
+		return
+	}
+
+	binders.ReturnJSONGeneralResponse(c, collateralResponse(*collateral))
+}
+
+// UpdateCollateralController replaces the details of collateral while its loan is pending.
+// Photos left out of the request are deleted from storage.
+func (ctrl *CollateralController) UpdateCollateralController(c *gin.Context) {
+	id, valid := pathID(c)
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	var req bindings.CollateralRequest
+	if !binders.ValidateBindJSONRequest(c, &req) {
+		return
+	}
+
+	_, previous, err := ctrl.CollateralModel.UpdateCollateral(id, models.Collateral{
+		Type:           req.Type,
+		Description:    parameters.SanitizeText(req.Description, false),
+		EstimatedValue: req.EstimatedValue,
+		SerialNumber:   req.SerialNumber,
+		Images:         req.Images,
+	})
+	if err != nil {
+		c.JSON(collateralErrorStatus(err), gin.H{"error": "Error updating collateral: " + err.Error()})
+		return
+	}
+
+	kept := map[string]bool{}
+	for _, image := range uploadedImages(req.Images) {
+		kept[*image.PublicID] = true
+	}
+	removed := []deserializers.DefaultImage{}
+	for _, image := range uploadedImages(previous.Images) {
+		if !kept[*image.PublicID] {
+			removed = append(removed, image)
+		}
+	}
+	ctrl.removeCollateralImages(id, removed)
+
+	collateral, err := ctrl.CollateralModel.GetCollateralByField("id", strconv.FormatUint(uint64(id), 10))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching collateral: " + err.Error()})
+		return
+	}
+
+	binders.ReturnJSONGeneralResponse(c, collateralResponse(*collateral))
+}
+
+// DeleteCollateralController removes collateral, and its photos, from a pending loan
+func (ctrl *CollateralController) DeleteCollateralController(c *gin.Context) {
+	id, valid := pathID(c)
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	collateral, err := ctrl.CollateralModel.DeleteCollateral(id)
+	if err != nil {
+		c.JSON(collateralErrorStatus(err), gin.H{"error": "Error deleting collateral: " + err.Error()})
+		return
+	}
+
+	ctrl.removeCollateralImages(id, uploadedImages(collateral.Images))
+
+	binders.ReturnJSONGeneralResponse(c, collateralResponse(collateral))
+}
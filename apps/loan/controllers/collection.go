@@ -0,0 +1,202 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kifangamukundi/gm/libs/binders"
+	"github.com/kifangamukundi/gm/libs/parameters"
+	"github.com/kifangamukundi/gm/loan/bindings"
+	"github.com/kifangamukundi/gm/loan/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+type CollectionController struct {
+	CollectionModel *models.CollectionModel
+	UserModel       *models.UserModel
+}
+
+func NewCollectionController(collectionModel *models.CollectionModel, userModel *models.UserModel) *CollectionController {
+	return &CollectionController{CollectionModel: collectionModel, UserModel: userModel}
+}
+
+// collectionErrorStatus maps collection errors to the HTTP status the client should see
+func collectionErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, models.ErrNotLoanAgent):
+		return http.StatusForbidden
+	case errors.Is(err, models.ErrNotCollectable):
+		return http.StatusConflict
+	case errors.Is(err, models.ErrInvalidCollectionAction):
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusInternalServerError
+}
+
+func collectionActionResponse(action models.CollectionAction) bindings.CollectionActionResponse {
+	return bindings.CollectionActionResponse{
+		ID:                  action.ID,
+		LoanID:              action.LoanID,
+		InstalmentID:        action.InstalmentID,
+		AgentID:             action.AgentID,
+		Type:                action.Type,
+		Outcome:             action.Outcome,
+		PromisedAmount:      action.PromisedAmount,
+		PromisedDate:        action.PromisedDate,
+		Notes:               action.Notes,
+		RecordedByFirstName: action.RecordedBy.FirstName,
+		RecordedByLastName:  action.RecordedBy.LastName,
+		ContactedAt:         action.ContactedAt,
+	}
+}
+
+// currentAgent resolves the logged in user to their agent, or responds with the error
+func (ctrl *CollectionController) currentAgent(c *gin.Context) (*models.User, bool) {
+	decodedUser, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return nil, false
+	}
+	u := decodedUser.(models.User)
+
+	user, err := ctrl.UserModel.GetUserByFieldPreloaded("id", strconv.Itoa(int(u.ID)))
+	if err != nil || user.Agent == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Agent not found"})
+		return nil, false
+	}
+
+	return user, true
+}
+
+// GetWorklistController lists the instalments the logged in agent has to collect, due on or
+// before ?date= (today by default), grouped by group with the last contact on each loan
+func (ctrl *CollectionController) GetWorklistController(c *gin.Context) {
+	user, ok := ctrl.currentAgent(c)
+	if !ok {
+		return
+	}
+
+	date, ok := dateQuery(c, "date")
+	if !ok {
+		return
+	}
+	if date == nil {
+		now := time.Now()
+		date = &now
+	}
+
+	worklist, err := ctrl.CollectionModel.Worklist(user.Agent.ID, *date)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error building worklist: " + err.Error()})
+		return
+	}
+
+	response := bindings.WorklistResponse{
+		AgentID:   worklist.AgentID,
+		Date:      worklist.Date,
+		AmountDue: worklist.AmountDue,
+		Groups:    make([]bindings.CollectionGroupResponse, 0, len(worklist.Groups)),
+	}
+	for _, group := range worklist.Groups {
+		groupResponse := bindings.CollectionGroupResponse{
+			GroupID:   group.GroupID,
+			GroupName: group.GroupName,
+			AmountDue: group.AmountDue,
+			Items:     make([]bindings.CollectionItemResponse, 0, len(group.Items)),
+		}
+		for _, item := range group.Items {
+			itemResponse := bindings.CollectionItemResponse{
+				LoanID:       item.LoanID,
+				InstalmentID: item.InstalmentID,
+				Number:       item.Number,
+				MemberID:     item.MemberID,
+				FirstName:    item.FirstName,
+				LastName:     item.LastName,
+				MobileNumber: item.MobileNumber,
+				DueDate:      item.DueDate,
+				AmountDue:    item.AmountDue,
+				DaysPastDue:  item.DaysPastDue,
+			}
+			if item.LastContact != nil {
+				lastContact := collectionActionResponse(*item.LastContact)
+				itemResponse.LastContact = &lastContact
+			}
+			groupResponse.Items = append(groupResponse.Items, itemResponse)
+		}
+		response.Groups = append(response.Groups, groupResponse)
+	}
+
+	binders.ReturnJSONGeneralResponse(c, response)
+}
+
+// RecordCollectionActionController records a promise to pay or the outcome of a visit
+// against a loan in the logged in agent's portfolio
+func (ctrl *CollectionController) RecordCollectionActionController(c *gin.Context) {
+	id, valid := pathID(c)
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	var req bindings.CollectionActionRequest
+	if !binders.ValidateBindJSONRequest(c, &req) {
+		return
+	}
+
+	user, ok := ctrl.currentAgent(c)
+	if !ok {
+		return
+	}
+
+	action := models.CollectionAction{
+		LoanID:         id,
+		InstalmentID:   req.InstalmentID,
+		AgentID:        user.Agent.ID,
+		Type:           req.Type,
+		Outcome:        req.Outcome,
+		PromisedAmount: req.PromisedAmount,
+		Notes:          parameters.SanitizeText(req.Notes, false),
+		RecordedByID:   user.ID,
+	}
+	if req.PromisedDate != "" {
+		promisedDate, err := time.Parse("2006-01-02", req.PromisedDate)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid PromisedDate, expected YYYY-MM-DD"})
+			return
+		}
+		action.PromisedDate = &promisedDate
+	}
+
+	if err := ctrl.CollectionModel.RecordCollectionAction(&action); err != nil {
+		c.JSON(collectionErrorStatus(err), gin.H{"error": "Error recording collection action: " + err.Error()})
+		return
+	}
+
+	action.RecordedBy = *user
+	binders.ReturnJSONResponse(c, http.StatusCreated, true, gin.H{binders.ItemKey: collectionActionResponse(action)})
+}
+
+// GetLoanCollectionActionsController lists the promises and visits recorded on a loan, newest first
+func (ctrl *CollectionController) GetLoanCollectionActionsController(c *gin.Context) {
+	id, valid := pathID(c)
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	actions, err := ctrl.CollectionModel.GetLoanCollectionActions(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching collection actions: " + err.Error()})
+		return
+	}
+
+	response := make([]bindings.CollectionActionResponse, 0, len(actions))
+	for _, action := range actions {
+		response = append(response, collectionActionResponse(action))
+	}
+
+	binders.ReturnJSONGeneralResponse(c, response)
+}
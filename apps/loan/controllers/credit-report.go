@@ -0,0 +1,158 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/kifangamukundi/gm/libs/binders"
+	"github.com/kifangamukundi/gm/libs/parameters"
+	"github.com/kifangamukundi/gm/loan/bindings"
+	"github.com/kifangamukundi/gm/loan/bureau"
+	"github.com/kifangamukundi/gm/loan/config"
+	"github.com/kifangamukundi/gm/loan/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+type CreditReportController struct {
+	CreditReportModel *models.CreditReportModel
+	LoanModel         *models.LoanModel
+	Bureau            bureau.CreditBureau
+	BureauConfig      *config.BureauConfig
+}
+
+func NewCreditReportController(creditReportModel *models.CreditReportModel, loanModel *models.LoanModel, creditBureau bureau.CreditBureau, bureauConfig *config.BureauConfig) *CreditReportController {
+	return &CreditReportController{
+		CreditReportModel: creditReportModel,
+		LoanModel:         loanModel,
+		Bureau:            creditBureau,
+		BureauConfig:      bureauConfig,
+	}
+}
+
+func creditReportResponse(report models.CreditReport) bindings.CreditReportResponse {
+	response := bindings.CreditReportResponse{
+		ID:            report.ID,
+		LoanID:        report.LoanID,
+		MemberID:      report.MemberID,
+		Provider:      report.Provider,
+		Reference:     report.Reference,
+		ListingStatus: report.ListingStatus,
+		Score:         report.Score,
+		Listings:      make([]bindings.CreditListingResponse, 0, len(report.Listings)),
+		RetrievedAt:   report.RetrievedAt,
+	}
+
+	if report.RequestedBy != nil {
+		response.RequestedByFirstName = report.RequestedBy.FirstName
+		response.RequestedByLastName = report.RequestedBy.LastName
+	}
+
+	for _, listing := range report.Listings {
+		response.Listings = append(response.Listings, bindings.CreditListingResponse{
+			Lender:      listing.Lender,
+			Amount:      listing.Amount,
+			DaysPastDue: listing.DaysPastDue,
+			Status:      listing.Status,
+			ListedAt:    listing.ListedAt,
+		})
+	}
+
+	return response
+}
+
+// LookupCreditReportController asks the bureau about the borrower again, e.g. when the report
+// retrieved at approval is out of date
+func (ctrl *CreditReportController) LookupCreditReportController(c *gin.Context) {
+	id, valid := parameters.ConvertParamToValidID(c, "id")
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	loan, err := ctrl.LoanModel.GetLoanByFieldPreloaded("id", string(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Loan not found"})
+		return
+	}
+
+	decodedUser, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+	u := decodedUser.(models.User)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	report, err := ctrl.CreditReportModel.LookupLoan(ctx, ctrl.Bureau, loan.ID, &u.ID)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Error checking credit bureau: " + err.Error()})
+		return
+	}
+
+	report.RequestedBy = &u
+	binders.ReturnJSONResponse(c, http.StatusCreated, true, gin.H{binders.ItemKey: creditReportResponse(report)})
+}
+
+// GetLoanCreditReportsController lists the bureau reports retrieved for a loan, newest first
+func (ctrl *CreditReportController) GetLoanCreditReportsController(c *gin.Context) {
+	id, valid := parameters.ConvertParamToValidID(c, "id")
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	loan, err := ctrl.LoanModel.GetLoanByFieldPreloaded("id", string(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Loan not found"})
+		return
+	}
+
+	reports, err := ctrl.CreditReportModel.GetLoanCreditReports(loan.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching credit reports: " + err.Error()})
+		return
+	}
+
+	items := make([]bindings.CreditReportResponse, 0, len(reports))
+	for _, report := range reports {
+		items = append(items, creditReportResponse(report))
+	}
+
+	binders.ReturnJSONGeneralResponse(c, items)
+}
+
+// ExportBureauSubmissionController downloads the performance of every disbursed loan as at
+// ?asOf= (default today) in the bureau's batch format
+func (ctrl *CreditReportController) ExportBureauSubmissionController(c *gin.Context) {
+	asOf, ok := dateQuery(c, "asOf")
+	if !ok {
+		return
+	}
+
+	if asOf == nil {
+		now := time.Now()
+		asOf = &now
+	}
+
+	batch, err := ctrl.CreditReportModel.SubmissionBatch(ctrl.BureauConfig.InstitutionCode, *asOf)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error building bureau submission: " + err.Error()})
+		return
+	}
+
+	var out bytes.Buffer
+	if err := ctrl.Bureau.WriteSubmission(&out, batch); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error writing bureau submission: " + err.Error()})
+		return
+	}
+
+	filename := fmt.Sprintf("%s-%s.txt", ctrl.BureauConfig.InstitutionCode, asOf.Format("20060102"))
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Data(http.StatusOK, "text/plain; charset=utf-8", out.Bytes())
+}
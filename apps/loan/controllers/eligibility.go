@@ -0,0 +1,93 @@
+package controllers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/kifangamukundi/gm/libs/binders"
+	"github.com/kifangamukundi/gm/libs/parameters"
+	"github.com/kifangamukundi/gm/loan/bindings"
+	"github.com/kifangamukundi/gm/loan/models"
+	"github.com/kifangamukundi/gm/loan/money"
+
+	"github.com/gin-gonic/gin"
+)
+
+type EligibilityController struct {
+	EligibilityModel *models.EligibilityModel
+	MemberModel      *models.MemberModel
+	ProductModel     *models.LoanProductModel
+}
+
+func NewEligibilityController(eligibilityModel *models.EligibilityModel, memberModel *models.MemberModel, productModel *models.LoanProductModel) *EligibilityController {
+	return &EligibilityController{EligibilityModel: eligibilityModel, MemberModel: memberModel, ProductModel: productModel}
+}
+
+func eligibilityResponse(productId uint, eligibility models.Eligibility) bindings.EligibilityResponse {
+	return bindings.EligibilityResponse{
+		CreditScoreResponse: bindings.CreditScoreResponse{
+			MemberID:         eligibility.MemberID,
+			Score:            eligibility.Score,
+			OnTimeRate:       eligibility.OnTimeRate,
+			InstalmentsDue:   eligibility.InstalmentsDue,
+			MaxDaysInArrears: eligibility.MaxDaysInArrears,
+			CompletedCycles:  eligibility.CompletedCycles,
+			OpenLoans:        eligibility.OpenLoans,
+			LoansInArrears:   eligibility.LoansInArrears,
+		},
+		ProductID:      productId,
+		Amount:         eligibility.Amount,
+		MaxAmount:      eligibility.MaxAmount,
+		LadderLimit:    eligibility.LadderLimit,
+		SavingsBalance: eligibility.SavingsBalance,
+		SavingsLimit:   eligibility.SavingsLimit,
+		Eligible:       eligibility.Eligible,
+		Reasons:        eligibility.Reasons,
+	}
+}
+
+// GetMemberEligibilityController scores the member and tells the agent whether they may
+// borrow ?amount= on ?productId=, and why not. Without an amount it only reports the largest
+// loan the member may take on the product.
+func (ctrl *EligibilityController) GetMemberEligibilityController(c *gin.Context) {
+	id, valid := parameters.ConvertParamToValidID(c, "id")
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	member, err := ctrl.MemberModel.GetMemberByField("id", string(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+		return
+	}
+
+	productId := c.Query("productId")
+	if productId == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "productId is required"})
+		return
+	}
+
+	product, err := ctrl.ProductModel.GetLoanProductByField("id", productId)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Loan product not found"})
+		return
+	}
+
+	amount := money.Zero
+	if value := c.Query("amount"); value != "" {
+		amount, err = money.Parse(value)
+		if err != nil || amount < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid amount"})
+			return
+		}
+	}
+
+	eligibility, err := ctrl.EligibilityModel.CheckEligibility(member.ID, product, amount, time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking eligibility: " + err.Error()})
+		return
+	}
+
+	binders.ReturnJSONGeneralResponse(c, eligibilityResponse(product.ID, eligibility))
+}
@@ -0,0 +1,244 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kifangamukundi/gm/libs/binders"
+	"github.com/kifangamukundi/gm/libs/parameters"
+	"github.com/kifangamukundi/gm/loan/bindings"
+	"github.com/kifangamukundi/gm/loan/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+type GuaranteeController struct {
+	GuaranteeModel *models.GuaranteeModel
+	LoanModel      *models.LoanModel
+}
+
+func NewGuaranteeController(guaranteeModel *models.GuaranteeModel, loanModel *models.LoanModel) *GuaranteeController {
+	return &GuaranteeController{GuaranteeModel: guaranteeModel, LoanModel: loanModel}
+}
+
+// guaranteeErrorStatus maps guarantee errors to the HTTP status the client should see
+func guaranteeErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, models.ErrNotOwnGuarantee):
+		return http.StatusForbidden
+	case errors.Is(err, models.ErrGuaranteeNotPending):
+		return http.StatusConflict
+	case errors.Is(err, models.ErrNotGuarantor), errors.Is(err, models.ErrGuaranteeNotCallable), errors.Is(err, models.ErrInsufficientSavings):
+		return http.StatusUnprocessableEntity
+	}
+	return transitionErrorStatus(err)
+}
+
+// guaranteeResponse maps a guarantee and what was recovered on it to their API representation
+func guaranteeResponse(guarantee models.LoanGuarantee) bindings.GuaranteeResponse {
+	response := bindings.GuaranteeResponse{
+		ID:                 guarantee.ID,
+		LoanID:             guarantee.LoanID,
+		GuarantorID:        guarantee.GuarantorID,
+		GuarantorFirstName: guarantee.Guarantor.User.FirstName,
+		GuarantorLastName:  guarantee.Guarantor.User.LastName,
+		PledgeType:         guarantee.PledgeType,
+		Amount:             guarantee.Amount,
+		Status:             guarantee.Status,
+		Recovered:          guarantee.Recovered,
+		RespondedAt:        guarantee.RespondedAt,
+		DeclineReason:      guarantee.DeclineReason,
+		CreatedAt:          guarantee.CreatedAt,
+		Recoveries:         make([]bindings.GuaranteeRecoveryResponse, 0, len(guarantee.Recoveries)),
+	}
+
+	for _, recovery := range guarantee.Recoveries {
+		response.Recoveries = append(response.Recoveries, guaranteeRecoveryResponse(recovery))
+	}
+
+	return response
+}
+
+func guaranteeRecoveryResponse(recovery models.GuaranteeRecovery) bindings.GuaranteeRecoveryResponse {
+	return bindings.GuaranteeRecoveryResponse{
+		ID:          recovery.ID,
+		Amount:      recovery.Amount,
+		PaymentID:   recovery.PaymentID,
+		Reference:   recovery.Reference,
+		RecoveredAt: recovery.RecoveredAt,
+	}
+}
+
+// AddGuaranteeController asks a member of the borrower's group to guarantee a pending loan.
+// The loan cannot be approved until the guarantor accepts or declines.
+func (ctrl *GuaranteeController) AddGuaranteeController(c *gin.Context) {
+	id, valid := parameters.ConvertParamToValidID(c, "id")
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	var req bindings.AddGuaranteeRequest
+	if !binders.ValidateBindJSONRequest(c, &req) {
+		return
+	}
+
+	loan, err := ctrl.LoanModel.GetLoanByFieldPreloaded("id", string(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Loan not found"})
+		return
+	}
+
+	decodedUser, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+	u := decodedUser.(models.User)
+
+	guarantee := models.LoanGuarantee{
+		LoanID:      loan.ID,
+		GuarantorID: req.GuarantorID,
+		PledgeType:  req.PledgeType,
+		Amount:      req.Amount,
+		CreatedByID: u.ID,
+	}
+
+	if err := ctrl.GuaranteeModel.AddGuarantee(&guarantee); err != nil {
+		c.JSON(guaranteeErrorStatus(err), gin.H{"error": "Error adding guarantee: " + err.Error()})
+		return
+	}
+
+	created, err := ctrl.GuaranteeModel.GetGuaranteeByField("id", strconv.FormatUint(uint64(guarantee.ID), 10))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching guarantee: " + err.Error()})
+		return
+	}
+
+	binders.ReturnJSONResponse(c, http.StatusCreated, true, gin.H{binders.ItemKey: guaranteeResponse(*created)})
+}
+
+// GetLoanGuaranteesController lists the pledges made on a loan
+func (ctrl *GuaranteeController) GetLoanGuaranteesController(c *gin.Context) {
+	id, valid := parameters.ConvertParamToValidID(c, "id")
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	loan, err := ctrl.LoanModel.GetLoanByFieldPreloaded("id", string(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Loan not found"})
+		return
+	}
+
+	guarantees, err := ctrl.GuaranteeModel.GetLoanGuarantees(loan.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching guarantees: " + err.Error()})
+		return
+	}
+
+	items := make([]bindings.GuaranteeResponse, 0, len(guarantees))
+	for _, guarantee := range guarantees {
+		items = append(items, guaranteeResponse(guarantee))
+	}
+
+	binders.ReturnJSONGeneralResponse(c, items)
+}
+
+// AcceptGuaranteeController lets the guarantor accept a pledge made in their name
+func (ctrl *GuaranteeController) AcceptGuaranteeController(c *gin.Context) {
+	ctrl.respondToGuarantee(c, true)
+}
+
+// DeclineGuaranteeController lets the guarantor decline a pledge made in their name
+func (ctrl *GuaranteeController) DeclineGuaranteeController(c *gin.Context) {
+	ctrl.respondToGuarantee(c, false)
+}
+
+func (ctrl *GuaranteeController) respondToGuarantee(c *gin.Context, accept bool) {
+	id, valid := pathID(c)
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	var req bindings.LoanStatusReasonRequest
+	if c.Request.ContentLength > 0 && !binders.ValidateBindJSONRequest(c, &req) {
+		return
+	}
+
+	decodedUser, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+	u := decodedUser.(models.User)
+
+	if _, err := ctrl.GuaranteeModel.RespondToGuarantee(id, u.ID, accept, parameters.SanitizeText(req.Reason, false)); err != nil {
+		c.JSON(guaranteeErrorStatus(err), gin.H{"error": "Error responding to guarantee: " + err.Error()})
+		return
+	}
+
+	guarantee, err := ctrl.GuaranteeModel.GetGuaranteeByField("id", strconv.FormatUint(uint64(id), 10))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching guarantee: " + err.Error()})
+		return
+	}
+
+	binders.ReturnJSONGeneralResponse(c, guaranteeResponse(*guarantee))
+}
+
+// RemoveGuaranteeController removes a pledge from a pending loan, e.g. one the guarantor
+// declined, so the loan can be approved or another guarantor asked
+func (ctrl *GuaranteeController) RemoveGuaranteeController(c *gin.Context) {
+	id, valid := pathID(c)
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	if _, err := ctrl.GuaranteeModel.GetGuaranteeByField("id", strconv.FormatUint(uint64(id), 10)); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Guarantee not found"})
+		return
+	}
+
+	if err := ctrl.GuaranteeModel.RemoveGuarantee(id); err != nil {
+		c.JSON(guaranteeErrorStatus(err), gin.H{"error": "Error removing guarantee: " + err.Error()})
+		return
+	}
+
+	binders.ReturnJSONOkayGenericResponse(c)
+}
+
+// RecoverGuaranteeController records money collected from the guarantor of a defaulted loan
+// and applies it to the loan
+func (ctrl *GuaranteeController) RecoverGuaranteeController(c *gin.Context) {
+	id, valid := pathID(c)
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	var req bindings.RecoverGuaranteeRequest
+	if !binders.ValidateBindJSONRequest(c, &req) {
+		return
+	}
+
+	decodedUser, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+	u := decodedUser.(models.User)
+
+	recovery, err := ctrl.GuaranteeModel.RecoverFromGuarantor(id, req.Amount, req.Reference, u.ID, time.Now())
+	if err != nil {
+		c.JSON(guaranteeErrorStatus(err), gin.H{"error": "Error recovering from guarantor: " + err.Error()})
+		return
+	}
+
+	binders.ReturnJSONResponse(c, http.StatusCreated, true, gin.H{binders.ItemKey: guaranteeRecoveryResponse(recovery)})
+}
@@ -0,0 +1,205 @@
+package controllers
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/kifangamukundi/gm/libs/binders"
+	"github.com/kifangamukundi/gm/libs/parameters"
+	"github.com/kifangamukundi/gm/loan/bindings"
+	"github.com/kifangamukundi/gm/loan/models"
+	"github.com/kifangamukundi/gm/loan/money"
+
+	"github.com/gin-gonic/gin"
+)
+
+type LedgerController struct {
+	LedgerModel *models.LedgerModel
+}
+
+func NewLedgerController(ledgerModel *models.LedgerModel) *LedgerController {
+	return &LedgerController{LedgerModel: ledgerModel}
+}
+
+func accountResponse(account models.Account) bindings.AccountResponse {
+	return bindings.AccountResponse{
+		ID:       account.ID,
+		Code:     account.Code,
+		Name:     account.Name,
+		Type:     account.Type,
+		IsActive: account.IsActive,
+	}
+}
+
+// dateQuery reads an optional YYYY-MM-DD query parameter
+func dateQuery(c *gin.Context, name string) (*time.Time, bool) {
+	value := c.Query(name)
+	if value == "" {
+		return nil, true
+	}
+
+	date, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + name + " date, expected YYYY-MM-DD"})
+		return nil, false
+	}
+
+	return &date, true
+}
+
+// nextDay returns the start of the day after date so that date itself is included in a range
+func nextDay(date *time.Time) *time.Time {
+	if date == nil {
+		return nil
+	}
+	end := date.AddDate(0, 0, 1)
+	return &end
+}
+
+func (ctrl *LedgerController) GetAccountsController(c *gin.Context) {
+	accounts, err := ctrl.LedgerModel.GetAccounts()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].Code < accounts[j].Code })
+
+	response := make([]bindings.AccountResponse, 0, len(accounts))
+	for _, account := range accounts {
+		response = append(response, accountResponse(account))
+	}
+
+	binders.ReturnJSONGeneralResponse(c, response)
+}
+
+// GetTrialBalanceController lists the debit or credit balance of every account from postings
+// dated up to and including asOf, which defaults to today.
+func (ctrl *LedgerController) GetTrialBalanceController(c *gin.Context) {
+	asOf, ok := dateQuery(c, "asOf")
+	if !ok {
+		return
+	}
+	if asOf == nil {
+		today := time.Now().UTC().Truncate(24 * time.Hour)
+		asOf = &today
+	}
+
+	accounts, err := ctrl.LedgerModel.GetAccounts()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	totals, err := ctrl.LedgerModel.GetAccountTotals(nil, nextDay(asOf))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].Code < accounts[j].Code })
+
+	response := bindings.TrialBalanceResponse{AsOf: *asOf, Accounts: []bindings.TrialBalanceLine{}}
+	for _, account := range accounts {
+		total, posted := totals[account.ID]
+		if !posted {
+			continue
+		}
+
+		line := bindings.TrialBalanceLine{
+			AccountID: account.ID,
+			Code:      account.Code,
+			Name:      account.Name,
+			Type:      account.Type,
+		}
+
+		net := total.Debit - total.Credit
+		if net >= 0 {
+			line.Debit = net
+		} else {
+			line.Credit = -net
+		}
+
+		response.TotalDebit += line.Debit
+		response.TotalCredit += line.Credit
+		response.Accounts = append(response.Accounts, line)
+	}
+	response.IsBalanced = response.TotalDebit == response.TotalCredit
+
+	binders.ReturnJSONGeneralResponse(c, response)
+}
+
+// GetAccountStatementController lists an account's postings between from and to inclusive
+// with a running balance in the account's normal direction.
+func (ctrl *LedgerController) GetAccountStatementController(c *gin.Context) {
+	id, valid := parameters.ConvertParamToValidID(c, "id")
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	from, ok := dateQuery(c, "from")
+	if !ok {
+		return
+	}
+	to, ok := dateQuery(c, "to")
+	if !ok {
+		return
+	}
+	if from != nil && to != nil && to.Before(*from) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "to must not be before from"})
+		return
+	}
+
+	account, err := ctrl.LedgerModel.GetAccountByField("id", string(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+		return
+	}
+
+	opening := money.Zero
+	if from != nil {
+		totals, err := ctrl.LedgerModel.GetAccountTotals(nil, from)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		total := totals[account.ID]
+		opening = account.Balance(total.Debit, total.Credit)
+	}
+
+	postings, err := ctrl.LedgerModel.GetAccountPostings(account.ID, from, nextDay(to))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	balance := opening
+	lines := make([]bindings.StatementLine, 0, len(postings))
+	for _, posting := range postings {
+		balance += account.Balance(posting.Debit, posting.Credit)
+		lines = append(lines, bindings.StatementLine{
+			EntryDate:   posting.JournalEntry.EntryDate,
+			EntryID:     posting.JournalEntryID,
+			SourceType:  posting.JournalEntry.SourceType,
+			LoanID:      posting.JournalEntry.LoanID,
+			Reference:   posting.JournalEntry.Reference,
+			Description: posting.JournalEntry.Description,
+			Debit:       posting.Debit,
+			Credit:      posting.Credit,
+			Balance:     balance,
+		})
+	}
+
+	response := bindings.AccountStatementResponse{
+		Account:        accountResponse(*account),
+		From:           from,
+		To:             to,
+		OpeningBalance: opening,
+		Lines:          lines,
+		ClosingBalance: balance,
+	}
+
+	binders.ReturnJSONGeneralResponse(c, response)
+}
@@ -0,0 +1,278 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/kifangamukundi/gm/libs/binders"
+	"github.com/kifangamukundi/gm/libs/parameters"
+	"github.com/kifangamukundi/gm/libs/queryparams"
+	"github.com/kifangamukundi/gm/libs/transformations"
+	"github.com/kifangamukundi/gm/loan/bindings"
+	"github.com/kifangamukundi/gm/loan/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+type LoanProductController struct {
+	LoanProductModel *models.LoanProductModel
+}
+
+func NewLoanProductController(loanProductModel *models.LoanProductModel) *LoanProductController {
+	return &LoanProductController{LoanProductModel: loanProductModel}
+}
+
+func loanProductResponse(product models.LoanProduct) bindings.LoanProductResponse {
+	return bindings.LoanProductResponse{
+		ID:                 product.ID,
+		ProductName:        product.ProductName,
+		InterestMethod:     product.InterestMethod,
+		InterestRate:       product.InterestRate,
+		RatePerPeriod:      product.RatePerPeriod,
+		MinAmount:          product.MinAmount,
+		MaxAmount:          product.MaxAmount,
+		MinTerm:            product.MinTerm,
+		MaxTerm:            product.MaxTerm,
+		RepaymentFrequency: product.RepaymentFrequency,
+		ProcessingFee:      product.ProcessingFee,
+		FeePercentage:      product.FeePercentage,
+		GracePeriod:        product.GracePeriod,
+		IsActive:           product.IsActive,
+		AllocationOrder:    product.AllocationOrder,
+		PenaltyType:        product.PenaltyType,
+		PenaltyAmount:      product.PenaltyAmount,
+		PenaltyPercent:     product.PenaltyPercent,
+		PenaltyCap:         product.PenaltyCap,
+		PenaltyGraceDays:   product.PenaltyGraceDays,
+		ArrearsAfterDays:   product.ArrearsAfterDays,
+		SettlementRebate:   product.SettlementRebate,
+		WriteOffAfterDays:  product.WriteOffAfterDays,
+		MaxLoanToValue:     product.MaxLoanToValue,
+		LadderStartAmount:  product.LadderStartAmount,
+		LadderStepPercent:  product.LadderStepPercent,
+		SavingsMultiplier:  product.SavingsMultiplier,
+		CreatedAt:          product.CreatedAt,
+		UpdatedAt:          product.UpdatedAt,
+	}
+}
+
+// penaltyType defaults products that do not ask for a penalty to none
+func penaltyType(value string) string {
+	if value == "" {
+		return models.PenaltyTypeNone
+	}
+	return value
+}
+
+// arrearsAfterDays defaults products that do not say when a loan falls into arrears to the
+// day after an instalment is missed
+func arrearsAfterDays(value *int) int {
+	if value == nil {
+		return models.DefaultArrearsAfterDays
+	}
+	return *value
+}
+
+func (ctrl *LoanProductController) CreateLoanProductController(c *gin.Context) {
+	var req bindings.CreateLoanProductRequest
+	if !binders.ValidateBindJSONRequest(c, &req) {
+		return
+	}
+
+	order, err := models.ParseAllocationOrder(req.AllocationOrder)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	product := models.LoanProduct{
+		ProductName:        req.ProductName,
+		InterestMethod:     req.InterestMethod,
+		InterestRate:       req.InterestRate,
+		MinAmount:          req.MinAmount,
+		MaxAmount:          req.MaxAmount,
+		MinTerm:            req.MinTerm,
+		MaxTerm:            req.MaxTerm,
+		RepaymentFrequency: req.RepaymentFrequency,
+		ProcessingFee:      req.ProcessingFee,
+		FeePercentage:      req.FeePercentage,
+		GracePeriod:        req.GracePeriod,
+		IsActive:           true,
+		AllocationOrder:    strings.Join(order, ","),
+		PenaltyType:        penaltyType(req.PenaltyType),
+		PenaltyAmount:      req.PenaltyAmount,
+		PenaltyPercent:     req.PenaltyPercent,
+		PenaltyCap:         req.PenaltyCap,
+		PenaltyGraceDays:   req.PenaltyGraceDays,
+		ArrearsAfterDays:   arrearsAfterDays(req.ArrearsAfterDays),
+		SettlementRebate:   req.SettlementRebate,
+		WriteOffAfterDays:  req.WriteOffAfterDays,
+		MaxLoanToValue:     req.MaxLoanToValue,
+		LadderStartAmount:  req.LadderStartAmount,
+		LadderStepPercent:  req.LadderStepPercent,
+		SavingsMultiplier:  req.SavingsMultiplier,
+	}
+
+	if err := ctrl.LoanProductModel.CreateLoanProduct(&product); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	binders.ReturnJSONCreatedGenericResponse(c)
+}
+
+func (ctrl *LoanProductController) GetLoanProductsController(c *gin.Context) {
+	page, limit, skip, sortOrder, sortByColumn, searchRegex, filterCriteria, err := queryparams.ExtractPaginationParams(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	products, totalCount, count, err := ctrl.LoanProductModel.GetLoanProducts(skip, limit, sortOrder, sortByColumn, searchRegex, filterCriteria)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching loan products: " + err.Error()})
+		return
+	}
+
+	fieldNames := []string{
+		"id",
+		"title",
+		"method",
+		"rate",
+		"frequency",
+		"active",
+	}
+
+	transformedProducts := transformations.Transform(products, fieldNames,
+		func(product models.LoanProduct) interface{} { return product.ID },
+		func(product models.LoanProduct) interface{} { return product.ProductName },
+		func(product models.LoanProduct) interface{} { return product.InterestMethod },
+		func(product models.LoanProduct) interface{} { return product.InterestRate },
+		func(product models.LoanProduct) interface{} { return product.RepaymentFrequency },
+		func(product models.LoanProduct) interface{} { return product.IsActive },
+	)
+
+	binders.ReturnJSONPaginateResponse(c, page, limit, int(totalCount), int(count), transformedProducts)
+}
+
+func (ctrl *LoanProductController) GetLoanProductByIdController(c *gin.Context) {
+	id, valid := parameters.ConvertParamToValidID(c, "id")
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	product, err := ctrl.LoanProductModel.GetLoanProductByField("id", string(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Loan product not found"})
+		return
+	}
+
+	binders.ReturnJSONGeneralResponse(c, loanProductResponse(*product))
+}
+
+func (ctrl *LoanProductController) UpdateLoanProductController(c *gin.Context) {
+	id, valid := parameters.ConvertParamToValidID(c, "id")
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	idInt, err := strconv.Atoi(string(id))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	var req bindings.UpdateLoanProductRequest
+	if !binders.ValidateBindJSONRequest(c, &req) {
+		return
+	}
+
+	allocationOrder := ""
+	if req.AllocationOrder != "" {
+		order, err := models.ParseAllocationOrder(req.AllocationOrder)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		allocationOrder = strings.Join(order, ",")
+	}
+
+	product, err := ctrl.LoanProductModel.UpdateLoanProduct(idInt, models.LoanProduct{
+		ProductName:        req.ProductName,
+		InterestMethod:     req.InterestMethod,
+		InterestRate:       req.InterestRate,
+		MinAmount:          req.MinAmount,
+		MaxAmount:          req.MaxAmount,
+		MinTerm:            req.MinTerm,
+		MaxTerm:            req.MaxTerm,
+		RepaymentFrequency: req.RepaymentFrequency,
+		ProcessingFee:      req.ProcessingFee,
+		FeePercentage:      req.FeePercentage,
+		GracePeriod:        req.GracePeriod,
+		AllocationOrder:    allocationOrder,
+		PenaltyType:        penaltyType(req.PenaltyType),
+		PenaltyAmount:      req.PenaltyAmount,
+		PenaltyPercent:     req.PenaltyPercent,
+		PenaltyCap:         req.PenaltyCap,
+		PenaltyGraceDays:   req.PenaltyGraceDays,
+		ArrearsAfterDays:   arrearsAfterDays(req.ArrearsAfterDays),
+		SettlementRebate:   req.SettlementRebate,
+		WriteOffAfterDays:  req.WriteOffAfterDays,
+		MaxLoanToValue:     req.MaxLoanToValue,
+		LadderStartAmount:  req.LadderStartAmount,
+		LadderStepPercent:  req.LadderStepPercent,
+		SavingsMultiplier:  req.SavingsMultiplier,
+	}, req.IsActive)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating loan product: " + err.Error()})
+		return
+	}
+
+	binders.ReturnJSONGeneralResponse(c, loanProductResponse(product))
+}
+
+func (ctrl *LoanProductController) DeleteLoanProductController(c *gin.Context) {
+	id, valid := parameters.ConvertParamToValidID(c, "id")
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	idUint, err := strconv.ParseUint(string(id), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	err = ctrl.LoanProductModel.DeleteLoanProduct(uint(idUint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting loan product: " + err.Error()})
+		return
+	}
+
+	binders.ReturnJSONOkayGenericResponse(c)
+}
+
+// Get all active products formatted in {id, title} for the loan application form
+func (ctrl *LoanProductController) GetAllLoanProductsController(c *gin.Context) {
+	products, err := ctrl.LoanProductModel.GetActiveLoanProducts()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting loan products: " + err.Error()})
+		return
+	}
+
+	fieldNames := []string{
+		"id",
+		"title",
+	}
+
+	transformedProducts := transformations.Transform(products, fieldNames,
+		func(product models.LoanProduct) interface{} { return product.ID },
+		func(product models.LoanProduct) interface{} { return product.ProductName },
+	)
+
+	binders.ReturnJSONGeneralResponse(c, transformedProducts)
+}
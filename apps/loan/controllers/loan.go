@@ -2,10 +2,10 @@ package controllers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
-	"os"
 	"strconv"
 	"time"
 
@@ -14,34 +14,75 @@ import (
 	"github.com/kifangamukundi/gm/libs/queryparams"
 	"github.com/kifangamukundi/gm/libs/transformations"
 	"github.com/kifangamukundi/gm/loan/bindings"
+	"github.com/kifangamukundi/gm/loan/bureau"
+	"github.com/kifangamukundi/gm/loan/config"
+	"github.com/kifangamukundi/gm/loan/deserializers"
+	"github.com/kifangamukundi/gm/loan/handlers"
 	"github.com/kifangamukundi/gm/loan/models"
+	"github.com/kifangamukundi/gm/loan/money"
+	"github.com/kifangamukundi/gm/loan/payments"
+	"github.com/kifangamukundi/gm/loan/services"
 
+	"github.com/cloudinary/cloudinary-go/v2"
 	"github.com/gin-gonic/gin"
-	"github.com/jwambugu/mpesa-golang-sdk"
 )
 
 type LoanController struct {
 	LoanModel     *models.LoanModel
 	DisburseModel *models.DisburseModel
+	ScheduleModel *models.ScheduleModel
+	PaymentModel  *models.PaymentModel
+	ProductModel  *models.LoanProductModel
 	UserModel     *models.UserModel
 	OfficerModel  *models.OfficerModel
 	AgentModel    *models.AgentModel
 	GroupModel    *models.GroupModel
 	MemberModel   *models.MemberModel
+	Gateway       payments.PaymentGateway
+
+	DisbursementJobModel *models.DisbursementJobModel
+	ApprovalModel        *models.ApprovalModel
+
+	// Storage for the images uploaded with a loan
+	CloudinaryConfig *config.CloudinaryConfig
+	Cloudinary       *cloudinary.Cloudinary
+
+	// Credit reference bureau checked when a loan is first signed off
+	Bureau bureau.CreditBureau
 }
 
-func NewLoanController(loanModel *models.LoanModel, disburseModel *models.DisburseModel, userModel *models.UserModel, officerModel *models.OfficerModel, agentModel *models.AgentModel, groupModel *models.GroupModel, memberModel *models.MemberModel) *LoanController {
+func NewLoanController(loanModel *models.LoanModel, disburseModel *models.DisburseModel, scheduleModel *models.ScheduleModel, paymentModel *models.PaymentModel, productModel *models.LoanProductModel, userModel *models.UserModel, officerModel *models.OfficerModel, agentModel *models.AgentModel, groupModel *models.GroupModel, memberModel *models.MemberModel, disbursementJobModel *models.DisbursementJobModel, approvalModel *models.ApprovalModel, gateway payments.PaymentGateway, cloudinaryConfig *config.CloudinaryConfig, cld *cloudinary.Cloudinary, creditBureau bureau.CreditBureau) *LoanController {
 	return &LoanController{
 		LoanModel:     loanModel,
 		DisburseModel: disburseModel,
+		ScheduleModel: scheduleModel,
+		PaymentModel:  paymentModel,
+		ProductModel:  productModel,
 		UserModel:     userModel,
 		OfficerModel:  officerModel,
 		AgentModel:    agentModel,
 		GroupModel:    groupModel,
 		MemberModel:   memberModel,
+		Gateway:       gateway,
+
+		DisbursementJobModel: disbursementJobModel,
+		ApprovalModel:        approvalModel,
+
+		CloudinaryConfig: cloudinaryConfig,
+		Cloudinary:       cld,
+
+		Bureau: creditBureau,
 	}
 }
 
+// transitionErrorStatus maps state machine violations and lost races to 409 and everything else to 500
+func transitionErrorStatus(err error) int {
+	if errors.Is(err, models.ErrIllegalTransition) || errors.Is(err, models.ErrConcurrentTransition) {
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
 func (ctrl *LoanController) CreateLoanController(c *gin.Context) {
 	var req bindings.CreateLoanRequest
 	if !binders.ValidateBindJSONRequest(c, &req) {
@@ -74,20 +115,45 @@ func (ctrl *LoanController) CreateLoanController(c *gin.Context) {
 		return
 	}
 
-	description := parameters.SanitizeText(*req.LoanPurpose, false)
+	product, err := ctrl.ProductModel.GetLoanProductByField("id", strconv.Itoa(req.ProductID))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Loan product not found"})
+		return
+	}
 
-	const InterestRate float64 = 10.0
+	if err := product.ValidateTerms(req.Amount, req.Term); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+
+	eligibility, err := models.NewEligibilityModel(ctrl.LoanModel.Service).CheckEligibility(member.ID, product, req.Amount, time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking eligibility: " + err.Error()})
+		return
+	}
+	if !eligibility.Eligible {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Member is not eligible for this loan", "reasons": eligibility.Reasons})
+		return
+	}
+
+	description := parameters.SanitizeText(*req.LoanPurpose, false)
 
 	newLoan := models.Loan{
-		AgentID:      agent.ID,
-		Amount:       req.Amount,
-		Interest:     InterestRate,
-		Term:         req.Term,
-		LoanPurpose:  &description,
-		DefaultImage: req.DefaultImage,
-		Images:       req.Images,
-		GroupID:      group.ID,
-		MemberID:     member.ID,
+		AgentID:            agent.ID,
+		Amount:             req.Amount,
+		Interest:           product.InterestRate,
+		RatePerPeriod:      product.RatePerPeriod,
+		Term:               req.Term,
+		ProductID:          &product.ID,
+		InterestMethod:     product.InterestMethod,
+		RepaymentFrequency: product.RepaymentFrequency,
+		Fees:               product.CalculateFees(req.Amount),
+		GracePeriod:        product.GracePeriod,
+		LoanPurpose:        &description,
+		DefaultImage:       req.DefaultImage,
+		Images:             req.Images,
+		GroupID:            group.ID,
+		MemberID:           member.ID,
 	}
 
 	if err := ctrl.LoanModel.CreateLoan(&newLoan); err != nil {
@@ -232,11 +298,48 @@ func (ctrl *LoanController) GetLoanByIdController(c *gin.Context) {
 		return
 	}
 
+	productName := ""
+	if loan.Product != nil {
+		productName = loan.Product.ProductName
+	}
+
+	history, err := ctrl.LoanModel.GetLoanStatusHistory(loan.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching status history: " + err.Error()})
+		return
+	}
+
+	creditReport, err := models.NewCreditReportModel(ctrl.LoanModel.Service).LatestLoanCreditReport(loan.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching credit report: " + err.Error()})
+		return
+	}
+
+	statusHistory := make([]bindings.LoanStatusHistoryResponse, 0, len(history))
+	for _, entry := range history {
+		item := bindings.LoanStatusHistoryResponse{
+			FromStatus: entry.FromStatus,
+			ToStatus:   entry.ToStatus,
+			Reason:     entry.Reason,
+			CreatedAt:  entry.CreatedAt,
+		}
+		if entry.Actor != nil {
+			item.ActorFirstName = entry.Actor.FirstName
+			item.ActorLastName = entry.Actor.LastName
+		}
+		statusHistory = append(statusHistory, item)
+	}
+
 	response := bindings.LoanResponse{
 		ID:               loan.ID,
 		Amount:           loan.Amount,
 		Interest:         loan.Interest,
+		RatePerPeriod:    loan.RatePerPeriod,
 		Term:             loan.Term,
+		InterestMethod:   loan.InterestMethod,
+		Frequency:        loan.RepaymentFrequency,
+		ProductName:      productName,
+		Fees:             loan.Fees,
 		DefaultImage:     loan.DefaultImage,
 		Images:           loan.Images,
 		LoanPurpose:      loan.LoanPurpose,
@@ -249,8 +352,68 @@ func (ctrl *LoanController) GetLoanByIdController(c *gin.Context) {
 		GroupName:        group.GroupName,
 		MemberFirstName:  member.User.FirstName,
 		MemberLastName:   member.User.LastName,
+		StatusHistory:    statusHistory,
 		CreatedAt:        loan.CreatedAt,
 		UpdatedAt:        loan.UpdatedAt,
+
+		RefinancedLoanID: loan.RefinancedLoanID,
+		SettlementAmount: loan.SettlementAmount,
+	}
+
+	if creditReport != nil {
+		response.CreditListingStatus = creditReport.ListingStatus
+		response.CreditReportedAt = &creditReport.RetrievedAt
+	}
+
+	binders.ReturnJSONGeneralResponse(c, response)
+}
+
+func (ctrl *LoanController) GetLoanScheduleController(c *gin.Context) {
+	id, valid := parameters.ConvertParamToValidID(c, "id")
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	loan, err := ctrl.LoanModel.GetLoanByFieldPreloaded("id", string(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Loan not found"})
+		return
+	}
+
+	instalments, err := ctrl.ScheduleModel.GetLoanSchedule(loan.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching schedule: " + err.Error()})
+		return
+	}
+
+	items := make([]bindings.InstalmentResponse, 0, len(instalments))
+	for _, instalment := range instalments {
+		items = append(items, bindings.InstalmentResponse{
+			ID:             instalment.ID,
+			Number:         instalment.Number,
+			DueDate:        instalment.DueDate,
+			Principal:      instalment.Principal,
+			Interest:       instalment.Interest,
+			Fees:           instalment.Fees,
+			TotalDue:       instalment.TotalDue,
+			OpeningBalance: instalment.OpeningBalance,
+			ClosingBalance: instalment.ClosingBalance,
+			Status:         instalment.Status,
+			Penalty:        instalment.Penalty,
+			AmountPaid:     instalment.AmountPaid(),
+			Balance:        instalment.Balance(),
+			PaidAt:         instalment.PaidAt,
+		})
+	}
+
+	response := bindings.LoanScheduleResponse{
+		LoanID:             loan.ID,
+		InterestMethod:     loan.InterestMethod,
+		RepaymentFrequency: loan.RepaymentFrequency,
+		DueDate:            loan.DueDate,
+		RemainingBalance:   loan.RemainingBalance,
+		Instalments:        items,
 	}
 
 	binders.ReturnJSONGeneralResponse(c, response)
@@ -293,87 +456,309 @@ func (ctrl *LoanController) ApproveLoanController(c *gin.Context) {
 		return
 	}
 
-	if loan.Status == "approved" {
-		c.JSON(http.StatusConflict, gin.H{"error": "Loan has already been approved"})
+	if loan.Status != models.LoanStatusPending {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Loan is %s and cannot be approved", loan.Status)})
 		return
-	} else if loan.Status == "rejected" {
-		c.JSON(http.StatusConflict, gin.H{"error": "Loan has already been rejected"})
+	}
+
+	// M-Pesa only pays out whole shillings, so refuse rather than truncate the principal
+	if !loan.Amount.IsWhole() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Loan amount %s is not a whole number of shillings", loan.Amount)})
+		return
+	}
+
+	// The borrower is checked with the credit bureau on the first sign-off. The report is
+	// kept on the loan for the officers who sign after.
+	creditReportModel := models.NewCreditReportModel(ctrl.LoanModel.Service)
+	creditReport, err := creditReportModel.LatestLoanCreditReport(loan.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching credit report: " + err.Error()})
+		return
+	}
+	if creditReport == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		if _, err := creditReportModel.LookupLoan(ctx, ctrl.Bureau, loan.ID, &u.ID); err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Error checking credit bureau: " + err.Error()})
+			return
+		}
+	}
+
+	// The sign-off, and on the last required tier the approval and queued payout, are one
+	// unit of work. The loan is claimed with a conditional status update, so of two final
+	// sign-offs at once only one enqueues a payout. The disbursement worker submits it to
+	// M-Pesa outside this request.
+	var progress models.ApprovalProgress
+	var job *models.DisbursementJob
+	err = ctrl.LoanModel.Service.WithTransaction(func(tx services.Service) error {
+		// Every guarantor has to have answered before anyone signs the loan off
+		if err := models.NewGuaranteeModel(tx).CheckGuarantees(loan.ID); err != nil {
+			return err
+		}
+		if err := models.NewCollateralModel(tx).CheckLoanToValue(loan); err != nil {
+			return err
+		}
+
+		progress, err = models.NewApprovalModel(tx).RecordApproval(loan, u, &officer.ID)
+		if err != nil || !progress.Complete() {
+			return err
+		}
+
+		if _, err := models.NewLoanModel(tx).ApproveLoan(loan.ID, officer.ID, u.ID); err != nil {
+			return err
+		}
+
+		queued, err := models.NewDisbursementJobModel(tx).EnqueueDisbursement(loan, &officer.ID, &u.ID, mobileNumber)
+		job = &queued
+		return err
+	})
+	if err != nil {
+		c.JSON(approvalErrorStatus(err), gin.H{"error": "Error approving loan: " + err.Error()})
 		return
 	}
 
-	// dueDate := now.AddDate(0, 0, loan.Term) do this after disbursement
-	// loan.DueDate = &dueDate
+	status := loan.Status
+	if job != nil {
+		status = models.LoanStatusApproved
+	}
 
-	consumerKey := os.Getenv("MPESA_CONSUMER_KEY")
-	consumerSecret := os.Getenv("MPESA_CONSUMER_SECRET")
-	initiatorPassword := os.Getenv("MPESA_INITIATOR_PASSWORD")
+	binders.ReturnJSONGeneralResponse(c, approvalProgressResponse(loan.ID, status, progress, job))
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
+// approvalErrorStatus maps sign-offs the user may not make to 403 and 409, and top-ups
+// that no longer cover the loan they settle, unanswered guarantees or too little collateral
+// to 422
+func approvalErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, models.ErrApproverNotAuthorised):
+		return http.StatusForbidden
+	case errors.Is(err, models.ErrAlreadyApproved):
+		return http.StatusConflict
+	case errors.Is(err, models.ErrTopUpTooSmall), errors.Is(err, models.ErrGuaranteesPending),
+		errors.Is(err, models.ErrLoanToValue):
+		return http.StatusUnprocessableEntity
+	}
+	return transitionErrorStatus(err)
+}
 
-	mpesaApp := mpesa.NewApp(http.DefaultClient, consumerKey, consumerSecret, mpesa.EnvironmentSandbox)
-
-	b2cResp, err := mpesaApp.B2C(ctx, initiatorPassword, mpesa.B2CRequest{
-		InitiatorName: "testapi",
-		// SalaryPaymentCommandID, BusinessPaymentCommandID, PromotionPaymentCommandID
-		CommandID:       mpesa.BusinessPaymentCommandID,
-		Amount:          uint(loan.Amount),
-		PartyA:          600999,
-		PartyB:          mobileNumber,
-		QueueTimeOutURL: "https://your-server.com/api/v1/mpesa/b2c-timeout",
-		ResultURL:       "https://your-server.com/api/v1/mpesa/b2c-result",
-		Remarks:         "Loan disbursement",
-		Occasion:        "Loan",
-	})
+// approvalProgressResponse maps a loan's sign-offs to their API representation
+func approvalProgressResponse(loanId uint, status string, progress models.ApprovalProgress, job *models.DisbursementJob) bindings.LoanApprovalProgressResponse {
+	response := bindings.LoanApprovalProgressResponse{
+		LoanID:       loanId,
+		Status:       status,
+		Complete:     progress.Complete(),
+		Approvals:    make([]bindings.LoanApprovalResponse, 0, len(progress.Approvals)),
+		PendingTiers: make([]string, 0, len(progress.Pending)),
+	}
+
+	for _, approval := range progress.Approvals {
+		response.Approvals = append(response.Approvals, bindings.LoanApprovalResponse{
+			Level:             approval.Level,
+			TierName:          approval.TierName,
+			ApproverFirstName: approval.Approver.FirstName,
+			ApproverLastName:  approval.Approver.LastName,
+			CreatedAt:         approval.CreatedAt,
+		})
+	}
 
+	for _, tier := range progress.Pending {
+		response.PendingTiers = append(response.PendingTiers, tier.Name)
+	}
+
+	if job != nil {
+		disbursement := disbursementJobResponse(*job)
+		response.Disbursement = &disbursement
+	}
+
+	return response
+}
+
+// GetLoanApprovalsController shows who has signed the loan off at each tier and which
+// tiers are still to sign off.
+func (ctrl *LoanController) GetLoanApprovalsController(c *gin.Context) {
+	id, valid := parameters.ConvertParamToValidID(c, "id")
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	loan, err := ctrl.LoanModel.GetLoanByFieldPreloaded("id", string(id))
 	if err != nil {
-		log.Printf("B2C Payment Error: %v\n", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Loan disbursement failed. Check logs."})
+		c.JSON(http.StatusNotFound, gin.H{"error": "Loan not found"})
 		return
 	}
 
-	if b2cResp.ResponseCode != "0" {
-		log.Printf("M-Pesa Error: %s - %s\n", b2cResp.ResponseCode, b2cResp.ResponseDescription)
-		c.JSON(http.StatusBadGateway, gin.H{"error": "M-Pesa transaction failed", "message": b2cResp.ResponseDescription})
+	progress, err := ctrl.ApprovalModel.GetApprovalProgress(loan)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching approvals: " + err.Error()})
 		return
 	}
 
-	disbursement := models.Disbursement{
-		LoanID:                   loan.ID,
-		OfficerID:                &officer.ID,
-		OriginatorConversationID: b2cResp.OriginatorConversationID,
-		ConversationID:           b2cResp.ConversationID,
-		ResponseCode:             b2cResp.ResponseCode,
-		ResponseDesc:             b2cResp.ResponseDescription,
-		Status:                   "pending",
-		CreatedAt:                time.Now(),
+	// Tiers only matter while the loan is waiting for approval
+	if loan.Status != models.LoanStatusPending {
+		progress.Pending = nil
 	}
 
-	if err := ctrl.DisburseModel.CreateDisbursement(&disbursement); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	binders.ReturnJSONGeneralResponse(c, approvalProgressResponse(loan.ID, loan.Status, progress, nil))
+}
+
+// disbursementJobResponse maps a queued payout to its API representation
+func disbursementJobResponse(job models.DisbursementJob) bindings.DisbursementJobResponse {
+	response := bindings.DisbursementJobResponse{
+		ID:             job.ID,
+		LoanID:         job.LoanID,
+		Amount:         job.Amount,
+		Status:         job.Status,
+		Attempts:       job.Attempts,
+		MaxAttempts:    job.MaxAttempts,
+		NextAttemptAt:  job.NextAttemptAt,
+		LastError:      job.LastError,
+		DisbursementID: job.DisbursementID,
+		CreatedAt:      job.CreatedAt,
+		UpdatedAt:      job.UpdatedAt,
+	}
+
+	if job.Disbursement != nil {
+		response.DisbursementStatus = job.Disbursement.Status
+		response.TransactionID = job.Disbursement.TransactionID
+	}
+
+	return response
+}
+
+// GetLoanDisbursementsController lists the loan's queued payouts with their attempts and
+// last error, so officers can see where a disbursement is stuck.
+func (ctrl *LoanController) GetLoanDisbursementsController(c *gin.Context) {
+	id, valid := parameters.ConvertParamToValidID(c, "id")
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	loan, err := ctrl.LoanModel.GetLoanByFieldPreloaded("id", string(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Loan not found"})
+		return
+	}
+
+	jobs, err := ctrl.DisbursementJobModel.GetLoanDisbursementJobs(loan.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching disbursements: " + err.Error()})
+		return
+	}
+
+	items := make([]bindings.DisbursementJobResponse, 0, len(jobs))
+	for _, job := range jobs {
+		items = append(items, disbursementJobResponse(job))
+	}
+
+	binders.ReturnJSONGeneralResponse(c, items)
+}
+
+// RetryDisbursementController puts a dead-lettered payout back in the queue. A payout still
+// waiting for its result is only abandoned when the request confirms it was not paid.
+func (ctrl *LoanController) RetryDisbursementController(c *gin.Context) {
+	id, valid := parameters.ConvertParamToValidID(c, "id")
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	loan, err := ctrl.LoanModel.GetLoanByFieldPreloaded("id", string(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Loan not found"})
+		return
+	}
+
+	decodedUser, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+	u := decodedUser.(models.User)
+
+	if loan.Status != models.LoanStatusApproved {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Loan is %s and cannot be disbursed", loan.Status)})
 		return
 	}
 
-	_, err = ctrl.LoanModel.ApproveLoan(loan.ID, "approved")
+	var req bindings.RetryDisbursementRequest
+	if c.Request.ContentLength > 0 && !binders.ValidateBindJSONRequest(c, &req) {
+		return
+	}
+
+	job, err := ctrl.DisbursementJobModel.RetryDisbursementJob(loan.ID, &u.ID, req.AbandonPending, time.Now())
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating loan: " + err.Error()})
+		status := http.StatusInternalServerError
+		if errors.Is(err, models.ErrDisbursementNotRetryable) {
+			status = http.StatusConflict
+		} else if errors.Is(err, models.ErrTopUpTooSmall) {
+			status = http.StatusUnprocessableEntity
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	binders.ReturnJSONGeneralResponse(c, disbursementJobResponse(job))
+}
+
+func (ctrl *LoanController) RejectLoanController(c *gin.Context) {
+	id, valid := parameters.ConvertParamToValidID(c, "id")
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	loan, err := ctrl.LoanModel.GetLoanByFieldPreloaded("id", string(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Loan not found"})
+		return
+	}
+
+	decodedUser, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+	u := decodedUser.(models.User)
+
+	officer, err := ctrl.OfficerModel.GetOfficerByFieldPreloaded("user_id", fmt.Sprintf("%d", u.ID))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Officer not found"})
+		return
+	}
+
+	if !models.CanTransition(loan.Status, models.LoanStatusRejected) {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Loan is %s and cannot be rejected", loan.Status)})
+		return
+	}
+
+	var req bindings.LoanStatusReasonRequest
+	if c.Request.ContentLength > 0 && !binders.ValidateBindJSONRequest(c, &req) {
+		return
+	}
+
+	_, err = ctrl.LoanModel.RejectLoan(loan.ID, officer.ID, u.ID, parameters.SanitizeText(req.Reason, false))
+	if err != nil {
+		c.JSON(transitionErrorStatus(err), gin.H{"error": "Error updating loan: " + err.Error()})
 		return
 	}
 
 	response := struct {
-		ConversationID      string `json:"ConversationID"`
-		ResponseCode        string `json:"ResponseCode"`
-		ResponseDescription string `json:"ResponseDescription"`
+		ID     uint         `json:"ID"`
+		Amount money.Amount `json:"Amount"`
 	}{
-		ConversationID:      b2cResp.ConversationID,
-		ResponseCode:        b2cResp.ResponseCode,
-		ResponseDescription: b2cResp.ResponseDescription,
+		ID:     loan.ID,
+		Amount: loan.Amount,
 	}
 
 	binders.ReturnJSONGeneralResponse(c, response)
 }
 
-func (ctrl *LoanController) RejectLoanController(c *gin.Context) {
+// DefaultLoanController declares a loan in arrears to be in default so that its guarantors
+// can be called on
+func (ctrl *LoanController) DefaultLoanController(c *gin.Context) {
 	id, valid := parameters.ConvertParamToValidID(c, "id")
 	if !valid {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
@@ -399,23 +784,95 @@ func (ctrl *LoanController) RejectLoanController(c *gin.Context) {
 		return
 	}
 
-	if loan.Status == "approved" {
-		c.JSON(http.StatusConflict, gin.H{"error": "Loan has already been approved"})
+	if loan.Status != models.LoanStatusInArrears {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Loan is %s and cannot be defaulted", loan.Status)})
+		return
+	}
+
+	var req bindings.LoanStatusReasonRequest
+	if c.Request.ContentLength > 0 && !binders.ValidateBindJSONRequest(c, &req) {
+		return
+	}
+
+	_, err = ctrl.LoanModel.DefaultLoan(loan.ID, officer.ID, u.ID, parameters.SanitizeText(req.Reason, false))
+	if err != nil {
+		c.JSON(transitionErrorStatus(err), gin.H{"error": "Error updating loan: " + err.Error()})
+		return
+	}
+
+	response := struct {
+		ID     uint         `json:"ID"`
+		Amount money.Amount `json:"Amount"`
+	}{
+		ID:     loan.ID,
+		Amount: loan.Amount,
+	}
+
+	binders.ReturnJSONGeneralResponse(c, response)
+}
+
+// CancelLoanController lets the agent who raised a loan withdraw it while it is still
+// pending, e.g. when it was raised against the wrong member. The images uploaded with the
+// loan are deleted.
+func (ctrl *LoanController) CancelLoanController(c *gin.Context) {
+	id, valid := parameters.ConvertParamToValidID(c, "id")
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	var req bindings.CancelLoanRequest
+	if !binders.ValidateBindJSONRequest(c, &req) {
+		return
+	}
+
+	loan, err := ctrl.LoanModel.GetLoanByFieldPreloaded("id", string(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Loan not found"})
+		return
+	}
+
+	decodedUser, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+	u := decodedUser.(models.User)
+
+	agent, err := ctrl.AgentModel.GetAgentByField("user_id", strconv.Itoa(int(u.ID)))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Agent not found"})
+		return
+	}
+	if loan.AgentID != agent.ID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the agent who created the loan can cancel it"})
 		return
-	} else if loan.Status == "rejected" {
-		c.JSON(http.StatusConflict, gin.H{"error": "Loan has already been rejected"})
+	}
+
+	if loan.Status != models.LoanStatusPending {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Loan is %s and cannot be cancelled", loan.Status)})
 		return
 	}
 
-	_, err = ctrl.LoanModel.RejectLoan(loan.ID, officer.ID, "rejected")
+	_, err = ctrl.LoanModel.CancelLoan(loan.ID, u.ID, parameters.SanitizeText(req.Reason, false))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating loan: " + err.Error()})
+		c.JSON(transitionErrorStatus(err), gin.H{"error": "Error cancelling loan: " + err.Error()})
 		return
 	}
 
+	// The loan stays cancelled if the images cannot be deleted, they are kept on it for a later cleanup
+	images := uploadedImages(loan.DefaultImage, loan.Images)
+	if len(images) > 0 {
+		if err := handlers.RemoveAllImages(ctrl.CloudinaryConfig, ctrl.Cloudinary, images); err != nil {
+			log.Printf("Error removing images of cancelled loan %d: %v\n", loan.ID, err)
+		} else if err := ctrl.LoanModel.ClearLoanImages(loan.ID); err != nil {
+			log.Printf("Error clearing images of cancelled loan %d: %v\n", loan.ID, err)
+		}
+	}
+
 	response := struct {
-		ID     uint    `json:"ID"`
-		Amount float64 `json:"Amount"`
+		ID     uint         `json:"ID"`
+		Amount money.Amount `json:"Amount"`
 	}{
 		ID:     loan.ID,
 		Amount: loan.Amount,
@@ -423,3 +880,123 @@ func (ctrl *LoanController) RejectLoanController(c *gin.Context) {
 
 	binders.ReturnJSONGeneralResponse(c, response)
 }
+
+// uploadedImages lists each stored image once, the default image is usually one of the images
+func uploadedImages(sets ...deserializers.DefaultImageSlice) []deserializers.DefaultImage {
+	seen := map[string]bool{}
+	images := []deserializers.DefaultImage{}
+
+	for _, set := range sets {
+		for _, image := range set {
+			if image.PublicID == nil || seen[*image.PublicID] {
+				continue
+			}
+			seen[*image.PublicID] = true
+			images = append(images, image)
+		}
+	}
+
+	return images
+}
+
+// RepayLoanController prompts the borrower's phone (or the number given in the request) to
+// pay towards the loan. The balance only changes once the STK callback confirms the payment.
+func (ctrl *LoanController) RepayLoanController(c *gin.Context) {
+	id, valid := parameters.ConvertParamToValidID(c, "id")
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	var req bindings.RepayLoanRequest
+	if !binders.ValidateBindJSONRequest(c, &req) {
+		return
+	}
+
+	loan, err := ctrl.LoanModel.GetLoanByFieldPreloaded("id", string(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Loan not found"})
+		return
+	}
+
+	// Payments on a written-off loan are collected as recoveries
+	if loan.Status != models.LoanStatusActive && loan.Status != models.LoanStatusInArrears && loan.Status != models.LoanStatusWrittenOff {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Loan is %s and cannot be repaid", loan.Status)})
+		return
+	}
+
+	// M-Pesa only collects whole shillings
+	if !req.Amount.IsWhole() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Amount must be a whole number of shillings"})
+		return
+	}
+	if req.Amount > loan.RemainingBalance {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Amount exceeds the remaining balance of %s", loan.RemainingBalance)})
+		return
+	}
+
+	phoneNumber := req.PhoneNumber
+	if phoneNumber == "" {
+		member, err := ctrl.UserModel.GetUserByFieldPreloaded("id", fmt.Sprintf("%d", loan.Member.UserID))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+			return
+		}
+		phoneNumber = member.MobileNumber
+	}
+	mobileNumber, err := strconv.ParseUint(phoneNumber, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid phone number format"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	stkResp, err := ctrl.Gateway.STKPush(ctx, payments.STKPushRequest{
+		PhoneNumber:      mobileNumber,
+		Amount:           uint(req.Amount.Shillings()),
+		AccountReference: fmt.Sprintf("LOAN%d", loan.ID),
+		TransactionDesc:  "Loan repayment",
+	})
+
+	if err != nil {
+		log.Printf("STK Push Error: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "STK Push failed. Check logs."})
+		return
+	}
+
+	if stkResp.ResponseCode != "0" {
+		log.Printf("M-Pesa Error: %s - %s\n", stkResp.ResponseCode, stkResp.ResponseDescription)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "M-Pesa transaction failed", "message": stkResp.ResponseDescription})
+		return
+	}
+
+	payment := models.Payment{
+		LoanID:            loan.ID,
+		Amount:            req.Amount,
+		PhoneNumber:       phoneNumber,
+		CheckoutRequestID: stkResp.CheckoutRequestID,
+		MerchantRequestID: stkResp.MerchantRequestID,
+		Status:            models.PaymentStatusPending,
+		ResponseCode:      stkResp.ResponseCode,
+		ResponseDesc:      stkResp.ResponseDescription,
+		TransactionDesc:   "Loan repayment",
+		PaymentMode:       models.PaymentModeMpesa,
+	}
+
+	if err := ctrl.PaymentModel.CreatePayment(&payment); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	response := bindings.RepayLoanResponse{
+		PaymentID:           payment.ID,
+		CheckoutRequestID:   stkResp.CheckoutRequestID,
+		ResponseCode:        stkResp.ResponseCode,
+		ResponseDescription: stkResp.ResponseDescription,
+		CustomerMessage:     stkResp.CustomerMessage,
+	}
+
+	binders.ReturnJSONGeneralResponse(c, response)
+}
@@ -2,60 +2,230 @@ package controllers
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/kifangamukundi/gm/loan/models"
+	"github.com/kifangamukundi/gm/loan/money"
+	"github.com/kifangamukundi/gm/loan/payments"
+	"github.com/kifangamukundi/gm/loan/services"
+
 	"github.com/gin-gonic/gin"
 	"github.com/jwambugu/mpesa-golang-sdk"
 )
 
-func PaymentController(c *gin.Context) {
-	consumerKey := "YOUR_CONSUMER_KEY"
-	consumerSecret := "YOUR_CONSUMER_SECRET"
-	passkey := "YOUR_PASSKEY"
+type MpesaController struct {
+	LoanModel     *models.LoanModel
+	DisburseModel *models.DisburseModel
+	ScheduleModel *models.ScheduleModel
+	PaymentModel  *models.PaymentModel
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
+	AllocationModel *models.AllocationModel
+	LedgerModel     *models.LedgerModel
+	SavingsModel    *models.SavingsModel
 
-	// Initialize Mpesa App
-	mpesaApp := mpesa.NewApp(http.DefaultClient, consumerKey, consumerSecret, mpesa.EnvironmentSandbox)
-
-	// Perform STK Push
-	stkResp, err := mpesaApp.STKPush(ctx, passkey, mpesa.STKPushRequest{
-		BusinessShortCode: 174379,
-		TransactionType:   mpesa.CustomerPayBillOnlineTransactionType,
-		Amount:            1,
-		PartyA:            254702817040,
-		PartyB:            174379,
-		PhoneNumber:       254702817040,
-		CallBackURL:       "https://your-server.com/api/v1/mpesa/stk-callback", // Webhook
-		AccountReference:  "TestRef",
-		TransactionDesc:   "Test Payment",
-	})
+	Gateway payments.PaymentGateway
+}
 
+func NewMpesaController(loanModel *models.LoanModel, disburseModel *models.DisburseModel, scheduleModel *models.ScheduleModel, paymentModel *models.PaymentModel, allocationModel *models.AllocationModel, ledgerModel *models.LedgerModel, savingsModel *models.SavingsModel, gateway payments.PaymentGateway) *MpesaController {
+	return &MpesaController{
+		LoanModel:       loanModel,
+		DisburseModel:   disburseModel,
+		ScheduleModel:   scheduleModel,
+		PaymentModel:    paymentModel,
+		AllocationModel: allocationModel,
+		LedgerModel:     ledgerModel,
+		SavingsModel:    savingsModel,
+		Gateway:         gateway,
+	}
+}
+
+func (ctrl *MpesaController) DisburseCallbackController(c *gin.Context) {
+	callback, err := mpesa.UnmarshalCallback(c.Request.Body)
 	if err != nil {
-		log.Printf("STK Push Error: %v\n", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "STK Push failed. Check logs."})
+		log.Printf("Error decoding B2C Callback: %v\n", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
 		return
 	}
 
-	log.Printf("STK Push Success: %+v\n", stkResp)
+	log.Printf("B2C Callback Received: %+v\n", callback)
 
-	// Store CheckoutRequestID in DB for tracking (to query later if needed)
-	// db.SavePaymentStatus(stkResp.CheckoutRequestID, "Pending")
+	ctrl.finalizeDisbursement(c, callback.Result, false)
+}
 
-	// Return CheckoutRequestID to client
-	c.JSON(http.StatusOK, gin.H{
-		"message":             "STK Push initiated successfully",
-		"CheckoutRequestID":   stkResp.CheckoutRequestID,
-		"ResponseCode":        stkResp.ResponseCode,
-		"ResponseDescription": stkResp.ResponseDescription,
+func (ctrl *MpesaController) DisburseTimeoutController(c *gin.Context) {
+	callback, err := mpesa.UnmarshalCallback(c.Request.Body)
+	if err != nil {
+		log.Printf("Error decoding B2C Timeout: %v\n", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	log.Printf("B2C Timeout Received: %+v\n", callback)
+
+	ctrl.finalizeDisbursement(c, callback.Result, true)
+}
+
+// finalizeDisbursement applies a B2C result to its disbursement and loan. Safaricom retries
+// callbacks it thinks were not delivered, so results for disbursements that are no longer
+// pending are acknowledged without being applied again.
+func (ctrl *MpesaController) finalizeDisbursement(c *gin.Context, result mpesa.CallbackResult, timedOut bool) {
+	var disbursement *models.Disbursement
+	var withdrawal *models.SavingsTransaction
+	found := awaitCallbackRecord(func() bool {
+		var err error
+		if disbursement, err = ctrl.DisburseModel.GetDisbursementByField("originator_conversation_id", result.OriginatorConversationID); err == nil {
+			return true
+		}
+		// Savings withdrawals are paid out through the same B2C callbacks
+		withdrawal, err = ctrl.SavingsModel.GetSavingsTransactionByField("originator_conversation_id", result.OriginatorConversationID)
+		return err == nil
 	})
+	if !found {
+		log.Printf("No disbursement or savings withdrawal for B2C result %s, acknowledging for reconciliation\n", result.OriginatorConversationID)
+		acknowledgeCallback(c)
+		return
+	}
+	if disbursement == nil {
+		ctrl.finalizeSavingsWithdrawal(c, withdrawal, result, timedOut)
+		return
+	}
+
+	if !disbursement.IsAwaitingResult() {
+		log.Printf("B2C result for %s already applied as %s, ignoring replay\n", result.OriginatorConversationID, disbursement.Status)
+		acknowledgeCallback(c)
+		return
+	}
+
+	// The disbursement, the loan and its schedule and ledger entries change together or not at all
+	err := ctrl.DisburseModel.Service.WithTransaction(func(tx services.Service) error {
+		disburseModel := models.NewDisburseModel(tx)
+		loanModel := models.NewLoanModel(tx)
+		jobModel := models.NewDisbursementJobModel(tx)
+
+		// Payouts made before the queue have no job
+		job, err := jobModel.GetDisbursementJob(*disbursement)
+		if err != nil {
+			return err
+		}
+
+		// An abandoned payout was given up on by an officer so its job could be retried, its
+		// result no longer decides what happens to the job
+		abandoned := disbursement.Status == models.DisbursementStatusAbandoned
+
+		// A timeout does not mean the borrower was not paid, so the payout is never retried
+		// blindly. The job is dead-lettered for an officer to check M-Pesa, and the disbursement
+		// stays pending so a result that still arrives is applied.
+		if timedOut {
+			if job == nil || abandoned || job.Status != models.DisbursementJobSubmitted {
+				log.Printf("B2C request %s timed out, leaving it for reconciliation\n", result.OriginatorConversationID)
+				return nil
+			}
+			_, err := jobModel.FailDisbursementJob(job.ID, "B2C request timed out, check M-Pesa before retrying: "+result.ResultDesc, time.Now(), true)
+			return err
+		}
+
+		if result.ResultCode != 0 {
+			if _, err := disburseModel.FinalizeDisbursement(disbursement.ID, models.DisbursementStatusFailed, result.TransactionID, result.ResultCode, result.ResultDesc); err != nil {
+				return err
+			}
+
+			// A queued payout is retried by the disbursement worker with backoff, which also puts
+			// the loan back to approved. Payouts made before the queue only need the latter, and
+			// a dead-lettered job has already done it.
+			switch {
+			case job == nil:
+				_, err := loanModel.TransitionLoan(disbursement.LoanID, models.LoanStatusApproved, nil, "B2C payout failed: "+result.ResultDesc, nil)
+				return err
+			case job.Status == models.DisbursementJobSubmitted && !abandoned:
+				_, err := jobModel.FailDisbursementJob(job.ID, result.ResultDesc, time.Now(), false)
+				return err
+			}
+			return nil
+		}
+
+		if job != nil {
+			switch job.Status {
+			case models.DisbursementJobCompleted:
+				// Another attempt has already disbursed the loan, so this one paid the borrower twice
+				completed, err := disburseModel.FinalizeDisbursement(disbursement.ID, models.DisbursementStatusCompleted, result.TransactionID, result.ResultCode, result.ResultDesc)
+				if err != nil {
+					return err
+				}
+				log.Printf("Disbursement %d paid loan %d again after job %d completed, %s is to be recovered from the borrower\n", completed.ID, completed.LoanID, job.ID, completed.Amount)
+				return nil
+			case models.DisbursementJobDead, models.DisbursementJobQueued:
+				// The loan went back to approved when the job was dead-lettered. The late result still
+				// disburses it, and cancels the retry if one was queued.
+				if _, err := loanModel.TransitionLoan(disbursement.LoanID, models.LoanStatusDisbursing, nil, "Late B2C result: "+result.TransactionID, nil); err != nil {
+					return err
+				}
+			default:
+				if abandoned {
+					log.Printf("Abandoned disbursement %d for loan %d was paid while job %d is being retried, check the retry was not paid too\n", disbursement.ID, disbursement.LoanID, job.ID)
+				}
+			}
+		}
+
+		completed, err := disburseModel.FinalizeDisbursement(disbursement.ID, models.DisbursementStatusCompleted, result.TransactionID, result.ResultCode, result.ResultDesc)
+		if err != nil {
+			return err
+		}
+
+		loan, err := loanModel.ActivateLoan(disbursement.LoanID, *completed.DisbursedAt, "B2C payout completed: "+result.TransactionID)
+		if err != nil {
+			return err
+		}
+
+		// The money has reached the borrower so the repayment clock starts now
+		instalments, err := models.NewScheduleModel(tx).CreateSchedule(&loan, *completed.DisbursedAt)
+		if err != nil {
+			return fmt.Errorf("error generating repayment schedule: %v", err)
+		}
+
+		if err := models.NewLedgerModel(tx).PostDisbursement(completed, instalments); err != nil {
+			return fmt.Errorf("error posting disbursement to the ledger: %v", err)
+		}
+
+		// A top-up settles the loan it refinances with whatever was not paid out
+		if loan.RefinancedLoanID != nil {
+			settlement := loan.Amount - completed.Amount
+			if _, err := models.NewRefinanceModel(tx).SettleRefinancedLoan(loan, settlement, *completed.DisbursedAt); err != nil {
+				return fmt.Errorf("error settling refinanced loan: %v", err)
+			}
+			loan.SettlementAmount = settlement
+			if err := loanModel.UpdateLoanColumns(&loan, "settlement_amount"); err != nil {
+				return err
+			}
+		}
+
+		if job == nil {
+			return nil
+		}
+		return jobModel.CompleteDisbursementJob(job, completed.ID)
+	})
+	if errors.Is(err, models.ErrDisbursementNotPending) {
+		log.Printf("B2C result for %s already applied, ignoring replay\n", result.OriginatorConversationID)
+		acknowledgeCallback(c)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	acknowledgeCallback(c)
 }
 
-func PaymentCallbackController(c *gin.Context) {
-	// Decode the callback body using UnmarshalSTKPushCallback
+// PaymentCallbackController records the outcome of an STK push started by RepayLoanController,
+// as confirmed by an STK query. Successful payments are applied to the loan balance; replays
+// of callbacks for payments that are no longer pending are acknowledged without being applied
+// again.
+func (ctrl *MpesaController) PaymentCallbackController(c *gin.Context) {
 	callback, err := mpesa.UnmarshalSTKPushCallback(c.Request.Body)
 	if err != nil {
 		log.Printf("Error decoding STK Callback: %v\n", err)
@@ -65,102 +235,295 @@ func PaymentCallbackController(c *gin.Context) {
 
 	log.Printf("STK Callback Received: %+v\n", callback)
 
-	// Extract transaction details
-	resultCode := callback.Body.STKCallback.ResultCode
-	resultDesc := callback.Body.STKCallback.ResultDesc
-	merchantRequestID := callback.Body.STKCallback.MerchantRequestID
-	checkoutRequestID := callback.Body.STKCallback.CheckoutRequestID
+	result := callback.Body.STKCallback
+
+	var payment *models.Payment
+	var deposit *models.SavingsTransaction
+	found := awaitCallbackRecord(func() bool {
+		if payment, err = ctrl.PaymentModel.GetPaymentByField("checkout_request_id", result.CheckoutRequestID); err == nil {
+			return true
+		}
+		// Savings deposits are collected through the same STK callbacks
+		deposit, err = ctrl.SavingsModel.GetSavingsTransactionByField("checkout_request_id", result.CheckoutRequestID)
+		return err == nil
+	})
+	if !found {
+		log.Printf("No payment or savings deposit for STK result %s, acknowledging for reconciliation\n", result.CheckoutRequestID)
+		acknowledgeCallback(c)
+		return
+	}
+	if payment == nil {
+		ctrl.finalizeSavingsDeposit(c, deposit, result)
+		return
+	}
 
-	// TODO: Update DB based on ResultCode
-	// Example:
-	// if resultCode == 0 {
-	//     db.UpdateTransactionStatus(checkoutRequestID, "Success")
-	// } else {
-	//     db.UpdateTransactionStatus(checkoutRequestID, "Failed")
-	// }
+	if payment.Status != models.PaymentStatusPending {
+		log.Printf("STK result for %s already applied as %s, ignoring replay\n", result.CheckoutRequestID, payment.Status)
+		acknowledgeCallback(c)
+		return
+	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"message":           "STK Callback processed",
-		"MerchantRequestID": merchantRequestID,
-		"CheckoutRequestID": checkoutRequestID,
-		"ResultCode":        resultCode,
-		"ResultDesc":        resultDesc,
+	resultCode, resultDesc, ok := ctrl.confirmSTKResult(c, result)
+	if !ok {
+		return
+	}
+
+	if resultCode != 0 {
+		_, err = ctrl.PaymentModel.FinalizePayment(payment.ID, models.PaymentStatusFailed, 0, "", resultCode, resultDesc)
+		if err != nil && !errors.Is(err, models.ErrPaymentNotPending) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		acknowledgeCallback(c)
+		return
+	}
+
+	amount, receipt, ok := stkPaymentDetails(c, result, payment.Amount)
+	if !ok {
+		return
+	}
+
+	// A payment is only marked successful together with its allocations, so a failure
+	// leaves it pending and a replayed callback can apply it again
+	err = ctrl.PaymentModel.Service.WithTransaction(func(tx services.Service) error {
+		completed, err := models.NewPaymentModel(tx).FinalizePayment(payment.ID, models.PaymentStatusSuccess, amount, receipt, resultCode, resultDesc)
+		if err != nil {
+			return err
+		}
+
+		return applyRepayment(tx, completed)
 	})
-}
+	if errors.Is(err, models.ErrPaymentNotPending) {
+		log.Printf("STK result for %s already applied, ignoring replay\n", result.CheckoutRequestID)
+		acknowledgeCallback(c)
+		return
+	}
+	if err != nil {
+		c.JSON(transitionErrorStatus(err), gin.H{"error": "Error applying repayment: " + err.Error()})
+		return
+	}
 
-func DisburseController(c *gin.Context) {
-	consumerKey := "YOUR_CONSUMER_KEY"
-	consumerSecret := "YOUR_CONSUMER_SECRET"
-	initiatorPassword := "YOUR_INITIATOR_PASSWORD"
+	acknowledgeCallback(c)
+}
 
+// confirmSTKResult asks M-Pesa for the outcome of the STK push instead of taking the callback's
+// word for it. It writes the error response and returns false when M-Pesa cannot confirm it,
+// leaving the payment pending for a replayed callback or reconciliation.
+func (ctrl *MpesaController) confirmSTKResult(c *gin.Context, result mpesa.STKCallback) (int, string, bool) {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	// Initialize Mpesa Client
-	mpesaApp := mpesa.NewApp(http.DefaultClient, consumerKey, consumerSecret, mpesa.EnvironmentSandbox)
-
-	// Perform B2C Payment
-	b2cResp, err := mpesaApp.B2C(ctx, initiatorPassword, mpesa.B2CRequest{
-		InitiatorName:   "TestInitiator",
-		Amount:          1000,         // Loan amount
-		PartyA:          600000,       // Your paybill or shortcode
-		PartyB:          254712345678, // Borrower's phone number
-		QueueTimeOutURL: "https://your-server.com/api/v1/mpesa/b2c-timeout",
-		ResultURL:       "https://your-server.com/api/v1/mpesa/b2c-result",
-		Remarks:         "Loan disbursement",
-		Occasion:        "Loan",
-	})
+	resp, err := ctrl.Gateway.STKQuery(ctx, result.CheckoutRequestID)
+	if err != nil {
+		log.Printf("STK Query Error for %s: %v\n", result.CheckoutRequestID, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Could not confirm the payment with M-Pesa", "message": err.Error()})
+		return 0, "", false
+	}
+
+	resultCode, err := strconv.Atoi(resp.ResultCode)
+	if err != nil {
+		log.Printf("STK Query for %s returned result code %q\n", result.CheckoutRequestID, resp.ResultCode)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Could not confirm the payment with M-Pesa"})
+		return 0, "", false
+	}
+	if resultCode != result.ResultCode {
+		log.Printf("STK callback for %s reported %d but M-Pesa reports %d\n", result.CheckoutRequestID, result.ResultCode, resultCode)
+	}
+
+	return resultCode, resp.ResultDesc, true
+}
+
+// stkPaymentDetails reads what M-Pesa collected and its receipt from a successful STK callback.
+// The collected amount may be less than requested but never more, such callbacks are rejected
+// and the payment stays pending for reconciliation.
+func stkPaymentDetails(c *gin.Context, result mpesa.STKCallback, requested money.Amount) (money.Amount, string, bool) {
+	amount := requested
+	receipt := ""
+	for _, item := range result.CallbackMetadata.Item {
+		switch item.Name {
+		case "Amount":
+			if value, ok := item.Value.(float64); ok {
+				amount = money.FromFloat(value)
+			}
+		case "MpesaReceiptNumber":
+			if value, ok := item.Value.(string); ok {
+				receipt = value
+			}
+		}
+	}
+
+	if amount > requested {
+		log.Printf("STK callback for %s reported %s but only %s was requested\n", result.CheckoutRequestID, amount, requested)
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Amount is more than was requested"})
+		return 0, "", false
+	}
+
+	return amount, receipt, true
+}
+
+// finalizeSavingsDeposit credits a savings deposit with what M-Pesa collected, or marks it
+// failed. Replays for deposits that are no longer pending are acknowledged.
+func (ctrl *MpesaController) finalizeSavingsDeposit(c *gin.Context, deposit *models.SavingsTransaction, result mpesa.STKCallback) {
+	if deposit.Status != models.SavingsStatusPending {
+		log.Printf("STK result for %s already applied as %s, ignoring replay\n", result.CheckoutRequestID, deposit.Status)
+		acknowledgeCallback(c)
+		return
+	}
+
+	resultCode, resultDesc, ok := ctrl.confirmSTKResult(c, result)
+	if !ok {
+		return
+	}
+
+	if resultCode != 0 {
+		if _, err := ctrl.SavingsModel.FailSavingsTransaction(deposit.ID, resultDesc); err != nil && !errors.Is(err, models.ErrSavingsNotPending) {
+			c.JSON(savingsErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		acknowledgeCallback(c)
+		return
+	}
+
+	amount, receipt, ok := stkPaymentDetails(c, result, deposit.Amount)
+	if !ok {
+		return
+	}
+
+	if _, err := ctrl.SavingsModel.CompleteSavingsTransaction(deposit.ID, amount, receipt, resultDesc); err != nil && !errors.Is(err, models.ErrSavingsNotPending) {
+		c.JSON(savingsErrorStatus(err), gin.H{"error": "Error crediting savings deposit: " + err.Error()})
+		return
+	}
+
+	acknowledgeCallback(c)
+}
+
+// finalizeSavingsWithdrawal completes a savings withdrawal once its payout goes through, or
+// returns the amount to the member's balance when M-Pesa reports it failed. A timeout leaves
+// the withdrawal pending since the member may still have been paid.
+func (ctrl *MpesaController) finalizeSavingsWithdrawal(c *gin.Context, withdrawal *models.SavingsTransaction, result mpesa.CallbackResult, timedOut bool) {
+	if withdrawal.Status != models.SavingsStatusPending {
+		log.Printf("B2C result for %s already applied as %s, ignoring replay\n", result.OriginatorConversationID, withdrawal.Status)
+		acknowledgeCallback(c)
+		return
+	}
+
+	if timedOut {
+		log.Printf("B2C request %s for savings withdrawal %d timed out, leaving it for reconciliation\n", result.OriginatorConversationID, withdrawal.ID)
+		acknowledgeCallback(c)
+		return
+	}
 
+	var err error
+	if result.ResultCode != 0 {
+		_, err = ctrl.SavingsModel.FailSavingsTransaction(withdrawal.ID, result.ResultDesc)
+	} else {
+		_, err = ctrl.SavingsModel.CompleteSavingsTransaction(withdrawal.ID, 0, result.TransactionID, result.ResultDesc)
+	}
 	if err != nil {
-		log.Printf("B2C Payment Error: %v\n", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Loan disbursement failed. Check logs."})
+		c.JSON(savingsErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
-	log.Printf("B2C Payment Success: %+v\n", b2cResp)
+	acknowledgeCallback(c)
+}
 
-	c.JSON(http.StatusOK, gin.H{
-		"message":             "Loan sent successfully",
-		"ConversationID":      b2cResp.ConversationID,
-		"ResponseCode":        b2cResp.ResponseCode,
-		"ResponseDescription": b2cResp.ResponseDescription,
-	})
+// applyRepayment runs a successful payment through the loan product's allocation waterfall,
+// posts the allocations to the ledger and records the balance that is left on the loan,
+// all within the caller's transaction.
+func applyRepayment(tx services.Service, payment models.Payment) error {
+	loanModel := models.NewLoanModel(tx)
+
+	loan, err := loanModel.GetLoanByFieldPreloaded("id", fmt.Sprintf("%d", payment.LoanID))
+	if err != nil {
+		return err
+	}
+
+	if loan.Status == models.LoanStatusWrittenOff {
+		recovery, err := models.NewWriteOffModel(tx).RecordRecovery(loan.ID, payment.Amount, &payment.ID, payment.MpesaReceiptNumber, nil, *payment.PaidAt)
+		if err != nil {
+			return err
+		}
+		log.Printf("Payment %d recovered %s on written-off loan %d\n", payment.ID, recovery.Amount, loan.ID)
+		return nil
+	}
+
+	instalments, err := models.NewScheduleModel(tx).GetLoanSchedule(loan.ID)
+	if err != nil {
+		return err
+	}
+
+	// A payment covering an open settlement quote earns its rebate before being allocated
+	quote, err := models.NewSettlementModel(tx).ApplySettlementQuote(loan, payment, instalments)
+	if err != nil {
+		return err
+	}
+	if quote != nil {
+		log.Printf("Payment %d settles loan %d under quote %d\n", payment.ID, loan.ID, quote.ID)
+	}
+
+	allocations, balance, err := models.NewAllocationModel(tx).ApplyPayment(payment, loan.Product.RepaymentOrder(), instalments)
+	if err != nil {
+		return err
+	}
+
+	for _, allocation := range allocations {
+		if allocation.Component == models.ComponentOverpayment {
+			log.Printf("Payment %d overpaid loan %d by %s\n", payment.ID, loan.ID, allocation.Amount)
+		}
+	}
+
+	if err := models.NewLedgerModel(tx).PostRepayment(payment, allocations); err != nil {
+		return err
+	}
+
+	_, err = loanModel.RecordRepayment(loan, balance, *payment.PaidAt)
+	return err
 }
 
-func DisburseCallbackController(c *gin.Context) {
-	// Decode the callback body using UnmarshalCallback
+// ResultCallbackController logs transaction status and reversal results. Neither changes
+// any records yet, they are kept for reconciliation.
+func (ctrl *MpesaController) ResultCallbackController(c *gin.Context) {
 	callback, err := mpesa.UnmarshalCallback(c.Request.Body)
 	if err != nil {
-		log.Printf("Error decoding B2C Callback: %v\n", err)
+		log.Printf("Error decoding M-Pesa Result: %v\n", err)
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
 		return
 	}
 
-	log.Printf("B2C Callback Received: %+v\n", callback)
+	log.Printf("M-Pesa Result Received on %s: %+v\n", c.FullPath(), callback.Result)
+
+	acknowledgeCallback(c)
+}
+
+// Callbacks can arrive before the request that started them has recorded its M-Pesa IDs, so
+// their records are looked up a few times before the callback is given up on
+const (
+	callbackLookupAttempts = 5
+	callbackLookupDelay    = 500 * time.Millisecond
+)
+
+// awaitCallbackRecord retries lookup until it finds the record a callback belongs to. Callbacks
+// whose record never turns up are acknowledged anyway, a 404 only makes Safaricom retry a
+// result that is already in the log for reconciliation.
+func awaitCallbackRecord(lookup func() bool) bool {
+	for attempt := 1; ; attempt++ {
+		if lookup() {
+			return true
+		}
+		if attempt == callbackLookupAttempts {
+			return false
+		}
+		time.Sleep(callbackLookupDelay)
+	}
+}
 
-	// Extract transaction details
-	resultCode := callback.Result.ResultCode
-	resultDesc := callback.Result.ResultDesc
-	originatorConversationID := callback.Result.OriginatorConversationID
-	conversationID := callback.Result.ConversationID
-	transactionID := callback.Result.TransactionID
-
-	// TODO: Update DB based on ResultCode
-	// Example:
-	// if resultCode == 0 {
-	//     db.UpdateLoanStatus(originatorConversationID, "Success", transactionID)
-	// } else {
-	//     db.UpdateLoanStatus(originatorConversationID, "Failed", "")
-	// }
-
-	// Respond to Safaricom confirming receipt
+// acknowledgeCallback sends the response Safaricom expects for a received callback
+func acknowledgeCallback(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
-		"message":                  "B2C Callback processed",
-		"OriginatorConversationID": originatorConversationID,
-		"ConversationID":           conversationID,
-		"TransactionID":            transactionID,
-		"ResultCode":               resultCode,
-		"ResultDesc":               resultDesc,
+		"ResultCode": 0,
+		"ResultDesc": "Accepted",
 	})
 }
 
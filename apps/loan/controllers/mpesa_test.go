@@ -0,0 +1,446 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/kifangamukundi/gm/loan/bureau"
+	"github.com/kifangamukundi/gm/loan/config"
+	"github.com/kifangamukundi/gm/loan/database"
+	"github.com/kifangamukundi/gm/loan/jobs"
+	"github.com/kifangamukundi/gm/loan/loanrepository"
+	"github.com/kifangamukundi/gm/loan/middlewares"
+	"github.com/kifangamukundi/gm/loan/migrations"
+	"github.com/kifangamukundi/gm/loan/models"
+	"github.com/kifangamukundi/gm/loan/money"
+	"github.com/kifangamukundi/gm/loan/payments"
+	"github.com/kifangamukundi/gm/loan/payments/paymentstest"
+	"github.com/kifangamukundi/gm/loan/seeds"
+	"github.com/kifangamukundi/gm/loan/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jwambugu/mpesa-golang-sdk"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// loanFlow is a sqlite database with one agent, officer, member and product, served by the
+// loan and M-Pesa controllers with the simulator standing in for Daraja
+type loanFlow struct {
+	service   services.Service
+	engine    *gin.Engine
+	simulator *paymentstest.Simulator
+	jobModel  *models.DisbursementJobModel
+
+	agent   models.User
+	officer models.User
+	group   models.Group
+	member  models.Member
+	product models.LoanProduct
+}
+
+func newLoanFlow(t *testing.T) *loanFlow {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "loan.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	database.DB = db
+	migrations.RunMigrations()
+	if err := seeds.SeedAccounts(db); err != nil {
+		t.Fatalf("failed to seed accounts: %v", err)
+	}
+
+	service := services.NewEntityService(loanrepository.NewLoanRepository(db))
+	flow := &loanFlow{service: service, jobModel: models.NewDisbursementJobModel(service)}
+
+	users := []*models.User{&flow.agent, &flow.officer, {}}
+	for i, user := range users {
+		*user = models.User{FirstName: "Test", LastName: strconv.Itoa(i), Email: fmt.Sprintf("user%d@example.com", i), MobileNumber: fmt.Sprintf("25470000000%d", i)}
+		mustCreate(t, service, user)
+	}
+	agent := models.Agent{UserID: flow.agent.ID, IsActive: true}
+	mustCreate(t, service, &agent)
+	mustCreate(t, service, &models.Officer{UserID: flow.officer.ID, IsActive: true})
+	flow.group = models.Group{GroupName: "Test group", AgentID: agent.ID}
+	mustCreate(t, service, &flow.group)
+	flow.member = models.Member{UserID: users[2].ID, AgentID: agent.ID, IsActive: true}
+	mustCreate(t, service, &flow.member)
+	flow.product = models.LoanProduct{
+		ProductName: "Test product", InterestRate: 12, InterestMethod: models.InterestMethodFlat, RepaymentFrequency: models.FrequencyMonthly,
+		MinAmount: money.FromShillings(1000), MaxAmount: money.FromShillings(50000), MinTerm: 1, MaxTerm: 12, IsActive: true,
+	}
+	mustCreate(t, service, &flow.product)
+
+	mpesaConfig := &config.MpesaConfig{CallbackBaseURL: "https://loan.test", CallbackToken: "callback-token"}
+	flow.engine = gin.New()
+	flow.simulator = paymentstest.NewSimulator(mpesaConfig, flow.engine)
+	flow.simulator.Manual = true
+
+	loanModel := models.NewLoanModel(service)
+	disburseModel := models.NewDisburseModel(service)
+	scheduleModel := models.NewScheduleModel(service)
+	paymentModel := models.NewPaymentModel(service)
+
+	loanController := NewLoanController(loanModel, disburseModel, scheduleModel, paymentModel, models.NewLoanProductModel(service), models.NewUserModel(service),
+		models.NewOfficerModel(service), models.NewAgentModel(service), models.NewGroupModel(service), models.NewMemberModel(service), flow.jobModel,
+		models.NewApprovalModel(service), flow.simulator, nil, nil, bureau.NewFileBureau(filepath.Join(t.TempDir(), "bureau.json")))
+	mpesaController := NewMpesaController(loanModel, disburseModel, scheduleModel, paymentModel, models.NewAllocationModel(service),
+		models.NewLedgerModel(service), models.NewSavingsModel(service), flow.simulator)
+
+	// The user is set the way AdvancedAuth sets it
+	asUser := func(user models.User) gin.HandlerFunc {
+		return func(c *gin.Context) { c.Set("user", user) }
+	}
+	flow.engine.POST("/loans", asUser(flow.agent), loanController.CreateLoanController)
+	flow.engine.PATCH("/loans/:id/approve", asUser(flow.officer), loanController.ApproveLoanController)
+	flow.engine.POST("/loans/:id/repay", asUser(flow.agent), loanController.RepayLoanController)
+	flow.engine.POST("/loans/:id/disbursements/retry", asUser(flow.officer), loanController.RetryDisbursementController)
+
+	callbackAuth := middlewares.MpesaCallbackAuth(mpesaConfig)
+	flow.engine.POST(payments.B2CResultPath, callbackAuth, mpesaController.DisburseCallbackController)
+	flow.engine.POST(payments.B2CTimeoutPath, callbackAuth, mpesaController.DisburseTimeoutController)
+	flow.engine.POST(payments.STKCallbackPath, callbackAuth, mpesaController.PaymentCallbackController)
+
+	return flow
+}
+
+func mustCreate(t *testing.T, service services.Service, entity interface{}) {
+	t.Helper()
+	if err := service.CreateEntity(entity); err != nil {
+		t.Fatalf("failed to create %T: %v", entity, err)
+	}
+}
+
+// request serves a JSON request and fails the test unless it gets the wanted status
+func (f *loanFlow) request(t *testing.T, method, path string, body interface{}, wantStatus int) {
+	t.Helper()
+
+	payload, err := json.Marshal(body)
+	if err != nil {
+		t.Fatalf("failed to encode %s %s: %v", method, path, err)
+	}
+
+	req := httptest.NewRequest(method, path, bytes.NewReader(payload))
+	req.Header.Set("Content-Type", "application/json")
+	recorder := httptest.NewRecorder()
+	f.engine.ServeHTTP(recorder, req)
+
+	if recorder.Code != wantStatus {
+		t.Fatalf("%s %s = %d %s, want %d", method, path, recorder.Code, recorder.Body.String(), wantStatus)
+	}
+}
+
+// createApprovedLoan applies for a loan and signs it off, which queues its payout
+func (f *loanFlow) createApprovedLoan(t *testing.T, amount money.Amount, term int) models.Loan {
+	t.Helper()
+
+	f.request(t, http.MethodPost, "/loans", gin.H{
+		"Amount": amount, "Term": term, "LoanPurpose": "Stock for the shop",
+		"GroupID": f.group.ID, "MemberID": f.member.ID, "ProductID": f.product.ID,
+	}, http.StatusCreated)
+
+	var loans []models.Loan
+	result, err := f.service.GetEntitiesByFields(&loans, map[string]interface{}{"member_id": f.member.ID, "status": models.LoanStatusPending})
+	if err != nil || len(*result.(*[]models.Loan)) != 1 {
+		t.Fatalf("expected one pending loan, got %v (%v)", result, err)
+	}
+	loan := (*result.(*[]models.Loan))[0]
+
+	f.request(t, http.MethodPatch, fmt.Sprintf("/loans/%d/approve", loan.ID), nil, http.StatusOK)
+	f.expectLoan(t, loan.ID, models.LoanStatusApproved)
+
+	return loan
+}
+
+func (f *loanFlow) expectLoan(t *testing.T, id uint, status string) models.Loan {
+	t.Helper()
+
+	loan := models.Loan{ID: id}
+	if _, err := f.service.GetEntityByID(&loan, id); err != nil {
+		t.Fatalf("failed to get loan %d: %v", id, err)
+	}
+	if loan.Status != status {
+		t.Fatalf("loan %d is %s, want %s", id, loan.Status, status)
+	}
+	return loan
+}
+
+func (f *loanFlow) expectJob(t *testing.T, loanId uint, status string) models.DisbursementJob {
+	t.Helper()
+
+	queued, err := f.jobModel.GetLoanDisbursementJobs(loanId)
+	if err != nil || len(queued) != 1 {
+		t.Fatalf("expected one disbursement job for loan %d, got %d (%v)", loanId, len(queued), err)
+	}
+	if queued[0].Status != status {
+		t.Fatalf("disbursement job for loan %d is %s (%s), want %s", loanId, queued[0].Status, queued[0].LastError, status)
+	}
+	return queued[0]
+}
+
+// timeOut delivers M-Pesa's timeout for the disbursement while its result is still held by
+// the simulator, which dead-letters the job
+func (f *loanFlow) timeOut(t *testing.T, disbursementId uint) {
+	t.Helper()
+
+	disbursement := f.expectDisbursement(t, disbursementId, models.DisbursementStatusPending)
+	timeout := mpesa.Callback{Result: mpesa.CallbackResult{
+		OriginatorConversationID: disbursement.OriginatorConversationID,
+		ConversationID:           disbursement.ConversationID,
+		ResultCode:               1,
+		ResultDesc:               "The request timed out",
+	}}
+	f.request(t, http.MethodPost, payments.B2CTimeoutPath+"?"+payments.CallbackTokenParam+"=callback-token", timeout, http.StatusOK)
+}
+
+func (f *loanFlow) expectDisbursement(t *testing.T, id uint, status string) models.Disbursement {
+	t.Helper()
+
+	disbursement, err := models.NewDisburseModel(f.service).GetDisbursementByField("id", strconv.Itoa(int(id)))
+	if err != nil {
+		t.Fatalf("failed to get disbursement %d: %v", id, err)
+	}
+	if disbursement.Status != status {
+		t.Fatalf("disbursement %d is %s, want %s", id, disbursement.Status, status)
+	}
+	return *disbursement
+}
+
+func TestLoanFlowAgainstSimulator(t *testing.T) {
+	flow := newLoanFlow(t)
+
+	loan := flow.createApprovedLoan(t, money.FromShillings(10000), 2)
+	flow.expectJob(t, loan.ID, models.DisbursementJobQueued)
+
+	if err := jobs.ProcessDisbursementQueue(flow.jobModel, flow.simulator, time.Now()); err != nil {
+		t.Fatalf("ProcessDisbursementQueue returned %v", err)
+	}
+	flow.expectJob(t, loan.ID, models.DisbursementJobSubmitted)
+	flow.expectLoan(t, loan.ID, models.LoanStatusDisbursing)
+
+	// The B2C result completes the payout and starts the schedule
+	flow.simulator.Flush()
+	flow.expectJob(t, loan.ID, models.DisbursementJobCompleted)
+	active := flow.expectLoan(t, loan.ID, models.LoanStatusActive)
+	if want := money.FromShillings(10200); active.RemainingBalance != want {
+		t.Fatalf("remaining balance after disbursement = %s, want %s", active.RemainingBalance, want)
+	}
+
+	// Two STK repayments, the second clearing the loan
+	repayPath := fmt.Sprintf("/loans/%d/repay", loan.ID)
+	flow.request(t, http.MethodPost, repayPath, gin.H{"Amount": money.FromShillings(5100)}, http.StatusOK)
+	flow.simulator.Flush()
+	partial := flow.expectLoan(t, loan.ID, models.LoanStatusActive)
+	if want := money.FromShillings(5100); partial.RemainingBalance != want {
+		t.Fatalf("remaining balance after the first repayment = %s, want %s", partial.RemainingBalance, want)
+	}
+
+	flow.request(t, http.MethodPost, repayPath, gin.H{"Amount": money.FromShillings(5100)}, http.StatusOK)
+	flow.simulator.Flush()
+	closed := flow.expectLoan(t, loan.ID, models.LoanStatusClosed)
+	if !closed.IsFullyPaid || closed.RemainingBalance != 0 {
+		t.Fatalf("closed loan has %s remaining, fully paid %t", closed.RemainingBalance, closed.IsFullyPaid)
+	}
+
+	instalments, err := models.NewScheduleModel(flow.service).GetLoanSchedule(loan.ID)
+	if err != nil {
+		t.Fatalf("failed to get schedule: %v", err)
+	}
+	for _, instalment := range instalments {
+		if instalment.Status != models.InstalmentStatusPaid {
+			t.Errorf("instalment %d is %s after the loan closed", instalment.Number, instalment.Status)
+		}
+	}
+}
+
+func TestLoanFlowDeadLettersTimedOutPayout(t *testing.T) {
+	flow := newLoanFlow(t)
+
+	loan := flow.createApprovedLoan(t, money.FromShillings(5000), 1)
+	if err := jobs.ProcessDisbursementQueue(flow.jobModel, flow.simulator, time.Now()); err != nil {
+		t.Fatalf("ProcessDisbursementQueue returned %v", err)
+	}
+	job := flow.expectJob(t, loan.ID, models.DisbursementJobSubmitted)
+
+	flow.timeOut(t, *job.DisbursementID)
+
+	dead := flow.expectJob(t, loan.ID, models.DisbursementJobDead)
+	if dead.LastError == "" {
+		t.Errorf("dead-lettered job has no reason")
+	}
+	flow.expectLoan(t, loan.ID, models.LoanStatusApproved)
+
+	// The worker leaves dead-lettered jobs for an officer instead of paying out again
+	if err := jobs.ProcessDisbursementQueue(flow.jobModel, flow.simulator, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("ProcessDisbursementQueue returned %v", err)
+	}
+	flow.expectJob(t, loan.ID, models.DisbursementJobDead)
+
+	// The result that still arrives disburses the loan
+	flow.simulator.Flush()
+	flow.expectJob(t, loan.ID, models.DisbursementJobCompleted)
+	flow.expectLoan(t, loan.ID, models.LoanStatusActive)
+}
+
+func TestLoanFlowRetriesFailedPayoutsUntilDeadLettered(t *testing.T) {
+	flow := newLoanFlow(t)
+	flow.simulator.Outcome = func(kind string, phoneNumber uint64, amount uint) int {
+		return paymentstest.SimulatorResultInsufficientFunds
+	}
+
+	loan := flow.createApprovedLoan(t, money.FromShillings(5000), 1)
+
+	now := time.Now()
+	for attempt := 1; attempt <= models.DisbursementMaxAttempts; attempt++ {
+		if err := jobs.ProcessDisbursementQueue(flow.jobModel, flow.simulator, now); err != nil {
+			t.Fatalf("ProcessDisbursementQueue returned %v", err)
+		}
+		failedAt := time.Now()
+		flow.simulator.Flush()
+
+		want := models.DisbursementJobQueued
+		if attempt == models.DisbursementMaxAttempts {
+			want = models.DisbursementJobDead
+		}
+		job := flow.expectJob(t, loan.ID, want)
+		if job.Attempts != attempt {
+			t.Fatalf("job has %d attempts after attempt %d", job.Attempts, attempt)
+		}
+		flow.expectLoan(t, loan.ID, models.LoanStatusApproved)
+
+		// Nothing is tried again before the backoff has passed
+		if job.Status == models.DisbursementJobQueued {
+			if earliest := failedAt.Add(models.DisbursementBackoff(attempt)); job.NextAttemptAt.Before(earliest) {
+				t.Fatalf("attempt %d queued the next for %s, before %s", attempt, job.NextAttemptAt, earliest)
+			}
+			if err := jobs.ProcessDisbursementQueue(flow.jobModel, flow.simulator, job.NextAttemptAt.Add(-time.Second)); err != nil {
+				t.Fatalf("ProcessDisbursementQueue returned %v", err)
+			}
+			flow.expectJob(t, loan.ID, models.DisbursementJobQueued)
+			now = job.NextAttemptAt
+		}
+	}
+}
+
+func TestLoanFlowRetryWaitsForAPendingPayout(t *testing.T) {
+	flow := newLoanFlow(t)
+
+	loan := flow.createApprovedLoan(t, money.FromShillings(5000), 1)
+	if err := jobs.ProcessDisbursementQueue(flow.jobModel, flow.simulator, time.Now()); err != nil {
+		t.Fatalf("ProcessDisbursementQueue returned %v", err)
+	}
+	first := *flow.expectJob(t, loan.ID, models.DisbursementJobSubmitted).DisbursementID
+	flow.timeOut(t, first)
+	flow.expectJob(t, loan.ID, models.DisbursementJobDead)
+
+	// The timed out payout may yet reach the borrower, so it has to be checked on M-Pesa first
+	retryPath := fmt.Sprintf("/loans/%d/disbursements/retry", loan.ID)
+	flow.request(t, http.MethodPost, retryPath, gin.H{}, http.StatusConflict)
+	flow.expectJob(t, loan.ID, models.DisbursementJobDead)
+
+	flow.request(t, http.MethodPost, retryPath, gin.H{"AbandonPending": true}, http.StatusOK)
+	flow.expectJob(t, loan.ID, models.DisbursementJobQueued)
+	flow.expectDisbursement(t, first, models.DisbursementStatusAbandoned)
+
+	// The abandoned payout went through after all, which cancels the queued retry
+	flow.simulator.Flush()
+	job := flow.expectJob(t, loan.ID, models.DisbursementJobCompleted)
+	if *job.DisbursementID != first {
+		t.Errorf("job completed by disbursement %d, want %d", *job.DisbursementID, first)
+	}
+	flow.expectDisbursement(t, first, models.DisbursementStatusCompleted)
+	flow.expectLoan(t, loan.ID, models.LoanStatusActive)
+
+	if err := jobs.ProcessDisbursementQueue(flow.jobModel, flow.simulator, time.Now()); err != nil {
+		t.Fatalf("ProcessDisbursementQueue returned %v", err)
+	}
+	flow.simulator.Flush()
+	flow.expectJob(t, loan.ID, models.DisbursementJobCompleted)
+
+	var disbursements []models.Disbursement
+	result, err := flow.service.GetEntitiesByFields(&disbursements, map[string]interface{}{"loan_id": loan.ID})
+	if err != nil || len(*result.(*[]models.Disbursement)) != 1 {
+		t.Fatalf("expected the one disbursement, got %v (%v)", result, err)
+	}
+}
+
+func TestLoanFlowLateResultDuringARetry(t *testing.T) {
+	flow := newLoanFlow(t)
+
+	loan := flow.createApprovedLoan(t, money.FromShillings(5000), 1)
+	if err := jobs.ProcessDisbursementQueue(flow.jobModel, flow.simulator, time.Now()); err != nil {
+		t.Fatalf("ProcessDisbursementQueue returned %v", err)
+	}
+	first := *flow.expectJob(t, loan.ID, models.DisbursementJobSubmitted).DisbursementID
+	flow.timeOut(t, first)
+
+	flow.request(t, http.MethodPost, fmt.Sprintf("/loans/%d/disbursements/retry", loan.ID), gin.H{"AbandonPending": true}, http.StatusOK)
+	if err := jobs.ProcessDisbursementQueue(flow.jobModel, flow.simulator, time.Now()); err != nil {
+		t.Fatalf("ProcessDisbursementQueue returned %v", err)
+	}
+	second := *flow.expectJob(t, loan.ID, models.DisbursementJobSubmitted).DisbursementID
+
+	// Both payouts reach the borrower. The first disburses the loan and the second is recorded
+	// without disbursing it again.
+	flow.simulator.Flush()
+	job := flow.expectJob(t, loan.ID, models.DisbursementJobCompleted)
+	if *job.DisbursementID != first {
+		t.Errorf("job completed by disbursement %d, want %d", *job.DisbursementID, first)
+	}
+	flow.expectDisbursement(t, first, models.DisbursementStatusCompleted)
+	duplicate := flow.expectDisbursement(t, second, models.DisbursementStatusCompleted)
+	if duplicate.JobID == nil || *duplicate.JobID != job.ID {
+		t.Errorf("duplicate payout is linked to job %v, want %d", duplicate.JobID, job.ID)
+	}
+
+	active := flow.expectLoan(t, loan.ID, models.LoanStatusActive)
+	instalments, err := models.NewScheduleModel(flow.service).GetLoanSchedule(loan.ID)
+	if err != nil {
+		t.Fatalf("failed to get schedule: %v", err)
+	}
+	if len(instalments) != active.Term {
+		t.Errorf("loan has %d instalments over a term of %d", len(instalments), active.Term)
+	}
+}
+
+func TestLoanFlowFinalizesALegacyPayout(t *testing.T) {
+	flow := newLoanFlow(t)
+
+	// A payout sent straight from the approval, before payouts were queued, left the loan
+	// approved and the amount unrecorded
+	loan := flow.createApprovedLoan(t, money.FromShillings(5000), 1)
+	legacy := models.Disbursement{LoanID: loan.ID, OriginatorConversationID: "29115-legacy-1", ConversationID: "AG_legacy_1", ResponseCode: "0", ResponseDesc: "Accepted", Status: models.DisbursementStatusPending}
+	mustCreate(t, flow.service, &legacy)
+
+	if err := migrations.MoveLegacyPayouts(database.DB); err != nil {
+		t.Fatalf("MoveLegacyPayouts returned %v", err)
+	}
+	flow.expectLoan(t, loan.ID, models.LoanStatusDisbursing)
+	if moved := flow.expectDisbursement(t, legacy.ID, models.DisbursementStatusPending); moved.Amount != money.FromShillings(5000) {
+		t.Errorf("legacy payout amount = %s, want 5000.00", moved.Amount)
+	}
+
+	result := mpesa.Callback{Result: mpesa.CallbackResult{
+		OriginatorConversationID: legacy.OriginatorConversationID,
+		ConversationID:           legacy.ConversationID,
+		ResultDesc:               "The service request is processed successfully.",
+		TransactionID:            "LEGACY0001",
+	}}
+	flow.request(t, http.MethodPost, payments.B2CResultPath+"?"+payments.CallbackTokenParam+"=callback-token", result, http.StatusOK)
+
+	flow.expectDisbursement(t, legacy.ID, models.DisbursementStatusCompleted)
+	if active := flow.expectLoan(t, loan.ID, models.LoanStatusActive); active.RemainingBalance == 0 {
+		t.Errorf("legacy payout activated the loan without a schedule")
+	}
+}
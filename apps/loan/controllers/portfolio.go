@@ -0,0 +1,260 @@
+package controllers
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/kifangamukundi/gm/libs/binders"
+	"github.com/kifangamukundi/gm/libs/exporters"
+	"github.com/kifangamukundi/gm/loan/bindings"
+	"github.com/kifangamukundi/gm/loan/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Columns of the exported reports, in order
+var (
+	parExportFields   = []string{"Measure", "Loans", "Outstanding", "Ratio"}
+	agingExportFields = []string{"Bucket", "Loans", "Outstanding", "Arrears", "Share"}
+)
+
+type PortfolioController struct {
+	PortfolioModel *models.PortfolioModel
+}
+
+func NewPortfolioController(portfolioModel *models.PortfolioModel) *PortfolioController {
+	return &PortfolioController{PortfolioModel: portfolioModel}
+}
+
+// idQuery reads an optional ID from the query string, 0 when it is missing. It responds with
+// 400 and returns false when the value is not an ID.
+func idQuery(c *gin.Context, name string) (uint, bool) {
+	value := c.Query(name)
+	if value == "" {
+		return 0, true
+	}
+
+	id, err := strconv.ParseUint(value, 10, 32)
+	if err != nil || id == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + name})
+		return 0, false
+	}
+
+	return uint(id), true
+}
+
+// portfolioFilter reads the report filters from the query string
+func portfolioFilter(c *gin.Context) (models.PortfolioFilter, bool) {
+	var filter models.PortfolioFilter
+	var ok bool
+
+	if filter.AgentID, ok = idQuery(c, "agentId"); !ok {
+		return filter, false
+	}
+	if filter.OfficerID, ok = idQuery(c, "officerId"); !ok {
+		return filter, false
+	}
+	if filter.GroupID, ok = idQuery(c, "groupId"); !ok {
+		return filter, false
+	}
+	if filter.RegionID, ok = idQuery(c, "regionId"); !ok {
+		return filter, false
+	}
+	if filter.From, ok = dateQuery(c, "from"); !ok {
+		return filter, false
+	}
+	if filter.To, ok = dateQuery(c, "to"); !ok {
+		return filter, false
+	}
+	if filter.From != nil && filter.To != nil && filter.To.Before(*filter.From) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "to must not be before from"})
+		return filter, false
+	}
+
+	return filter, true
+}
+
+// reportDate reads the optional asOf date the report is run for. The report covers the whole
+// of that day and defaults to now. Future dates are refused.
+func reportDate(c *gin.Context) (time.Time, bool) {
+	now := time.Now()
+
+	date, ok := dateQuery(c, "asOf")
+	if !ok {
+		return now, false
+	}
+	if date == nil {
+		return now, true
+	}
+
+	asOf := nextDay(date).Add(-time.Nanosecond)
+	if date.After(now) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "asOf must not be in the future"})
+		return now, false
+	}
+	if asOf.After(now) {
+		asOf = now
+	}
+
+	return asOf, true
+}
+
+// portfolioReport builds the report for the request's filters, or responds with the error
+func (ctrl *PortfolioController) portfolioReport(c *gin.Context) (models.PortfolioReport, bool) {
+	filter, ok := portfolioFilter(c)
+	if !ok {
+		return models.PortfolioReport{}, false
+	}
+
+	asOf, ok := reportDate(c)
+	if !ok {
+		return models.PortfolioReport{}, false
+	}
+
+	report, err := ctrl.PortfolioModel.Report(filter, asOf)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error building portfolio report: " + err.Error()})
+		return report, false
+	}
+
+	return report, true
+}
+
+func portfolioFilterResponse(filter models.PortfolioFilter) bindings.PortfolioFilterResponse {
+	return bindings.PortfolioFilterResponse{
+		AgentID:   filter.AgentID,
+		OfficerID: filter.OfficerID,
+		GroupID:   filter.GroupID,
+		RegionID:  filter.RegionID,
+		From:      filter.From,
+		To:        filter.To,
+	}
+}
+
+// roundPercent keeps two decimal places of a percentage
+func roundPercent(percent float64) float64 {
+	return math.Round(percent*100) / 100
+}
+
+// exportReport writes rows to a temporary XLSX or PDF file and sends it as an attachment
+// named after the report. Formats other than xlsx and pdf are refused.
+func exportReport(c *gin.Context, format, name, title string, rows interface{}, fields []string) {
+	if format != "xlsx" && format != "pdf" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid format, expected xlsx or pdf"})
+		return
+	}
+
+	file, err := os.CreateTemp("", name+"-*."+format)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating export: " + err.Error()})
+		return
+	}
+	file.Close()
+	defer os.Remove(file.Name())
+
+	if format == "xlsx" {
+		err = exporters.ExportToXLSX(rows, file.Name(), fields)
+	} else {
+		err = exporters.ExportToPDF(rows, file.Name(), fields, title)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error exporting report: " + err.Error()})
+		return
+	}
+
+	c.FileAttachment(file.Name(), fmt.Sprintf("%s-%s.%s", name, time.Now().Format("2006-01-02"), format))
+}
+
+// GetParReportController reports PAR1, PAR30, PAR60 and PAR90 over the portfolio loans that
+// match the agentId, officerId, groupId, regionId and disbursement from and to filters, as at
+// the end of the asOf day or now. With ?format=xlsx or ?format=pdf the ratios are downloaded
+// instead.
+func (ctrl *PortfolioController) GetParReportController(c *gin.Context) {
+	report, ok := ctrl.portfolioReport(c)
+	if !ok {
+		return
+	}
+
+	par := make([]bindings.ParRatioResponse, 0, len(report.Par))
+	for _, ratio := range report.Par {
+		par = append(par, bindings.ParRatioResponse{
+			Measure:     fmt.Sprintf("PAR%d", ratio.Threshold),
+			Loans:       ratio.Loans,
+			Outstanding: ratio.Outstanding,
+			Ratio:       roundPercent(ratio.Ratio),
+		})
+	}
+
+	if format := c.Query("format"); format != "" {
+		exportReport(c, format, "portfolio-at-risk", "Portfolio at Risk", par, parExportFields)
+		return
+	}
+
+	binders.ReturnJSONGeneralResponse(c, bindings.ParReportResponse{
+		AsOf:        report.AsOf,
+		Filter:      portfolioFilterResponse(report.Filter),
+		LoanCount:   report.LoanCount,
+		Outstanding: report.Outstanding,
+		Arrears:     report.Arrears,
+		Par:         par,
+	})
+}
+
+// GetAgingReportController buckets the portfolio loans that match the filters by the age of
+// their oldest unpaid instalment, listing each loan. With ?format=xlsx or ?format=pdf the
+// buckets are downloaded instead.
+func (ctrl *PortfolioController) GetAgingReportController(c *gin.Context) {
+	report, ok := ctrl.portfolioReport(c)
+	if !ok {
+		return
+	}
+
+	buckets := make([]bindings.AgingBucketResponse, 0, len(report.Aging))
+	for _, bucket := range report.Aging {
+		buckets = append(buckets, bindings.AgingBucketResponse{
+			Bucket:      bucket.Name,
+			MinDays:     bucket.MinDays,
+			MaxDays:     bucket.MaxDays,
+			Loans:       bucket.Loans,
+			Outstanding: bucket.Outstanding,
+			Arrears:     bucket.Arrears,
+			Share:       roundPercent(bucket.Share),
+		})
+	}
+
+	if format := c.Query("format"); format != "" {
+		exportReport(c, format, "arrears-aging", "Arrears Aging", buckets, agingExportFields)
+		return
+	}
+
+	loans := make([]bindings.PortfolioLoanResponse, 0, len(report.Loans))
+	for _, loan := range report.Loans {
+		loans = append(loans, bindings.PortfolioLoanResponse{
+			LoanID:      loan.LoanID,
+			MemberID:    loan.MemberID,
+			AgentID:     loan.AgentID,
+			GroupID:     loan.GroupID,
+			OfficerID:   loan.OfficerID,
+			Status:      loan.Status,
+			DisbursedAt: loan.DisbursedAt,
+			Outstanding: loan.Outstanding,
+			Arrears:     loan.Arrears,
+			DaysPastDue: loan.DaysPastDue,
+			Bucket:      loan.Bucket,
+		})
+	}
+
+	binders.ReturnJSONGeneralResponse(c, bindings.AgingReportResponse{
+		AsOf:        report.AsOf,
+		Filter:      portfolioFilterResponse(report.Filter),
+		LoanCount:   report.LoanCount,
+		Outstanding: report.Outstanding,
+		Arrears:     report.Arrears,
+		Buckets:     buckets,
+		Loans:       loans,
+	})
+}
@@ -0,0 +1,148 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kifangamukundi/gm/libs/binders"
+	"github.com/kifangamukundi/gm/libs/parameters"
+	"github.com/kifangamukundi/gm/loan/bindings"
+	"github.com/kifangamukundi/gm/loan/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+type RefinanceController struct {
+	RefinanceModel *models.RefinanceModel
+	LoanModel      *models.LoanModel
+	ProductModel   *models.LoanProductModel
+}
+
+func NewRefinanceController(refinanceModel *models.RefinanceModel, loanModel *models.LoanModel, productModel *models.LoanProductModel) *RefinanceController {
+	return &RefinanceController{RefinanceModel: refinanceModel, LoanModel: loanModel, ProductModel: productModel}
+}
+
+// CreateTopUpController creates a new loan for the borrower of an active loan. Once approved
+// and paid out it settles what is still owed on the existing loan and only the difference
+// reaches the borrower.
+func (ctrl *RefinanceController) CreateTopUpController(c *gin.Context) {
+	id, valid := parameters.ConvertParamToValidID(c, "id")
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	var req bindings.TopUpLoanRequest
+	if !binders.ValidateBindJSONRequest(c, &req) {
+		return
+	}
+
+	previous, err := ctrl.LoanModel.GetLoanByFieldPreloaded("id", string(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Loan not found"})
+		return
+	}
+
+	product := previous.Product
+	if req.ProductID != 0 {
+		product, err = ctrl.ProductModel.GetLoanProductByField("id", strconv.Itoa(req.ProductID))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Loan product not found"})
+			return
+		}
+	}
+	if product == nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "A loan product is required for the top-up"})
+		return
+	}
+
+	if err := product.ValidateTerms(req.Amount, req.Term); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+
+	description := parameters.SanitizeText(*req.LoanPurpose, false)
+
+	topUp := models.Loan{
+		Amount:             req.Amount,
+		Interest:           product.InterestRate,
+		RatePerPeriod:      product.RatePerPeriod,
+		Term:               req.Term,
+		ProductID:          &product.ID,
+		InterestMethod:     product.InterestMethod,
+		RepaymentFrequency: product.RepaymentFrequency,
+		Fees:               product.CalculateFees(req.Amount),
+		GracePeriod:        product.GracePeriod,
+		LoanPurpose:        &description,
+	}
+
+	now := time.Now()
+	if err := ctrl.RefinanceModel.CreateTopUp(previous, &topUp, product, now); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, models.ErrNotRefinanceable) {
+			status = http.StatusConflict
+		} else if errors.Is(err, models.ErrTopUpTooSmall) || errors.Is(err, models.ErrTopUpNotEligible) {
+			status = http.StatusUnprocessableEntity
+		}
+		c.JSON(status, gin.H{"error": "Error creating top-up: " + err.Error()})
+		return
+	}
+
+	payoff, err := ctrl.RefinanceModel.Payoff(previous, now)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	net, err := ctrl.RefinanceModel.NetDisbursement(&topUp, now)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	binders.ReturnJSONResponse(c, http.StatusCreated, true, gin.H{binders.ItemKey: bindings.TopUpLoanResponse{
+		LoanID:             topUp.ID,
+		RefinancedLoanID:   previous.ID,
+		Amount:             topUp.Amount,
+		OutstandingBalance: payoff.Total,
+		NetDisbursement:    net,
+	}})
+}
+
+// GetRefinanceChainController lists the loans linked to this one by top-ups, oldest first
+func (ctrl *RefinanceController) GetRefinanceChainController(c *gin.Context) {
+	id, valid := parameters.ConvertParamToValidID(c, "id")
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	idUint, err := strconv.ParseUint(string(id), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	chain, err := ctrl.RefinanceModel.GetRefinanceChain(uint(idUint))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Loan not found"})
+		return
+	}
+
+	items := make([]bindings.RefinanceChainLoanResponse, 0, len(chain))
+	for _, loan := range chain {
+		items = append(items, bindings.RefinanceChainLoanResponse{
+			LoanID:           loan.ID,
+			RefinancedLoanID: loan.RefinancedLoanID,
+			Amount:           loan.Amount,
+			SettlementAmount: loan.SettlementAmount,
+			Status:           loan.Status,
+			DisbursedAt:      loan.DisbursedAt,
+			CreatedAt:        loan.CreatedAt,
+		})
+	}
+
+	binders.ReturnJSONGeneralResponse(c, items)
+}
@@ -0,0 +1,247 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kifangamukundi/gm/libs/binders"
+	"github.com/kifangamukundi/gm/libs/parameters"
+	"github.com/kifangamukundi/gm/loan/bindings"
+	"github.com/kifangamukundi/gm/loan/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+type RestructureController struct {
+	RestructureModel *models.RestructureModel
+	LoanModel        *models.LoanModel
+}
+
+func NewRestructureController(restructureModel *models.RestructureModel, loanModel *models.LoanModel) *RestructureController {
+	return &RestructureController{RestructureModel: restructureModel, LoanModel: loanModel}
+}
+
+// restructureErrorStatus maps restructure errors to the HTTP status the client should see
+func restructureErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, models.ErrSelfApproval):
+		return http.StatusForbidden
+	case errors.Is(err, models.ErrRestructureNotPending):
+		return http.StatusConflict
+	}
+	return transitionErrorStatus(err)
+}
+
+// restructureResponse maps a restructure and the schedule it replaced to their API representation
+func restructureResponse(restructure models.LoanRestructure) bindings.RestructureResponse {
+	response := bindings.RestructureResponse{
+		ID:                   restructure.ID,
+		LoanID:               restructure.LoanID,
+		Status:               restructure.Status,
+		ArrearsTreatment:     restructure.ArrearsTreatment,
+		Term:                 restructure.Term,
+		InterestRate:         restructure.InterestRate,
+		RatePerPeriod:        restructure.RatePerPeriod,
+		GracePeriod:          restructure.GracePeriod,
+		Reason:               restructure.Reason,
+		RequestedByFirstName: restructure.RequestedBy.FirstName,
+		RequestedByLastName:  restructure.RequestedBy.LastName,
+		DecisionReason:       restructure.DecisionReason,
+		DecidedAt:            restructure.DecidedAt,
+		CreatedAt:            restructure.CreatedAt,
+		PreviousTerm:         restructure.PreviousTerm,
+		PreviousRate:         restructure.PreviousRate,
+		PreviousDueDate:      restructure.PreviousDueDate,
+		OutstandingPrincipal: restructure.OutstandingPrincipal,
+		ArrearsInterest:      restructure.ArrearsInterest,
+		ArrearsFees:          restructure.ArrearsFees,
+		ArrearsPenalty:       restructure.ArrearsPenalty,
+		Capitalized:          restructure.Capitalized,
+		Waived:               restructure.Waived,
+		NewPrincipal:         restructure.NewPrincipal,
+		NewInterest:          restructure.NewInterest,
+		OriginalSchedule:     make([]bindings.InstalmentResponse, 0, len(restructure.OriginalSchedule)),
+	}
+
+	if restructure.DecidedBy != nil {
+		response.DecidedByFirstName = restructure.DecidedBy.FirstName
+		response.DecidedByLastName = restructure.DecidedBy.LastName
+	}
+
+	for _, instalment := range restructure.OriginalSchedule {
+		paid := instalment.PrincipalPaid + instalment.InterestPaid + instalment.FeesPaid + instalment.PenaltyPaid
+		response.OriginalSchedule = append(response.OriginalSchedule, bindings.InstalmentResponse{
+			ID:             instalment.ID,
+			Number:         instalment.Number,
+			DueDate:        instalment.DueDate,
+			Principal:      instalment.Principal,
+			Interest:       instalment.Interest,
+			Fees:           instalment.Fees,
+			TotalDue:       instalment.TotalDue,
+			OpeningBalance: instalment.OpeningBalance,
+			ClosingBalance: instalment.ClosingBalance,
+			Status:         instalment.Status,
+			Penalty:        instalment.Penalty,
+			AmountPaid:     paid,
+			Balance:        instalment.TotalDue + instalment.Penalty - paid,
+			PaidAt:         instalment.PaidAt,
+		})
+	}
+
+	return response
+}
+
+// pathID reads the numeric ID from the path
+func pathID(c *gin.Context) (uint, bool) {
+	id, valid := parameters.ConvertParamToValidID(c, "id")
+	if !valid {
+		return 0, false
+	}
+
+	idUint, err := strconv.ParseUint(string(id), 10, 32)
+	if err != nil {
+		return 0, false
+	}
+
+	return uint(idUint), true
+}
+
+// RequestRestructureController asks for an active or in arrears loan to be rescheduled.
+// Nothing changes on the loan until another user approves the request.
+func (ctrl *RestructureController) RequestRestructureController(c *gin.Context) {
+	id, valid := parameters.ConvertParamToValidID(c, "id")
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	var req bindings.RestructureLoanRequest
+	if !binders.ValidateBindJSONRequest(c, &req) {
+		return
+	}
+
+	loan, err := ctrl.LoanModel.GetLoanByFieldPreloaded("id", string(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Loan not found"})
+		return
+	}
+
+	decodedUser, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+	u := decodedUser.(models.User)
+
+	restructure := models.LoanRestructure{
+		LoanID:           loan.ID,
+		ArrearsTreatment: req.ArrearsTreatment,
+		Term:             req.Term,
+		InterestRate:     *req.InterestRate,
+		GracePeriod:      req.GracePeriod,
+		Reason:           req.Reason,
+		RequestedByID:    u.ID,
+	}
+
+	if err := ctrl.RestructureModel.RequestRestructure(&restructure); err != nil {
+		c.JSON(restructureErrorStatus(err), gin.H{"error": "Error requesting restructure: " + err.Error()})
+		return
+	}
+
+	restructure.RequestedBy = u
+	binders.ReturnJSONResponse(c, http.StatusCreated, true, gin.H{binders.ItemKey: restructureResponse(restructure)})
+}
+
+// GetLoanRestructuresController lists a loan's restructures with the schedule each one replaced
+func (ctrl *RestructureController) GetLoanRestructuresController(c *gin.Context) {
+	id, valid := parameters.ConvertParamToValidID(c, "id")
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	loan, err := ctrl.LoanModel.GetLoanByFieldPreloaded("id", string(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Loan not found"})
+		return
+	}
+
+	restructures, err := ctrl.RestructureModel.GetLoanRestructures(loan.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching restructures: " + err.Error()})
+		return
+	}
+
+	items := make([]bindings.RestructureResponse, 0, len(restructures))
+	for _, restructure := range restructures {
+		items = append(items, restructureResponse(restructure))
+	}
+
+	binders.ReturnJSONGeneralResponse(c, items)
+}
+
+// ApproveRestructureController applies a pending restructure. The user who requested it
+// cannot approve it.
+func (ctrl *RestructureController) ApproveRestructureController(c *gin.Context) {
+	id, valid := pathID(c)
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	decodedUser, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+	u := decodedUser.(models.User)
+
+	if _, err := ctrl.RestructureModel.ApproveRestructure(id, u.ID, time.Now()); err != nil {
+		c.JSON(restructureErrorStatus(err), gin.H{"error": "Error approving restructure: " + err.Error()})
+		return
+	}
+
+	restructure, err := ctrl.RestructureModel.GetRestructureByField("id", strconv.FormatUint(uint64(id), 10))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching restructure: " + err.Error()})
+		return
+	}
+
+	binders.ReturnJSONGeneralResponse(c, restructureResponse(*restructure))
+}
+
+// RejectRestructureController closes a pending restructure without changing the loan
+func (ctrl *RestructureController) RejectRestructureController(c *gin.Context) {
+	id, valid := pathID(c)
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	var req bindings.LoanStatusReasonRequest
+	if !binders.ValidateBindJSONRequest(c, &req) {
+		return
+	}
+
+	decodedUser, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+	u := decodedUser.(models.User)
+
+	if _, err := ctrl.RestructureModel.RejectRestructure(id, u.ID, req.Reason); err != nil {
+		c.JSON(restructureErrorStatus(err), gin.H{"error": "Error rejecting restructure: " + err.Error()})
+		return
+	}
+
+	restructure, err := ctrl.RestructureModel.GetRestructureByField("id", strconv.FormatUint(uint64(id), 10))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching restructure: " + err.Error()})
+		return
+	}
+
+	binders.ReturnJSONGeneralResponse(c, restructureResponse(*restructure))
+}
@@ -0,0 +1,441 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kifangamukundi/gm/libs/binders"
+	"github.com/kifangamukundi/gm/libs/parameters"
+	"github.com/kifangamukundi/gm/loan/bindings"
+	"github.com/kifangamukundi/gm/loan/models"
+	"github.com/kifangamukundi/gm/loan/payments"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SavingsController struct {
+	SavingsModel *models.SavingsModel
+	MemberModel  *models.MemberModel
+	GroupModel   *models.GroupModel
+	UserModel    *models.UserModel
+	Gateway      payments.PaymentGateway
+}
+
+func NewSavingsController(savingsModel *models.SavingsModel, memberModel *models.MemberModel, groupModel *models.GroupModel, userModel *models.UserModel, gateway payments.PaymentGateway) *SavingsController {
+	return &SavingsController{
+		SavingsModel: savingsModel,
+		MemberModel:  memberModel,
+		GroupModel:   groupModel,
+		UserModel:    userModel,
+		Gateway:      gateway,
+	}
+}
+
+// savingsErrorStatus maps savings errors to the HTTP status the client should see
+func savingsErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, models.ErrInsufficientSavings), errors.Is(err, models.ErrNotGroupMember):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, models.ErrSavingsNotPending):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+func savingsTransactionResponse(transaction models.SavingsTransaction) bindings.SavingsTransactionResponse {
+	response := bindings.SavingsTransactionResponse{
+		ID:                     transaction.ID,
+		AccountID:              transaction.AccountID,
+		MemberID:               transaction.MemberID,
+		Type:                   transaction.Type,
+		Channel:                transaction.Channel,
+		Amount:                 transaction.Amount,
+		Status:                 transaction.Status,
+		Reference:              transaction.Reference,
+		PhoneNumber:            transaction.PhoneNumber,
+		CheckoutRequestID:      transaction.CheckoutRequestID,
+		ResultDesc:             transaction.ResultDesc,
+		ContributionScheduleID: transaction.ContributionScheduleID,
+		CompletedAt:            transaction.CompletedAt,
+		CreatedAt:              transaction.CreatedAt,
+	}
+	if transaction.RecordedBy != nil {
+		response.RecordedByFirstName = transaction.RecordedBy.FirstName
+		response.RecordedByLastName = transaction.RecordedBy.LastName
+	}
+	return response
+}
+
+func contributionScheduleResponse(schedule models.ContributionSchedule) bindings.ContributionScheduleResponse {
+	return bindings.ContributionScheduleResponse{
+		ID:        schedule.ID,
+		GroupID:   schedule.GroupID,
+		Amount:    schedule.Amount,
+		Frequency: schedule.Frequency,
+		StartDate: schedule.StartDate,
+		IsActive:  schedule.IsActive,
+		CreatedAt: schedule.CreatedAt,
+		UpdatedAt: schedule.UpdatedAt,
+	}
+}
+
+// savingsMember loads the member in the path, or responds with the error
+func (ctrl *SavingsController) savingsMember(c *gin.Context) (*models.Member, bool) {
+	id, valid := parameters.ConvertParamToValidID(c, "id")
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return nil, false
+	}
+
+	member, err := ctrl.MemberModel.GetMemberByField("id", string(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+		return nil, false
+	}
+
+	return member, true
+}
+
+// mpesaPhoneNumber returns the number an M-Pesa transaction goes through, the member's own
+// unless the request names another
+func (ctrl *SavingsController) mpesaPhoneNumber(c *gin.Context, member *models.Member, requested string) (string, uint64, bool) {
+	phoneNumber := requested
+	if phoneNumber == "" {
+		user, err := ctrl.UserModel.GetUserByFieldPreloaded("id", fmt.Sprintf("%d", member.UserID))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+			return "", 0, false
+		}
+		phoneNumber = user.MobileNumber
+	}
+
+	mobileNumber, err := strconv.ParseUint(phoneNumber, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid phone number format"})
+		return "", 0, false
+	}
+
+	return phoneNumber, mobileNumber, true
+}
+
+// GetMemberSavingsController returns the member's savings balance, what is held by their
+// savings pledges and their deposits and withdrawals
+func (ctrl *SavingsController) GetMemberSavingsController(c *gin.Context) {
+	member, ok := ctrl.savingsMember(c)
+	if !ok {
+		return
+	}
+
+	balance, err := ctrl.SavingsModel.GetSavingsBalance(member.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching savings: " + err.Error()})
+		return
+	}
+
+	transactions, err := ctrl.SavingsModel.GetMemberTransactions(member.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching savings transactions: " + err.Error()})
+		return
+	}
+
+	response := bindings.SavingsAccountResponse{
+		MemberID:      member.ID,
+		AccountNumber: models.SavingsAccountNumber(member.ID),
+		Balance:       balance.Balance,
+		Held:          balance.Held,
+		Available:     balance.Available,
+		Transactions:  make([]bindings.SavingsTransactionResponse, 0, len(transactions)),
+	}
+	for _, transaction := range transactions {
+		response.Transactions = append(response.Transactions, savingsTransactionResponse(transaction))
+	}
+
+	binders.ReturnJSONGeneralResponse(c, response)
+}
+
+// DepositSavingsController records a cash deposit into the member's savings, or prompts the
+// member to pay an M-Pesa deposit that is credited once the STK callback confirms it
+func (ctrl *SavingsController) DepositSavingsController(c *gin.Context) {
+	member, ok := ctrl.savingsMember(c)
+	if !ok {
+		return
+	}
+
+	var req bindings.SavingsDepositRequest
+	if !binders.ValidateBindJSONRequest(c, &req) {
+		return
+	}
+
+	decodedUser, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+	u := decodedUser.(models.User)
+
+	deposit := models.SavingsTransaction{
+		MemberID:     member.ID,
+		Channel:      req.Channel,
+		Amount:       req.Amount,
+		Reference:    parameters.TrimWhitespace(req.Reference),
+		RecordedByID: &u.ID,
+	}
+
+	if req.ContributionGroupID != nil {
+		schedule, err := ctrl.SavingsModel.GetGroupSchedule(*req.ContributionGroupID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching contribution schedule: " + err.Error()})
+			return
+		}
+		if schedule == nil {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Group has no contribution schedule"})
+			return
+		}
+		deposit.ContributionScheduleID = &schedule.ID
+	}
+
+	if req.Channel == models.SavingsChannelMpesa {
+		// M-Pesa only collects whole shillings
+		if !req.Amount.IsWhole() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Amount must be a whole number of shillings"})
+			return
+		}
+
+		phoneNumber, mobileNumber, ok := ctrl.mpesaPhoneNumber(c, member, req.PhoneNumber)
+		if !ok {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		stkResp, err := ctrl.Gateway.STKPush(ctx, payments.STKPushRequest{
+			PhoneNumber:      mobileNumber,
+			Amount:           uint(req.Amount.Shillings()),
+			AccountReference: models.SavingsAccountNumber(member.ID),
+			TransactionDesc:  "Savings deposit",
+		})
+		if err != nil {
+			log.Printf("STK Push Error: %v\n", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "STK Push failed. Check logs."})
+			return
+		}
+		if stkResp.ResponseCode != "0" {
+			log.Printf("M-Pesa Error: %s - %s\n", stkResp.ResponseCode, stkResp.ResponseDescription)
+			c.JSON(http.StatusBadGateway, gin.H{"error": "M-Pesa transaction failed", "message": stkResp.ResponseDescription})
+			return
+		}
+
+		deposit.PhoneNumber = phoneNumber
+		deposit.CheckoutRequestID = stkResp.CheckoutRequestID
+	}
+
+	if err := ctrl.SavingsModel.RecordDeposit(&deposit); err != nil {
+		c.JSON(savingsErrorStatus(err), gin.H{"error": "Error recording deposit: " + err.Error()})
+		return
+	}
+
+	deposit.RecordedBy = &u
+	binders.ReturnJSONResponse(c, http.StatusCreated, true, gin.H{binders.ItemKey: savingsTransactionResponse(deposit)})
+}
+
+// WithdrawSavingsController pays out savings the member has not pledged, in cash or through
+// an M-Pesa payout. The amount leaves the balance straight away and is returned if the
+// payout fails.
+func (ctrl *SavingsController) WithdrawSavingsController(c *gin.Context) {
+	member, ok := ctrl.savingsMember(c)
+	if !ok {
+		return
+	}
+
+	var req bindings.SavingsWithdrawalRequest
+	if !binders.ValidateBindJSONRequest(c, &req) {
+		return
+	}
+
+	decodedUser, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+	u := decodedUser.(models.User)
+
+	withdrawal := models.SavingsTransaction{
+		MemberID:     member.ID,
+		Channel:      req.Channel,
+		Amount:       req.Amount,
+		Reference:    parameters.TrimWhitespace(req.Reference),
+		RecordedByID: &u.ID,
+	}
+
+	var mobileNumber uint64
+	if req.Channel == models.SavingsChannelMpesa {
+		if !req.Amount.IsWhole() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Amount must be a whole number of shillings"})
+			return
+		}
+
+		withdrawal.PhoneNumber, mobileNumber, ok = ctrl.mpesaPhoneNumber(c, member, req.PhoneNumber)
+		if !ok {
+			return
+		}
+	}
+
+	if err := ctrl.SavingsModel.RecordWithdrawal(&withdrawal); err != nil {
+		c.JSON(savingsErrorStatus(err), gin.H{"error": "Error recording withdrawal: " + err.Error()})
+		return
+	}
+
+	if req.Channel == models.SavingsChannelMpesa {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		resp, err := ctrl.Gateway.B2C(ctx, payments.B2CRequest{
+			PhoneNumber: mobileNumber,
+			Amount:      uint(req.Amount.Shillings()),
+			Remarks:     "Savings withdrawal",
+			Occasion:    "Savings",
+		})
+		// The member may already have been paid when the request failed after it was sent, so
+		// the withdrawal is left pending for reconciliation rather than refunded
+		if payments.IsAmbiguous(err) {
+			log.Printf("B2C outcome unknown for savings withdrawal %d, leaving it pending: %v\n", withdrawal.ID, err)
+			c.JSON(http.StatusAccepted, gin.H{"message": "M-Pesa payout outcome unknown, the withdrawal is pending reconciliation", binders.ItemKey: savingsTransactionResponse(withdrawal)})
+			return
+		}
+		if err == nil && resp.ResponseCode != "0" {
+			err = fmt.Errorf("M-Pesa rejected the payout: %s - %s", resp.ResponseCode, resp.ResponseDescription)
+		}
+		if err != nil {
+			log.Printf("B2C Error for savings withdrawal %d: %v\n", withdrawal.ID, err)
+			if _, failErr := ctrl.SavingsModel.FailSavingsTransaction(withdrawal.ID, err.Error()); failErr != nil {
+				log.Printf("Error returning savings withdrawal %d to the balance: %v\n", withdrawal.ID, failErr)
+			}
+			c.JSON(http.StatusBadGateway, gin.H{"error": "M-Pesa payout failed", "message": err.Error()})
+			return
+		}
+
+		if err := ctrl.SavingsModel.SetPayoutReference(withdrawal.ID, resp.OriginatorConversationID); err != nil {
+			// The payout was accepted but cannot be matched to its result, leave the withdrawal
+			// pending for reconciliation by the conversation ID
+			log.Printf("Savings withdrawal %d paid out as %s was not recorded: %v\n", withdrawal.ID, resp.OriginatorConversationID, err)
+		}
+		withdrawal.OriginatorConversationID = resp.OriginatorConversationID
+	}
+
+	withdrawal.RecordedBy = &u
+	binders.ReturnJSONResponse(c, http.StatusCreated, true, gin.H{binders.ItemKey: savingsTransactionResponse(withdrawal)})
+}
+
+// SetContributionScheduleController sets what each member of the group is expected to save
+// per period, replacing any schedule the group already has
+func (ctrl *SavingsController) SetContributionScheduleController(c *gin.Context) {
+	id, valid := parameters.ConvertParamToValidID(c, "id")
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	var req bindings.ContributionScheduleRequest
+	if !binders.ValidateBindJSONRequest(c, &req) {
+		return
+	}
+
+	group, err := ctrl.GroupModel.GetGroupByField("id", string(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Group not found"})
+		return
+	}
+
+	startDate, err := time.Parse("2006-01-02", req.StartDate)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid StartDate, expected YYYY-MM-DD"})
+		return
+	}
+
+	decodedUser, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+	u := decodedUser.(models.User)
+
+	schedule := models.ContributionSchedule{
+		GroupID:     group.ID,
+		Amount:      req.Amount,
+		Frequency:   req.Frequency,
+		StartDate:   startDate,
+		IsActive:    req.IsActive == nil || *req.IsActive,
+		CreatedByID: u.ID,
+	}
+
+	if err := ctrl.SavingsModel.SetGroupSchedule(&schedule); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error setting contribution schedule: " + err.Error()})
+		return
+	}
+
+	binders.ReturnJSONGeneralResponse(c, contributionScheduleResponse(schedule))
+}
+
+// GetGroupContributionsController compares each member's contributions with what the group's
+// schedule expected of them by ?asOf= (today by default)
+func (ctrl *SavingsController) GetGroupContributionsController(c *gin.Context) {
+	id, valid := parameters.ConvertParamToValidID(c, "id")
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	asOf, ok := dateQuery(c, "asOf")
+	if !ok {
+		return
+	}
+	if asOf == nil {
+		now := time.Now()
+		asOf = &now
+	}
+
+	group, err := ctrl.GroupModel.GetGroupByField("id", string(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Group not found"})
+		return
+	}
+
+	contributions, err := ctrl.SavingsModel.GroupContributions(group.ID, *asOf)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching contributions: " + err.Error()})
+		return
+	}
+	if contributions == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Group has no contribution schedule"})
+		return
+	}
+
+	response := bindings.GroupContributionsResponse{
+		Schedule:    contributionScheduleResponse(contributions.Schedule),
+		AsOf:        contributions.AsOf,
+		PeriodsDue:  contributions.PeriodsDue,
+		NextDueDate: contributions.NextDueDate,
+		Expected:    contributions.Expected,
+		Contributed: contributions.Contributed,
+		Arrears:     contributions.Arrears,
+		Members:     make([]bindings.MemberContributionsResponse, 0, len(contributions.Members)),
+	}
+	for _, member := range contributions.Members {
+		response.Members = append(response.Members, bindings.MemberContributionsResponse{
+			MemberID:    member.MemberID,
+			FirstName:   member.FirstName,
+			LastName:    member.LastName,
+			Expected:    member.Expected,
+			Contributed: member.Contributed,
+			Arrears:     member.Arrears,
+		})
+	}
+
+	binders.ReturnJSONGeneralResponse(c, response)
+}
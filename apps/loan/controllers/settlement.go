@@ -0,0 +1,122 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kifangamukundi/gm/libs/binders"
+	"github.com/kifangamukundi/gm/libs/parameters"
+	"github.com/kifangamukundi/gm/loan/bindings"
+	"github.com/kifangamukundi/gm/loan/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SettlementController struct {
+	SettlementModel *models.SettlementModel
+}
+
+func NewSettlementController(settlementModel *models.SettlementModel) *SettlementController {
+	return &SettlementController{SettlementModel: settlementModel}
+}
+
+func settlementQuoteResponse(quote models.SettlementQuote) bindings.SettlementQuoteResponse {
+	return bindings.SettlementQuoteResponse{
+		ID:                quote.ID,
+		LoanID:            quote.LoanID,
+		AsOf:              quote.AsOf,
+		ValidUntil:        quote.ValidUntil,
+		Principal:         quote.Principal,
+		AccruedInterest:   quote.AccruedInterest,
+		UnaccruedInterest: quote.UnaccruedInterest,
+		Fees:              quote.Fees,
+		Penalties:         quote.Penalties,
+		RebateRate:        quote.RebateRate,
+		Rebate:            quote.Rebate,
+		Total:             quote.Total,
+		Status:            quote.Status,
+		PaymentID:         quote.PaymentID,
+		SettledAt:         quote.SettledAt,
+		CreatedAt:         quote.CreatedAt,
+	}
+}
+
+// CreateSettlementQuoteController quotes what it costs to close the loan today. A payment
+// of at least the quoted total made by the end of ValidUntil closes the loan.
+func (ctrl *SettlementController) CreateSettlementQuoteController(c *gin.Context) {
+	id, valid := parameters.ConvertParamToValidID(c, "id")
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	idUint, err := strconv.ParseUint(string(id), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	var req bindings.SettlementQuoteRequest
+	if !binders.ValidateBindJSONRequest(c, &req) {
+		return
+	}
+
+	decodedUser, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+	u := decodedUser.(models.User)
+
+	now := time.Now()
+	validUntil := now
+	if req.ValidUntil != "" {
+		validUntil, err = time.ParseInLocation("2006-01-02", req.ValidUntil, now.Location())
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ValidUntil date, expected YYYY-MM-DD"})
+			return
+		}
+	}
+
+	quote, err := ctrl.SettlementModel.CreateSettlementQuote(uint(idUint), validUntil, &u.ID, now)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, models.ErrNotSettleable) {
+			status = http.StatusUnprocessableEntity
+		}
+		c.JSON(status, gin.H{"error": "Error creating settlement quote: " + err.Error()})
+		return
+	}
+
+	binders.ReturnJSONResponse(c, http.StatusCreated, true, gin.H{binders.ItemKey: settlementQuoteResponse(quote)})
+}
+
+// GetLoanSettlementQuotesController lists the loan's settlement quotes, newest first
+func (ctrl *SettlementController) GetLoanSettlementQuotesController(c *gin.Context) {
+	id, valid := parameters.ConvertParamToValidID(c, "id")
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	idUint, err := strconv.ParseUint(string(id), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	quotes, err := ctrl.SettlementModel.GetLoanSettlementQuotes(uint(idUint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching settlement quotes: " + err.Error()})
+		return
+	}
+
+	items := make([]bindings.SettlementQuoteResponse, 0, len(quotes))
+	for _, quote := range quotes {
+		items = append(items, settlementQuoteResponse(quote))
+	}
+
+	binders.ReturnJSONGeneralResponse(c, items)
+}
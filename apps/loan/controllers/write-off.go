@@ -0,0 +1,317 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kifangamukundi/gm/libs/binders"
+	"github.com/kifangamukundi/gm/libs/parameters"
+	"github.com/kifangamukundi/gm/loan/bindings"
+	"github.com/kifangamukundi/gm/loan/models"
+	"github.com/kifangamukundi/gm/loan/money"
+
+	"github.com/gin-gonic/gin"
+)
+
+type WriteOffController struct {
+	WriteOffModel *models.WriteOffModel
+	LoanModel     *models.LoanModel
+	OfficerModel  *models.OfficerModel
+}
+
+func NewWriteOffController(writeOffModel *models.WriteOffModel, loanModel *models.LoanModel, officerModel *models.OfficerModel) *WriteOffController {
+	return &WriteOffController{WriteOffModel: writeOffModel, LoanModel: loanModel, OfficerModel: officerModel}
+}
+
+// writeOffErrorStatus maps write-off errors to the HTTP status the client should see
+func writeOffErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, models.ErrSelfApproval):
+		return http.StatusForbidden
+	case errors.Is(err, models.ErrWriteOffNotPending):
+		return http.StatusConflict
+	case errors.Is(err, models.ErrNotWriteOffEligible):
+		return http.StatusUnprocessableEntity
+	}
+	return transitionErrorStatus(err)
+}
+
+func recoveryResponse(recovery models.LoanRecovery) bindings.RecoveryResponse {
+	return bindings.RecoveryResponse{
+		ID:          recovery.ID,
+		LoanID:      recovery.LoanID,
+		WriteOffID:  recovery.WriteOffID,
+		Amount:      recovery.Amount,
+		PaymentID:   recovery.PaymentID,
+		Reference:   recovery.Reference,
+		RecoveredAt: recovery.RecoveredAt,
+	}
+}
+
+// writeOffResponse maps a write-off and the recoveries made against it to their API representation
+func writeOffResponse(writeOff models.LoanWriteOff) bindings.WriteOffResponse {
+	response := bindings.WriteOffResponse{
+		ID:                   writeOff.ID,
+		LoanID:               writeOff.LoanID,
+		Status:               writeOff.Status,
+		DaysPastDue:          writeOff.DaysPastDue,
+		Reason:               writeOff.Reason,
+		RequestedByFirstName: writeOff.RequestedBy.FirstName,
+		RequestedByLastName:  writeOff.RequestedBy.LastName,
+		DecisionReason:       writeOff.DecisionReason,
+		DecidedAt:            writeOff.DecidedAt,
+		CreatedAt:            writeOff.CreatedAt,
+		Principal:            writeOff.Principal,
+		Interest:             writeOff.Interest,
+		Fees:                 writeOff.Fees,
+		Penalty:              writeOff.Penalty,
+		Amount:               writeOff.Amount,
+		Recovered:            money.Zero,
+		Recoveries:           make([]bindings.RecoveryResponse, 0, len(writeOff.Recoveries)),
+	}
+
+	if writeOff.DecidedBy != nil {
+		response.DecidedByFirstName = writeOff.DecidedBy.FirstName
+		response.DecidedByLastName = writeOff.DecidedBy.LastName
+	}
+
+	for _, recovery := range writeOff.Recoveries {
+		response.Recovered += recovery.Amount
+		response.Recoveries = append(response.Recoveries, recoveryResponse(recovery))
+	}
+
+	return response
+}
+
+func writeOffReportRow(row models.WriteOffReportRow) bindings.WriteOffReportRow {
+	return bindings.WriteOffReportRow{
+		AgentID:           row.AgentID,
+		AgentName:         row.AgentName,
+		RegionID:          row.RegionID,
+		RegionName:        row.RegionName,
+		ActiveLoans:       row.ActiveLoans,
+		ActiveOutstanding: row.ActiveOutstanding,
+		WrittenOffLoans:   row.WrittenOffLoans,
+		WrittenOff:        row.WrittenOff,
+		Recovered:         row.Recovered,
+		NetLoss:           row.NetLoss,
+	}
+}
+
+// RequestWriteOffController asks for a loan that is past its product's write-off threshold
+// to be written off. Nothing changes on the loan until an officer approves the request.
+func (ctrl *WriteOffController) RequestWriteOffController(c *gin.Context) {
+	id, valid := parameters.ConvertParamToValidID(c, "id")
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	var req bindings.WriteOffLoanRequest
+	if !binders.ValidateBindJSONRequest(c, &req) {
+		return
+	}
+
+	loan, err := ctrl.LoanModel.GetLoanByFieldPreloaded("id", string(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Loan not found"})
+		return
+	}
+
+	decodedUser, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+	u := decodedUser.(models.User)
+
+	writeOff := models.LoanWriteOff{
+		LoanID:        loan.ID,
+		Reason:        req.Reason,
+		RequestedByID: u.ID,
+	}
+
+	if err := ctrl.WriteOffModel.RequestWriteOff(&writeOff, time.Now()); err != nil {
+		c.JSON(writeOffErrorStatus(err), gin.H{"error": "Error requesting write-off: " + err.Error()})
+		return
+	}
+
+	writeOff.RequestedBy = u
+	binders.ReturnJSONResponse(c, http.StatusCreated, true, gin.H{binders.ItemKey: writeOffResponse(writeOff)})
+}
+
+// GetLoanWriteOffsController lists a loan's write-offs with the recoveries made against them
+func (ctrl *WriteOffController) GetLoanWriteOffsController(c *gin.Context) {
+	id, valid := parameters.ConvertParamToValidID(c, "id")
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	loan, err := ctrl.LoanModel.GetLoanByFieldPreloaded("id", string(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Loan not found"})
+		return
+	}
+
+	writeOffs, err := ctrl.WriteOffModel.GetLoanWriteOffs(loan.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching write-offs: " + err.Error()})
+		return
+	}
+
+	items := make([]bindings.WriteOffResponse, 0, len(writeOffs))
+	for _, writeOff := range writeOffs {
+		items = append(items, writeOffResponse(writeOff))
+	}
+
+	binders.ReturnJSONGeneralResponse(c, items)
+}
+
+// ApproveWriteOffController writes off the loan of a pending request. Only loan officers
+// may approve, and not the user who requested it.
+func (ctrl *WriteOffController) ApproveWriteOffController(c *gin.Context) {
+	id, valid := pathID(c)
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	decodedUser, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+	u := decodedUser.(models.User)
+
+	if _, err := ctrl.OfficerModel.GetOfficerByFieldPreloaded("user_id", fmt.Sprintf("%d", u.ID)); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only loan officers may approve write-offs"})
+		return
+	}
+
+	if _, err := ctrl.WriteOffModel.ApproveWriteOff(id, u.ID, time.Now()); err != nil {
+		c.JSON(writeOffErrorStatus(err), gin.H{"error": "Error approving write-off: " + err.Error()})
+		return
+	}
+
+	writeOff, err := ctrl.WriteOffModel.GetWriteOffByField("id", strconv.FormatUint(uint64(id), 10))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching write-off: " + err.Error()})
+		return
+	}
+
+	binders.ReturnJSONGeneralResponse(c, writeOffResponse(*writeOff))
+}
+
+// RejectWriteOffController closes a pending write-off without changing the loan
+func (ctrl *WriteOffController) RejectWriteOffController(c *gin.Context) {
+	id, valid := pathID(c)
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	var req bindings.LoanStatusReasonRequest
+	if !binders.ValidateBindJSONRequest(c, &req) {
+		return
+	}
+
+	decodedUser, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+	u := decodedUser.(models.User)
+
+	if _, err := ctrl.WriteOffModel.RejectWriteOff(id, u.ID, req.Reason); err != nil {
+		c.JSON(writeOffErrorStatus(err), gin.H{"error": "Error rejecting write-off: " + err.Error()})
+		return
+	}
+
+	writeOff, err := ctrl.WriteOffModel.GetWriteOffByField("id", strconv.FormatUint(uint64(id), 10))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching write-off: " + err.Error()})
+		return
+	}
+
+	binders.ReturnJSONGeneralResponse(c, writeOffResponse(*writeOff))
+}
+
+// RecordRecoveryController records money collected on a written-off loan outside M-Pesa,
+// such as from an auctioned asset. M-Pesa repayments on written-off loans are recorded as
+// recoveries when they are confirmed.
+func (ctrl *WriteOffController) RecordRecoveryController(c *gin.Context) {
+	id, valid := parameters.ConvertParamToValidID(c, "id")
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	var req bindings.RecordRecoveryRequest
+	if !binders.ValidateBindJSONRequest(c, &req) {
+		return
+	}
+
+	loan, err := ctrl.LoanModel.GetLoanByFieldPreloaded("id", string(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Loan not found"})
+		return
+	}
+
+	decodedUser, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+	u := decodedUser.(models.User)
+
+	recovery, err := ctrl.WriteOffModel.RecordRecovery(loan.ID, req.Amount, nil, req.Reference, &u.ID, time.Now())
+	if err != nil {
+		c.JSON(writeOffErrorStatus(err), gin.H{"error": "Error recording recovery: " + err.Error()})
+		return
+	}
+
+	binders.ReturnJSONResponse(c, http.StatusCreated, true, gin.H{binders.ItemKey: recoveryResponse(recovery)})
+}
+
+// GetWriteOffReportController totals write-offs approved and recoveries collected between
+// from and to inclusive per agent and per region, next to each agent's active portfolio.
+func (ctrl *WriteOffController) GetWriteOffReportController(c *gin.Context) {
+	from, ok := dateQuery(c, "from")
+	if !ok {
+		return
+	}
+	to, ok := dateQuery(c, "to")
+	if !ok {
+		return
+	}
+	if from != nil && to != nil && to.Before(*from) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "to must not be before from"})
+		return
+	}
+
+	report, err := ctrl.WriteOffModel.Report(from, nextDay(to))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error building write-off report: " + err.Error()})
+		return
+	}
+
+	response := bindings.WriteOffReportResponse{
+		From:    from,
+		To:      to,
+		Agents:  make([]bindings.WriteOffReportRow, 0, len(report.Agents)),
+		Regions: make([]bindings.WriteOffReportRow, 0, len(report.Regions)),
+		Totals:  writeOffReportRow(report.Totals),
+	}
+	for _, row := range report.Agents {
+		response.Agents = append(response.Agents, writeOffReportRow(row))
+	}
+	for _, row := range report.Regions {
+		response.Regions = append(response.Regions, writeOffReportRow(row))
+	}
+
+	binders.ReturnJSONGeneralResponse(c, response)
+}
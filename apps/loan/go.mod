@@ -11,10 +11,12 @@ require (
 	github.com/jwambugu/mpesa-golang-sdk v1.0.8
 	github.com/kifangamukundi/gm/libs/auths v0.0.0-00010101000000-000000000000
 	github.com/kifangamukundi/gm/libs/binders v0.0.0-00010101000000-000000000000
+	github.com/kifangamukundi/gm/libs/exporters v0.0.0-00010101000000-000000000000
 	github.com/kifangamukundi/gm/libs/parameters v0.0.0-00010101000000-000000000000
 	github.com/kifangamukundi/gm/libs/queryparams v0.0.0-00010101000000-000000000000
 	github.com/kifangamukundi/gm/libs/rates v0.0.0-00010101000000-000000000000
 	github.com/kifangamukundi/gm/libs/repositories v0.0.0-00010101000000-000000000000
+	github.com/kifangamukundi/gm/libs/schedules v0.0.0-00010101000000-000000000000
 	github.com/kifangamukundi/gm/libs/transformations v0.0.0-00010101000000-000000000000
 	github.com/robfig/cron/v3 v3.0.1
 	github.com/twilio/twilio-go v1.23.12
@@ -48,18 +50,25 @@ require (
 	github.com/jinzhu/inflection v1.0.0 // indirect
 	github.com/jinzhu/now v1.1.5 // indirect
 	github.com/json-iterator/go v1.1.12 // indirect
+	github.com/jung-kurt/gofpdf v1.16.2 // indirect
 	github.com/klauspost/cpuid/v2 v2.2.9 // indirect
 	github.com/leodido/go-urn v1.4.0 // indirect
 	github.com/mattn/go-isatty v0.0.20 // indirect
 	github.com/mattn/go-sqlite3 v1.14.22 // indirect
 	github.com/modern-go/concurrent v0.0.0-20180306012644-bacd9c7ef1dd // indirect
 	github.com/modern-go/reflect2 v1.0.2 // indirect
+	github.com/mohae/deepcopy v0.0.0-20170929034955-c48cc78d4826 // indirect
 	github.com/pelletier/go-toml/v2 v2.2.3 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/redis/go-redis/v9 v9.7.0 // indirect
+	github.com/richardlehane/mscfb v1.0.4 // indirect
+	github.com/richardlehane/msoleps v1.0.4 // indirect
 	github.com/twitchyliquid64/golang-asm v0.15.1 // indirect
 	github.com/ugorji/go/codec v1.2.12 // indirect
 	github.com/ulule/limiter/v3 v3.11.2 // indirect
+	github.com/xuri/efp v0.0.0-20240408161823-9ad904a10d6d // indirect
+	github.com/xuri/excelize/v2 v2.9.0 // indirect
+	github.com/xuri/nfp v0.0.0-20240318013403-ab9948c2c4a7 // indirect
 	golang.org/x/arch v0.12.0 // indirect
 	golang.org/x/crypto v0.33.0 // indirect
 	golang.org/x/net v0.35.0 // indirect
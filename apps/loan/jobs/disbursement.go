@@ -0,0 +1,126 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/kifangamukundi/gm/loan/models"
+	"github.com/kifangamukundi/gm/loan/payments"
+)
+
+// ProcessDisbursementQueue submits every queued payout that is due to M-Pesa. Jobs are
+// claimed with a conditional update, so overlapping runs and several instances never
+// submit the same job twice. Failed attempts are queued again with exponential backoff
+// and dead-lettered after their last attempt.
+//
+// A request that failed after it was sent, such as one that timed out, may still have been
+// paid, so it is dead-lettered instead of retried.
+//
+// A job still processing once its lease has run out was abandoned between claiming and
+// recording the payout, so M-Pesa may or may not have accepted it. Those are dead-lettered
+// rather than retried, leaving an officer to reconcile before retrying by hand. So are
+// submitted jobs whose B2C result never arrived.
+func ProcessDisbursementQueue(jobModel *models.DisbursementJobModel, gateway payments.PaymentGateway, now time.Time) error {
+	abandoned, err := jobModel.GetDueDisbursementJobs(models.DisbursementJobProcessing, now)
+	if err != nil {
+		return fmt.Errorf("failed to get abandoned disbursement jobs: %v", err)
+	}
+
+	for _, job := range abandoned {
+		reason := "Worker stopped while submitting the payout, check M-Pesa before retrying"
+		if _, err := jobModel.FailDisbursementJob(job.ID, reason, now, true); err != nil {
+			log.Printf("Failed to dead-letter abandoned disbursement job %d: %v", job.ID, err)
+		}
+	}
+
+	unanswered, err := jobModel.GetDueDisbursementJobs(models.DisbursementJobSubmitted, now)
+	if err != nil {
+		return fmt.Errorf("failed to get submitted disbursement jobs: %v", err)
+	}
+
+	for _, job := range unanswered {
+		reason := "No B2C result received, check M-Pesa before retrying"
+		if _, err := jobModel.FailDisbursementJob(job.ID, reason, now, true); err != nil {
+			log.Printf("Failed to dead-letter unanswered disbursement job %d: %v", job.ID, err)
+		}
+	}
+
+	due, err := jobModel.GetDueDisbursementJobs(models.DisbursementJobQueued, now)
+	if err != nil {
+		return fmt.Errorf("failed to get queued disbursement jobs: %v", err)
+	}
+
+	submitted := 0
+	for _, queued := range due {
+		job, err := jobModel.StartDisbursementJob(queued.ID, now)
+		if err != nil {
+			log.Printf("Failed to start disbursement job %d: %v", queued.ID, err)
+			continue
+		}
+		if job == nil {
+			continue
+		}
+
+		if err := submitDisbursement(jobModel, gateway, job, now); err != nil {
+			log.Printf("Disbursement job %d attempt %d failed: %v", job.ID, job.Attempts, err)
+			reason, deadLetter := err.Error(), payments.IsAmbiguous(err)
+			if deadLetter {
+				reason = "Payout may have been sent, check M-Pesa before retrying: " + reason
+			}
+			if _, err := jobModel.FailDisbursementJob(job.ID, reason, now, deadLetter); err != nil {
+				log.Printf("Failed to record failure of disbursement job %d: %v", job.ID, err)
+			}
+			continue
+		}
+
+		submitted++
+	}
+
+	if len(due) > 0 || len(abandoned) > 0 || len(unanswered) > 0 {
+		log.Printf("Disbursement queue submitted %d of %d due jobs, %d abandoned, %d unanswered", submitted, len(due), len(abandoned), len(unanswered))
+	}
+
+	return nil
+}
+
+// submitDisbursement sends one B2C payout and records the disbursement M-Pesa accepted
+func submitDisbursement(jobModel *models.DisbursementJobModel, gateway payments.PaymentGateway, job *models.DisbursementJob, now time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	resp, err := gateway.B2C(ctx, payments.B2CRequest{
+		PhoneNumber: job.PhoneNumber,
+		Amount:      uint(job.Amount.Shillings()),
+		Remarks:     "Loan disbursement",
+		Occasion:    "Loan",
+	})
+	if err != nil {
+		return err
+	}
+
+	if resp.ResponseCode != "0" {
+		return fmt.Errorf("M-Pesa rejected the payout: %s - %s", resp.ResponseCode, resp.ResponseDescription)
+	}
+
+	disbursement := models.Disbursement{
+		LoanID:                   job.LoanID,
+		OfficerID:                job.OfficerID,
+		Amount:                   job.Amount,
+		OriginatorConversationID: resp.OriginatorConversationID,
+		ConversationID:           resp.ConversationID,
+		ResponseCode:             resp.ResponseCode,
+		ResponseDesc:             resp.ResponseDescription,
+		Status:                   models.DisbursementStatusPending,
+		CreatedAt:                now,
+	}
+
+	if _, err := jobModel.SubmitDisbursementJob(job.ID, &disbursement, now); err != nil {
+		// The payout was accepted but not recorded, leave the job processing so it is
+		// dead-lettered for reconciliation by the conversation ID once its lease runs out
+		log.Printf("Disbursement %s for loan %d was not recorded: %v", resp.OriginatorConversationID, job.LoanID, err)
+	}
+
+	return nil
+}
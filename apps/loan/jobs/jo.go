@@ -2,20 +2,53 @@ package jobs
 
 import (
 	"log"
+	"time"
+
+	"github.com/kifangamukundi/gm/libs/schedules"
+	"github.com/kifangamukundi/gm/loan/loanrepository"
+	"github.com/kifangamukundi/gm/loan/models"
+	"github.com/kifangamukundi/gm/loan/payments"
+	"github.com/kifangamukundi/gm/loan/services"
 
 	"github.com/robfig/cron/v3"
+	"gorm.io/gorm"
 )
 
 // InitializeJobs sets up and starts the cron scheduler
-func InitializeJobs() {
+func InitializeJobs(db *gorm.DB, gateway payments.PaymentGateway) {
 	c := cron.New()
 
+	service := services.NewEntityService(loanrepository.NewLoanRepository(db))
+	loanModel := models.NewLoanModel(service)
+	penaltyModel := models.NewPenaltyModel(service)
+	disbursementJobModel := models.NewDisbursementJobModel(service)
+
 	// Use the "EVERY_MINUTE" schedule for the PingServer job
 	// _, err := c.AddFunc(schedules.Schedules["EVERY_5_MINUTES"], PingServer)
 	// if err != nil {
 	// 	log.Fatalf("Failed to schedule PingServer job: %v", err)
 	// }
 
+	// Penalties are accrued once a day, shortly after midnight
+	_, err := c.AddFunc(schedules.Schedules["DAILY_AT_1"], func() {
+		if err := AccruePenalties(penaltyModel, loanModel, time.Now()); err != nil {
+			log.Printf("Penalty accrual failed: %v", err)
+		}
+	})
+	if err != nil {
+		log.Fatalf("Failed to schedule penalty accrual job: %v", err)
+	}
+
+	// Queued payouts are picked up every minute, which is also the backoff granularity
+	_, err = c.AddFunc(schedules.Schedules["EVERY_MINUTE"], func() {
+		if err := ProcessDisbursementQueue(disbursementJobModel, gateway, time.Now()); err != nil {
+			log.Printf("Disbursement queue failed: %v", err)
+		}
+	})
+	if err != nil {
+		log.Fatalf("Failed to schedule disbursement queue job: %v", err)
+	}
+
 	// Start the cron scheduler
 	c.Start()
 
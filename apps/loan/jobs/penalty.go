@@ -0,0 +1,83 @@
+package jobs
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/kifangamukundi/gm/loan/models"
+)
+
+// AccruePenalties charges penalties on overdue instalments according to each loan product's
+// penalty rule and moves loans into or out of arrears. Charges are keyed by instalment and
+// day, so running it more than once for the same day charges nothing extra. Each charge is
+// posted to the ledger as penalty income together with the charge itself.
+func AccruePenalties(penaltyModel *models.PenaltyModel, loanModel *models.LoanModel, asOf time.Time) error {
+	instalments, err := penaltyModel.GetOverdueInstalments(asOf)
+	if err != nil {
+		return fmt.Errorf("failed to get overdue instalments: %v", err)
+	}
+
+	day := asOf.Format("2006-01-02")
+	daysLate := map[uint]int{}
+	rules := map[uint]models.PenaltyRule{}
+	charged := 0
+
+	for _, instalment := range instalments {
+		rule := instalment.Loan.Product.PenaltyRule()
+		days := models.DaysOverdue(instalment.DueDate, asOf)
+
+		rules[instalment.LoanID] = rule
+		if days > daysLate[instalment.LoanID] {
+			daysLate[instalment.LoanID] = days
+		}
+
+		amount := rule.PenaltyDue(instalment, days)
+		if amount <= 0 {
+			continue
+		}
+
+		charge, err := penaltyModel.ChargePenalty(instalment.ID, day, amount, days, rule.Type)
+		if err != nil {
+			log.Printf("Failed to charge penalty on instalment %d: %v", instalment.ID, err)
+			continue
+		}
+		if charge == nil {
+			continue
+		}
+
+		charged++
+	}
+
+	for loanId, days := range daysLate {
+		if days <= rules[loanId].ArrearsAfterDays {
+			continue
+		}
+
+		_, err := loanModel.TransitionLoan(loanId, models.LoanStatusInArrears, nil, fmt.Sprintf("Instalment %d days overdue", days), nil)
+		if err != nil && !errors.Is(err, models.ErrIllegalTransition) {
+			log.Printf("Failed to move loan %d into arrears: %v", loanId, err)
+		}
+	}
+
+	// Loans whose overdue instalments have been paid go back to active
+	inArrears, err := loanModel.GetLoansByStatus(models.LoanStatusInArrears)
+	if err != nil {
+		return fmt.Errorf("failed to get loans in arrears: %v", err)
+	}
+
+	for _, loan := range inArrears {
+		if days, late := daysLate[loan.ID]; late && days > rules[loan.ID].ArrearsAfterDays {
+			continue
+		}
+
+		if _, err := loanModel.TransitionLoan(loan.ID, models.LoanStatusActive, nil, "Arrears cleared", nil); err != nil {
+			log.Printf("Failed to move loan %d out of arrears: %v", loan.ID, err)
+		}
+	}
+
+	log.Printf("Penalty accrual for %s charged %d of %d overdue instalments", day, charged, len(instalments))
+
+	return nil
+}
@@ -3,10 +3,12 @@ package loanrepository
 import (
 	"fmt"
 	"reflect"
+	"time"
 
 	"github.com/kifangamukundi/gm/libs/queryparams"
 	"github.com/kifangamukundi/gm/libs/repositories"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type LoanRepositoryInterface interface {
@@ -16,6 +18,16 @@ type LoanRepositoryInterface interface {
 	GetAllFilteredAgentMemberLoans(agentId, groupId, memberId, skip, limit int, sortOrder, sortByColumn, searchRegex string, searchColumns []string, filterCriteria interface{}, model interface{}, preload []string) ([]interface{}, int64, int64, error)
 	GetAllFilteredTest(agentId, skip, limit int, sortOrder, sortByColumn, searchRegex string, searchColumns []string, filterCriteria interface{}, model interface{}, preload []string) ([]interface{}, int64, int64, error)
 	GetAllFilteredTest2(groupId, agentId, skip, limit int, sortOrder, sortByColumn, searchRegex string, searchColumns []string, filterCriteria interface{}, model interface{}, preload []string) ([]interface{}, int64, int64, error)
+	GetOverdueInstalments(model interface{}, asOf time.Time, loanStatuses []string, loanConditions map[string]interface{}, preload ...string) (interface{}, error)
+	GetLatestByField(model interface{}, field string, values interface{}, preload ...string) (interface{}, error)
+	GetAllByFieldOrdered(model interface{}, field string, value interface{}, order string, preload ...string) (interface{}, error)
+	SumPostingsByAccount(result interface{}, from, to *time.Time) error
+	GetAccountPostings(model interface{}, accountId uint, from, to *time.Time, preload ...string) (interface{}, error)
+	GetDueDisbursementJobs(model interface{}, status string, asOf time.Time) (interface{}, error)
+	SumByAgent(result interface{}, table, amountColumn, dateColumn string, conditions map[string]interface{}, from, to *time.Time) error
+	UpdateWhere(model interface{}, conditions map[string]interface{}) (int64, error)
+	UpdateColumnsWhere(model interface{}, conditions map[string]interface{}, columns []string) (int64, error)
+	Transaction(fn func(repo LoanRepositoryInterface) error) error
 }
 
 type LoanRepository struct {
@@ -250,6 +262,157 @@ func (r *LoanRepository) GetAllFilteredTest2(groupId, agentId, skip, limit int,
 	return result, totalCount, filteredCount, nil
 }
 
+// GetOverdueInstalments finds unpaid instalments that fell due before asOf on loans in one of the given statuses
+func (r *LoanRepository) GetOverdueInstalments(model interface{}, asOf time.Time, loanStatuses []string, loanConditions map[string]interface{}, preload ...string) (interface{}, error) {
+	query := r.DB.Joins("JOIN loans ON loans.id = instalments.loan_id").
+		Where("instalments.status NOT IN (?)", []string{"paid", "restructured"}).
+		Where("instalments.due_date < ?", asOf).
+		Where("loans.status IN (?)", loanStatuses).
+		Order("instalments.loan_id, instalments.due_date")
+
+	for column, value := range loanConditions {
+		query = query.Where(fmt.Sprintf("loans.%s = ?", column), value)
+	}
+
+	for _, p := range preload {
+		query = query.Preload(p)
+	}
+
+	if err := query.Find(model).Error; err != nil {
+		return nil, err
+	}
+	return model, nil
+}
+
+// GetLatestByField loads the row with the highest id for each of the values of field
+func (r *LoanRepository) GetLatestByField(model interface{}, field string, values interface{}, preload ...string) (interface{}, error) {
+	latest := r.DB.Model(model).Select("MAX(id)").Where(fmt.Sprintf("%s IN (?)", field), values).Group(field)
+	query := r.DB.Where("id IN (?)", latest)
+
+	for _, p := range preload {
+		query = query.Preload(p)
+	}
+
+	if err := query.Find(model).Error; err != nil {
+		return nil, err
+	}
+	return model, nil
+}
+
+// GetAllByFieldOrdered loads every row whose field equals value, sorted by order
+func (r *LoanRepository) GetAllByFieldOrdered(model interface{}, field string, value interface{}, order string, preload ...string) (interface{}, error) {
+	query := r.DB.Where(fmt.Sprintf("%s = ?", field), value).Order(order)
+
+	for _, p := range preload {
+		query = query.Preload(p)
+	}
+
+	if err := query.Find(model).Error; err != nil {
+		return nil, err
+	}
+	return model, nil
+}
+
+// SumPostingsByAccount totals debits and credits per account for journal entries dated in [from, to)
+func (r *LoanRepository) SumPostingsByAccount(result interface{}, from, to *time.Time) error {
+	query := r.DB.Table("postings").
+		Select("postings.account_id, SUM(postings.debit) AS debit, SUM(postings.credit) AS credit").
+		Joins("JOIN journal_entries ON journal_entries.id = postings.journal_entry_id")
+
+	if from != nil {
+		query = query.Where("journal_entries.entry_date >= ?", *from)
+	}
+	if to != nil {
+		query = query.Where("journal_entries.entry_date < ?", *to)
+	}
+
+	return query.Group("postings.account_id").Scan(result).Error
+}
+
+// GetAccountPostings finds the postings on an account for journal entries dated in [from, to), oldest first
+func (r *LoanRepository) GetAccountPostings(model interface{}, accountId uint, from, to *time.Time, preload ...string) (interface{}, error) {
+	query := r.DB.Joins("JOIN journal_entries ON journal_entries.id = postings.journal_entry_id").
+		Where("postings.account_id = ?", accountId)
+
+	if from != nil {
+		query = query.Where("journal_entries.entry_date >= ?", *from)
+	}
+	if to != nil {
+		query = query.Where("journal_entries.entry_date < ?", *to)
+	}
+
+	for _, p := range preload {
+		query = query.Preload(p)
+	}
+
+	if err := query.Order("journal_entries.entry_date, postings.id").Find(model).Error; err != nil {
+		return nil, err
+	}
+	return model, nil
+}
+
+// GetDueDisbursementJobs finds disbursement jobs in the given status whose next attempt is due by asOf, oldest first
+func (r *LoanRepository) GetDueDisbursementJobs(model interface{}, status string, asOf time.Time) (interface{}, error) {
+	query := r.DB.Where("status = ?", status).
+		Where("next_attempt_at <= ?", asOf).
+		Order("next_attempt_at, id")
+
+	if err := query.Find(model).Error; err != nil {
+		return nil, err
+	}
+	return model, nil
+}
+
+// SumByAgent counts the loans behind the rows of table and totals amountColumn per loan agent.
+// Rows of tables other than loans are matched to their loan through loan_id. Conditions use
+// qualified column names and rows are limited to those with dateColumn in [from, to) when a
+// dateColumn is given.
+func (r *LoanRepository) SumByAgent(result interface{}, table, amountColumn, dateColumn string, conditions map[string]interface{}, from, to *time.Time) error {
+	query := r.DB.Table(table)
+	if table != "loans" {
+		query = query.Joins("JOIN loans ON loans.id = " + table + ".loan_id")
+	}
+
+	query = query.Select("loans.agent_id, COUNT(DISTINCT loans.id) AS count, SUM(" + table + "." + amountColumn + ") AS amount").
+		Where(conditions)
+
+	if dateColumn != "" && from != nil {
+		query = query.Where(table+"."+dateColumn+" >= ?", *from)
+	}
+	if dateColumn != "" && to != nil {
+		query = query.Where(table+"."+dateColumn+" < ?", *to)
+	}
+
+	return query.Group("loans.agent_id").Scan(result).Error
+}
+
+// UpdateWhere saves every column of model only if its row still matches conditions and
+// returns how many rows were changed, 0 meaning another request changed the row first
+func (r *LoanRepository) UpdateWhere(model interface{}, conditions map[string]interface{}) (int64, error) {
+	result := r.DB.Model(model).Where(conditions).Select("*").Omit(clause.Associations).Updates(model)
+	return result.RowsAffected, result.Error
+}
+
+// UpdateColumnsWhere saves only the given columns of model, if its row still matches conditions
+// when there are any, and returns how many rows were changed
+func (r *LoanRepository) UpdateColumnsWhere(model interface{}, conditions map[string]interface{}, columns []string) (int64, error) {
+	query := r.DB.Model(model)
+	if len(conditions) > 0 {
+		query = query.Where(conditions)
+	}
+
+	result := query.Select(columns).Updates(model)
+	return result.RowsAffected, result.Error
+}
+
+// Transaction runs fn with a repository bound to a database transaction, committing when
+// fn returns nil and rolling back otherwise. Nested calls use savepoints.
+func (r *LoanRepository) Transaction(fn func(repo LoanRepositoryInterface) error) error {
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		return fn(NewLoanRepository(tx))
+	})
+}
+
 // ✅ Forward Base Repository Methods
 func (r *LoanRepository) Create(model interface{}) error {
 	return r.repo.Create(model)
@@ -10,6 +10,7 @@ import (
 	"github.com/kifangamukundi/gm/loan/emails"
 	"github.com/kifangamukundi/gm/loan/jobs"
 	"github.com/kifangamukundi/gm/loan/migrations"
+	"github.com/kifangamukundi/gm/loan/payments"
 	"github.com/kifangamukundi/gm/libs/rates"
 	"github.com/kifangamukundi/gm/loan/routes"
 	"github.com/kifangamukundi/gm/loan/seeds"
@@ -71,11 +72,14 @@ func main() {
 		})
 	})
 
+	// Payment gateway, the simulator posts its callbacks straight back into this engine
+	gateway := payments.NewDarajaGateway(http.DefaultClient, config.GetMpesaConfig())
+
 	// Initialize routes with the database instance
-	routes.InitializeRoutes(r, db)
+	routes.InitializeRoutes(r, db, gateway)
 
-	// Initialize cron jobs
-	jobs.InitializeJobs()
+	// Initialize cron jobs, including the worker that submits queued disbursements
+	jobs.InitializeJobs(db, gateway)
 
 	// Load and Initialize Mail Configurations from the config package
 	mailConfig := config.LoadMailConfig()
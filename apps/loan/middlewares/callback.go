@@ -0,0 +1,41 @@
+package middlewares
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+
+	"github.com/kifangamukundi/gm/loan/config"
+	"github.com/kifangamukundi/gm/loan/payments"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MpesaCallbackAuth only lets through callbacks that carry the configured callback token and,
+// when an allowlist is configured, come from one of its addresses. Callbacks are rejected
+// outright while no token is configured.
+func MpesaCallbackAuth(cfg *config.MpesaConfig) gin.HandlerFunc {
+	allowed := map[string]bool{}
+	for _, ip := range cfg.CallbackAllowedIPs {
+		allowed[ip] = true
+	}
+
+	return func(c *gin.Context) {
+		if len(allowed) > 0 && !allowed[c.ClientIP()] {
+			log.Printf("Rejected M-Pesa callback on %s from %s\n", c.FullPath(), c.ClientIP())
+			c.JSON(http.StatusForbidden, gin.H{"error": ErrForbidden.Error()})
+			c.Abort()
+			return
+		}
+
+		token := c.Query(payments.CallbackTokenParam)
+		if cfg.CallbackToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(cfg.CallbackToken)) != 1 {
+			log.Printf("Rejected M-Pesa callback on %s from %s with an invalid token\n", c.FullPath(), c.ClientIP())
+			c.JSON(http.StatusUnauthorized, gin.H{"error": ErrUnauthorized.Error()})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
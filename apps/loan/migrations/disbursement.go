@@ -0,0 +1,53 @@
+package migrations
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/kifangamukundi/gm/loan/loanrepository"
+	"github.com/kifangamukundi/gm/loan/models"
+	"github.com/kifangamukundi/gm/loan/services"
+
+	"gorm.io/gorm"
+)
+
+// MoveLegacyPayouts prepares B2C payouts sent before payouts were queued, and still waiting
+// for their result, for the result callback. Their loans were left approved, so they are
+// moved to disbursing, and the payouts get the loan amount they did not record. Payouts
+// that are done or already moved are skipped, so it is safe to run on every start.
+func MoveLegacyPayouts(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		queued := tx.Model(&models.DisbursementJob{}).Select("disbursement_id").Where("disbursement_id IS NOT NULL")
+
+		var payouts []models.Disbursement
+		err := tx.Where("status = ? AND job_id IS NULL AND id NOT IN (?)", models.DisbursementStatusPending, queued).Find(&payouts).Error
+		if err != nil {
+			return fmt.Errorf("failed to get legacy payouts: %v", err)
+		}
+
+		loanModel := models.NewLoanModel(services.NewEntityService(loanrepository.NewLoanRepository(tx)))
+		for _, payout := range payouts {
+			loan := models.Loan{}
+			if err := tx.First(&loan, payout.LoanID).Error; err != nil {
+				return fmt.Errorf("loan %d not found: %v", payout.LoanID, err)
+			}
+
+			if payout.Amount == 0 {
+				if err := tx.Model(&payout).Update("amount", loan.Amount).Error; err != nil {
+					return fmt.Errorf("failed to set the amount of disbursement %d: %v", payout.ID, err)
+				}
+			}
+
+			if loan.Status != models.LoanStatusApproved {
+				continue
+			}
+			reason := fmt.Sprintf("B2C payout %s sent before payouts were queued", payout.OriginatorConversationID)
+			if _, err := loanModel.TransitionLoan(loan.ID, models.LoanStatusDisbursing, nil, reason, nil); err != nil {
+				return err
+			}
+			log.Printf("Moved loan %d to disbursing for legacy payout %d", loan.ID, payout.ID)
+		}
+
+		return nil
+	})
+}
@@ -9,6 +9,17 @@ import (
 
 // RunMigrations runs migrations for all models
 func RunMigrations() {
+	// Amounts moved from float shillings to integer cents and must be converted before
+	// AutoMigrate changes the column types
+	if err := ConvertMoneyColumns(database.DB); err != nil {
+		log.Fatalf("Migration failed: %v", err)
+	}
+
+	// Interest rates became annual, existing ones keep being charged per period
+	if err := KeepPerPeriodRates(database.DB); err != nil {
+		log.Fatalf("Migration failed: %v", err)
+	}
+
 	err := database.DB.AutoMigrate(
 		&models.Country{},
 		&models.Region{},
@@ -29,10 +40,33 @@ func RunMigrations() {
 		&models.Agent{},
 		&models.Group{},
 		&models.Member{},
+		&models.LoanProduct{},
 		&models.Loan{},
 		&models.Officer{},
 		&models.Disbursement{},
+		&models.DisbursementJob{},
+		&models.ApprovalTier{},
+		&models.LoanApproval{},
+		&models.LoanRestructure{},
 		&models.Payment{},
+		&models.Instalment{},
+		&models.LoanStatusHistory{},
+		&models.PaymentAllocation{},
+		&models.PenaltyCharge{},
+		&models.SettlementQuote{},
+		&models.LoanWriteOff{},
+		&models.LoanRecovery{},
+		&models.LoanGuarantee{},
+		&models.GuaranteeRecovery{},
+		&models.Collateral{},
+		&models.CreditReport{},
+		&models.CollectionAction{},
+		&models.SavingsAccount{},
+		&models.ContributionSchedule{},
+		&models.SavingsTransaction{},
+		&models.Account{},
+		&models.JournalEntry{},
+		&models.Posting{},
 
 		// Join tables and associations
 		&models.RolePermission{},
@@ -41,7 +75,12 @@ func RunMigrations() {
 	)
 	if err != nil {
 		log.Fatalf("Migration failed: %v", err)
-	} else {
-		log.Println("Database migration completed successfully!")
 	}
+
+	// Payouts sent before the disbursement queue need the job_id column AutoMigrate adds
+	if err := MoveLegacyPayouts(database.DB); err != nil {
+		log.Fatalf("Migration failed: %v", err)
+	}
+
+	log.Println("Database migration completed successfully!")
 }
@@ -0,0 +1,136 @@
+package migrations
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/kifangamukundi/gm/loan/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// moneyColumns lists every column that used to hold shillings as a float and now holds
+// cents as an integer, see money.Amount
+var moneyColumns = []struct {
+	Model   interface{}
+	Columns []string
+}{
+	{&models.LoanProduct{}, []string{"min_amount", "max_amount", "processing_fee", "penalty_cap", "penalty_amount"}},
+	{&models.Loan{}, []string{"amount", "fees", "remaining_balance"}},
+	{&models.Disbursement{}, []string{"amount"}},
+	{&models.Payment{}, []string{"amount"}},
+	{&models.Instalment{}, []string{"principal", "interest", "fees", "total_due", "opening_balance", "closing_balance", "penalty", "penalty_paid", "fees_paid", "interest_paid", "principal_paid"}},
+	{&models.PaymentAllocation{}, []string{"amount"}},
+	{&models.PenaltyCharge{}, []string{"amount"}},
+	{&models.Posting{}, []string{"debit", "credit"}},
+}
+
+// isFloatColumn reports whether the database still stores the column as a floating point
+// or decimal type, which is what marks it as not yet converted
+func isFloatColumn(columnType gorm.ColumnType) bool {
+	name := strings.ToLower(columnType.DatabaseTypeName())
+	for _, floating := range []string{"real", "float", "double", "numeric", "decimal"} {
+		if strings.Contains(name, floating) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitPenaltyPercent moves the percentages of percent_of_arrears products out of
+// penalty_amount into penalty_percent, before penalty_amount is converted to cents
+func splitPenaltyPercent(db *gorm.DB) error {
+	product := &models.LoanProduct{}
+	if !db.Migrator().HasTable(product) {
+		return nil
+	}
+
+	columnTypes, err := db.Migrator().ColumnTypes(product)
+	if err != nil {
+		return fmt.Errorf("failed to read columns: %v", err)
+	}
+
+	converted := true
+	for _, columnType := range columnTypes {
+		if columnType.Name() == "penalty_amount" && isFloatColumn(columnType) {
+			converted = false
+		}
+	}
+	if converted {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if !tx.Migrator().HasColumn(product, "PenaltyPercent") {
+			if err := tx.Migrator().AddColumn(product, "PenaltyPercent"); err != nil {
+				return err
+			}
+		}
+
+		percentProducts := tx.Model(product).Where("penalty_type = ?", models.PenaltyTypePercentOfArrears)
+		if err := percentProducts.Session(&gorm.Session{}).Update("penalty_percent", gorm.Expr("penalty_amount")).Error; err != nil {
+			return err
+		}
+		return percentProducts.Session(&gorm.Session{}).Update("penalty_amount", 0).Error
+	})
+}
+
+// ConvertMoneyColumns rewrites amounts stored in shillings as floats into whole cents,
+// rounding half away from zero, and changes the columns to integers. Columns that are
+// already integers are skipped, so it is safe to run on every start.
+func ConvertMoneyColumns(db *gorm.DB) error {
+	if err := splitPenaltyPercent(db); err != nil {
+		return fmt.Errorf("failed to split penalty percentages: %v", err)
+	}
+
+	for _, table := range moneyColumns {
+		if !db.Migrator().HasTable(table.Model) {
+			continue
+		}
+
+		columnTypes, err := db.Migrator().ColumnTypes(table.Model)
+		if err != nil {
+			return fmt.Errorf("failed to read columns: %v", err)
+		}
+
+		pending := map[string]bool{}
+		for _, columnType := range columnTypes {
+			if isFloatColumn(columnType) {
+				pending[columnType.Name()] = true
+			}
+		}
+
+		for _, column := range table.Columns {
+			if !pending[column] {
+				continue
+			}
+
+			err := db.Transaction(func(tx *gorm.DB) error {
+				stmt := &gorm.Statement{DB: tx}
+				if err := stmt.Parse(table.Model); err != nil {
+					return err
+				}
+
+				// Postgres rounds double precision half to even, numeric half away from zero
+				toCents := "UPDATE ? SET ? = ROUND(? * 100)"
+				if tx.Dialector.Name() == "postgres" {
+					toCents = "UPDATE ? SET ? = ROUND((? * 100)::numeric)"
+				}
+				if err := tx.Exec(toCents, clause.Table{Name: stmt.Table}, clause.Column{Name: column}, clause.Column{Name: column}).Error; err != nil {
+					return err
+				}
+
+				return tx.Migrator().AlterColumn(table.Model, column)
+			})
+			if err != nil {
+				return fmt.Errorf("failed to convert %s to cents: %v", column, err)
+			}
+
+			log.Printf("Converted %s to cents", column)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,42 @@
+package migrations
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/kifangamukundi/gm/loan/models"
+
+	"gorm.io/gorm"
+)
+
+// KeepPerPeriodRates adds the RatePerPeriod flag to the tables that store an interest rate
+// and sets it on every existing row. Rates used to be charged per repayment period and are
+// now annual, so products, loans and restructure requests made before the change keep
+// charging what they always did. Tables that already have the flag are skipped, so it is
+// safe to run on every start.
+func KeepPerPeriodRates(db *gorm.DB) error {
+	for _, model := range []interface{}{&models.LoanProduct{}, &models.Loan{}, &models.LoanRestructure{}} {
+		if !db.Migrator().HasTable(model) || db.Migrator().HasColumn(model, "RatePerPeriod") {
+			continue
+		}
+
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			return err
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Migrator().AddColumn(model, "RatePerPeriod"); err != nil {
+				return err
+			}
+			return tx.Session(&gorm.Session{AllowGlobalUpdate: true}).Model(model).UpdateColumn("rate_per_period", true).Error
+		})
+		if err != nil {
+			return fmt.Errorf("failed to keep per period rates on %s: %v", stmt.Table, err)
+		}
+
+		log.Printf("Kept per period rates on %s", stmt.Table)
+	}
+
+	return nil
+}
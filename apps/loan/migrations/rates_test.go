@@ -0,0 +1,57 @@
+package migrations
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/kifangamukundi/gm/loan/models"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestKeepPerPeriodRates(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "loan.db")), &gorm.Config{
+		Logger:                                   logger.Discard,
+		DisableForeignKeyConstraintWhenMigrating: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+
+	// A loan stored before rates became annual, when the flag did not exist
+	if err := db.AutoMigrate(&models.Loan{}); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+	if err := db.Migrator().DropColumn(&models.Loan{}, "RatePerPeriod"); err != nil {
+		t.Fatalf("failed to drop the flag: %v", err)
+	}
+	purpose := "Stock for the shop"
+	if err := db.Omit("RatePerPeriod").Create(&models.Loan{Amount: 100000, Interest: 5, Term: 2, LoanPurpose: &purpose}).Error; err != nil {
+		t.Fatalf("failed to create loan: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := KeepPerPeriodRates(db); err != nil {
+			t.Fatalf("KeepPerPeriodRates returned %v", err)
+		}
+		if i == 0 {
+			// Loans made once the flag exists charge annual rates
+			if err := db.Create(&models.Loan{Amount: 100000, Interest: 60, Term: 2, LoanPurpose: &purpose}).Error; err != nil {
+				t.Fatalf("failed to create loan: %v", err)
+			}
+		}
+	}
+
+	var loans []models.Loan
+	if err := db.Order("id").Find(&loans).Error; err != nil {
+		t.Fatalf("failed to get loans: %v", err)
+	}
+	if len(loans) != 2 {
+		t.Fatalf("got %d loans, want 2", len(loans))
+	}
+	if !loans[0].RatePerPeriod || loans[1].RatePerPeriod {
+		t.Errorf("loans charge per period: %v and %v, want true for the old loan only", loans[0].RatePerPeriod, loans[1].RatePerPeriod)
+	}
+}
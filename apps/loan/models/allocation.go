@@ -0,0 +1,238 @@
+package models
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kifangamukundi/gm/loan/money"
+	"github.com/kifangamukundi/gm/loan/services"
+)
+
+const (
+	ComponentPenalty     = "penalty"
+	ComponentFees        = "fees"
+	ComponentInterest    = "interest"
+	ComponentPrincipal   = "principal"
+	ComponentOverpayment = "overpayment"
+)
+
+// DefaultAllocationOrder is the waterfall used when a product does not configure its own
+var DefaultAllocationOrder = []string{ComponentPenalty, ComponentFees, ComponentInterest, ComponentPrincipal}
+
+// PaymentAllocation records how much of a payment went to one component of one instalment.
+// Money left over once every instalment is cleared is recorded as an overpayment without an instalment.
+type PaymentAllocation struct {
+	ID           uint         `gorm:"primaryKey"`
+	PaymentID    uint         `gorm:"index"` // Foreign key to Payment
+	Payment      Payment      `gorm:"foreignKey:PaymentID;constraint:onDelete:CASCADE"`
+	LoanID       uint         `gorm:"index"` // Foreign key to Loan
+	Loan         Loan         `gorm:"foreignKey:LoanID;constraint:onDelete:CASCADE"`
+	InstalmentID *uint        `gorm:"index;default:null"`
+	Instalment   *Instalment  `gorm:"foreignKey:InstalmentID;constraint:onDelete:SET NULL"`
+	Component    string       `gorm:"not null"` // penalty, fees, interest, principal, overpayment
+	Amount       money.Amount `gorm:"not null"`
+	CreatedAt    time.Time    `gorm:"not null"`
+}
+
+type AllocationModel struct {
+	Service services.Service
+}
+
+func NewAllocationModel(service services.Service) *AllocationModel {
+	return &AllocationModel{Service: service}
+}
+
+// ParseAllocationOrder reads a comma separated waterfall such as "penalty,fees,interest,principal".
+// Every component must appear exactly once; an empty value gives the default order.
+func ParseAllocationOrder(value string) ([]string, error) {
+	if strings.TrimSpace(value) == "" {
+		return DefaultAllocationOrder, nil
+	}
+
+	order := strings.Split(value, ",")
+	seen := map[string]bool{}
+	for i, component := range order {
+		component = strings.TrimSpace(component)
+		switch component {
+		case ComponentPenalty, ComponentFees, ComponentInterest, ComponentPrincipal:
+		default:
+			return nil, fmt.Errorf("unknown allocation component: %s", component)
+		}
+		if seen[component] {
+			return nil, fmt.Errorf("allocation component %s is listed twice", component)
+		}
+		seen[component] = true
+		order[i] = component
+	}
+
+	if len(seen) != len(DefaultAllocationOrder) {
+		return nil, fmt.Errorf("allocation order must list penalty, fees, interest and principal")
+	}
+
+	return order, nil
+}
+
+// Outstanding returns what is still owed on a component of the instalment
+func (i *Instalment) Outstanding(component string) money.Amount {
+	switch component {
+	case ComponentPenalty:
+		return i.Penalty - i.PenaltyPaid
+	case ComponentFees:
+		return i.Fees - i.FeesPaid
+	case ComponentInterest:
+		return i.Interest - i.InterestPaid
+	case ComponentPrincipal:
+		return i.Principal - i.PrincipalPaid
+	}
+	return money.Zero
+}
+
+// Balance returns everything still owed on the instalment, penalties included
+func (i *Instalment) Balance() money.Amount {
+	return i.Outstanding(ComponentPenalty) + i.Outstanding(ComponentFees) + i.Outstanding(ComponentInterest) + i.Outstanding(ComponentPrincipal)
+}
+
+// AmountPaid returns everything collected against the instalment so far
+func (i *Instalment) AmountPaid() money.Amount {
+	return i.PenaltyPaid + i.FeesPaid + i.InterestPaid + i.PrincipalPaid
+}
+
+func (i *Instalment) pay(component string, amount money.Amount) {
+	switch component {
+	case ComponentPenalty:
+		i.PenaltyPaid += amount
+	case ComponentFees:
+		i.FeesPaid += amount
+	case ComponentInterest:
+		i.InterestPaid += amount
+	case ComponentPrincipal:
+		i.PrincipalPaid += amount
+	}
+}
+
+// AllocatePayment splits amount across the instalments, oldest due date first, clearing
+// each instalment's components in the given order before moving to the next one. The
+// instalments are updated in place and the allocations are returned without IDs.
+func AllocatePayment(instalments []Instalment, amount money.Amount, order []string, paidAt time.Time) []PaymentAllocation {
+	var allocations []PaymentAllocation
+	remaining := amount
+
+	for i := range instalments {
+		if remaining <= 0 {
+			break
+		}
+
+		instalment := &instalments[i]
+		if instalment.Balance() <= 0 {
+			continue
+		}
+
+		for _, component := range order {
+			outstanding := instalment.Outstanding(component)
+			if outstanding <= 0 || remaining <= 0 {
+				continue
+			}
+
+			applied := money.Min(outstanding, remaining)
+
+			instalment.pay(component, applied)
+			remaining -= applied
+
+			id := instalment.ID
+			allocations = append(allocations, PaymentAllocation{
+				LoanID:       instalment.LoanID,
+				InstalmentID: &id,
+				Component:    component,
+				Amount:       applied,
+			})
+		}
+
+		if instalment.Balance() <= 0 {
+			instalment.Status = InstalmentStatusPaid
+			instalment.PaidAt = &paidAt
+		} else {
+			instalment.Status = InstalmentStatusPartiallyPaid
+		}
+	}
+
+	if remaining > 0 {
+		allocations = append(allocations, PaymentAllocation{
+			Component: ComponentOverpayment,
+			Amount:    remaining,
+		})
+	}
+
+	return allocations
+}
+
+// ApplyPayment runs a successful payment through the waterfall, storing the allocations
+// and the updated instalments. It returns the allocations and what is left to pay on the loan.
+func (m *AllocationModel) ApplyPayment(payment Payment, order []string, instalments []Instalment) ([]PaymentAllocation, money.Amount, error) {
+	paidAt := payment.UpdatedAt
+	if payment.PaidAt != nil {
+		paidAt = *payment.PaidAt
+	}
+
+	before := make(map[uint]Instalment, len(instalments))
+	for _, instalment := range instalments {
+		before[instalment.ID] = instalment
+	}
+
+	allocations := AllocatePayment(instalments, payment.Amount, order, paidAt)
+
+	for i := range allocations {
+		allocations[i].PaymentID = payment.ID
+		allocations[i].LoanID = payment.LoanID
+		if err := m.Service.CreateEntity(&allocations[i]); err != nil {
+			return nil, 0, fmt.Errorf("failed to record allocation: %v", err)
+		}
+	}
+
+	balance := money.Zero
+	for i := range instalments {
+		instalment := &instalments[i]
+		balance += instalment.Balance()
+
+		previous := before[instalment.ID]
+		if instalment.Status == previous.Status && instalment.Balance() == previous.Balance() {
+			continue
+		}
+
+		// Only the paid columns are written, and only while the instalment is as it was read,
+		// so a penalty charged or a payment applied in the meantime is not overwritten
+		updated, err := m.Service.UpdateEntityColumns(instalment, map[string]interface{}{
+			"penalty":        previous.Penalty,
+			"penalty_paid":   previous.PenaltyPaid,
+			"fees_paid":      previous.FeesPaid,
+			"interest_paid":  previous.InterestPaid,
+			"principal_paid": previous.PrincipalPaid,
+			"status":         previous.Status,
+		}, "penalty_paid", "fees_paid", "interest_paid", "principal_paid", "status", "paid_at", "updated_at")
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to update instalment: %v", err)
+		}
+		if !updated {
+			return nil, 0, fmt.Errorf("instalment %d was changed by another request", instalment.ID)
+		}
+	}
+
+	return allocations, balance, nil
+}
+
+func (m *AllocationModel) GetPaymentAllocations(paymentId uint) ([]PaymentAllocation, error) {
+	var allocations []PaymentAllocation
+
+	result, err := m.Service.GetAllEntititiesByFieldWithPreload(&allocations, "payment_id", strconv.Itoa(int(paymentId)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get allocations: %v", err)
+	}
+
+	allocationsPtr, ok := result.(*[]PaymentAllocation)
+	if !ok {
+		return nil, fmt.Errorf("unexpected result type: %T", result)
+	}
+
+	return *allocationsPtr, nil
+}
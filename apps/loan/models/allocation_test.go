@@ -0,0 +1,195 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kifangamukundi/gm/loan/money"
+	"github.com/kifangamukundi/gm/loan/services"
+)
+
+func TestAllocatePayment(t *testing.T) {
+	paidAt := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	defaultOrder := []string{ComponentPenalty, ComponentFees, ComponentInterest, ComponentPrincipal}
+
+	schedule := func() []Instalment {
+		return []Instalment{
+			{ID: 1, LoanID: 7, Principal: 1000, Interest: 100, Fees: 50, Penalty: 20},
+			{ID: 2, LoanID: 7, Principal: 1000, Interest: 100, Fees: 50},
+		}
+	}
+
+	type allocation struct {
+		instalment uint
+		component  string
+		amount     money.Amount
+	}
+
+	tests := []struct {
+		name        string
+		instalments []Instalment
+		amount      money.Amount
+		order       []string
+		want        []allocation
+		wantStatus  []string
+	}{
+		{
+			name:        "clears the oldest instalment component by component",
+			instalments: schedule(),
+			amount:      1170,
+			order:       defaultOrder,
+			want: []allocation{
+				{1, ComponentPenalty, 20},
+				{1, ComponentFees, 50},
+				{1, ComponentInterest, 100},
+				{1, ComponentPrincipal, 1000},
+			},
+			wantStatus: []string{InstalmentStatusPaid, ""},
+		},
+		{
+			name:        "carries the rest to the next instalment",
+			instalments: schedule(),
+			amount:      1300,
+			order:       defaultOrder,
+			want: []allocation{
+				{1, ComponentPenalty, 20},
+				{1, ComponentFees, 50},
+				{1, ComponentInterest, 100},
+				{1, ComponentPrincipal, 1000},
+				{2, ComponentFees, 50},
+				{2, ComponentInterest, 80},
+			},
+			wantStatus: []string{InstalmentStatusPaid, InstalmentStatusPartiallyPaid},
+		},
+		{
+			name:        "follows the product's order",
+			instalments: schedule(),
+			amount:      1010,
+			order:       []string{ComponentPrincipal, ComponentInterest, ComponentFees, ComponentPenalty},
+			want: []allocation{
+				{1, ComponentPrincipal, 1000},
+				{1, ComponentInterest, 10},
+			},
+			wantStatus: []string{InstalmentStatusPartiallyPaid, ""},
+		},
+		{
+			name: "skips paid instalments and keeps the overpayment",
+			instalments: []Instalment{
+				{ID: 1, LoanID: 7, Principal: 1000, PrincipalPaid: 1000, Status: InstalmentStatusPaid, PaidAt: &paidAt},
+				{ID: 2, LoanID: 7, Principal: 1000, Interest: 100, InterestPaid: 40},
+			},
+			amount: 1100,
+			order:  defaultOrder,
+			want: []allocation{
+				{2, ComponentInterest, 60},
+				{2, ComponentPrincipal, 1000},
+				{0, ComponentOverpayment, 40},
+			},
+			wantStatus: []string{InstalmentStatusPaid, InstalmentStatusPaid},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allocations := AllocatePayment(tt.instalments, tt.amount, tt.order, paidAt)
+
+			got := []allocation{}
+			total := money.Zero
+			for _, a := range allocations {
+				row := allocation{component: a.Component, amount: a.Amount}
+				if a.InstalmentID != nil {
+					row.instalment = *a.InstalmentID
+				}
+				got = append(got, row)
+				total += a.Amount
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("allocations = %v, want %v", got, tt.want)
+			}
+			if total != tt.amount {
+				t.Errorf("allocated %s of %s", total, tt.amount)
+			}
+
+			for i, status := range tt.wantStatus {
+				if tt.instalments[i].Status != status {
+					t.Errorf("instalment %d is %q, want %q", i+1, tt.instalments[i].Status, status)
+				}
+				if status == InstalmentStatusPaid && tt.instalments[i].PaidAt == nil {
+					t.Errorf("instalment %d is paid without a PaidAt", i+1)
+				}
+			}
+		})
+	}
+}
+
+func TestRepaymentKeepsAConcurrentPenalty(t *testing.T) {
+	service := newTestService(t, append(ledgerEntities, &Loan{}, &Instalment{}, &PenaltyCharge{}, &Payment{}, &PaymentAllocation{}, &LoanStatusHistory{})...)
+	seedTestAccounts(t, service)
+	loan := createTestLoan(t, service, LoanStatusInArrears, 22000)
+	for number := 1; number <= 2; number++ {
+		instalment := Instalment{LoanID: loan.ID, Number: number, Principal: 10000, Interest: 1000}
+		if err := service.CreateEntity(&instalment); err != nil {
+			t.Fatalf("failed to create instalment: %v", err)
+		}
+	}
+
+	order := []string{ComponentPenalty, ComponentFees, ComponentInterest, ComponentPrincipal}
+	paidAt := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	payment := Payment{LoanID: loan.ID, Amount: 11000, Status: PaymentStatusSuccess, PaidAt: &paidAt}
+	if err := service.CreateEntity(&payment); err != nil {
+		t.Fatalf("failed to create payment: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		instalment int
+	}{
+		{name: "on the instalment being paid", instalment: 0},
+		{name: "on a later instalment", instalment: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stale := getTestLoan(t, service, loan.ID)
+			instalments, err := NewScheduleModel(service).GetLoanSchedule(loan.ID)
+			if err != nil {
+				t.Fatalf("GetLoanSchedule returned %v", err)
+			}
+
+			// The penalty job charges the instalment after the repayment has read the loan and
+			// its schedule but before it writes them back
+			if _, err := NewPenaltyModel(service).ChargePenalty(instalments[tt.instalment].ID, "2026-03-01", 500, 1, PenaltyTypeFlat); err != nil {
+				t.Fatalf("ChargePenalty returned %v", err)
+			}
+			charged := getTestLoan(t, service, loan.ID)
+
+			err = service.WithTransaction(func(tx services.Service) error {
+				_, balance, err := NewAllocationModel(tx).ApplyPayment(payment, order, instalments)
+				if err != nil {
+					return err
+				}
+				_, err = NewLoanModel(tx).RecordRepayment(&stale, balance, paidAt)
+				return err
+			})
+			if err == nil {
+				t.Fatal("repayment over a stale schedule succeeded, want an error")
+			}
+
+			stored := getTestLoan(t, service, loan.ID)
+			if stored.RemainingBalance != charged.RemainingBalance {
+				t.Errorf("remaining balance = %s, want the penalised %s", stored.RemainingBalance, charged.RemainingBalance)
+			}
+			penalised := Instalment{ID: instalments[tt.instalment].ID}
+			if _, err := service.GetEntityByID(&penalised, penalised.ID); err != nil {
+				t.Fatalf("failed to get instalment: %v", err)
+			}
+			if penalised.Penalty != 500 {
+				t.Errorf("instalment penalty = %s, want 5.00", penalised.Penalty)
+			}
+			if penalised.InterestPaid != 0 || penalised.PrincipalPaid != 0 {
+				t.Errorf("rolled back repayment left %s interest and %s principal paid", penalised.InterestPaid, penalised.PrincipalPaid)
+			}
+		})
+	}
+}
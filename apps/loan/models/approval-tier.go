@@ -0,0 +1,244 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/kifangamukundi/gm/libs/parameters"
+	"github.com/kifangamukundi/gm/loan/money"
+	"github.com/kifangamukundi/gm/loan/services"
+)
+
+var (
+	ErrAlreadyApproved       = errors.New("user has already approved this loan")
+	ErrApproverNotAuthorised = errors.New("user does not hold the role this approval level requires")
+)
+
+// ApprovalTier is one level of sign-off a loan needs before it is disbursed. Loans above
+// AboveAmount need it, and every loan needs the lowest tier. Tiers are signed off in level
+// order by different users.
+type ApprovalTier struct {
+	ID          uint         `gorm:"primaryKey"`
+	Level       int          `gorm:"uniqueIndex;not null"` // Order tiers sign off in, lowest first
+	Name        string       `gorm:"not null"`
+	AboveAmount money.Amount `gorm:"not null;default:0"` // Loans larger than this need this tier
+	RoleID      uint         `gorm:"index"`              // Role an approver at this tier must hold
+	Role        Role         `gorm:"foreignKey:RoleID;constraint:onDelete:RESTRICT"`
+	CreatedAt   time.Time    `gorm:"not null"`
+	UpdatedAt   time.Time    `gorm:"not null"`
+}
+
+// ApprovalProgress is where a loan stands after a sign-off
+type ApprovalProgress struct {
+	Approvals []LoanApproval
+	Pending   []ApprovalTier // Required tiers still to sign off, in level order
+}
+
+// Complete reports whether every required tier has signed off
+func (p ApprovalProgress) Complete() bool {
+	return len(p.Pending) == 0
+}
+
+type ApprovalModel struct {
+	Service services.Service
+}
+
+func NewApprovalModel(service services.Service) *ApprovalModel {
+	return &ApprovalModel{Service: service}
+}
+
+// defaultApprovalTier applies when no tiers are configured, so a single user with
+// edit_loan can approve as before
+var defaultApprovalTier = ApprovalTier{Level: 1, Name: "Approval"}
+
+func (m *ApprovalModel) CreateApprovalTier(tier *ApprovalTier) error {
+	tier.Name = parameters.TrimWhitespace(tier.Name)
+
+	if err := m.Service.CreateEntity(tier); err != nil {
+		return fmt.Errorf("failed to create approval tier: %v", err)
+	}
+
+	return nil
+}
+
+// GetApprovalTiers returns every tier with its role, in level order.
+func (m *ApprovalModel) GetApprovalTiers() ([]ApprovalTier, error) {
+	var tiers []ApprovalTier
+
+	result, err := m.Service.GetAllEntitiesWithPreload(&tiers, "Role")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get approval tiers: %v", err)
+	}
+
+	tiersPtr, ok := result.(*[]ApprovalTier)
+	if !ok {
+		return nil, fmt.Errorf("unexpected result type: %T", result)
+	}
+
+	sort.Slice(*tiersPtr, func(i, j int) bool {
+		return (*tiersPtr)[i].Level < (*tiersPtr)[j].Level
+	})
+
+	return *tiersPtr, nil
+}
+
+func (m *ApprovalModel) GetApprovalTierByField(field, value string) (*ApprovalTier, error) {
+	var tier ApprovalTier
+
+	result, err := m.Service.GetEntityByFieldWithPreload(&tier, field, value, "Role")
+	if err != nil {
+		log.Printf("Error fetching approval tier by %s: %v", field, err)
+		return nil, err
+	}
+
+	return result.(*ApprovalTier), nil
+}
+
+func (m *ApprovalModel) UpdateApprovalTier(id uint, changes ApprovalTier) (ApprovalTier, error) {
+	tier := &ApprovalTier{ID: id}
+
+	_, err := m.Service.GetEntityByID(tier, id)
+	if err != nil {
+		return ApprovalTier{}, fmt.Errorf("approval tier not found: %v", err)
+	}
+
+	tier.Level = changes.Level
+	tier.Name = parameters.TrimWhitespace(changes.Name)
+	tier.AboveAmount = changes.AboveAmount
+	tier.RoleID = changes.RoleID
+
+	if err := m.Service.UpdateEntity(tier); err != nil {
+		return ApprovalTier{}, fmt.Errorf("failed to update approval tier: %v", err)
+	}
+
+	return *tier, nil
+}
+
+func (m *ApprovalModel) DeleteApprovalTier(id uint) error {
+	if err := m.Service.HardDeleteEntity(&ApprovalTier{}, id, "approval tier"); err != nil {
+		return fmt.Errorf("failed to delete approval tier: %v", err)
+	}
+
+	return nil
+}
+
+// RequiredTiers returns the tiers a loan of the given amount needs, in level order. The
+// lowest tier is always required, so no loan is approved without a sign-off.
+func (m *ApprovalModel) RequiredTiers(amount money.Amount) ([]ApprovalTier, error) {
+	tiers, err := m.GetApprovalTiers()
+	if err != nil {
+		return nil, err
+	}
+	if len(tiers) == 0 {
+		return []ApprovalTier{defaultApprovalTier}, nil
+	}
+
+	required := []ApprovalTier{tiers[0]}
+	for _, tier := range tiers[1:] {
+		if amount > tier.AboveAmount {
+			required = append(required, tier)
+		}
+	}
+
+	return required, nil
+}
+
+// GetLoanApprovals returns the sign-offs recorded on a loan, in level order.
+func (m *ApprovalModel) GetLoanApprovals(loanId uint) ([]LoanApproval, error) {
+	var approvals []LoanApproval
+
+	result, err := m.Service.GetOrderedEntitiesByField(&approvals, "loan_id", loanId, "level asc", "Approver")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get loan approvals: %v", err)
+	}
+
+	return *result.(*[]LoanApproval), nil
+}
+
+// GetApprovalProgress returns the loan's sign-offs and the required tiers still missing.
+func (m *ApprovalModel) GetApprovalProgress(loan *Loan) (ApprovalProgress, error) {
+	required, err := m.RequiredTiers(loan.Amount)
+	if err != nil {
+		return ApprovalProgress{}, err
+	}
+
+	approvals, err := m.GetLoanApprovals(loan.ID)
+	if err != nil {
+		return ApprovalProgress{}, err
+	}
+
+	signed := map[int]bool{}
+	for _, approval := range approvals {
+		signed[approval.Level] = true
+	}
+
+	progress := ApprovalProgress{Approvals: approvals, Pending: []ApprovalTier{}}
+	for _, tier := range required {
+		if !signed[tier.Level] {
+			progress.Pending = append(progress.Pending, tier)
+		}
+	}
+
+	return progress, nil
+}
+
+// RecordApproval signs the loan off at its next pending tier on behalf of a user who holds
+// the tier's role and has not signed off another level.
+func (m *ApprovalModel) RecordApproval(loan *Loan, user User, officerId *uint) (ApprovalProgress, error) {
+	progress, err := m.GetApprovalProgress(loan)
+	if err != nil {
+		return ApprovalProgress{}, err
+	}
+	if progress.Complete() {
+		return progress, nil
+	}
+
+	for _, approval := range progress.Approvals {
+		if approval.ApproverID == user.ID {
+			return ApprovalProgress{}, fmt.Errorf("%w at level %d", ErrAlreadyApproved, approval.Level)
+		}
+	}
+
+	tier := progress.Pending[0]
+	if tier.RoleID != 0 && !userHasRole(user, tier.RoleID) {
+		return ApprovalProgress{}, fmt.Errorf("%w: %s", ErrApproverNotAuthorised, tier.Name)
+	}
+
+	approval := LoanApproval{
+		LoanID:     loan.ID,
+		Level:      tier.Level,
+		TierName:   tier.Name,
+		ApproverID: user.ID,
+		OfficerID:  officerId,
+	}
+
+	// The insert runs in a savepoint so the caller's transaction survives a lost race
+	err = m.Service.WithTransaction(func(tx services.Service) error {
+		return tx.CreateEntity(&approval)
+	})
+	if err != nil {
+		taken, countErr := m.Service.CountEntities(&LoanApproval{}, map[string]interface{}{"loan_id": loan.ID, "level": tier.Level})
+		if countErr == nil && taken > 0 {
+			return ApprovalProgress{}, fmt.Errorf("%w: level %d was approved by another request", ErrConcurrentTransition, tier.Level)
+		}
+		return ApprovalProgress{}, fmt.Errorf("failed to record approval: %v", err)
+	}
+
+	approval.Approver = user
+	progress.Approvals = append(progress.Approvals, approval)
+	progress.Pending = progress.Pending[1:]
+
+	return progress, nil
+}
+
+func userHasRole(user User, roleId uint) bool {
+	for _, role := range user.Roles {
+		if role.ID == roleId {
+			return true
+		}
+	}
+	return false
+}
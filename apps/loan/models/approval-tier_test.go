@@ -0,0 +1,51 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/kifangamukundi/gm/loan/money"
+)
+
+func TestRequiredTiers(t *testing.T) {
+	service := newTestService(t, &Role{}, &ApprovalTier{})
+	model := NewApprovalModel(service)
+
+	if required, err := model.RequiredTiers(money.FromShillings(1000)); err != nil || len(required) != 1 || required[0].Name != defaultApprovalTier.Name {
+		t.Fatalf("RequiredTiers with no tiers = %v, %v, want the default tier", required, err)
+	}
+
+	tiers := []ApprovalTier{
+		{Level: 1, Name: "Branch", AboveAmount: money.FromShillings(5000), RoleID: 1},
+		{Level: 2, Name: "Credit committee", AboveAmount: money.FromShillings(50000), RoleID: 2},
+	}
+	for i := range tiers {
+		if err := model.CreateApprovalTier(&tiers[i]); err != nil {
+			t.Fatalf("CreateApprovalTier returned %v", err)
+		}
+	}
+
+	tests := []struct {
+		amount money.Amount
+		want   []string
+	}{
+		{amount: money.FromShillings(1000), want: []string{"Branch"}},
+		{amount: money.FromShillings(5000), want: []string{"Branch"}},
+		{amount: money.FromShillings(20000), want: []string{"Branch"}},
+		{amount: money.FromShillings(80000), want: []string{"Branch", "Credit committee"}},
+	}
+
+	for _, tt := range tests {
+		required, err := model.RequiredTiers(tt.amount)
+		if err != nil {
+			t.Fatalf("RequiredTiers(%s) returned %v", tt.amount, err)
+		}
+		got := []string{}
+		for _, tier := range required {
+			got = append(got, tier.Name)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("RequiredTiers(%s) = %v, want %v", tt.amount, got, tt.want)
+		}
+	}
+}
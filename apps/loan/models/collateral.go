@@ -0,0 +1,310 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/kifangamukundi/gm/libs/parameters"
+	"github.com/kifangamukundi/gm/loan/deserializers"
+	"github.com/kifangamukundi/gm/loan/money"
+	"github.com/kifangamukundi/gm/loan/services"
+)
+
+const (
+	LienStatusPending  = "pending"  // Recorded against a loan that has not been approved
+	LienStatusActive   = "active"   // Held from approval until the loan ends
+	LienStatusReleased = "released" // Returned to the borrower
+
+	CollateralTypeVehicle   = "vehicle"
+	CollateralTypeLand      = "land"
+	CollateralTypeEquipment = "equipment"
+	CollateralTypeHousehold = "household"
+	CollateralTypeLivestock = "livestock"
+	CollateralTypeOther     = "other"
+)
+
+var (
+	ErrCollateralLocked  = errors.New("collateral can no longer be changed")
+	ErrCollateralPledged = errors.New("collateral is already pledged")
+	ErrLoanToValue       = errors.New("loan exceeds the product's loan-to-value limit")
+)
+
+// lienTransitions lists the lien status collateral moves to when its loan enters a status.
+// A loan closed by a top-up hands its collateral over first, see transferCollateral.
+var lienTransitions = map[string]string{
+	LoanStatusApproved:  LienStatusActive,
+	LoanStatusClosed:    LienStatusReleased,
+	LoanStatusRejected:  LienStatusReleased,
+	LoanStatusCancelled: LienStatusReleased,
+}
+
+// Collateral is an asset pledged as security for a loan. Its lien follows the loan, see
+// lienTransitions, and its value counts towards the product's MaxLoanToValue until released.
+type Collateral struct {
+	ID             uint                            `gorm:"primaryKey"`
+	LoanID         uint                            `gorm:"index"` // Foreign key to Loan
+	Loan           Loan                            `gorm:"foreignKey:LoanID;constraint:onDelete:CASCADE"`
+	Type           string                          `gorm:"not null;default:'other'"` // vehicle, land, equipment, household, livestock, other
+	Description    string                          `gorm:"not null"`
+	EstimatedValue money.Amount                    `gorm:"not null"`
+	SerialNumber   string                          `gorm:"not null;default:'';index"` // Serial, registration or title number
+	Images         deserializers.DefaultImageSlice `json:"Images" gorm:"type:jsonb;serializer:json"`
+
+	LienStatus       string     `gorm:"not null;default:'pending';index"`
+	LienRegisteredAt *time.Time `gorm:"default:null"`
+	LienReleasedAt   *time.Time `gorm:"default:null"`
+
+	CreatedByID uint `gorm:"index"`
+	CreatedBy   User `gorm:"foreignKey:CreatedByID;constraint:onDelete:RESTRICT"`
+
+	CreatedAt time.Time `gorm:"not null"`
+	UpdatedAt time.Time `gorm:"not null"`
+}
+
+type CollateralModel struct {
+	Service services.Service
+}
+
+func NewCollateralModel(service services.Service) *CollateralModel {
+	return &CollateralModel{Service: service}
+}
+
+// checkSerialNumber fails with ErrCollateralPledged when an asset with the serial number is
+// still held against a loan other than the collateral itself.
+func (m *CollateralModel) checkSerialNumber(collateral *Collateral) error {
+	if collateral.SerialNumber == "" {
+		return nil
+	}
+
+	for _, status := range []string{LienStatusPending, LienStatusActive} {
+		var held []Collateral
+		result, err := m.Service.GetEntitiesByFields(&held, map[string]interface{}{"serial_number": collateral.SerialNumber, "lien_status": status})
+		if err != nil {
+			return fmt.Errorf("failed to check serial number: %v", err)
+		}
+
+		for _, other := range *result.(*[]Collateral) {
+			if other.ID != collateral.ID {
+				return fmt.Errorf("%w: %s secures loan %d", ErrCollateralPledged, collateral.SerialNumber, other.LoanID)
+			}
+		}
+	}
+
+	return nil
+}
+
+// editableCollateral loads collateral that may still be changed, which is only while its loan
+// is pending.
+func (m *CollateralModel) editableCollateral(id uint) (*Collateral, error) {
+	collateral := &Collateral{ID: id}
+	if _, err := m.Service.GetEntityByID(collateral, id); err != nil {
+		return nil, fmt.Errorf("collateral not found: %v", err)
+	}
+
+	loan := &Loan{ID: collateral.LoanID}
+	if _, err := m.Service.GetEntityByID(loan, collateral.LoanID); err != nil {
+		return nil, fmt.Errorf("loan not found: %v", err)
+	}
+	if loan.Status != LoanStatusPending || collateral.LienStatus != LienStatusPending {
+		return nil, fmt.Errorf("%w: loan is %s", ErrCollateralLocked, loan.Status)
+	}
+
+	return collateral, nil
+}
+
+// AddCollateral records an asset against a pending loan. An asset with a serial number can
+// only secure one loan at a time.
+func (m *CollateralModel) AddCollateral(collateral *Collateral) error {
+	loan := &Loan{ID: collateral.LoanID}
+	if _, err := m.Service.GetEntityByID(loan, collateral.LoanID); err != nil {
+		return fmt.Errorf("loan not found: %v", err)
+	}
+	if loan.Status != LoanStatusPending {
+		return fmt.Errorf("%w: loan is %s", ErrCollateralLocked, loan.Status)
+	}
+
+	collateral.Description = parameters.TrimWhitespace(collateral.Description)
+	collateral.SerialNumber = parameters.TrimWhitespace(collateral.SerialNumber)
+	if collateral.Type == "" {
+		collateral.Type = CollateralTypeOther
+	}
+	collateral.LienStatus = LienStatusPending
+
+	if err := m.checkSerialNumber(collateral); err != nil {
+		return err
+	}
+
+	if err := m.Service.CreateEntity(collateral); err != nil {
+		return fmt.Errorf("failed to create collateral: %v", err)
+	}
+
+	return nil
+}
+
+func (m *CollateralModel) GetCollateralByField(field, value string) (*Collateral, error) {
+	var collateral Collateral
+
+	result, err := m.Service.GetEntityByFieldWithPreload(&collateral, field, value, "CreatedBy")
+	if err != nil {
+		log.Printf("Error fetching collateral by %s: %v", field, err)
+		return nil, err
+	}
+
+	return result.(*Collateral), nil
+}
+
+// GetLoanCollateral returns every asset recorded against the loan, oldest first.
+func (m *CollateralModel) GetLoanCollateral(loanId uint) ([]Collateral, error) {
+	var collateral []Collateral
+
+	result, err := m.Service.GetOrderedEntitiesByField(&collateral, "loan_id", loanId, "id asc", "CreatedBy")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get collateral: %v", err)
+	}
+
+	return *result.(*[]Collateral), nil
+}
+
+// UpdateCollateral replaces the details of collateral on a pending loan. It returns the
+// collateral as it was so the caller can clean up photos that were dropped.
+func (m *CollateralModel) UpdateCollateral(id uint, changes Collateral) (Collateral, Collateral, error) {
+	collateral, err := m.editableCollateral(id)
+	if err != nil {
+		return Collateral{}, Collateral{}, err
+	}
+	previous := *collateral
+
+	collateral.Type = changes.Type
+	collateral.Description = parameters.TrimWhitespace(changes.Description)
+	collateral.EstimatedValue = changes.EstimatedValue
+	collateral.SerialNumber = parameters.TrimWhitespace(changes.SerialNumber)
+	collateral.Images = changes.Images
+
+	if err := m.checkSerialNumber(collateral); err != nil {
+		return Collateral{}, Collateral{}, err
+	}
+
+	if err := m.Service.UpdateEntity(collateral); err != nil {
+		return Collateral{}, Collateral{}, fmt.Errorf("failed to update collateral: %v", err)
+	}
+
+	return *collateral, previous, nil
+}
+
+// DeleteCollateral removes collateral from a pending loan and returns what was removed
+func (m *CollateralModel) DeleteCollateral(id uint) (Collateral, error) {
+	collateral, err := m.editableCollateral(id)
+	if err != nil {
+		return Collateral{}, err
+	}
+
+	if err := m.Service.HardDeleteEntity(&Collateral{}, id, "collateral"); err != nil {
+		return Collateral{}, fmt.Errorf("failed to delete collateral: %v", err)
+	}
+
+	return *collateral, nil
+}
+
+// CollateralValue totals the estimated value of the loan's collateral that is still held
+func (m *CollateralModel) CollateralValue(loanId uint) (money.Amount, error) {
+	collateral, err := m.GetLoanCollateral(loanId)
+	if err != nil {
+		return money.Zero, err
+	}
+
+	value := money.Zero
+	for _, item := range collateral {
+		if item.LienStatus != LienStatusReleased {
+			value += item.EstimatedValue
+		}
+	}
+
+	return value, nil
+}
+
+// CheckLoanToValue fails with ErrLoanToValue when the loan is more than its product's
+// MaxLoanToValue percentage of its collateral, counting that of the loan a top-up settles.
+func (m *CollateralModel) CheckLoanToValue(loan *Loan) error {
+	if loan.Product == nil || loan.Product.MaxLoanToValue <= 0 {
+		return nil
+	}
+
+	value, err := m.CollateralValue(loan.ID)
+	if err != nil {
+		return err
+	}
+	if loan.RefinancedLoanID != nil {
+		refinanced, err := m.CollateralValue(*loan.RefinancedLoanID)
+		if err != nil {
+			return err
+		}
+		value += refinanced
+	}
+
+	limit := value.Percent(loan.Product.MaxLoanToValue)
+	if loan.Amount > limit {
+		return fmt.Errorf("%w: %s against collateral worth %s allows at most %s at %.2f%%", ErrLoanToValue, loan.Amount, value, limit, loan.Product.MaxLoanToValue)
+	}
+
+	return nil
+}
+
+// updateLiens moves the liens on a loan's collateral along with the loan entering status to.
+// It runs inside the loan's status transition so the two cannot disagree.
+func updateLiens(tx services.Service, loanId uint, to string, at time.Time) error {
+	lienStatus, ok := lienTransitions[to]
+	if !ok {
+		return nil
+	}
+
+	var collateral []Collateral
+	result, err := tx.GetEntitiesByFields(&collateral, map[string]interface{}{"loan_id": loanId})
+	if err != nil {
+		return fmt.Errorf("failed to get collateral: %v", err)
+	}
+
+	for _, item := range *result.(*[]Collateral) {
+		if item.LienStatus == lienStatus || item.LienStatus == LienStatusReleased {
+			continue
+		}
+
+		item.LienStatus = lienStatus
+		switch lienStatus {
+		case LienStatusActive:
+			item.LienRegisteredAt = &at
+		case LienStatusReleased:
+			item.LienReleasedAt = &at
+		}
+
+		if err := tx.UpdateEntity(&item); err != nil {
+			return fmt.Errorf("failed to update lien on collateral %d: %v", item.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// transferCollateral moves the collateral still held against one loan to another, keeping its
+// lien, for a loan that is settled by a top-up that takes over its debt.
+func transferCollateral(tx services.Service, fromLoanId, toLoanId uint) error {
+	var collateral []Collateral
+	result, err := tx.GetEntitiesByFields(&collateral, map[string]interface{}{"loan_id": fromLoanId})
+	if err != nil {
+		return fmt.Errorf("failed to get collateral: %v", err)
+	}
+
+	for _, item := range *result.(*[]Collateral) {
+		if item.LienStatus == LienStatusReleased {
+			continue
+		}
+
+		item.LoanID = toLoanId
+		if _, err := tx.UpdateEntityColumns(&item, nil, "loan_id", "updated_at"); err != nil {
+			return fmt.Errorf("failed to move collateral %d: %v", item.ID, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,278 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/kifangamukundi/gm/loan/money"
+	"github.com/kifangamukundi/gm/loan/services"
+)
+
+const (
+	CollectionActionPromise = "promise_to_pay"
+	CollectionActionVisit   = "visit"
+
+	VisitOutcomePaid      = "paid"
+	VisitOutcomePromised  = "promised" // Recorded with the amount and date promised
+	VisitOutcomeNotHome   = "not_home"
+	VisitOutcomeRefused   = "refused"
+	VisitOutcomeRelocated = "relocated"
+	VisitOutcomeOther     = "other"
+)
+
+var (
+	ErrNotLoanAgent            = errors.New("loan is not in the agent's portfolio")
+	ErrNotCollectable          = errors.New("loan is not being collected")
+	ErrInvalidCollectionAction = errors.New("invalid collection action")
+)
+
+// CollectionAction is a contact an agent made with a member about a loan they are collecting
+// on: a visit and how it went, or a commitment by the member to pay an amount by a date
+type CollectionAction struct {
+	ID           uint        `gorm:"primaryKey"`
+	LoanID       uint        `gorm:"index"` // Foreign key to Loan
+	Loan         Loan        `gorm:"foreignKey:LoanID;constraint:onDelete:CASCADE"`
+	InstalmentID *uint       `gorm:"index;default:null"` // The worklist item acted on, if any
+	Instalment   *Instalment `gorm:"foreignKey:InstalmentID;constraint:onDelete:SET NULL"`
+	AgentID      uint        `gorm:"index"`
+	Agent        Agent       `gorm:"foreignKey:AgentID;constraint:onDelete:CASCADE"`
+	Type         string      `gorm:"not null;index"`      // promise_to_pay, visit
+	Outcome      string      `gorm:"not null;default:''"` // Visits only
+
+	PromisedAmount money.Amount `gorm:"not null;default:0"`
+	PromisedDate   *time.Time   `gorm:"default:null"`
+
+	Notes        string    `gorm:"not null;default:''"`
+	RecordedByID uint      `gorm:"index"`
+	RecordedBy   User      `gorm:"foreignKey:RecordedByID;constraint:onDelete:RESTRICT"`
+	ContactedAt  time.Time `gorm:"not null;index"`
+
+	CreatedAt time.Time `gorm:"not null"`
+}
+
+// CollectionItem is an instalment due or overdue on the worklist, with the member to collect from
+type CollectionItem struct {
+	LoanID       uint
+	InstalmentID uint
+	Number       int
+	MemberID     uint
+	FirstName    string
+	LastName     string
+	MobileNumber string
+	DueDate      time.Time
+	AmountDue    money.Amount // What is left to pay on the instalment
+	DaysPastDue  int          // 0 for instalments falling due on the worklist day
+	LastContact  *CollectionAction
+}
+
+// CollectionGroup is the worklist items of the members of one group
+type CollectionGroup struct {
+	GroupID   uint
+	GroupName string
+	AmountDue money.Amount
+	Items     []CollectionItem
+}
+
+// Worklist is what an agent has to collect on a day, grouped by group
+type Worklist struct {
+	AgentID   uint
+	Date      time.Time
+	AmountDue money.Amount
+	Groups    []CollectionGroup
+}
+
+type CollectionModel struct {
+	Service services.Service
+}
+
+func NewCollectionModel(service services.Service) *CollectionModel {
+	return &CollectionModel{Service: service}
+}
+
+// lastContacts returns the latest action recorded against each of the loans
+func (m *CollectionModel) lastContacts(loanIds []uint) (map[uint]*CollectionAction, error) {
+	contacts := map[uint]*CollectionAction{}
+	if len(loanIds) == 0 {
+		return contacts, nil
+	}
+
+	var actions []CollectionAction
+	result, err := m.Service.GetLatestEntitiesByField(&actions, "loan_id", loanIds, "RecordedBy")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get collection actions: %v", err)
+	}
+
+	for _, action := range *result.(*[]CollectionAction) {
+		contacts[action.LoanID] = &action
+	}
+
+	return contacts, nil
+}
+
+// Worklist lists the unpaid instalments on the agent's loans that fall due on or before date,
+// oldest first within each group, with the last contact made about each loan
+func (m *CollectionModel) Worklist(agentId uint, date time.Time) (Worklist, error) {
+	worklist := Worklist{AgentID: agentId, Date: date, Groups: []CollectionGroup{}}
+
+	endOfDay := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, date.Location()).AddDate(0, 0, 1)
+
+	var instalments []Instalment
+	result, err := m.Service.GetOverdueInstalments(&instalments, endOfDay, PortfolioStatuses, map[string]interface{}{"agent_id": agentId}, "Loan.Member.User", "Loan.Group")
+	if err != nil {
+		return worklist, err
+	}
+
+	items := []Instalment{}
+	loanIds := []uint{}
+	seen := map[uint]bool{}
+	for _, instalment := range *result.(*[]Instalment) {
+		if instalment.Balance() <= 0 {
+			continue
+		}
+		items = append(items, instalment)
+		if !seen[instalment.LoanID] {
+			seen[instalment.LoanID] = true
+			loanIds = append(loanIds, instalment.LoanID)
+		}
+	}
+
+	contacts, err := m.lastContacts(loanIds)
+	if err != nil {
+		return worklist, err
+	}
+
+	groups := map[uint]*CollectionGroup{}
+	for _, instalment := range items {
+		loan := instalment.Loan
+
+		group, ok := groups[loan.GroupID]
+		if !ok {
+			group = &CollectionGroup{GroupID: loan.GroupID, GroupName: loan.Group.GroupName, Items: []CollectionItem{}}
+			groups[loan.GroupID] = group
+		}
+
+		item := CollectionItem{
+			LoanID:       loan.ID,
+			InstalmentID: instalment.ID,
+			Number:       instalment.Number,
+			MemberID:     loan.MemberID,
+			FirstName:    loan.Member.User.FirstName,
+			LastName:     loan.Member.User.LastName,
+			MobileNumber: loan.Member.User.MobileNumber,
+			DueDate:      instalment.DueDate,
+			AmountDue:    instalment.Balance(),
+			DaysPastDue:  max(DaysOverdue(instalment.DueDate, date), 0),
+			LastContact:  contacts[loan.ID],
+		}
+
+		group.Items = append(group.Items, item)
+		group.AmountDue += item.AmountDue
+		worklist.AmountDue += item.AmountDue
+	}
+
+	for _, group := range groups {
+		sort.SliceStable(group.Items, func(i, j int) bool {
+			return group.Items[i].DaysPastDue > group.Items[j].DaysPastDue
+		})
+		worklist.Groups = append(worklist.Groups, *group)
+	}
+	sort.Slice(worklist.Groups, func(i, j int) bool {
+		return worklist.Groups[i].GroupName < worklist.Groups[j].GroupName
+	})
+
+	return worklist, nil
+}
+
+// checkPromise fails unless the promise is for no more than is owed, on a day not yet past
+func checkPromise(action *CollectionAction, loan *Loan, now time.Time) error {
+	if action.PromisedAmount <= 0 || action.PromisedDate == nil {
+		return fmt.Errorf("%w: a promise needs an amount and a date", ErrInvalidCollectionAction)
+	}
+	if DaysOverdue(*action.PromisedDate, now) > 0 {
+		return fmt.Errorf("%w: promised date has passed", ErrInvalidCollectionAction)
+	}
+	if action.PromisedAmount > loan.RemainingBalance {
+		return fmt.Errorf("%w: promised amount is more than the %s owed", ErrInvalidCollectionAction, loan.RemainingBalance)
+	}
+	return nil
+}
+
+// checkCollectionAction fails unless the action is complete for its type and any instalment
+// it names is on the loan
+func (m *CollectionModel) checkCollectionAction(action *CollectionAction, loan *Loan, now time.Time) error {
+	switch action.Type {
+	case CollectionActionPromise:
+		action.Outcome = ""
+		if err := checkPromise(action, loan, now); err != nil {
+			return err
+		}
+	case CollectionActionVisit:
+		switch action.Outcome {
+		case "":
+			return fmt.Errorf("%w: a visit needs an outcome", ErrInvalidCollectionAction)
+		case VisitOutcomePromised:
+			if err := checkPromise(action, loan, now); err != nil {
+				return err
+			}
+		default:
+			action.PromisedAmount = money.Zero
+			action.PromisedDate = nil
+		}
+	default:
+		return fmt.Errorf("%w: unknown type %q", ErrInvalidCollectionAction, action.Type)
+	}
+
+	if action.InstalmentID != nil {
+		var instalment Instalment
+		result, err := m.Service.GetEntityByID(&instalment, *action.InstalmentID)
+		if err != nil || result.(*Instalment).LoanID != loan.ID {
+			return fmt.Errorf("%w: instalment is not on this loan", ErrInvalidCollectionAction)
+		}
+	}
+
+	return nil
+}
+
+// RecordCollectionAction stores a promise or visit outcome against a loan the agent is collecting
+func (m *CollectionModel) RecordCollectionAction(action *CollectionAction) error {
+	var loan Loan
+	result, err := m.Service.GetEntityByID(&loan, action.LoanID)
+	if err != nil {
+		return fmt.Errorf("loan not found: %v", err)
+	}
+	loanPtr := result.(*Loan)
+
+	if loanPtr.AgentID != action.AgentID {
+		return ErrNotLoanAgent
+	}
+	if !openLoanStatuses[loanPtr.Status] {
+		return fmt.Errorf("%w: loan is %s", ErrNotCollectable, loanPtr.Status)
+	}
+
+	now := time.Now()
+	if err := m.checkCollectionAction(action, loanPtr, now); err != nil {
+		return err
+	}
+
+	action.ContactedAt = now
+
+	if err := m.Service.CreateEntity(action); err != nil {
+		return fmt.Errorf("failed to record collection action: %v", err)
+	}
+
+	return nil
+}
+
+// GetLoanCollectionActions returns the contacts made about the loan, newest first
+func (m *CollectionModel) GetLoanCollectionActions(loanId uint) ([]CollectionAction, error) {
+	var actions []CollectionAction
+
+	result, err := m.Service.GetOrderedEntitiesByField(&actions, "loan_id", loanId, "id desc", "RecordedBy")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get collection actions: %v", err)
+	}
+
+	return *result.(*[]CollectionAction), nil
+}
@@ -0,0 +1,188 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/kifangamukundi/gm/loan/money"
+)
+
+var ErrNotGroupMember = errors.New("member does not belong to the group")
+
+// ContributionSchedule is what each member of a group is expected to save every period,
+// starting on StartDate. A group has one schedule; changing it starts counting afresh.
+type ContributionSchedule struct {
+	ID        uint         `gorm:"primaryKey"`
+	GroupID   uint         `gorm:"uniqueIndex"` // Foreign key to Group
+	Group     Group        `gorm:"foreignKey:GroupID;constraint:onDelete:CASCADE"`
+	Amount    money.Amount `gorm:"not null"`                   // Expected from each member per period
+	Frequency string       `gorm:"not null;default:'monthly'"` // daily, weekly, monthly
+	StartDate time.Time    `gorm:"not null"`                   // First contribution falls due on this day
+	IsActive  bool         `gorm:"default:true"`
+
+	CreatedByID uint `gorm:"index"`
+	CreatedBy   User `gorm:"foreignKey:CreatedByID;constraint:onDelete:RESTRICT"`
+
+	CreatedAt time.Time `gorm:"not null"`
+	UpdatedAt time.Time `gorm:"not null"`
+}
+
+// MemberContributions is how far one member is keeping up with their group's schedule
+type MemberContributions struct {
+	MemberID    uint
+	FirstName   string
+	LastName    string
+	Expected    money.Amount
+	Contributed money.Amount // Completed deposits made as contributions since the schedule started
+	Arrears     money.Amount
+}
+
+// GroupContributions is the state of a group's contribution schedule as at AsOf
+type GroupContributions struct {
+	Schedule    ContributionSchedule
+	AsOf        time.Time
+	PeriodsDue  int
+	NextDueDate time.Time
+	Expected    money.Amount
+	Contributed money.Amount
+	Arrears     money.Amount
+	Members     []MemberContributions
+}
+
+// GetGroupSchedule returns the group's contribution schedule, or nil when it has none
+func (m *SavingsModel) GetGroupSchedule(groupId uint) (*ContributionSchedule, error) {
+	var schedules []ContributionSchedule
+
+	result, err := m.Service.GetEntitiesByFields(&schedules, map[string]interface{}{"group_id": groupId})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get contribution schedule: %v", err)
+	}
+	if found := *result.(*[]ContributionSchedule); len(found) > 0 {
+		return &found[0], nil
+	}
+
+	return nil, nil
+}
+
+// SetGroupSchedule creates the group's contribution schedule or replaces its terms
+func (m *SavingsModel) SetGroupSchedule(schedule *ContributionSchedule) error {
+	existing, err := m.GetGroupSchedule(schedule.GroupID)
+	if err != nil {
+		return err
+	}
+
+	if existing == nil {
+		if err := m.Service.CreateEntity(schedule); err != nil {
+			return fmt.Errorf("failed to create contribution schedule: %v", err)
+		}
+		return nil
+	}
+
+	existing.Amount = schedule.Amount
+	existing.Frequency = schedule.Frequency
+	existing.StartDate = schedule.StartDate
+	existing.IsActive = schedule.IsActive
+	if err := m.Service.UpdateEntity(existing); err != nil {
+		return fmt.Errorf("failed to update contribution schedule: %v", err)
+	}
+
+	*schedule = *existing
+	return nil
+}
+
+// groupMembers returns the group with its members and their users
+func (m *SavingsModel) groupMembers(groupId uint) (*Group, error) {
+	var group Group
+
+	result, err := m.Service.GetEntityByFieldWithPreload(&group, "id", fmt.Sprintf("%d", groupId), "Members.User")
+	if err != nil {
+		return nil, fmt.Errorf("group not found: %v", err)
+	}
+
+	return result.(*Group), nil
+}
+
+// checkContribution fails unless the member belongs to the group the schedule is for
+func (m *SavingsModel) checkContribution(scheduleId, memberId uint) error {
+	schedule := &ContributionSchedule{ID: scheduleId}
+	if _, err := m.Service.GetEntityByID(schedule, scheduleId); err != nil {
+		return fmt.Errorf("contribution schedule not found: %v", err)
+	}
+
+	group, err := m.groupMembers(schedule.GroupID)
+	if err != nil {
+		return err
+	}
+	for _, member := range group.Members {
+		if member.ID == memberId {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: member %d is not in group %d", ErrNotGroupMember, memberId, schedule.GroupID)
+}
+
+// GroupContributions compares what each member of the group has contributed against what
+// the schedule expected of them by asOf
+func (m *SavingsModel) GroupContributions(groupId uint, asOf time.Time) (*GroupContributions, error) {
+	schedule, err := m.GetGroupSchedule(groupId)
+	if err != nil || schedule == nil {
+		return nil, err
+	}
+
+	group, err := m.groupMembers(groupId)
+	if err != nil {
+		return nil, err
+	}
+
+	contributions := GroupContributions{Schedule: *schedule, AsOf: asOf, Members: []MemberContributions{}}
+
+	if schedule.IsActive {
+		for DaysOverdue(NextDueDate(schedule.StartDate, schedule.Frequency, contributions.PeriodsDue), asOf) >= 0 {
+			contributions.PeriodsDue++
+		}
+	}
+	contributions.NextDueDate = NextDueDate(schedule.StartDate, schedule.Frequency, contributions.PeriodsDue)
+	expected := schedule.Amount.Mul(float64(contributions.PeriodsDue))
+
+	var deposits []SavingsTransaction
+	result, err := m.Service.GetEntitiesByFields(&deposits, map[string]interface{}{
+		"contribution_schedule_id": schedule.ID,
+		"status":                   SavingsStatusCompleted,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get contributions: %v", err)
+	}
+
+	contributed := map[uint]money.Amount{}
+	for _, deposit := range *result.(*[]SavingsTransaction) {
+		if deposit.CompletedAt == nil || deposit.CompletedAt.Before(schedule.StartDate) || DaysOverdue(*deposit.CompletedAt, asOf) < 0 {
+			continue
+		}
+		contributed[deposit.MemberID] += deposit.Amount
+	}
+
+	for _, member := range group.Members {
+		row := MemberContributions{
+			MemberID:    member.ID,
+			FirstName:   member.User.FirstName,
+			LastName:    member.User.LastName,
+			Expected:    expected,
+			Contributed: contributed[member.ID],
+		}
+		row.Arrears = max(row.Expected-row.Contributed, money.Zero)
+
+		contributions.Expected += row.Expected
+		contributions.Contributed += row.Contributed
+		contributions.Arrears += row.Arrears
+		contributions.Members = append(contributions.Members, row)
+	}
+
+	sort.Slice(contributions.Members, func(i, j int) bool {
+		return contributions.Members[i].MemberID < contributions.Members[j].MemberID
+	})
+
+	return &contributions, nil
+}
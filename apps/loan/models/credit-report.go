@@ -0,0 +1,152 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/kifangamukundi/gm/loan/bureau"
+	"github.com/kifangamukundi/gm/loan/services"
+)
+
+// submissionStatuses maps the statuses of disbursed loans to how they are reported to the bureau
+var submissionStatuses = map[string]string{
+	LoanStatusActive:     bureau.AccountStatusPerforming,
+	LoanStatusInArrears:  bureau.AccountStatusArrears,
+	LoanStatusDefaulted:  bureau.AccountStatusDefault,
+	LoanStatusClosed:     bureau.AccountStatusClosed,
+	LoanStatusWrittenOff: bureau.AccountStatusWrittenOff,
+}
+
+// CreditReport is a bureau report on the borrower, retrieved while their loan was up for approval
+type CreditReport struct {
+	ID            uint             `gorm:"primaryKey"`
+	LoanID        uint             `gorm:"index"` // Foreign key to Loan
+	Loan          Loan             `gorm:"foreignKey:LoanID;constraint:onDelete:CASCADE"`
+	MemberID      uint             `gorm:"index"`
+	Provider      string           `gorm:"not null"`
+	Reference     string           `gorm:"not null;default:''"` // The bureau's reference for the enquiry
+	ListingStatus string           `gorm:"not null;index"`      // clear, listed
+	Score         int              `gorm:"not null;default:0"`
+	Listings      []bureau.Listing `gorm:"type:jsonb;serializer:json"`
+	RetrievedAt   time.Time        `gorm:"not null"`
+
+	RequestedByID *uint `gorm:"index;default:null"` // Officer whose approval triggered the lookup
+	RequestedBy   *User `gorm:"foreignKey:RequestedByID;constraint:onDelete:SET NULL"`
+
+	CreatedAt time.Time `gorm:"not null"`
+}
+
+type CreditReportModel struct {
+	Service services.Service
+}
+
+func NewCreditReportModel(service services.Service) *CreditReportModel {
+	return &CreditReportModel{Service: service}
+}
+
+// LookupLoan asks the bureau about the loan's borrower and stores the report against the loan
+func (m *CreditReportModel) LookupLoan(ctx context.Context, provider bureau.CreditBureau, loanId uint, requestedById *uint) (CreditReport, error) {
+	var loan Loan
+	result, err := m.Service.GetEntityByFieldWithPreload(&loan, "id", fmt.Sprintf("%d", loanId), "Member.User")
+	if err != nil {
+		return CreditReport{}, fmt.Errorf("loan not found: %v", err)
+	}
+	borrower := result.(*Loan).Member.User
+
+	report, err := provider.Lookup(ctx, bureau.Subject{
+		MobileNumber: borrower.MobileNumber,
+		FirstName:    borrower.FirstName,
+		LastName:     borrower.LastName,
+	})
+	if err != nil {
+		return CreditReport{}, fmt.Errorf("credit bureau lookup failed: %v", err)
+	}
+
+	creditReport := CreditReport{
+		LoanID:        loanId,
+		MemberID:      result.(*Loan).MemberID,
+		Provider:      provider.Name(),
+		Reference:     report.Reference,
+		ListingStatus: report.ListingStatus,
+		Score:         report.Score,
+		Listings:      report.Listings,
+		RetrievedAt:   report.RetrievedAt,
+		RequestedByID: requestedById,
+	}
+
+	if err := m.Service.CreateEntity(&creditReport); err != nil {
+		return CreditReport{}, fmt.Errorf("failed to store credit report: %v", err)
+	}
+
+	return creditReport, nil
+}
+
+// GetLoanCreditReports returns the reports retrieved for the loan, newest first
+func (m *CreditReportModel) GetLoanCreditReports(loanId uint) ([]CreditReport, error) {
+	var reports []CreditReport
+
+	result, err := m.Service.GetOrderedEntitiesByField(&reports, "loan_id", loanId, "id desc", "RequestedBy")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get credit reports: %v", err)
+	}
+
+	return *result.(*[]CreditReport), nil
+}
+
+// LatestLoanCreditReport returns the newest report retrieved for the loan, or nil if the
+// bureau has not been asked yet
+func (m *CreditReportModel) LatestLoanCreditReport(loanId uint) (*CreditReport, error) {
+	reports, err := m.GetLoanCreditReports(loanId)
+	if err != nil || len(reports) == 0 {
+		return nil, err
+	}
+
+	return &reports[0], nil
+}
+
+// SubmissionBatch collects the performance of every disbursed loan as at asOf for the bureau
+func (m *CreditReportModel) SubmissionBatch(institutionCode string, asOf time.Time) (bureau.Batch, error) {
+	batch := bureau.Batch{InstitutionCode: institutionCode, AsOf: asOf, Accounts: []bureau.Account{}}
+	writeOffModel := NewWriteOffModel(m.Service)
+
+	for status, accountStatus := range submissionStatuses {
+		var loans []Loan
+		result, err := m.Service.GetAllEntititiesByFieldWithPreload(&loans, "status", status, "Member.User")
+		if err != nil {
+			return batch, fmt.Errorf("failed to get %s loans: %v", status, err)
+		}
+
+		for _, loan := range *result.(*[]Loan) {
+			// Loans paid out after the reporting day are left for the next submission
+			if loan.DisbursedAt == nil || DaysOverdue(*loan.DisbursedAt, asOf) < 0 {
+				continue
+			}
+
+			daysPastDue, err := writeOffModel.DaysPastDue(loan.ID, asOf)
+			if err != nil {
+				return batch, err
+			}
+
+			batch.Accounts = append(batch.Accounts, bureau.Account{
+				AccountNumber: fmt.Sprintf("L%08d", loan.ID),
+				MobileNumber:  loan.Member.User.MobileNumber,
+				FirstName:     loan.Member.User.FirstName,
+				LastName:      loan.Member.User.LastName,
+				DisbursedAt:   *loan.DisbursedAt,
+				DueDate:       loan.DueDate,
+				Amount:        loan.Amount,
+				Balance:       loan.RemainingBalance,
+				DaysPastDue:   daysPastDue,
+				Status:        accountStatus,
+			})
+		}
+	}
+
+	sort.Slice(batch.Accounts, func(i, j int) bool {
+		return batch.Accounts[i].AccountNumber < batch.Accounts[j].AccountNumber
+	})
+
+	return batch, nil
+}
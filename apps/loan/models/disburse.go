@@ -1,27 +1,47 @@
 package models
 
 import (
+	"errors"
 	"fmt"
+	"log"
 	"time"
 
+	"github.com/kifangamukundi/gm/loan/money"
 	"github.com/kifangamukundi/gm/loan/services"
 )
 
+const (
+	DisbursementStatusPending   = "pending"
+	DisbursementStatusCompleted = "completed"
+	DisbursementStatusFailed    = "failed"
+
+	// DisbursementStatusAbandoned is a payout an officer stopped waiting on, after checking
+	// M-Pesa, so its job could be retried. A result that still arrives for it is applied.
+	DisbursementStatusAbandoned = "abandoned"
+)
+
+// ErrDisbursementNotPending is returned when a disbursement's outcome has already been recorded
+var ErrDisbursementNotPending = errors.New("disbursement is not pending")
+
 type Disbursement struct {
-	ID                       uint       `gorm:"primaryKey"`
-	LoanID                   uint       `gorm:"index"` // Foreign key to Loan
-	Loan                     Loan       `gorm:"foreignKey:LoanID;constraint:onDelete:CASCADE"`
-	OriginatorConversationID string     `gorm:"uniqueIndex;not null"`       // Unique ID for request
-	ConversationID           string     `gorm:"index"`                      // M-Pesa conversation ID
-	TransactionID            string     `gorm:"index"`                      // M-Pesa transaction ID
-	ResponseCode             string     `gorm:"not null"`                   // Response code from M-Pesa
-	ResponseDesc             string     `gorm:"not null"`                   // Response description
-	Status                   string     `gorm:"not null;default:'pending'"` // pending, processing, completed, failed
-	DisbursedAt              *time.Time `gorm:""`                           // Nullable until processed
-	OfficerID                *uint      `gorm:"index;default:NULL"`         // Optional (for manual processing)
-	Officer                  *Officer   `gorm:"foreignKey:OfficerID;constraint:onDelete:SET NULL"`
-	CreatedAt                time.Time  `gorm:"not null"`
-	UpdatedAt                time.Time  `gorm:"not null"`
+	ID                       uint         `gorm:"primaryKey"`
+	LoanID                   uint         `gorm:"index"` // Foreign key to Loan
+	Loan                     Loan         `gorm:"foreignKey:LoanID;constraint:onDelete:CASCADE"`
+	JobID                    *uint        `gorm:"index;default:null"`         // Queued payout this was an attempt of, unset before the queue
+	Amount                   money.Amount `gorm:"not null;default:0"`         // Amount sent to the borrower
+	OriginatorConversationID string       `gorm:"uniqueIndex;not null"`       // Unique ID for request
+	ConversationID           string       `gorm:"index"`                      // M-Pesa conversation ID
+	TransactionID            string       `gorm:"index"`                      // M-Pesa transaction ID
+	ResponseCode             string       `gorm:"not null"`                   // Response code from M-Pesa
+	ResponseDesc             string       `gorm:"not null"`                   // Response description
+	ResultCode               *int         `gorm:"default:null"`               // Result code from the B2C callback
+	ResultDesc               string       `gorm:""`                           // Result description from the B2C callback
+	Status                   string       `gorm:"not null;default:'pending'"` // pending, completed, failed, abandoned
+	DisbursedAt              *time.Time   `gorm:""`                           // Nullable until processed
+	OfficerID                *uint        `gorm:"index;default:NULL"`         // Optional (for manual processing)
+	Officer                  *Officer     `gorm:"foreignKey:OfficerID;constraint:onDelete:SET NULL"`
+	CreatedAt                time.Time    `gorm:"not null"`
+	UpdatedAt                time.Time    `gorm:"not null"`
 }
 
 type DisburseModel struct {
@@ -39,3 +59,56 @@ func (m *DisburseModel) CreateDisbursement(disbursement *Disbursement) error {
 
 	return nil
 }
+
+func (m *DisburseModel) GetDisbursementByField(field, value string) (*Disbursement, error) {
+	var disbursement Disbursement
+
+	result, err := m.Service.GetEntityByField(field, value, &disbursement)
+	if err != nil {
+		log.Printf("Error fetching disbursement by %s: %v", field, err)
+		return nil, err
+	}
+
+	return result.(*Disbursement), nil
+}
+
+// IsAwaitingResult reports whether the disbursement's B2C result has not been applied yet
+func (d *Disbursement) IsAwaitingResult() bool {
+	return d.Status == DisbursementStatusPending || d.Status == DisbursementStatusAbandoned
+}
+
+// FinalizeDisbursement stores the outcome reported by the B2C result callback. Only a pending
+// or abandoned disbursement can be finalized, so a replayed callback fails with
+// ErrDisbursementNotPending.
+func (m *DisburseModel) FinalizeDisbursement(id uint, status, transactionID string, resultCode int, resultDesc string) (Disbursement, error) {
+	disbursement := &Disbursement{ID: id}
+
+	_, err := m.Service.GetEntityByID(disbursement, id)
+	if err != nil {
+		return Disbursement{}, fmt.Errorf("disbursement not found: %v", err)
+	}
+	if !disbursement.IsAwaitingResult() {
+		return Disbursement{}, fmt.Errorf("%w: it is %s", ErrDisbursementNotPending, disbursement.Status)
+	}
+	from := disbursement.Status
+
+	disbursement.Status = status
+	disbursement.TransactionID = transactionID
+	disbursement.ResultCode = &resultCode
+	disbursement.ResultDesc = resultDesc
+
+	if status == DisbursementStatusCompleted {
+		now := time.Now()
+		disbursement.DisbursedAt = &now
+	}
+
+	updated, err := m.Service.UpdateEntityIf(disbursement, map[string]interface{}{"status": from})
+	if err != nil {
+		return Disbursement{}, fmt.Errorf("failed to update disbursement: %v", err)
+	}
+	if !updated {
+		return Disbursement{}, fmt.Errorf("%w: it was finalized by another request", ErrDisbursementNotPending)
+	}
+
+	return *disbursement, nil
+}
@@ -0,0 +1,407 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/kifangamukundi/gm/loan/money"
+	"github.com/kifangamukundi/gm/loan/services"
+)
+
+const (
+	DisbursementJobQueued     = "queued"
+	DisbursementJobProcessing = "processing"
+	DisbursementJobSubmitted  = "submitted"
+	DisbursementJobCompleted  = "completed"
+	DisbursementJobDead       = "dead"
+)
+
+const (
+	// DisbursementMaxAttempts is how many payouts are tried before a job is dead-lettered
+	DisbursementMaxAttempts = 5
+
+	// DisbursementBaseBackoff is the wait after the first failed attempt. It doubles with
+	// every attempt after that.
+	DisbursementBaseBackoff = time.Minute
+
+	// DisbursementLease is how long a worker may hold a job. A job still processing after
+	// that was abandoned part way, e.g. by a restart.
+	DisbursementLease = 5 * time.Minute
+
+	// DisbursementResultTimeout is how long a submitted job waits for its B2C result before it
+	// is dead-lettered for reconciliation
+	DisbursementResultTimeout = time.Hour
+)
+
+// ErrDisbursementNotRetryable is returned when a manual retry is asked for a loan whose
+// latest disbursement job is still in the queue or has already gone through, or whose last
+// payout is still waiting for its result.
+var ErrDisbursementNotRetryable = errors.New("disbursement is not dead-lettered")
+
+// DisbursementJob is a queued B2C payout for an approved loan. Approval only enqueues the
+// job, a worker submits it to M-Pesa and the B2C callback completes it. Failed attempts are
+// retried with exponential backoff until MaxAttempts, after which the job is dead and an
+// officer has to retry it by hand.
+type DisbursementJob struct {
+	ID             uint          `gorm:"primaryKey"`
+	LoanID         uint          `gorm:"index"` // Foreign key to Loan
+	Loan           Loan          `gorm:"foreignKey:LoanID;constraint:onDelete:CASCADE"`
+	OfficerID      *uint         `gorm:"index;default:null"` // Officer who approved the loan
+	Officer        *Officer      `gorm:"foreignKey:OfficerID;constraint:onDelete:SET NULL"`
+	ActorID        *uint         `gorm:"default:null"`                    // User recorded against the loan's status changes
+	PhoneNumber    uint64        `gorm:"not null"`                        // Borrower's M-Pesa number
+	Amount         money.Amount  `gorm:"not null;default:0"`              // Amount to send
+	Status         string        `gorm:"not null;default:'queued';index"` // queued, processing, submitted, completed, dead
+	Attempts       int           `gorm:"not null;default:0"`
+	MaxAttempts    int           `gorm:"not null;default:5"`
+	NextAttemptAt  time.Time     `gorm:"not null;index"` // When a queued job is due, a processing job's lease runs out or a submitted job stops waiting for its result
+	LastError      string        `gorm:""`
+	DisbursementID *uint         `gorm:"index;default:null"` // Latest attempt M-Pesa accepted, every attempt keeps its own JobID
+	Disbursement   *Disbursement `gorm:"foreignKey:DisbursementID;constraint:onDelete:SET NULL"`
+	CreatedAt      time.Time     `gorm:"not null"`
+	UpdatedAt      time.Time     `gorm:"not null"`
+}
+
+type DisbursementJobModel struct {
+	Service services.Service
+}
+
+func NewDisbursementJobModel(service services.Service) *DisbursementJobModel {
+	return &DisbursementJobModel{Service: service}
+}
+
+// DisbursementBackoff returns how long to wait before the next attempt after the given
+// number of failed attempts.
+func DisbursementBackoff(attempts int) time.Duration {
+	if attempts < 1 {
+		attempts = 1
+	}
+	return DisbursementBaseBackoff << (attempts - 1)
+}
+
+// EnqueueDisbursement queues a payout for the loan that is due straight away.
+func (m *DisbursementJobModel) EnqueueDisbursement(loan *Loan, officerId, actorId *uint, phoneNumber uint64) (DisbursementJob, error) {
+	amount, err := NewRefinanceModel(m.Service).NetDisbursement(loan, time.Now())
+	if err != nil {
+		return DisbursementJob{}, err
+	}
+
+	job := DisbursementJob{
+		LoanID:        loan.ID,
+		OfficerID:     officerId,
+		ActorID:       actorId,
+		PhoneNumber:   phoneNumber,
+		Amount:        amount,
+		Status:        DisbursementJobQueued,
+		MaxAttempts:   DisbursementMaxAttempts,
+		NextAttemptAt: time.Now(),
+	}
+
+	if err := m.Service.CreateEntity(&job); err != nil {
+		return DisbursementJob{}, fmt.Errorf("failed to enqueue disbursement: %v", err)
+	}
+
+	return job, nil
+}
+
+func (m *DisbursementJobModel) GetDisbursementJobByField(field, value string) (*DisbursementJob, error) {
+	var job DisbursementJob
+
+	result, err := m.Service.GetEntityByField(field, value, &job)
+	if err != nil {
+		log.Printf("Error fetching disbursement job by %s: %v", field, err)
+		return nil, err
+	}
+
+	return result.(*DisbursementJob), nil
+}
+
+// GetLoanDisbursementJobs returns every disbursement job for the loan, oldest first.
+func (m *DisbursementJobModel) GetLoanDisbursementJobs(loanId uint) ([]DisbursementJob, error) {
+	var jobs []DisbursementJob
+
+	result, err := m.Service.GetOrderedEntitiesByField(&jobs, "loan_id", loanId, "id asc", "Disbursement")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get disbursement jobs: %v", err)
+	}
+
+	return *result.(*[]DisbursementJob), nil
+}
+
+// GetDueDisbursementJobs returns the jobs in status whose next attempt is due by asOf.
+func (m *DisbursementJobModel) GetDueDisbursementJobs(status string, asOf time.Time) ([]DisbursementJob, error) {
+	var jobs []DisbursementJob
+
+	result, err := m.Service.GetDueDisbursementJobs(&jobs, status, asOf)
+	if err != nil {
+		return nil, err
+	}
+
+	jobsPtr, ok := result.(*[]DisbursementJob)
+	if !ok {
+		return nil, fmt.Errorf("unexpected result type: %T", result)
+	}
+
+	return *jobsPtr, nil
+}
+
+// StartDisbursementJob claims a queued job for one attempt and moves its loan to disbursing.
+// It returns nil when the job was claimed by another worker first, or when the loan is no
+// longer approved, in which case the job is dead-lettered.
+func (m *DisbursementJobModel) StartDisbursementJob(id uint, now time.Time) (*DisbursementJob, error) {
+	job := &DisbursementJob{ID: id}
+	claimed := false
+
+	err := m.Service.WithTransaction(func(tx services.Service) error {
+		if _, err := tx.GetEntityByID(job, id); err != nil {
+			return fmt.Errorf("disbursement job not found: %v", err)
+		}
+		if job.Status != DisbursementJobQueued {
+			return nil
+		}
+
+		loan := &Loan{ID: job.LoanID}
+		if _, err := tx.GetEntityByID(loan, job.LoanID); err != nil {
+			return fmt.Errorf("loan not found: %v", err)
+		}
+
+		if loan.Status != LoanStatusApproved {
+			job.Status = DisbursementJobDead
+			job.LastError = fmt.Sprintf("Loan is %s and can no longer be disbursed", loan.Status)
+			_, err := tx.UpdateEntityIf(job, map[string]interface{}{"status": DisbursementJobQueued})
+			return err
+		}
+
+		job.Status = DisbursementJobProcessing
+		job.Attempts++
+		job.NextAttemptAt = now.Add(DisbursementLease)
+
+		updated, err := tx.UpdateEntityIf(job, map[string]interface{}{"status": DisbursementJobQueued})
+		if err != nil || !updated {
+			return err
+		}
+
+		reason := fmt.Sprintf("B2C payout attempt %d of %d", job.Attempts, job.MaxAttempts)
+		if _, err := NewLoanModel(tx).TransitionLoan(job.LoanID, LoanStatusDisbursing, job.ActorID, reason, nil); err != nil {
+			return err
+		}
+
+		claimed = true
+		return nil
+	})
+	if err != nil || !claimed {
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// SubmitDisbursementJob records the disbursement M-Pesa accepted for a processing job. The
+// job stays submitted until the B2C callback reports the outcome, or until
+// DisbursementResultTimeout has passed.
+func (m *DisbursementJobModel) SubmitDisbursementJob(id uint, disbursement *Disbursement, now time.Time) (DisbursementJob, error) {
+	job := &DisbursementJob{ID: id}
+
+	err := m.Service.WithTransaction(func(tx services.Service) error {
+		if _, err := tx.GetEntityByID(job, id); err != nil {
+			return fmt.Errorf("disbursement job not found: %v", err)
+		}
+
+		disbursement.JobID = &job.ID
+		if err := NewDisburseModel(tx).CreateDisbursement(disbursement); err != nil {
+			return err
+		}
+
+		// A late result for an earlier attempt completed the job while this one was being sent.
+		// The payout is kept so its result is still recorded, against the job it duplicates.
+		if job.Status == DisbursementJobCompleted {
+			log.Printf("Disbursement %s for loan %d was sent after job %d completed", disbursement.OriginatorConversationID, job.LoanID, job.ID)
+			return nil
+		}
+
+		job.Status = DisbursementJobSubmitted
+		job.DisbursementID = &disbursement.ID
+		job.NextAttemptAt = now.Add(DisbursementResultTimeout)
+		job.LastError = ""
+
+		updated, err := tx.UpdateEntityIf(job, map[string]interface{}{"status": DisbursementJobProcessing})
+		if err != nil {
+			return err
+		}
+		if !updated {
+			return fmt.Errorf("disbursement job %d is no longer processing", id)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return DisbursementJob{}, err
+	}
+
+	return *job, nil
+}
+
+// FailDisbursementJob records a failed attempt on a processing or submitted job and puts its
+// loan back to approved. The job is queued again after DisbursementBackoff, or dead-lettered
+// once it has used up its attempts or when deadLetter is set.
+func (m *DisbursementJobModel) FailDisbursementJob(id uint, reason string, now time.Time, deadLetter bool) (DisbursementJob, error) {
+	job := &DisbursementJob{ID: id}
+
+	err := m.Service.WithTransaction(func(tx services.Service) error {
+		if _, err := tx.GetEntityByID(job, id); err != nil {
+			return fmt.Errorf("disbursement job not found: %v", err)
+		}
+
+		from := job.Status
+		if from != DisbursementJobProcessing && from != DisbursementJobSubmitted {
+			return fmt.Errorf("disbursement job %d is %s and cannot fail", id, from)
+		}
+
+		job.LastError = reason
+		if deadLetter || job.Attempts >= job.MaxAttempts {
+			job.Status = DisbursementJobDead
+		} else {
+			job.Status = DisbursementJobQueued
+			job.NextAttemptAt = now.Add(DisbursementBackoff(job.Attempts))
+		}
+
+		updated, err := tx.UpdateEntityIf(job, map[string]interface{}{"status": from})
+		if err != nil {
+			return err
+		}
+		if !updated {
+			return fmt.Errorf("disbursement job %d was changed by another request", id)
+		}
+
+		_, err = NewLoanModel(tx).TransitionLoan(job.LoanID, LoanStatusApproved, nil, "B2C payout failed: "+reason, nil)
+		return err
+	})
+	if err != nil {
+		return DisbursementJob{}, err
+	}
+
+	return *job, nil
+}
+
+// GetDisbursementJob returns the job the disbursement was an attempt of, or nil for a
+// disbursement made before payouts were queued.
+func (m *DisbursementJobModel) GetDisbursementJob(disbursement Disbursement) (*DisbursementJob, error) {
+	if disbursement.JobID != nil {
+		job := &DisbursementJob{ID: *disbursement.JobID}
+		if _, err := m.Service.GetEntityByID(job, *disbursement.JobID); err != nil {
+			return nil, fmt.Errorf("disbursement job not found: %v", err)
+		}
+		return job, nil
+	}
+
+	// Attempts submitted before disbursements kept their job are only linked from the job
+	jobs, err := m.Service.GetEntitiesByFields(&[]DisbursementJob{}, map[string]interface{}{"disbursement_id": disbursement.ID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get disbursement job: %v", err)
+	}
+	linked := *jobs.(*[]DisbursementJob)
+	if len(linked) == 0 {
+		return nil, nil
+	}
+
+	return &linked[0], nil
+}
+
+// CompleteDisbursementJob marks the job as completed by the given disbursement. That includes
+// a job dead-lettered before the payout's result arrived, and a job queued again or being
+// retried after an earlier attempt was abandoned, which the late result cancels.
+func (m *DisbursementJobModel) CompleteDisbursementJob(job *DisbursementJob, disbursementId uint) error {
+	job.Status = DisbursementJobCompleted
+	job.DisbursementID = &disbursementId
+	job.Disbursement = nil
+
+	conditions := map[string]interface{}{"status": []string{DisbursementJobQueued, DisbursementJobProcessing, DisbursementJobSubmitted, DisbursementJobDead}}
+	updated, err := m.Service.UpdateEntityColumns(job, conditions, "status", "disbursement_id", "updated_at")
+	if err != nil {
+		return fmt.Errorf("failed to complete disbursement job: %v", err)
+	}
+	if !updated {
+		return fmt.Errorf("disbursement job %d was changed by another request", job.ID)
+	}
+
+	return nil
+}
+
+// RetryDisbursementJob puts the loan's dead-lettered disbursement job back in the queue
+// with a fresh set of attempts. The job's earlier payouts stay linked to it. One still
+// waiting for its result may have reached the borrower, so the retry is refused unless
+// abandonPending confirms an officer has checked M-Pesa, in which case those payouts are
+// abandoned. A result that still arrives for one of them completes the job instead.
+func (m *DisbursementJobModel) RetryDisbursementJob(loanId uint, actorId *uint, abandonPending bool, now time.Time) (DisbursementJob, error) {
+	var job DisbursementJob
+
+	err := m.Service.WithTransaction(func(tx services.Service) error {
+		jobs, err := NewDisbursementJobModel(tx).GetLoanDisbursementJobs(loanId)
+		if err != nil {
+			return err
+		}
+		if len(jobs) == 0 {
+			return fmt.Errorf("%w: loan %d has no disbursement jobs", ErrDisbursementNotRetryable, loanId)
+		}
+
+		job = jobs[len(jobs)-1]
+		if job.Status != DisbursementJobDead {
+			return fmt.Errorf("%w: latest job is %s", ErrDisbursementNotRetryable, job.Status)
+		}
+
+		result, err := tx.GetEntitiesByFields(&[]Disbursement{}, map[string]interface{}{"job_id": job.ID, "status": DisbursementStatusPending})
+		if err != nil {
+			return fmt.Errorf("failed to get disbursements: %v", err)
+		}
+		for _, pending := range *result.(*[]Disbursement) {
+			if !abandonPending {
+				return fmt.Errorf("%w: disbursement %d is still waiting for its B2C result, check M-Pesa before retrying", ErrDisbursementNotRetryable, pending.ID)
+			}
+
+			pending.Status = DisbursementStatusAbandoned
+			updated, err := tx.UpdateEntityColumns(&pending, map[string]interface{}{"status": DisbursementStatusPending}, "status", "updated_at")
+			if err != nil {
+				return fmt.Errorf("failed to abandon disbursement: %v", err)
+			}
+			if !updated {
+				return fmt.Errorf("%w: disbursement %d was finalized by another request", ErrDisbursementNotRetryable, pending.ID)
+			}
+		}
+
+		// What a top-up settles may have changed since the payout was queued
+		loan := &Loan{ID: loanId}
+		if _, err := tx.GetEntityByID(loan, loanId); err != nil {
+			return fmt.Errorf("loan not found: %v", err)
+		}
+		amount, err := NewRefinanceModel(tx).NetDisbursement(loan, now)
+		if err != nil {
+			return err
+		}
+
+		job.Disbursement = nil
+		job.Amount = amount
+		job.Status = DisbursementJobQueued
+		job.Attempts = 0
+		job.NextAttemptAt = now
+		if actorId != nil {
+			job.ActorID = actorId
+		}
+
+		updated, err := tx.UpdateEntityIf(&job, map[string]interface{}{"status": DisbursementJobDead})
+		if err != nil {
+			return fmt.Errorf("failed to retry disbursement job: %v", err)
+		}
+		if !updated {
+			return fmt.Errorf("%w: job was changed by another request", ErrDisbursementNotRetryable)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return DisbursementJob{}, err
+	}
+
+	return job, nil
+}
@@ -0,0 +1,180 @@
+package models
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/kifangamukundi/gm/loan/money"
+	"github.com/kifangamukundi/gm/loan/services"
+)
+
+// Points making up a member's credit score, out of 100
+const (
+	scoreOnTimePoints   = 60 // Scaled by the share of instalments paid on time
+	scoreCyclePoints    = 10 // Per repaid loan, up to scoreMaxCyclePoints
+	scoreMaxCyclePoints = 30
+	scoreArrearsPoints  = 10 // Never late, halved when never more than scoreArrearsGraceDays late
+)
+
+// scoreArrearsGraceDays is how late a member may have been and still earn half the arrears points
+const scoreArrearsGraceDays = 30
+
+// CreditScore summarises a member's repayment history on their disbursed loans
+type CreditScore struct {
+	MemberID         uint
+	Score            int     // 0 to 100, 0 for members without instalments due yet
+	OnTimeRate       float64 // Share of due instalments cleared by their due date, 0 to 1
+	InstalmentsDue   int
+	MaxDaysInArrears int // Longest any instalment went unpaid past its due date
+	CompletedCycles  int // Loans repaid in full
+	OpenLoans        int
+	LoansInArrears   int // Open loans in arrears or defaulted
+}
+
+// Eligibility is whether a member may take a loan of a given amount on a product, with the
+// reasons when they may not
+type Eligibility struct {
+	CreditScore
+	Amount         money.Amount
+	MaxAmount      money.Amount // Largest loan the product's ladder and savings multiplier allow the member
+	LadderLimit    money.Amount
+	SavingsBalance money.Amount // Savings the member may withdraw, counted when the product uses a multiplier
+	SavingsLimit   money.Amount // SavingsBalance times the product's multiplier, 0 when it has none
+	Eligible       bool
+	Reasons        []string
+}
+
+// openLoanStatuses are the statuses of loans the member still owes on
+var openLoanStatuses = map[string]bool{
+	LoanStatusActive:    true,
+	LoanStatusInArrears: true,
+	LoanStatusDefaulted: true,
+}
+
+type EligibilityModel struct {
+	Service services.Service
+}
+
+func NewEligibilityModel(service services.Service) *EligibilityModel {
+	return &EligibilityModel{Service: service}
+}
+
+// ScoreMember builds the member's credit score from their loans and the instalments their
+// payments cleared, as at asOf.
+func (m *EligibilityModel) ScoreMember(memberId uint, asOf time.Time) (CreditScore, error) {
+	score := CreditScore{MemberID: memberId}
+
+	var loans []Loan
+	result, err := m.Service.GetEntitiesByFields(&loans, map[string]interface{}{"member_id": memberId})
+	if err != nil {
+		return score, fmt.Errorf("failed to get member loans: %v", err)
+	}
+
+	scheduleModel := NewScheduleModel(m.Service)
+	onTime := 0
+
+	for _, loan := range *result.(*[]Loan) {
+		switch {
+		case loan.Status == LoanStatusClosed && loan.IsFullyPaid:
+			score.CompletedCycles++
+		case openLoanStatuses[loan.Status]:
+			score.OpenLoans++
+			if loan.Status != LoanStatusActive {
+				score.LoansInArrears++
+			}
+		}
+
+		if loan.DisbursedAt == nil {
+			continue
+		}
+
+		instalments, err := scheduleModel.GetLoanSchedule(loan.ID)
+		if err != nil {
+			return score, err
+		}
+
+		for _, instalment := range instalments {
+			if instalment.Status == InstalmentStatusRestructured {
+				continue
+			}
+
+			// Instalments not yet due only count once they have been paid
+			var late int
+			switch {
+			case instalment.PaidAt != nil:
+				late = DaysOverdue(instalment.DueDate, *instalment.PaidAt)
+			case DaysOverdue(instalment.DueDate, asOf) > 0:
+				late = DaysOverdue(instalment.DueDate, asOf)
+			default:
+				continue
+			}
+
+			score.InstalmentsDue++
+			if late <= 0 {
+				onTime++
+			} else if late > score.MaxDaysInArrears {
+				score.MaxDaysInArrears = late
+			}
+		}
+	}
+
+	if score.InstalmentsDue == 0 {
+		return score, nil
+	}
+
+	score.OnTimeRate = float64(onTime) / float64(score.InstalmentsDue)
+
+	points := int(math.Round(score.OnTimeRate * scoreOnTimePoints))
+	points += min(score.CompletedCycles*scoreCyclePoints, scoreMaxCyclePoints)
+	switch {
+	case score.MaxDaysInArrears == 0:
+		points += scoreArrearsPoints
+	case score.MaxDaysInArrears <= scoreArrearsGraceDays:
+		points += scoreArrearsPoints / 2
+	}
+	score.Score = points
+
+	return score, nil
+}
+
+// CheckEligibility decides whether the member may borrow amount on the product, given their
+// open loans in arrears, the product's ladder and its savings multiplier.
+func (m *EligibilityModel) CheckEligibility(memberId uint, product *LoanProduct, amount money.Amount, asOf time.Time) (Eligibility, error) {
+	score, err := m.ScoreMember(memberId, asOf)
+	if err != nil {
+		return Eligibility{}, err
+	}
+
+	eligibility := Eligibility{
+		CreditScore: score,
+		Amount:      amount,
+		LadderLimit: product.LadderLimit(score.CompletedCycles),
+		Reasons:     []string{},
+	}
+	eligibility.MaxAmount = eligibility.LadderLimit
+
+	if product.SavingsMultiplier > 0 {
+		savings, err := NewSavingsModel(m.Service).GetSavingsBalance(memberId)
+		if err != nil {
+			return Eligibility{}, err
+		}
+		eligibility.SavingsBalance = savings.Available
+		eligibility.SavingsLimit = savings.Available.Mul(product.SavingsMultiplier)
+		eligibility.MaxAmount = money.Min(eligibility.MaxAmount, eligibility.SavingsLimit)
+	}
+
+	if score.LoansInArrears > 0 {
+		eligibility.Reasons = append(eligibility.Reasons, fmt.Sprintf("member has %d open loan(s) in arrears", score.LoansInArrears))
+	}
+	if amount > eligibility.LadderLimit {
+		eligibility.Reasons = append(eligibility.Reasons, fmt.Sprintf("%s is more than the %s allowed after %d repaid loan(s) on %s", amount, eligibility.LadderLimit, score.CompletedCycles, product.ProductName))
+	}
+	if product.SavingsMultiplier > 0 && amount > eligibility.SavingsLimit {
+		eligibility.Reasons = append(eligibility.Reasons, fmt.Sprintf("%s is more than %g times the member's %s of savings", amount, product.SavingsMultiplier, eligibility.SavingsBalance))
+	}
+
+	eligibility.Eligible = len(eligibility.Reasons) == 0
+
+	return eligibility, nil
+}
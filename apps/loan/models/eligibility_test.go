@@ -0,0 +1,81 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScoreMember(t *testing.T) {
+	service := newTestService(t, &Loan{}, &Instalment{})
+
+	day := func(month time.Month, d int) time.Time { return time.Date(2026, month, d, 0, 0, 0, 0, time.UTC) }
+	at := func(d time.Time) *time.Time { return &d }
+	purpose := "Stock for the shop"
+
+	// Member 1 repaid one loan, once late, and is 20 days behind on a second. Member 3 repaid
+	// a loan on time and member 2 has never borrowed.
+	loans := []struct {
+		loan        Loan
+		instalments []Instalment
+	}{
+		{
+			loan: Loan{MemberID: 1, Status: LoanStatusClosed, IsFullyPaid: true, DisbursedAt: at(day(1, 1))},
+			instalments: []Instalment{
+				{Number: 1, DueDate: day(2, 1), PaidAt: at(day(1, 30)), Status: InstalmentStatusPaid},
+				{Number: 2, DueDate: day(3, 1), PaidAt: at(day(3, 11)), Status: InstalmentStatusPaid},
+			},
+		},
+		{
+			loan: Loan{MemberID: 1, Status: LoanStatusInArrears, DisbursedAt: at(day(2, 1))},
+			instalments: []Instalment{
+				{Number: 1, DueDate: day(3, 1)},
+				{Number: 2, DueDate: day(4, 1)},
+				{Number: 3, DueDate: day(2, 15), Status: InstalmentStatusRestructured},
+			},
+		},
+		{loan: Loan{MemberID: 1, Status: LoanStatusPending}},
+		{
+			loan: Loan{MemberID: 3, Status: LoanStatusClosed, IsFullyPaid: true, DisbursedAt: at(day(1, 1))},
+			instalments: []Instalment{
+				{Number: 1, DueDate: day(2, 1), PaidAt: at(day(2, 1)), Status: InstalmentStatusPaid},
+			},
+		},
+	}
+	for _, fixture := range loans {
+		loan := fixture.loan
+		loan.LoanPurpose = &purpose
+		if err := service.CreateEntity(&loan); err != nil {
+			t.Fatalf("failed to create loan: %v", err)
+		}
+		for _, instalment := range fixture.instalments {
+			instalment.LoanID = loan.ID
+			if err := service.CreateEntity(&instalment); err != nil {
+				t.Fatalf("failed to create instalment: %v", err)
+			}
+		}
+	}
+
+	tests := []struct {
+		memberId uint
+		want     CreditScore
+	}{
+		{
+			memberId: 1,
+			want: CreditScore{MemberID: 1, Score: 35, OnTimeRate: 1.0 / 3, InstalmentsDue: 3, MaxDaysInArrears: 20,
+				CompletedCycles: 1, OpenLoans: 1, LoansInArrears: 1},
+		},
+		{memberId: 2, want: CreditScore{MemberID: 2}},
+		{memberId: 3, want: CreditScore{MemberID: 3, Score: 80, OnTimeRate: 1, InstalmentsDue: 1, CompletedCycles: 1}},
+	}
+
+	model := NewEligibilityModel(service)
+	for _, tt := range tests {
+		got, err := model.ScoreMember(tt.memberId, day(3, 21))
+		if err != nil {
+			t.Fatalf("ScoreMember(%d) returned %v", tt.memberId, err)
+		}
+		if got != tt.want {
+			t.Errorf("ScoreMember(%d) = %+v, want %+v", tt.memberId, got, tt.want)
+		}
+	}
+}
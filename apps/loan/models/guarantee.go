@@ -0,0 +1,388 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/kifangamukundi/gm/loan/money"
+	"github.com/kifangamukundi/gm/loan/services"
+)
+
+const (
+	GuaranteeStatusPending  = "pending"
+	GuaranteeStatusAccepted = "accepted"
+	GuaranteeStatusDeclined = "declined"
+	GuaranteeStatusCalled   = "called" // Recovery from the guarantor has started
+
+	PledgeTypeCash    = "cash"    // The guarantor will pay up to Amount
+	PledgeTypeSavings = "savings" // Amount is held from the guarantor's savings
+)
+
+var (
+	ErrNotGuarantor         = errors.New("member cannot guarantee this loan")
+	ErrNotOwnGuarantee      = errors.New("only the guarantor can respond to a guarantee")
+	ErrGuaranteeNotPending  = errors.New("guarantee is not pending")
+	ErrGuaranteesPending    = errors.New("guarantees have not all been accepted")
+	ErrGuaranteeNotCallable = errors.New("guarantee cannot be called")
+)
+
+// LoanGuarantee is a pledge by another member of the borrower's group to cover up to Amount
+// of a loan once it defaults.
+type LoanGuarantee struct {
+	ID          uint         `gorm:"primaryKey"`
+	LoanID      uint         `gorm:"index;uniqueIndex:idx_loan_guarantor"` // Foreign key to Loan
+	Loan        Loan         `gorm:"foreignKey:LoanID;constraint:onDelete:CASCADE"`
+	GuarantorID uint         `gorm:"index;uniqueIndex:idx_loan_guarantor"` // Member pledging
+	Guarantor   Member       `gorm:"foreignKey:GuarantorID;constraint:onDelete:RESTRICT"`
+	PledgeType  string       `gorm:"not null;default:'cash'"` // cash, savings
+	Amount      money.Amount `gorm:"not null"`                // Most that can be recovered from the guarantor
+	Status      string       `gorm:"not null;default:'pending';index"`
+	Recovered   money.Amount `gorm:"not null;default:0"` // Collected from the guarantor so far
+
+	CreatedByID   uint       `gorm:"index"`
+	CreatedBy     User       `gorm:"foreignKey:CreatedByID;constraint:onDelete:RESTRICT"`
+	RespondedAt   *time.Time `gorm:"default:null"`
+	DeclineReason string     `gorm:"not null;default:''"`
+
+	Recoveries []GuaranteeRecovery `gorm:"foreignKey:GuaranteeID"`
+
+	CreatedAt time.Time `gorm:"not null"`
+	UpdatedAt time.Time `gorm:"not null"`
+}
+
+// GuaranteeRecovery is money collected from a guarantor and applied to the loan they guaranteed
+type GuaranteeRecovery struct {
+	ID           uint         `gorm:"primaryKey"`
+	GuaranteeID  uint         `gorm:"index"`
+	LoanID       uint         `gorm:"index"`
+	Amount       money.Amount `gorm:"not null"`
+	PaymentID    uint         `gorm:"uniqueIndex"` // Payment the amount was applied to the loan as
+	Payment      Payment      `gorm:"foreignKey:PaymentID;constraint:onDelete:CASCADE"`
+	Reference    string       `gorm:"not null;default:''"`
+	RecordedByID uint         `gorm:"index"`
+	RecordedBy   User         `gorm:"foreignKey:RecordedByID;constraint:onDelete:RESTRICT"`
+	RecoveredAt  time.Time    `gorm:"not null"`
+
+	CreatedAt time.Time `gorm:"not null"`
+}
+
+// Remaining is what can still be recovered from the guarantor
+func (g *LoanGuarantee) Remaining() money.Amount {
+	return g.Amount - g.Recovered
+}
+
+type GuaranteeModel struct {
+	Service services.Service
+}
+
+func NewGuaranteeModel(service services.Service) *GuaranteeModel {
+	return &GuaranteeModel{Service: service}
+}
+
+// checkSavingsPledge fails with ErrInsufficientSavings when the guarantor's savings not
+// already held by other pledges do not cover a savings pledge
+func checkSavingsPledge(service services.Service, guarantee *LoanGuarantee) error {
+	balance, err := NewSavingsModel(service).GetSavingsBalance(guarantee.GuarantorID)
+	if err != nil {
+		return err
+	}
+	if guarantee.Amount > balance.Available {
+		return fmt.Errorf("%w: guarantor has %s available, %s is held by other pledges", ErrInsufficientSavings, balance.Available, balance.Held)
+	}
+	return nil
+}
+
+// AddGuarantee records a pledge on a pending loan for the guarantor to accept. Guarantors
+// must belong to the loan's group, cannot be the borrower and may pledge once per loan.
+func (m *GuaranteeModel) AddGuarantee(guarantee *LoanGuarantee) error {
+	loan := &Loan{ID: guarantee.LoanID}
+	if _, err := m.Service.GetEntityByID(loan, guarantee.LoanID); err != nil {
+		return fmt.Errorf("loan not found: %v", err)
+	}
+	if loan.Status != LoanStatusPending {
+		return fmt.Errorf("%w: loan is %s", ErrIllegalTransition, loan.Status)
+	}
+
+	if guarantee.GuarantorID == loan.MemberID {
+		return fmt.Errorf("%w: borrowers cannot guarantee their own loan", ErrNotGuarantor)
+	}
+	if guarantee.Amount <= 0 || guarantee.Amount > loan.Amount {
+		return fmt.Errorf("%w: pledges must be between 0 and %s", ErrNotGuarantor, loan.Amount)
+	}
+
+	var guarantor Member
+	result, err := m.Service.GetEntityByFieldWithPreload(&guarantor, "id", fmt.Sprintf("%d", guarantee.GuarantorID), "Groups")
+	if err != nil {
+		return fmt.Errorf("guarantor not found: %v", err)
+	}
+
+	inGroup := false
+	for _, group := range result.(*Member).Groups {
+		if group.ID == loan.GroupID {
+			inGroup = true
+			break
+		}
+	}
+	if !inGroup {
+		return fmt.Errorf("%w: guarantors must be members of the borrower's group", ErrNotGuarantor)
+	}
+
+	existing, err := m.Service.CountEntities(&LoanGuarantee{}, map[string]interface{}{"loan_id": loan.ID, "guarantor_id": guarantee.GuarantorID})
+	if err != nil {
+		return err
+	}
+	if existing > 0 {
+		return fmt.Errorf("%w: member %d already guarantees loan %d", ErrNotGuarantor, guarantee.GuarantorID, loan.ID)
+	}
+
+	if guarantee.PledgeType == "" {
+		guarantee.PledgeType = PledgeTypeCash
+	}
+	if guarantee.PledgeType == PledgeTypeSavings {
+		if err := checkSavingsPledge(m.Service, guarantee); err != nil {
+			return err
+		}
+	}
+	guarantee.Status = GuaranteeStatusPending
+
+	if err := m.Service.CreateEntity(guarantee); err != nil {
+		return fmt.Errorf("failed to create guarantee: %v", err)
+	}
+
+	return nil
+}
+
+func (m *GuaranteeModel) GetGuaranteeByField(field, value string) (*LoanGuarantee, error) {
+	var guarantee LoanGuarantee
+
+	result, err := m.Service.GetEntityByFieldWithPreload(&guarantee, field, value, "Guarantor.User", "CreatedBy", "Recoveries")
+	if err != nil {
+		log.Printf("Error fetching guarantee by %s: %v", field, err)
+		return nil, err
+	}
+
+	return result.(*LoanGuarantee), nil
+}
+
+// GetLoanGuarantees returns every pledge made on the loan, oldest first.
+func (m *GuaranteeModel) GetLoanGuarantees(loanId uint) ([]LoanGuarantee, error) {
+	var guarantees []LoanGuarantee
+
+	result, err := m.Service.GetOrderedEntitiesByField(&guarantees, "loan_id", loanId, "id asc", "Guarantor.User", "CreatedBy", "Recoveries")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get guarantees: %v", err)
+	}
+
+	return *result.(*[]LoanGuarantee), nil
+}
+
+// CheckGuarantees fails with ErrGuaranteesPending while any pledge on the loan is pending or
+// declined. Declined pledges have to be removed before the loan can be approved.
+func (m *GuaranteeModel) CheckGuarantees(loanId uint) error {
+	pending, err := m.Service.CountEntities(&LoanGuarantee{}, map[string]interface{}{"loan_id": loanId, "status": GuaranteeStatusPending})
+	if err != nil {
+		return err
+	}
+	if pending > 0 {
+		return fmt.Errorf("%w: %d still to respond", ErrGuaranteesPending, pending)
+	}
+
+	declined, err := m.Service.CountEntities(&LoanGuarantee{}, map[string]interface{}{"loan_id": loanId, "status": GuaranteeStatusDeclined})
+	if err != nil {
+		return err
+	}
+	if declined > 0 {
+		return fmt.Errorf("%w: %d declined, remove or replace them", ErrGuaranteesPending, declined)
+	}
+
+	return nil
+}
+
+// RemoveGuarantee deletes a pledge from a loan that is still pending, e.g. one the guarantor
+// declined so that another member can be asked instead.
+func (m *GuaranteeModel) RemoveGuarantee(id uint) error {
+	guarantee := &LoanGuarantee{ID: id}
+	if _, err := m.Service.GetEntityByID(guarantee, id); err != nil {
+		return fmt.Errorf("guarantee not found: %v", err)
+	}
+
+	loan := &Loan{ID: guarantee.LoanID}
+	if _, err := m.Service.GetEntityByID(loan, guarantee.LoanID); err != nil {
+		return fmt.Errorf("loan not found: %v", err)
+	}
+	if loan.Status != LoanStatusPending {
+		return fmt.Errorf("%w: loan is %s", ErrIllegalTransition, loan.Status)
+	}
+
+	if err := m.Service.HardDeleteEntity(guarantee, id, "guarantee"); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// RespondToGuarantee accepts or declines a pending pledge on behalf of the guarantor, who is
+// the member linked to userId.
+func (m *GuaranteeModel) RespondToGuarantee(id, userId uint, accept bool, reason string) (LoanGuarantee, error) {
+	guarantee := &LoanGuarantee{ID: id}
+
+	if _, err := m.Service.GetEntityByID(guarantee, id); err != nil {
+		return LoanGuarantee{}, fmt.Errorf("guarantee not found: %v", err)
+	}
+
+	guarantor := &Member{ID: guarantee.GuarantorID}
+	if _, err := m.Service.GetEntityByID(guarantor, guarantee.GuarantorID); err != nil {
+		return LoanGuarantee{}, fmt.Errorf("guarantor not found: %v", err)
+	}
+	if guarantor.UserID != userId {
+		return LoanGuarantee{}, ErrNotOwnGuarantee
+	}
+
+	if guarantee.Status != GuaranteeStatusPending {
+		return LoanGuarantee{}, fmt.Errorf("%w: it is %s", ErrGuaranteeNotPending, guarantee.Status)
+	}
+
+	// Savings may have been withdrawn or pledged elsewhere since the pledge was made
+	if accept && guarantee.PledgeType == PledgeTypeSavings {
+		if err := checkSavingsPledge(m.Service, guarantee); err != nil {
+			return LoanGuarantee{}, err
+		}
+	}
+
+	now := time.Now()
+	guarantee.Status = GuaranteeStatusDeclined
+	guarantee.DeclineReason = reason
+	if accept {
+		guarantee.Status = GuaranteeStatusAccepted
+		guarantee.DeclineReason = ""
+	}
+	guarantee.RespondedAt = &now
+
+	updated, err := m.Service.UpdateEntityIf(guarantee, map[string]interface{}{"status": GuaranteeStatusPending})
+	if err != nil {
+		return LoanGuarantee{}, fmt.Errorf("failed to update guarantee: %v", err)
+	}
+	if !updated {
+		return LoanGuarantee{}, fmt.Errorf("%w: it was answered by another request", ErrGuaranteeNotPending)
+	}
+
+	return *guarantee, nil
+}
+
+// RecoverFromGuarantor applies money collected from a guarantor of a defaulted loan to the
+// loan as a repayment. Savings pledges are taken out of the guarantor's savings.
+func (m *GuaranteeModel) RecoverFromGuarantor(id uint, amount money.Amount, reference string, recordedById uint, recoveredAt time.Time) (GuaranteeRecovery, error) {
+	var recovery GuaranteeRecovery
+
+	err := m.Service.WithTransaction(func(tx services.Service) error {
+		guarantee := &LoanGuarantee{ID: id}
+		if _, err := tx.GetEntityByID(guarantee, id); err != nil {
+			return fmt.Errorf("guarantee not found: %v", err)
+		}
+		if guarantee.Status != GuaranteeStatusAccepted && guarantee.Status != GuaranteeStatusCalled {
+			return fmt.Errorf("%w: it is %s", ErrGuaranteeNotCallable, guarantee.Status)
+		}
+		if amount > guarantee.Remaining() {
+			return fmt.Errorf("%w: only %s is left on the pledge", ErrGuaranteeNotCallable, guarantee.Remaining())
+		}
+
+		loanModel := NewLoanModel(tx)
+		loan, err := loanModel.GetLoanByFieldPreloaded("id", fmt.Sprintf("%d", guarantee.LoanID))
+		if err != nil {
+			return fmt.Errorf("loan not found: %v", err)
+		}
+		if loan.Status != LoanStatusDefaulted {
+			return fmt.Errorf("%w: loan is %s, guarantees are called once it defaults", ErrGuaranteeNotCallable, loan.Status)
+		}
+
+		instalments, err := NewScheduleModel(tx).GetLoanSchedule(loan.ID)
+		if err != nil {
+			return err
+		}
+
+		outstanding := money.Zero
+		for _, instalment := range instalments {
+			outstanding += instalment.Balance()
+		}
+		if amount > outstanding {
+			return fmt.Errorf("%w: only %s is owed on the loan", ErrGuaranteeNotCallable, outstanding)
+		}
+
+		recoveries, err := tx.CountEntities(&GuaranteeRecovery{}, map[string]interface{}{"guarantee_id": guarantee.ID})
+		if err != nil {
+			return err
+		}
+
+		payment := Payment{
+			LoanID:            loan.ID,
+			Amount:            amount,
+			CheckoutRequestID: fmt.Sprintf("guarantee-%d-%d", guarantee.ID, recoveries+1),
+			Status:            PaymentStatusSuccess,
+			ResponseCode:      "0",
+			ResponseDesc:      "Recovered from guarantor",
+			TransactionDesc:   fmt.Sprintf("Guarantee %d: %s", guarantee.ID, reference),
+			PaymentMode:       PaymentModeGuarantee,
+			ResultDesc:        "Recovered from guarantor",
+			PaidAt:            &recoveredAt,
+		}
+		if err := tx.CreateEntity(&payment); err != nil {
+			return fmt.Errorf("failed to record payment: %v", err)
+		}
+
+		allocations, balance, err := NewAllocationModel(tx).ApplyPayment(payment, loan.Product.RepaymentOrder(), instalments)
+		if err != nil {
+			return err
+		}
+
+		// A savings pledge is collected straight from the guarantor's savings account
+		if guarantee.PledgeType == PledgeTypeSavings {
+			transfer, err := transferToLoan(tx, guarantee, amount, recordedById, recoveredAt)
+			if err != nil {
+				return err
+			}
+			if err := NewLedgerModel(tx).PostSavingsTransfer(payment, transfer, allocations); err != nil {
+				return fmt.Errorf("error posting recovery to the ledger: %v", err)
+			}
+		} else if err := NewLedgerModel(tx).PostRepayment(payment, allocations); err != nil {
+			return fmt.Errorf("error posting recovery to the ledger: %v", err)
+		}
+
+		if _, err := loanModel.RecordRepayment(loan, balance, recoveredAt); err != nil {
+			return err
+		}
+
+		recovery = GuaranteeRecovery{
+			GuaranteeID:  guarantee.ID,
+			LoanID:       loan.ID,
+			Amount:       amount,
+			PaymentID:    payment.ID,
+			Reference:    reference,
+			RecordedByID: recordedById,
+			RecoveredAt:  recoveredAt,
+		}
+		if err := tx.CreateEntity(&recovery); err != nil {
+			return fmt.Errorf("failed to record recovery: %v", err)
+		}
+
+		previousStatus := guarantee.Status
+		guarantee.Recovered += amount
+		guarantee.Status = GuaranteeStatusCalled
+
+		updated, err := tx.UpdateEntityIf(guarantee, map[string]interface{}{"status": previousStatus, "recovered": guarantee.Recovered - amount})
+		if err != nil {
+			return fmt.Errorf("failed to update guarantee: %v", err)
+		}
+		if !updated {
+			return fmt.Errorf("%w: it was recovered on by another request", ErrGuaranteeNotCallable)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return GuaranteeRecovery{}, err
+	}
+
+	return recovery, nil
+}
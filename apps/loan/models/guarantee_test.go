@@ -0,0 +1,138 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/kifangamukundi/gm/loan/money"
+)
+
+func TestGuaranteesGateApproval(t *testing.T) {
+	service := newTestService(t, savingsEntities...)
+	group, borrower, guarantor := createGroupMembers(t, service)
+	loan := createGroupLoan(t, service, group, borrower, LoanStatusPending)
+	outsider := Member{UserID: 3}
+	if err := service.CreateEntity(&outsider); err != nil {
+		t.Fatalf("failed to create member: %v", err)
+	}
+	model := NewGuaranteeModel(service)
+
+	refused := []struct {
+		name        string
+		guarantorId uint
+		amount      money.Amount
+	}{
+		{name: "the borrower", guarantorId: borrower.ID, amount: 5000},
+		{name: "a member of another group", guarantorId: outsider.ID, amount: 5000},
+		{name: "more than the loan", guarantorId: guarantor.ID, amount: loan.Amount + 1},
+	}
+	for _, tt := range refused {
+		guarantee := LoanGuarantee{LoanID: loan.ID, GuarantorID: tt.guarantorId, Amount: tt.amount}
+		if err := model.AddGuarantee(&guarantee); !errors.Is(err, ErrNotGuarantor) {
+			t.Errorf("pledge by %s returned %v, want %v", tt.name, err, ErrNotGuarantor)
+		}
+	}
+
+	guarantee := LoanGuarantee{LoanID: loan.ID, GuarantorID: guarantor.ID, Amount: money.FromShillings(5000)}
+	if err := model.AddGuarantee(&guarantee); err != nil {
+		t.Fatalf("AddGuarantee returned %v", err)
+	}
+	if guarantee.PledgeType != PledgeTypeCash || guarantee.Status != GuaranteeStatusPending {
+		t.Errorf("pledge is a %s pledge and %s", guarantee.PledgeType, guarantee.Status)
+	}
+	again := LoanGuarantee{LoanID: loan.ID, GuarantorID: guarantor.ID, Amount: money.FromShillings(1000)}
+	if err := model.AddGuarantee(&again); !errors.Is(err, ErrNotGuarantor) {
+		t.Errorf("second pledge by the same member returned %v, want %v", err, ErrNotGuarantor)
+	}
+	if err := model.CheckGuarantees(loan.ID); !errors.Is(err, ErrGuaranteesPending) {
+		t.Errorf("CheckGuarantees with a pending pledge returned %v, want %v", err, ErrGuaranteesPending)
+	}
+
+	if _, err := model.RespondToGuarantee(guarantee.ID, borrower.UserID, true, ""); !errors.Is(err, ErrNotOwnGuarantee) {
+		t.Errorf("the borrower accepting the pledge returned %v, want %v", err, ErrNotOwnGuarantee)
+	}
+	if _, err := model.RespondToGuarantee(guarantee.ID, guarantor.UserID, false, "Cannot afford it"); err != nil {
+		t.Fatalf("RespondToGuarantee returned %v", err)
+	}
+	if _, err := model.RespondToGuarantee(guarantee.ID, guarantor.UserID, true, ""); !errors.Is(err, ErrGuaranteeNotPending) {
+		t.Errorf("accepting a declined pledge returned %v, want %v", err, ErrGuaranteeNotPending)
+	}
+
+	// A declined pledge holds up approval until it is removed
+	if err := model.CheckGuarantees(loan.ID); !errors.Is(err, ErrGuaranteesPending) {
+		t.Errorf("CheckGuarantees with a declined pledge returned %v, want %v", err, ErrGuaranteesPending)
+	}
+	if err := model.RemoveGuarantee(guarantee.ID); err != nil {
+		t.Fatalf("RemoveGuarantee returned %v", err)
+	}
+	if err := model.CheckGuarantees(loan.ID); err != nil {
+		t.Errorf("CheckGuarantees returned %v once the declined pledge was removed", err)
+	}
+
+	approved := createGroupLoan(t, service, group, borrower, LoanStatusApproved)
+	accepted := LoanGuarantee{LoanID: approved.ID, GuarantorID: guarantor.ID, Amount: money.FromShillings(5000), Status: GuaranteeStatusAccepted}
+	if err := service.CreateEntity(&accepted); err != nil {
+		t.Fatalf("failed to create guarantee: %v", err)
+	}
+	if err := model.RemoveGuarantee(accepted.ID); !errors.Is(err, ErrIllegalTransition) {
+		t.Errorf("removing a pledge from an approved loan returned %v, want %v", err, ErrIllegalTransition)
+	}
+}
+
+func TestRecoverFromGuarantor(t *testing.T) {
+	service := newTestService(t, append(savingsEntities, &Agent{}, &LoanProduct{}, &Instalment{}, &Payment{}, &PaymentAllocation{},
+		&LoanStatusHistory{}, &Collateral{})...)
+	seedTestAccounts(t, service)
+	group, borrower, guarantor := createGroupMembers(t, service)
+	depositSavings(t, service, guarantor, money.FromShillings(100))
+
+	loan := createGroupLoan(t, service, group, borrower, LoanStatusDefaulted)
+	if _, err := service.UpdateEntityColumns(&Loan{ID: loan.ID, RemainingBalance: 11000}, nil, "remaining_balance"); err != nil {
+		t.Fatalf("failed to set the balance: %v", err)
+	}
+	instalment := Instalment{LoanID: loan.ID, Number: 1, DueDate: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), Principal: 10000, Interest: 1000, TotalDue: 11000}
+	if err := service.CreateEntity(&instalment); err != nil {
+		t.Fatalf("failed to create instalment: %v", err)
+	}
+
+	guarantee := LoanGuarantee{LoanID: loan.ID, GuarantorID: guarantor.ID, PledgeType: PledgeTypeSavings, Amount: 6000, Status: GuaranteeStatusAccepted}
+	if err := service.CreateEntity(&guarantee); err != nil {
+		t.Fatalf("failed to create guarantee: %v", err)
+	}
+
+	model := NewGuaranteeModel(service)
+	recoveredAt := time.Date(2026, 5, 1, 0, 0, 0, 0, time.UTC)
+	if _, err := model.RecoverFromGuarantor(guarantee.ID, 7000, "Called", 1, recoveredAt); !errors.Is(err, ErrGuaranteeNotCallable) {
+		t.Errorf("recovering more than the pledge returned %v, want %v", err, ErrGuaranteeNotCallable)
+	}
+	for _, amount := range []money.Amount{4000, 2000} {
+		if _, err := model.RecoverFromGuarantor(guarantee.ID, amount, "Called", 1, recoveredAt); err != nil {
+			t.Fatalf("RecoverFromGuarantor returned %v", err)
+		}
+	}
+	if _, err := model.RecoverFromGuarantor(guarantee.ID, 1, "Called", 1, recoveredAt); !errors.Is(err, ErrGuaranteeNotCallable) {
+		t.Errorf("recovering on a spent pledge returned %v, want %v", err, ErrGuaranteeNotCallable)
+	}
+
+	stored, err := model.GetGuaranteeByField("id", fmt.Sprintf("%d", guarantee.ID))
+	if err != nil {
+		t.Fatalf("GetGuaranteeByField returned %v", err)
+	}
+	if stored.Status != GuaranteeStatusCalled || stored.Recovered != 6000 || len(stored.Recoveries) != 2 {
+		t.Errorf("guarantee is %s with %s recovered in %d recoveries", stored.Status, stored.Recovered, len(stored.Recoveries))
+	}
+	if remaining := getTestLoan(t, service, loan.ID).RemainingBalance; remaining != 5000 {
+		t.Errorf("loan balance = %s, want 50.00", remaining)
+	}
+
+	// The savings account and the ledger both lose what was taken from the savings
+	account, err := NewSavingsModel(service).GetMemberAccount(guarantor.ID)
+	if err != nil {
+		t.Fatalf("GetMemberAccount returned %v", err)
+	}
+	if balances := ledgerBalances(t, service); account.Balance != 4000 || balances[AccountMemberSavings] != 4000 {
+		t.Errorf("savings account holds %s and the ledger %s, want 40.00", account.Balance, balances[AccountMemberSavings])
+	}
+}
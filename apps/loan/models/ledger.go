@@ -0,0 +1,524 @@
+package models
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/kifangamukundi/gm/loan/money"
+	"github.com/kifangamukundi/gm/loan/services"
+)
+
+const (
+	AccountTypeAsset     = "asset"
+	AccountTypeLiability = "liability"
+	AccountTypeEquity    = "equity"
+	AccountTypeIncome    = "income"
+	AccountTypeExpense   = "expense"
+
+	// Chart of accounts used by the automatic postings, see seeds/account.go
+	AccountCash                = "1000"
+	AccountCashOnHand          = "1010"
+	AccountPrincipalReceivable = "1100"
+	AccountInterestReceivable  = "1110"
+	AccountFeesReceivable      = "1120"
+	AccountPenaltyReceivable   = "1130"
+	AccountCustomerDeposits    = "2000"
+	AccountMemberSavings       = "2100"
+	AccountInterestIncome      = "4000"
+	AccountFeeIncome           = "4100"
+	AccountPenaltyIncome       = "4200"
+	AccountRecoveries          = "4300"
+	AccountLoanLosses          = "5000"
+	AccountWaivers             = "5100"
+
+	JournalSourceDisbursement      = "disbursement"
+	JournalSourceRepayment         = "repayment"
+	JournalSourcePenalty           = "penalty"
+	JournalSourceWriteOff          = "write_off"
+	JournalSourceRestructure       = "restructure"
+	JournalSourceRefinance         = "refinance"
+	JournalSourceSettlement        = "settlement"
+	JournalSourceRecovery          = "recovery"
+	JournalSourceSavingsDeposit    = "savings_deposit"
+	JournalSourceSavingsWithdrawal = "savings_withdrawal"
+)
+
+// receivableAccounts maps each instalment component to the account it is owed on
+var receivableAccounts = map[string]string{
+	ComponentPenalty:   AccountPenaltyReceivable,
+	ComponentFees:      AccountFeesReceivable,
+	ComponentInterest:  AccountInterestReceivable,
+	ComponentPrincipal: AccountPrincipalReceivable,
+}
+
+type Account struct {
+	ID        uint      `gorm:"primaryKey"`
+	Code      string    `gorm:"unique;not null;index"`
+	Name      string    `gorm:"not null"`
+	Type      string    `gorm:"not null"` // asset, liability, equity, income, expense
+	IsActive  bool      `gorm:"default:true"`
+	CreatedAt time.Time `gorm:"not null"`
+	UpdatedAt time.Time `gorm:"not null"`
+}
+
+// JournalEntry groups balanced postings for one business event. The unique source keeps an
+// event such as a repayment from being posted twice.
+type JournalEntry struct {
+	ID          uint      `gorm:"primaryKey"`
+	EntryDate   time.Time `gorm:"not null;index"`
+	SourceType  string    `gorm:"not null;uniqueIndex:idx_journal_source"` // One of the JournalSource constants
+	SourceID    uint      `gorm:"not null;uniqueIndex:idx_journal_source"`
+	LoanID      *uint     `gorm:"index;default:null"`
+	Loan        *Loan     `gorm:"foreignKey:LoanID;constraint:onDelete:SET NULL"`
+	Reference   string    `gorm:"index"`
+	Description string
+	Postings    []Posting `gorm:"foreignKey:JournalEntryID"`
+	CreatedAt   time.Time `gorm:"not null"`
+}
+
+// Posting is one side of a journal entry. Exactly one of Debit and Credit is set.
+type Posting struct {
+	ID             uint         `gorm:"primaryKey"`
+	JournalEntryID uint         `gorm:"index"`
+	JournalEntry   JournalEntry `gorm:"foreignKey:JournalEntryID;constraint:onDelete:CASCADE"`
+	AccountID      uint         `gorm:"index"`
+	Account        Account      `gorm:"foreignKey:AccountID"`
+	Debit          money.Amount `gorm:"not null;default:0"`
+	Credit         money.Amount `gorm:"not null;default:0"`
+	CreatedAt      time.Time    `gorm:"not null"`
+}
+
+// PostingLine is a posting expressed against an account code before it is stored
+type PostingLine struct {
+	AccountCode string
+	Debit       money.Amount
+	Credit      money.Amount
+}
+
+// AccountTotal is the sum of the postings on one account
+type AccountTotal struct {
+	AccountID uint
+	Debit     money.Amount
+	Credit    money.Amount
+}
+
+type LedgerModel struct {
+	Service services.Service
+}
+
+func NewLedgerModel(service services.Service) *LedgerModel {
+	return &LedgerModel{Service: service}
+}
+
+// IsDebitNormal reports whether the account type grows with debits
+func IsDebitNormal(accountType string) bool {
+	return accountType == AccountTypeAsset || accountType == AccountTypeExpense
+}
+
+// Balance returns the account balance in its normal direction
+func (a *Account) Balance(debit, credit money.Amount) money.Amount {
+	if IsDebitNormal(a.Type) {
+		return debit - credit
+	}
+	return credit - debit
+}
+
+func (m *LedgerModel) GetAccounts() ([]Account, error) {
+	var accounts []Account
+
+	result, err := m.Service.GetAllEntities(&accounts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get accounts: %v", err)
+	}
+
+	accountsPtr, ok := result.(*[]Account)
+	if !ok {
+		return nil, fmt.Errorf("unexpected result type: %T", result)
+	}
+
+	return *accountsPtr, nil
+}
+
+func (m *LedgerModel) GetAccountByField(field, value string) (*Account, error) {
+	var account Account
+
+	result, err := m.Service.GetEntityByField(field, value, &account)
+	if err != nil {
+		return nil, err
+	}
+
+	return result.(*Account), nil
+}
+
+// Post stores a journal entry after checking that its lines balance. Posting the same
+// source twice is a no-op, so callbacks and jobs can safely retry.
+func (m *LedgerModel) Post(entry JournalEntry, lines []PostingLine) (*JournalEntry, error) {
+	existing, err := m.Service.CountEntities(&JournalEntry{}, map[string]interface{}{"source_type": entry.SourceType, "source_id": entry.SourceID})
+	if err != nil {
+		return nil, err
+	}
+	if existing > 0 {
+		return nil, nil
+	}
+
+	debits, credits := money.Zero, money.Zero
+	postings := make([]Posting, 0, len(lines))
+	for _, line := range lines {
+		if line.Debit == 0 && line.Credit == 0 {
+			continue
+		}
+		if line.Debit < 0 || line.Credit < 0 || (line.Debit > 0 && line.Credit > 0) {
+			return nil, fmt.Errorf("posting to %s must be a single positive debit or credit", line.AccountCode)
+		}
+
+		account, err := m.GetAccountByField("code", line.AccountCode)
+		if err != nil {
+			return nil, fmt.Errorf("account %s not found: %v", line.AccountCode, err)
+		}
+
+		debits += line.Debit
+		credits += line.Credit
+		postings = append(postings, Posting{AccountID: account.ID, Debit: line.Debit, Credit: line.Credit})
+	}
+
+	if len(postings) == 0 {
+		return nil, nil
+	}
+	if debits != credits {
+		return nil, fmt.Errorf("journal entry is unbalanced: debits %s, credits %s", debits, credits)
+	}
+
+	entry.Postings = postings
+	if err := m.Service.CreateEntity(&entry); err != nil {
+		return nil, fmt.Errorf("failed to post journal entry: %v", err)
+	}
+
+	return &entry, nil
+}
+
+// PostDisbursement moves the principal out of cash into loans receivable and recognises the
+// scheduled interest and fees as receivable.
+func (m *LedgerModel) PostDisbursement(disbursement Disbursement, instalments []Instalment) error {
+	interest, fees := money.Zero, money.Zero
+	for _, instalment := range instalments {
+		interest += instalment.Interest
+		fees += instalment.Fees
+	}
+
+	loanId := disbursement.LoanID
+	entryDate := time.Now()
+	if disbursement.DisbursedAt != nil {
+		entryDate = *disbursement.DisbursedAt
+	}
+
+	_, err := m.Post(JournalEntry{
+		EntryDate:   entryDate,
+		SourceType:  JournalSourceDisbursement,
+		SourceID:    disbursement.ID,
+		LoanID:      &loanId,
+		Reference:   disbursement.TransactionID,
+		Description: fmt.Sprintf("Disbursement of loan %d", loanId),
+	}, []PostingLine{
+		{AccountCode: AccountPrincipalReceivable, Debit: disbursement.Amount},
+		{AccountCode: AccountCash, Credit: disbursement.Amount},
+		{AccountCode: AccountInterestReceivable, Debit: interest},
+		{AccountCode: AccountInterestIncome, Credit: interest},
+		{AccountCode: AccountFeesReceivable, Debit: fees},
+		{AccountCode: AccountFeeIncome, Credit: fees},
+	})
+	return err
+}
+
+// PostRepayment debits cash with the payment and credits each receivable by what the
+// allocation waterfall applied to it. Overpayments are held as customer deposits.
+func (m *LedgerModel) PostRepayment(payment Payment, allocations []PaymentAllocation) error {
+	lines, err := allocationLines(AccountCash, payment.Amount, allocations)
+	if err != nil {
+		return err
+	}
+
+	loanId := payment.LoanID
+	entryDate := payment.UpdatedAt
+	if payment.PaidAt != nil {
+		entryDate = *payment.PaidAt
+	}
+
+	_, err = m.Post(JournalEntry{
+		EntryDate:   entryDate,
+		SourceType:  JournalSourceRepayment,
+		SourceID:    payment.ID,
+		LoanID:      &loanId,
+		Reference:   payment.MpesaReceiptNumber,
+		Description: fmt.Sprintf("Repayment on loan %d", loanId),
+	}, lines)
+	return err
+}
+
+// PostSavingsTransfer applies a guarantor's pledged savings to the loan they guaranteed. Member
+// savings are debited in place of cash.
+func (m *LedgerModel) PostSavingsTransfer(payment Payment, transfer SavingsTransaction, allocations []PaymentAllocation) error {
+	lines, err := allocationLines(AccountMemberSavings, payment.Amount, allocations)
+	if err != nil {
+		return err
+	}
+
+	loanId := payment.LoanID
+	entryDate := payment.UpdatedAt
+	if payment.PaidAt != nil {
+		entryDate = *payment.PaidAt
+	}
+
+	_, err = m.Post(JournalEntry{
+		EntryDate:   entryDate,
+		SourceType:  JournalSourceRepayment,
+		SourceID:    payment.ID,
+		LoanID:      &loanId,
+		Reference:   fmt.Sprintf("SAV-%d", transfer.ID),
+		Description: fmt.Sprintf("Repayment on loan %d from the savings of member %d", loanId, transfer.MemberID),
+	}, lines)
+	return err
+}
+
+// PostRefinance settles a refinanced loan from the principal of the top-up that replaced it.
+// The top-up's principal receivable is debited in place of cash.
+func (m *LedgerModel) PostRefinance(payment Payment, topUpId uint, allocations []PaymentAllocation) error {
+	lines, err := allocationLines(AccountPrincipalReceivable, payment.Amount, allocations)
+	if err != nil {
+		return err
+	}
+
+	loanId := payment.LoanID
+	entryDate := payment.UpdatedAt
+	if payment.PaidAt != nil {
+		entryDate = *payment.PaidAt
+	}
+
+	_, err = m.Post(JournalEntry{
+		EntryDate:   entryDate,
+		SourceType:  JournalSourceRefinance,
+		SourceID:    payment.ID,
+		LoanID:      &loanId,
+		Reference:   fmt.Sprintf("LOAN-%d", topUpId),
+		Description: fmt.Sprintf("Loan %d settled by top-up loan %d", loanId, topUpId),
+	}, lines)
+	return err
+}
+
+// allocationLines debits the account the money came from and credits each receivable by what
+// the allocation waterfall applied to it, with overpayments held as customer deposits.
+func allocationLines(source string, amount money.Amount, allocations []PaymentAllocation) ([]PostingLine, error) {
+	totals := map[string]money.Amount{}
+	for _, allocation := range allocations {
+		account, ok := receivableAccounts[allocation.Component]
+		if allocation.Component == ComponentOverpayment {
+			account, ok = AccountCustomerDeposits, true
+		}
+		if !ok {
+			return nil, fmt.Errorf("no ledger account for %s", allocation.Component)
+		}
+		totals[account] += allocation.Amount
+	}
+
+	lines := []PostingLine{{AccountCode: source, Debit: amount}}
+	for _, account := range []string{AccountPenaltyReceivable, AccountFeesReceivable, AccountInterestReceivable, AccountPrincipalReceivable, AccountCustomerDeposits} {
+		lines = append(lines, PostingLine{AccountCode: account, Credit: totals[account]})
+	}
+
+	return lines, nil
+}
+
+// PostPenalty recognises an accrued penalty as receivable and income
+func (m *LedgerModel) PostPenalty(charge PenaltyCharge) error {
+	loanId := charge.LoanID
+
+	_, err := m.Post(JournalEntry{
+		EntryDate:   charge.CreatedAt,
+		SourceType:  JournalSourcePenalty,
+		SourceID:    charge.ID,
+		LoanID:      &loanId,
+		Reference:   charge.ChargeDate,
+		Description: fmt.Sprintf("Late penalty on loan %d, %d days overdue", loanId, charge.DaysOverdue),
+	}, []PostingLine{
+		{AccountCode: AccountPenaltyReceivable, Debit: charge.Amount},
+		{AccountCode: AccountPenaltyIncome, Credit: charge.Amount},
+	})
+	return err
+}
+
+// PostWriteOff moves everything still receivable on a loan into loan losses
+func (m *LedgerModel) PostWriteOff(sourceId, loanId uint, amounts map[string]money.Amount, description string) error {
+	return m.postLoss(JournalSourceWriteOff, AccountLoanLosses, sourceId, loanId, amounts, description)
+}
+
+// PostRecovery recognises money collected on a written-off loan as income. Anything beyond
+// what was written off is held as a customer deposit.
+func (m *LedgerModel) PostRecovery(recovery LoanRecovery, excess money.Amount) error {
+	loanId := recovery.LoanID
+
+	_, err := m.Post(JournalEntry{
+		EntryDate:   recovery.RecoveredAt,
+		SourceType:  JournalSourceRecovery,
+		SourceID:    recovery.ID,
+		LoanID:      &loanId,
+		Reference:   recovery.Reference,
+		Description: fmt.Sprintf("Recovery on written-off loan %d", loanId),
+	}, []PostingLine{
+		{AccountCode: AccountCash, Debit: recovery.Amount + excess},
+		{AccountCode: AccountRecoveries, Credit: recovery.Amount},
+		{AccountCode: AccountCustomerDeposits, Credit: excess},
+	})
+	return err
+}
+
+// PostRestructure clears the arrears a restructure replaced, either into principal or to
+// waivers, reverses the interest it dropped before it fell due and recognises the interest
+// on the new schedule. Fees not yet due move to the new schedule and stay receivable.
+func (m *LedgerModel) PostRestructure(restructure LoanRestructure) error {
+	arrears := map[string]money.Amount{
+		ComponentPenalty:  restructure.ArrearsPenalty,
+		ComponentFees:     restructure.ArrearsFees,
+		ComponentInterest: restructure.ArrearsInterest,
+	}
+
+	lines := []PostingLine{
+		{AccountCode: AccountPrincipalReceivable, Debit: restructure.Capitalized},
+		{AccountCode: AccountWaivers, Debit: restructure.Waived},
+	}
+	for _, component := range []string{ComponentPenalty, ComponentFees, ComponentInterest} {
+		lines = append(lines, PostingLine{AccountCode: receivableAccounts[component], Credit: arrears[component]})
+	}
+
+	lines = append(lines,
+		PostingLine{AccountCode: AccountInterestIncome, Debit: restructure.UnearnedInterest},
+		PostingLine{AccountCode: AccountInterestReceivable, Credit: restructure.UnearnedInterest},
+		PostingLine{AccountCode: AccountInterestReceivable, Debit: restructure.NewInterest},
+		PostingLine{AccountCode: AccountInterestIncome, Credit: restructure.NewInterest},
+	)
+
+	loanId := restructure.LoanID
+	entryDate := time.Now()
+	if restructure.DecidedAt != nil {
+		entryDate = *restructure.DecidedAt
+	}
+
+	_, err := m.Post(JournalEntry{
+		EntryDate:   entryDate,
+		SourceType:  JournalSourceRestructure,
+		SourceID:    restructure.ID,
+		LoanID:      &loanId,
+		Description: fmt.Sprintf("Restructure of loan %d", loanId),
+	}, lines)
+	return err
+}
+
+// PostSettlementRebate expenses what was waived when a loan was settled early under a quote
+func (m *LedgerModel) PostSettlementRebate(quoteId, loanId uint, amounts map[string]money.Amount, description string) error {
+	return m.postLoss(JournalSourceSettlement, AccountWaivers, quoteId, loanId, amounts, description)
+}
+
+// PostSavings moves a completed savings transaction between the till it went through and
+// the members' savings, which the organisation owes back to them
+func (m *LedgerModel) PostSavings(transaction SavingsTransaction) error {
+	till := AccountCash
+	if transaction.Channel == SavingsChannelCash {
+		till = AccountCashOnHand
+	}
+
+	entryDate := transaction.UpdatedAt
+	if transaction.CompletedAt != nil {
+		entryDate = *transaction.CompletedAt
+	}
+
+	entry := JournalEntry{
+		EntryDate:   entryDate,
+		SourceType:  JournalSourceSavingsDeposit,
+		SourceID:    transaction.ID,
+		Reference:   transaction.Reference,
+		Description: fmt.Sprintf("Savings deposit by member %d", transaction.MemberID),
+	}
+	lines := []PostingLine{
+		{AccountCode: till, Debit: transaction.Amount},
+		{AccountCode: AccountMemberSavings, Credit: transaction.Amount},
+	}
+
+	if transaction.Type == SavingsTransactionWithdrawal {
+		entry.SourceType = JournalSourceSavingsWithdrawal
+		entry.Description = fmt.Sprintf("Savings withdrawal by member %d", transaction.MemberID)
+		lines = []PostingLine{
+			{AccountCode: AccountMemberSavings, Debit: transaction.Amount},
+			{AccountCode: till, Credit: transaction.Amount},
+		}
+	}
+
+	_, err := m.Post(entry, lines)
+	return err
+}
+
+func (m *LedgerModel) postLoss(sourceType, expenseAccount string, sourceId, loanId uint, amounts map[string]money.Amount, description string) error {
+	total := money.Zero
+	lines := []PostingLine{}
+	for _, component := range DefaultAllocationOrder {
+		total += amounts[component]
+		lines = append(lines, PostingLine{AccountCode: receivableAccounts[component], Credit: amounts[component]})
+	}
+	lines = append(lines, PostingLine{AccountCode: expenseAccount, Debit: total})
+
+	_, err := m.Post(JournalEntry{
+		EntryDate:   time.Now(),
+		SourceType:  sourceType,
+		SourceID:    sourceId,
+		LoanID:      &loanId,
+		Description: description,
+	}, lines)
+	return err
+}
+
+// GetAccountTotals sums postings per account for entries dated in [from, to). Either bound may be nil.
+func (m *LedgerModel) GetAccountTotals(from, to *time.Time) (map[uint]AccountTotal, error) {
+	var totals []AccountTotal
+
+	if err := m.Service.SumPostingsByAccount(&totals, from, to); err != nil {
+		return nil, fmt.Errorf("failed to sum postings: %v", err)
+	}
+
+	byAccount := make(map[uint]AccountTotal, len(totals))
+	for _, total := range totals {
+		byAccount[total.AccountID] = total
+	}
+
+	return byAccount, nil
+}
+
+// GetAccountPostings returns an account's postings for entries dated in [from, to), oldest first
+func (m *LedgerModel) GetAccountPostings(accountId uint, from, to *time.Time) ([]Posting, error) {
+	var postings []Posting
+
+	result, err := m.Service.GetAccountPostings(&postings, accountId, from, to, "JournalEntry")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get postings: %v", err)
+	}
+
+	postingsPtr, ok := result.(*[]Posting)
+	if !ok {
+		return nil, fmt.Errorf("unexpected result type: %T", result)
+	}
+
+	return *postingsPtr, nil
+}
+
+func (m *LedgerModel) GetLoanJournal(loanId uint) ([]JournalEntry, error) {
+	var entries []JournalEntry
+
+	result, err := m.Service.GetAllEntititiesByFieldWithPreload(&entries, "loan_id", strconv.Itoa(int(loanId)), "Postings", "Postings.Account")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get journal: %v", err)
+	}
+
+	entriesPtr, ok := result.(*[]JournalEntry)
+	if !ok {
+		return nil, fmt.Errorf("unexpected result type: %T", result)
+	}
+
+	return *entriesPtr, nil
+}
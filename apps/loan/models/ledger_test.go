@@ -0,0 +1,152 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kifangamukundi/gm/loan/money"
+	"github.com/kifangamukundi/gm/loan/services"
+)
+
+// ledgerEntities are the tables the ledger posts to
+var ledgerEntities = []interface{}{&Account{}, &JournalEntry{}, &Posting{}}
+
+// seedTestAccounts creates the chart of accounts the automatic postings use
+func seedTestAccounts(t *testing.T, service services.Service) {
+	t.Helper()
+
+	accounts := map[string]string{
+		AccountCash: AccountTypeAsset, AccountCashOnHand: AccountTypeAsset, AccountPrincipalReceivable: AccountTypeAsset,
+		AccountInterestReceivable: AccountTypeAsset, AccountFeesReceivable: AccountTypeAsset, AccountPenaltyReceivable: AccountTypeAsset,
+		AccountCustomerDeposits: AccountTypeLiability, AccountMemberSavings: AccountTypeLiability,
+		AccountInterestIncome: AccountTypeIncome, AccountFeeIncome: AccountTypeIncome, AccountPenaltyIncome: AccountTypeIncome,
+		AccountRecoveries: AccountTypeIncome, AccountLoanLosses: AccountTypeExpense, AccountWaivers: AccountTypeExpense,
+	}
+	for code, accountType := range accounts {
+		if err := service.CreateEntity(&Account{Code: code, Name: code, Type: accountType, IsActive: true}); err != nil {
+			t.Fatalf("failed to create account %s: %v", code, err)
+		}
+	}
+}
+
+// ledgerBalances returns every account's balance in its normal direction by code, failing the
+// test when the ledger's debits and credits do not agree
+func ledgerBalances(t *testing.T, service services.Service) map[string]money.Amount {
+	t.Helper()
+
+	ledger := NewLedgerModel(service)
+	accounts, err := ledger.GetAccounts()
+	if err != nil {
+		t.Fatalf("GetAccounts returned %v", err)
+	}
+	totals, err := ledger.GetAccountTotals(nil, nil)
+	if err != nil {
+		t.Fatalf("GetAccountTotals returned %v", err)
+	}
+
+	balances := map[string]money.Amount{}
+	debits, credits := money.Zero, money.Zero
+	for _, account := range accounts {
+		total := totals[account.ID]
+		debits += total.Debit
+		credits += total.Credit
+		balances[account.Code] = account.Balance(total.Debit, total.Credit)
+	}
+	if debits != credits {
+		t.Fatalf("ledger does not balance: debits %s, credits %s", debits, credits)
+	}
+
+	return balances
+}
+
+func TestPostRejectsUnbalancedLines(t *testing.T) {
+	service := newTestService(t, ledgerEntities...)
+	seedTestAccounts(t, service)
+
+	tests := []struct {
+		name  string
+		lines []PostingLine
+	}{
+		{name: "debits and credits differ", lines: []PostingLine{{AccountCode: AccountCash, Debit: 100}, {AccountCode: AccountFeeIncome, Credit: 90}}},
+		{name: "negative amount", lines: []PostingLine{{AccountCode: AccountCash, Debit: -100}, {AccountCode: AccountFeeIncome, Credit: -100}}},
+		{name: "debit and credit on one line", lines: []PostingLine{{AccountCode: AccountCash, Debit: 100, Credit: 100}}},
+		{name: "unknown account", lines: []PostingLine{{AccountCode: "9999", Debit: 100}, {AccountCode: AccountFeeIncome, Credit: 100}}},
+	}
+
+	for i, tt := range tests {
+		entry := JournalEntry{EntryDate: time.Now(), SourceType: JournalSourcePenalty, SourceID: uint(i + 1)}
+		if _, err := NewLedgerModel(service).Post(entry, tt.lines); err == nil {
+			t.Errorf("%s: Post succeeded, want an error", tt.name)
+		}
+	}
+
+	if balances := ledgerBalances(t, service); balances[AccountCash] != 0 {
+		t.Errorf("rejected entries left %s in cash", balances[AccountCash])
+	}
+}
+
+func TestPostIgnoresARepostedSource(t *testing.T) {
+	service := newTestService(t, ledgerEntities...)
+	seedTestAccounts(t, service)
+	ledger := NewLedgerModel(service)
+
+	charge := PenaltyCharge{ID: 4, LoanID: 2, Amount: 500, ChargeDate: "2026-03-01", CreatedAt: time.Now()}
+	for i := 0; i < 2; i++ {
+		if err := ledger.PostPenalty(charge); err != nil {
+			t.Fatalf("PostPenalty returned %v", err)
+		}
+	}
+
+	balances := ledgerBalances(t, service)
+	if balances[AccountPenaltyReceivable] != 500 || balances[AccountPenaltyIncome] != 500 {
+		t.Errorf("penalty posted as %s receivable and %s income, want 5.00 once", balances[AccountPenaltyReceivable], balances[AccountPenaltyIncome])
+	}
+}
+
+func TestLoanPostingsBalance(t *testing.T) {
+	service := newTestService(t, ledgerEntities...)
+	seedTestAccounts(t, service)
+	ledger := NewLedgerModel(service)
+
+	paidAt := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	instalments := []Instalment{
+		{ID: 1, Principal: 50000, Interest: 5000, Fees: 1000},
+		{ID: 2, Principal: 50000, Interest: 5000, Fees: 1000},
+	}
+	if err := ledger.PostDisbursement(Disbursement{ID: 1, LoanID: 2, Amount: 100000, DisbursedAt: &paidAt}, instalments); err != nil {
+		t.Fatalf("PostDisbursement returned %v", err)
+	}
+	if err := ledger.PostPenalty(PenaltyCharge{ID: 1, LoanID: 2, Amount: 300, CreatedAt: paidAt}); err != nil {
+		t.Fatalf("PostPenalty returned %v", err)
+	}
+
+	instalmentId := uint(1)
+	allocations := []PaymentAllocation{
+		{InstalmentID: &instalmentId, Component: ComponentPenalty, Amount: 300},
+		{InstalmentID: &instalmentId, Component: ComponentFees, Amount: 1000},
+		{InstalmentID: &instalmentId, Component: ComponentInterest, Amount: 5000},
+		{InstalmentID: &instalmentId, Component: ComponentPrincipal, Amount: 50000},
+		{Component: ComponentOverpayment, Amount: 700},
+	}
+	if err := ledger.PostRepayment(Payment{ID: 1, LoanID: 2, Amount: 57000, PaidAt: &paidAt}, allocations); err != nil {
+		t.Fatalf("PostRepayment returned %v", err)
+	}
+
+	want := map[string]money.Amount{
+		AccountCash:                -43000,
+		AccountPrincipalReceivable: 50000,
+		AccountInterestReceivable:  5000,
+		AccountFeesReceivable:      1000,
+		AccountPenaltyReceivable:   0,
+		AccountCustomerDeposits:    700,
+		AccountInterestIncome:      10000,
+		AccountFeeIncome:           2000,
+		AccountPenaltyIncome:       300,
+	}
+	balances := ledgerBalances(t, service)
+	for code, amount := range want {
+		if balances[code] != amount {
+			t.Errorf("account %s = %s, want %s", code, balances[code], amount)
+		}
+	}
+}
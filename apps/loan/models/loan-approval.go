@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// LoanApproval is one sign-off on a loan at one approval tier. A loan is approved once it
+// has a sign-off at every tier its amount requires, see ApprovalModel.RecordApproval.
+type LoanApproval struct {
+	ID         uint      `gorm:"primaryKey"`
+	LoanID     uint      `gorm:"uniqueIndex:idx_loan_approval_level"` // Foreign key to Loan
+	Loan       Loan      `gorm:"foreignKey:LoanID;constraint:onDelete:CASCADE"`
+	Level      int       `gorm:"uniqueIndex:idx_loan_approval_level"` // Tier level signed off, one sign-off per level
+	TierName   string    `gorm:"not null"`                            // Tier name at the time of the sign-off
+	ApproverID uint      `gorm:"index"`                               // User who signed off
+	Approver   User      `gorm:"foreignKey:ApproverID;constraint:onDelete:RESTRICT"`
+	OfficerID  *uint     `gorm:"index;default:null"`
+	Officer    *Officer  `gorm:"foreignKey:OfficerID;constraint:onDelete:SET NULL"`
+	CreatedAt  time.Time `gorm:"not null"`
+}
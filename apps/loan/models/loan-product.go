@@ -0,0 +1,277 @@
+package models
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/kifangamukundi/gm/libs/parameters"
+	"github.com/kifangamukundi/gm/loan/money"
+	"github.com/kifangamukundi/gm/loan/services"
+)
+
+type LoanProduct struct {
+	ID                 uint         `gorm:"primaryKey"`
+	ProductName        string       `gorm:"unique;not null;index"`
+	InterestMethod     string       `gorm:"not null;default:'flat'"`    // flat, reducing_balance
+	InterestRate       float64      `gorm:"not null"`                   // Annual percentage, charged pro rata per repayment period
+	RatePerPeriod      bool         `gorm:"not null;default:false"`     // InterestRate is charged per period, as rates set before they became annual were
+	MinAmount          money.Amount `gorm:"not null"`                   // Smallest principal allowed
+	MaxAmount          money.Amount `gorm:"not null"`                   // Largest principal allowed
+	MinTerm            int          `gorm:"not null"`                   // Fewest repayment periods allowed
+	MaxTerm            int          `gorm:"not null"`                   // Most repayment periods allowed
+	RepaymentFrequency string       `gorm:"not null;default:'monthly'"` // daily, weekly, monthly
+	ProcessingFee      money.Amount `gorm:"not null;default:0"`         // Flat fee charged on every loan
+	FeePercentage      float64      `gorm:"not null;default:0"`         // Fee charged as a percentage of principal
+	GracePeriod        int          `gorm:"not null;default:0"`         // Periods before the first instalment falls due
+	IsActive           bool         `gorm:"default:true"`
+
+	// Order in which repayments clear penalty, fees, interest and principal, see ParseAllocationOrder
+	AllocationOrder string `gorm:"not null;default:'penalty,fees,interest,principal'"`
+
+	// Late payment handling, see PenaltyRule
+	PenaltyType      string       `gorm:"not null;default:'none'"` // none, flat, percent_of_arrears, per_day
+	PenaltyAmount    money.Amount `gorm:"not null;default:0"`      // Flat fee or daily amount for the flat and per_day types
+	PenaltyPercent   float64      `gorm:"not null;default:0"`      // Percentage of the arrears for the percent_of_arrears type
+	PenaltyCap       money.Amount `gorm:"not null;default:0"`      // Most penalty charged per instalment, 0 for no cap
+	PenaltyGraceDays int          `gorm:"not null;default:0"`      // Days late before any penalty is charged
+	ArrearsAfterDays int          `gorm:"not null"`                // Days late before the loan is marked in arrears
+
+	// Early settlement, see QuoteSettlement
+	SettlementRebate float64 `gorm:"not null;default:0"` // Percentage of interest not yet accrued waived on early settlement
+
+	// Bad debt, see WriteOffThreshold
+	WriteOffAfterDays int `gorm:"not null;default:180"` // Days past due before the loan may be written off
+
+	// Security, see CheckLoanToValue
+	MaxLoanToValue float64 `gorm:"not null;default:0"` // Largest principal as a percentage of collateral value, 0 when no collateral is required
+
+	// Progressive lending, see LadderLimit
+	LadderStartAmount money.Amount `gorm:"not null;default:0"` // Largest first loan, 0 to allow MaxAmount from the start
+	LadderStepPercent float64      `gorm:"not null;default:0"` // Increase in the limit for each loan the member has repaid
+	SavingsMultiplier float64      `gorm:"not null;default:0"` // Most a member may borrow as a multiple of their savings, 0 to ignore savings
+
+	Loans []Loan `gorm:"foreignKey:ProductID"`
+
+	CreatedAt time.Time `gorm:"not null"`
+	UpdatedAt time.Time `gorm:"not null"`
+}
+
+type LoanProductModel struct {
+	Service services.Service
+}
+
+func NewLoanProductModel(service services.Service) *LoanProductModel {
+	return &LoanProductModel{Service: service}
+}
+
+// ValidateTerms checks a requested amount and term against the product limits.
+func (p *LoanProduct) ValidateTerms(amount money.Amount, term int) error {
+	if !p.IsActive {
+		return fmt.Errorf("loan product %s is not active", p.ProductName)
+	}
+	if amount < p.MinAmount || amount > p.MaxAmount {
+		return fmt.Errorf("amount must be between %s and %s for %s", p.MinAmount, p.MaxAmount, p.ProductName)
+	}
+	if term < p.MinTerm || term > p.MaxTerm {
+		return fmt.Errorf("term must be between %d and %d periods for %s", p.MinTerm, p.MaxTerm, p.ProductName)
+	}
+	return nil
+}
+
+// CalculateFees returns the total fees charged for a loan of the given amount, with the
+// percentage fee rounded to the nearest cent.
+func (p *LoanProduct) CalculateFees(amount money.Amount) money.Amount {
+	return p.ProcessingFee + amount.Percent(p.FeePercentage)
+}
+
+// RepaymentOrder returns the product's allocation waterfall, falling back to the default
+// for loans without a product or a product with an unusable order.
+func (p *LoanProduct) RepaymentOrder() []string {
+	if p == nil {
+		return DefaultAllocationOrder
+	}
+
+	order, err := ParseAllocationOrder(p.AllocationOrder)
+	if err != nil {
+		log.Printf("Invalid allocation order on %s, using default: %v", p.ProductName, err)
+		return DefaultAllocationOrder
+	}
+	return order
+}
+
+// PenaltyRule returns the product's late payment rule. Loans without a product are never penalised.
+func (p *LoanProduct) PenaltyRule() PenaltyRule {
+	if p == nil {
+		return PenaltyRule{Type: PenaltyTypeNone, ArrearsAfterDays: DefaultArrearsAfterDays}
+	}
+
+	return PenaltyRule{
+		Type:             p.PenaltyType,
+		Amount:           p.PenaltyAmount,
+		Percent:          p.PenaltyPercent,
+		Cap:              p.PenaltyCap,
+		GraceDays:        p.PenaltyGraceDays,
+		ArrearsAfterDays: p.ArrearsAfterDays,
+	}
+}
+
+// WriteOffThreshold returns how many days past due a loan must be before it may be written
+// off, falling back to DefaultWriteOffAfterDays when the product does not set one.
+func (p *LoanProduct) WriteOffThreshold() int {
+	if p == nil || p.WriteOffAfterDays <= 0 {
+		return DefaultWriteOffAfterDays
+	}
+	return p.WriteOffAfterDays
+}
+
+// LadderLimit returns the largest loan a member who has repaid completedCycles loans may take,
+// growing from LadderStartAmount by LadderStepPercent per cycle up to MaxAmount.
+func (p *LoanProduct) LadderLimit(completedCycles int) money.Amount {
+	if p.LadderStartAmount <= 0 {
+		return p.MaxAmount
+	}
+
+	limit := p.LadderStartAmount
+	for i := 0; i < completedCycles && limit < p.MaxAmount; i++ {
+		limit = limit.Mul(1 + p.LadderStepPercent/100)
+	}
+
+	return money.Min(limit, p.MaxAmount)
+}
+
+func (m *LoanProductModel) CreateLoanProduct(product *LoanProduct) error {
+	product.ProductName = parameters.TrimWhitespace(product.ProductName)
+
+	if err := m.Service.CreateEntity(product); err != nil {
+		return fmt.Errorf("failed to create loan product: %v", err)
+	}
+
+	return nil
+}
+
+func (m *LoanProductModel) GetLoanProducts(skip, limit int, sortOrder, sortByColumn, searchRegex string, filterCriteria interface{}) ([]LoanProduct, int64, int64, error) {
+	searchColumns := []string{"product_name"}
+
+	preloads := []string{}
+
+	productsResult, totalCount, filteredCount, err := m.Service.GetEntitiesFiltered(&LoanProduct{}, skip, limit, sortOrder, sortByColumn, searchRegex, filterCriteria, searchColumns, preloads)
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("failed to get loan products: %v", err)
+	}
+
+	var products []LoanProduct
+	for _, product := range productsResult {
+		if c, ok := product.(*LoanProduct); ok {
+			products = append(products, *c)
+		} else {
+			return nil, 0, 0, fmt.Errorf("unexpected type in result: %T", product)
+		}
+	}
+
+	return products, totalCount, filteredCount, nil
+}
+
+func (m *LoanProductModel) GetLoanProductByField(field, value string) (*LoanProduct, error) {
+	var product LoanProduct
+
+	result, err := m.Service.GetEntityByField(field, value, &product)
+	if err != nil {
+		log.Printf("Error fetching loan product by %s: %v", field, err)
+		return nil, err
+	}
+
+	return result.(*LoanProduct), nil
+}
+
+func (m *LoanProductModel) GetActiveLoanProducts() ([]LoanProduct, error) {
+	var products []LoanProduct
+
+	result, err := m.Service.GetEntitiesByFields(&products, map[string]interface{}{"is_active": true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get loan products: %v", err)
+	}
+
+	productsPtr, ok := result.(*[]LoanProduct)
+	if !ok {
+		return nil, fmt.Errorf("unexpected result type: %T", result)
+	}
+
+	return *productsPtr, nil
+}
+
+// UpdateLoanProduct replaces the product's terms with changes. The product is only activated
+// or deactivated when isActive is given.
+func (m *LoanProductModel) UpdateLoanProduct(id int, changes LoanProduct, isActive *bool) (LoanProduct, error) {
+	product := &LoanProduct{ID: uint(id)}
+
+	_, err := m.Service.GetEntityByID(product, uint(id))
+	if err != nil {
+		return LoanProduct{}, fmt.Errorf("loan product not found: %v", err)
+	}
+
+	if changes.AllocationOrder == "" {
+		changes.AllocationOrder = product.AllocationOrder
+	}
+
+	product.ProductName = parameters.TrimWhitespace(changes.ProductName)
+	product.InterestMethod = changes.InterestMethod
+	// A new rate is annual, while resubmitting the old one keeps how it is charged
+	if changes.InterestRate != product.InterestRate {
+		product.RatePerPeriod = false
+	}
+	product.InterestRate = changes.InterestRate
+	product.MinAmount = changes.MinAmount
+	product.MaxAmount = changes.MaxAmount
+	product.MinTerm = changes.MinTerm
+	product.MaxTerm = changes.MaxTerm
+	product.RepaymentFrequency = changes.RepaymentFrequency
+	product.ProcessingFee = changes.ProcessingFee
+	product.FeePercentage = changes.FeePercentage
+	product.GracePeriod = changes.GracePeriod
+	if isActive != nil {
+		product.IsActive = *isActive
+	}
+	product.AllocationOrder = changes.AllocationOrder
+	product.PenaltyType = changes.PenaltyType
+	product.PenaltyAmount = changes.PenaltyAmount
+	product.PenaltyPercent = changes.PenaltyPercent
+	product.PenaltyCap = changes.PenaltyCap
+	product.PenaltyGraceDays = changes.PenaltyGraceDays
+	product.ArrearsAfterDays = changes.ArrearsAfterDays
+	product.SettlementRebate = changes.SettlementRebate
+	product.WriteOffAfterDays = changes.WriteOffAfterDays
+	product.MaxLoanToValue = changes.MaxLoanToValue
+	product.LadderStartAmount = changes.LadderStartAmount
+	product.LadderStepPercent = changes.LadderStepPercent
+	product.SavingsMultiplier = changes.SavingsMultiplier
+
+	if err := m.Service.UpdateEntity(product); err != nil {
+		return LoanProduct{}, fmt.Errorf("failed to update loan product: %v", err)
+	}
+
+	return *product, nil
+}
+
+func (m *LoanProductModel) DeleteLoanProduct(id uint) error {
+	product := &LoanProduct{}
+
+	_, err := m.Service.GetEntityByID(product, id)
+	if err != nil {
+		return fmt.Errorf("loan product not found: %v", err)
+	}
+
+	loans, err := m.Service.CountEntities(&Loan{}, map[string]interface{}{"product_id": id})
+	if err != nil {
+		return fmt.Errorf("failed to count product loans: %v", err)
+	}
+	if loans > 0 {
+		return fmt.Errorf("loan product has %d loans, deactivate it instead", loans)
+	}
+
+	if err := m.Service.HardDeleteEntity(product, id, "loan product"); err != nil {
+		return fmt.Errorf("failed to delete loan product: %v", err)
+	}
+
+	return nil
+}
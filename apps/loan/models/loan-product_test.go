@@ -0,0 +1,57 @@
+package models
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/kifangamukundi/gm/loan/money"
+)
+
+func TestCreateLoanProductKeepsArrearsAfterDays(t *testing.T) {
+	service := newTestService(t, &LoanProduct{})
+	model := NewLoanProductModel(service)
+
+	for _, days := range []int{0, 1, 7} {
+		product := LoanProduct{ProductName: "Product " + strconv.Itoa(days), InterestRate: 12, MaxAmount: 100000, MinTerm: 1, MaxTerm: 6, ArrearsAfterDays: days}
+		if err := model.CreateLoanProduct(&product); err != nil {
+			t.Fatalf("CreateLoanProduct returned %v", err)
+		}
+
+		stored, err := model.GetLoanProductByField("id", strconv.Itoa(int(product.ID)))
+		if err != nil {
+			t.Fatalf("GetLoanProductByField returned %v", err)
+		}
+		if stored.ArrearsAfterDays != days {
+			t.Errorf("ArrearsAfterDays stored as %d, want %d", stored.ArrearsAfterDays, days)
+		}
+		if rule := stored.PenaltyRule(); rule.ArrearsAfterDays != days {
+			t.Errorf("penalty rule has ArrearsAfterDays %d, want %d", rule.ArrearsAfterDays, days)
+		}
+	}
+}
+
+func TestLadderLimit(t *testing.T) {
+	ladder := LoanProduct{MaxAmount: 30000, LadderStartAmount: 10000, LadderStepPercent: 50}
+
+	tests := []struct {
+		name            string
+		product         LoanProduct
+		completedCycles int
+		want            money.Amount
+	}{
+		{name: "no ladder", product: LoanProduct{MaxAmount: 30000}, completedCycles: 0, want: 30000},
+		{name: "first loan", product: ladder, completedCycles: 0, want: 10000},
+		{name: "one cycle", product: ladder, completedCycles: 1, want: 15000},
+		{name: "two cycles", product: ladder, completedCycles: 2, want: 22500},
+		{name: "capped at the maximum", product: ladder, completedCycles: 3, want: 30000},
+		{name: "stays at the maximum", product: ladder, completedCycles: 20, want: 30000},
+		{name: "no step", product: LoanProduct{MaxAmount: 30000, LadderStartAmount: 10000}, completedCycles: 5, want: 10000},
+		{name: "each step rounds", product: LoanProduct{MaxAmount: 30000, LadderStartAmount: 1001, LadderStepPercent: 33.3}, completedCycles: 1, want: 1334},
+	}
+
+	for _, tt := range tests {
+		if got := tt.product.LadderLimit(tt.completedCycles); got != tt.want {
+			t.Errorf("%s: LadderLimit(%d) = %s, want %s", tt.name, tt.completedCycles, got, tt.want)
+		}
+	}
+}
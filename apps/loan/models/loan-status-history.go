@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+type LoanStatusHistory struct {
+	ID         uint      `gorm:"primaryKey"`
+	LoanID     uint      `gorm:"index"` // Foreign key to Loan
+	Loan       Loan      `gorm:"foreignKey:LoanID;constraint:onDelete:CASCADE"`
+	FromStatus string    `gorm:"not null"`
+	ToStatus   string    `gorm:"not null;index"`
+	ActorID    *uint     `gorm:"index;default:null"` // User who triggered the change, null for system jobs and callbacks
+	Actor      *User     `gorm:"foreignKey:ActorID;constraint:onDelete:SET NULL"`
+	Reason     string    `gorm:"not null;default:''"`
+	CreatedAt  time.Time `gorm:"not null;index"`
+}
@@ -1,21 +1,72 @@
 package models
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"time"
 
 	"github.com/kifangamukundi/gm/loan/deserializers"
+	"github.com/kifangamukundi/gm/loan/money"
 	"github.com/kifangamukundi/gm/loan/services"
 )
 
+const (
+	LoanStatusPending    = "pending"
+	LoanStatusApproved   = "approved"
+	LoanStatusRejected   = "rejected"
+	LoanStatusDisbursing = "disbursing"
+	LoanStatusActive     = "active"
+	LoanStatusInArrears  = "in_arrears"
+	LoanStatusClosed     = "closed"
+	LoanStatusDefaulted  = "defaulted"
+	LoanStatusWrittenOff = "written_off"
+	LoanStatusCancelled  = "cancelled"
+)
+
+// loanTransitions lists the statuses a loan may move to from each status.
+// Statuses without an entry are terminal.
+var loanTransitions = map[string][]string{
+	LoanStatusPending:    {LoanStatusApproved, LoanStatusRejected, LoanStatusCancelled},
+	LoanStatusApproved:   {LoanStatusDisbursing, LoanStatusCancelled},
+	LoanStatusDisbursing: {LoanStatusActive, LoanStatusApproved},
+	LoanStatusActive:     {LoanStatusInArrears, LoanStatusClosed},
+	LoanStatusInArrears:  {LoanStatusActive, LoanStatusClosed, LoanStatusDefaulted, LoanStatusWrittenOff},
+	LoanStatusDefaulted:  {LoanStatusInArrears, LoanStatusClosed, LoanStatusWrittenOff},
+}
+
+var ErrIllegalTransition = errors.New("illegal loan status transition")
+
+// ErrConcurrentTransition is returned when another request changed the loan's status between
+// it being read and being written, e.g. two officers approving the same loan at once.
+var ErrConcurrentTransition = errors.New("loan status was changed by another request")
+
+// CanTransition reports whether a loan in status from may move to status to.
+func CanTransition(from, to string) bool {
+	for _, allowed := range loanTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
 type Loan struct {
-	ID           uint                            `gorm:"primaryKey"`
-	Amount       float64                         `gorm:"not null"`
-	Interest     float64                         `gorm:"not null"`
-	Term         int                             `gorm:"not null"`
-	DefaultImage deserializers.DefaultImageSlice `json:"DefaultImage" gorm:"type:jsonb"`
-	Images       deserializers.DefaultImageSlice `json:"Images" gorm:"type:jsonb;serializer:json"`
+	ID            uint                            `gorm:"primaryKey"`
+	Amount        money.Amount                    `gorm:"not null"`
+	Interest      float64                         `gorm:"not null"` // Annual percentage rate, see PeriodRate
+	Term          int                             `gorm:"not null"`
+	RatePerPeriod bool                            `gorm:"not null;default:false"` // Interest is charged per period, as it was on loans made before rates became annual
+	DefaultImage  deserializers.DefaultImageSlice `json:"DefaultImage" gorm:"type:jsonb"`
+	Images        deserializers.DefaultImageSlice `json:"Images" gorm:"type:jsonb;serializer:json"`
+
+	// Repayment Terms
+	ProductID          *uint        `gorm:"index;default:null"`
+	Product            *LoanProduct `gorm:"foreignKey:ProductID;constraint:onDelete:SET NULL"`
+	InterestMethod     string       `gorm:"not null;default:'flat'"`    // flat, reducing_balance
+	RepaymentFrequency string       `gorm:"not null;default:'monthly'"` // daily, weekly, monthly
+	Fees               money.Amount `gorm:"not null;default:0"`         // Total fees spread across the schedule
+	GracePeriod        int          `gorm:"not null;default:0"`         // Periods before the first instalment falls due
 
 	// Loan Approval & Disbursement
 	Status      string     `gorm:"not null;default:'pending'"`
@@ -23,13 +74,14 @@ type Loan struct {
 	Officer     *Officer   `gorm:"foreignKey:OfficerID;constraint:onDelete:SET NULL"`
 	ApprovedAt  *time.Time `gorm:"default:null"`
 	RejectedAt  *time.Time `gorm:"default:null"`
+	CancelledAt *time.Time `gorm:"default:null"`
 	DisbursedAt *time.Time `gorm:"default:null"`
 
 	// Loan Repayment Tracking
-	RemainingBalance float64    `gorm:"not null;default:0"`
-	IsFullyPaid      bool       `gorm:"default:false"`
-	DueDate          *time.Time `gorm:"default:null"`
-	LastPaymentDate  *time.Time `gorm:"default:null"`
+	RemainingBalance money.Amount `gorm:"not null;default:0"`
+	IsFullyPaid      bool         `gorm:"default:false"`
+	DueDate          *time.Time   `gorm:"default:null"`
+	LastPaymentDate  *time.Time   `gorm:"default:null"`
 
 	// Borrower Details
 	MemberID uint   `gorm:"index"`
@@ -43,6 +95,11 @@ type Loan struct {
 	AgentID uint  `gorm:"index"`
 	Agent   Agent `gorm:"foreignKey:AgentID;constraint:onDelete:CASCADE"`
 
+	// Top-ups, see RefinanceModel
+	RefinancedLoanID *uint        `gorm:"index;default:null"` // Loan this one settles when it is paid out
+	RefinancedLoan   *Loan        `gorm:"foreignKey:RefinancedLoanID;constraint:onDelete:SET NULL"`
+	SettlementAmount money.Amount `gorm:"not null;default:0"` // Principal applied to the refinanced loan rather than paid out
+
 	// Other Loan Information
 	LoanPurpose *string   `gorm:"not null;index"`
 	CreatedAt   time.Time `gorm:"not null"`
@@ -116,7 +173,7 @@ func (m *LoanModel) GetLoans(skip, limit int, sortOrder, sortByColumn, searchReg
 func (m *LoanModel) GetLoanByFieldPreloaded(field, value string) (*Loan, error) {
 	var loan Loan
 
-	preloads := []string{"Agent", "Group", "Member"}
+	preloads := []string{"Agent", "Group", "Member", "Product"}
 
 	result, err := m.Service.GetEntityByFieldWithPreload(&loan, field, value, preloads...)
 	if err != nil {
@@ -132,43 +189,193 @@ func (m *LoanModel) GetLoanByFieldPreloaded(field, value string) (*Loan, error)
 	return loanPtr, nil
 }
 
-func (m *LoanModel) ApproveLoan(id uint, status string) (Loan, error) {
+// TransitionLoan moves a loan to a new status, applying any extra field changes in apply,
+// and records the move in the loan's status history. Moves not allowed by the state
+// machine fail with ErrIllegalTransition.
+//
+// Only the status and the columns apply sets are written, and only if the status is still
+// the one that was read, so of two requests racing to move the same loan one fails with
+// ErrConcurrentTransition and balances written by repayments meanwhile are kept. The update,
+// the history entry and any lien changes on the loan's collateral are written in one transaction.
+func (m *LoanModel) TransitionLoan(id uint, to string, actorId *uint, reason string, apply func(loan *Loan), columns ...string) (Loan, error) {
+	return m.transitionLoanFrom(id, "", to, actorId, reason, apply, columns...)
+}
+
+// transitionLoanFrom is TransitionLoan for moves that are only allowed out of one status,
+// such as approval, which the state machine also allows when a payout fails.
+func (m *LoanModel) transitionLoanFrom(id uint, expected, to string, actorId *uint, reason string, apply func(loan *Loan), columns ...string) (Loan, error) {
 	loan := &Loan{ID: uint(id)}
 
-	_, err := m.Service.GetEntityByID(loan, uint(id))
+	err := m.Service.WithTransaction(func(tx services.Service) error {
+		if _, err := tx.GetEntityByID(loan, uint(id)); err != nil {
+			return fmt.Errorf("loan not found: %v", err)
+		}
+
+		from := loan.Status
+		if !CanTransition(from, to) || (expected != "" && from != expected) {
+			return fmt.Errorf("%w: %s to %s", ErrIllegalTransition, from, to)
+		}
+
+		loan.Status = to
+		if apply != nil {
+			apply(loan)
+		}
+
+		columns = append([]string{"status"}, append(columns, "updated_at")...)
+		updated, err := tx.UpdateEntityColumns(loan, map[string]interface{}{"status": from}, columns...)
+		if err != nil {
+			return fmt.Errorf("failed to update loan: %v", err)
+		}
+		if !updated {
+			return fmt.Errorf("%w: expected %s", ErrConcurrentTransition, from)
+		}
+
+		history := LoanStatusHistory{
+			LoanID:     loan.ID,
+			FromStatus: from,
+			ToStatus:   to,
+			ActorID:    actorId,
+			Reason:     reason,
+		}
+
+		if err := tx.CreateEntity(&history); err != nil {
+			return fmt.Errorf("failed to record status history: %v", err)
+		}
+
+		return updateLiens(tx, loan.ID, to, time.Now())
+	})
 	if err != nil {
-		return Loan{}, fmt.Errorf("loan not found: %v", err)
+		return Loan{}, err
 	}
 
+	return *loan, nil
+}
+
+func (m *LoanModel) ApproveLoan(id, officerId, actorId uint) (Loan, error) {
 	now := time.Now()
 
-	loan.Status = status
-	loan.ApprovedAt = &now
+	return m.transitionLoanFrom(id, LoanStatusPending, LoanStatusApproved, &actorId, "Approved by officer", func(loan *Loan) {
+		loan.ApprovedAt = &now
+		loan.OfficerID = &officerId
+	}, "approved_at", "officer_id")
+}
 
-	if err := m.Service.UpdateEntity(loan); err != nil {
+func (m *LoanModel) RejectLoan(id, officerId, actorId uint, reason string) (Loan, error) {
+	now := time.Now()
+
+	if reason == "" {
+		reason = "Rejected by officer"
+	}
+
+	return m.TransitionLoan(id, LoanStatusRejected, &actorId, reason, func(loan *Loan) {
+		loan.RejectedAt = &now
+		loan.OfficerID = &officerId
+	}, "rejected_at", "officer_id")
+}
+
+// CancelLoan withdraws a pending loan, e.g. one raised against the wrong member. The reason
+// is kept in the loan's status history.
+func (m *LoanModel) CancelLoan(id, actorId uint, reason string) (Loan, error) {
+	now := time.Now()
+
+	return m.transitionLoanFrom(id, LoanStatusPending, LoanStatusCancelled, &actorId, reason, func(loan *Loan) {
+		loan.CancelledAt = &now
+	}, "cancelled_at")
+}
+
+// DefaultLoan marks a loan in arrears as defaulted, after which its guarantees can be called
+func (m *LoanModel) DefaultLoan(id, officerId, actorId uint, reason string) (Loan, error) {
+	if reason == "" {
+		reason = "Declared in default by officer"
+	}
+
+	return m.transitionLoanFrom(id, LoanStatusInArrears, LoanStatusDefaulted, &actorId, reason, func(loan *Loan) {
+		loan.OfficerID = &officerId
+	}, "officer_id")
+}
+
+// ClearLoanImages forgets the loan's uploaded images once they have been deleted from storage
+func (m *LoanModel) ClearLoanImages(id uint) error {
+	loan := &Loan{ID: id}
+
+	if _, err := m.Service.GetEntityByID(loan, id); err != nil {
+		return fmt.Errorf("loan not found: %v", err)
+	}
+
+	loan.DefaultImage = nil
+	loan.Images = nil
+
+	return m.UpdateLoanColumns(loan, "default_image", "images")
+}
+
+// UpdateLoanColumns saves only the given columns of the loan. The rest of the row is left to
+// whichever request is changing it, such as a status transition.
+func (m *LoanModel) UpdateLoanColumns(loan *Loan, columns ...string) error {
+	updated, err := m.Service.UpdateEntityColumns(loan, nil, append(columns, "updated_at")...)
+	if err != nil {
+		return fmt.Errorf("failed to update loan: %v", err)
+	}
+	if !updated {
+		return fmt.Errorf("loan %d not found", loan.ID)
+	}
+	return nil
+}
+
+// ActivateLoan marks a disbursing loan as active once the payout has reached the borrower.
+func (m *LoanModel) ActivateLoan(id uint, disbursedAt time.Time, reason string) (Loan, error) {
+	return m.TransitionLoan(id, LoanStatusActive, nil, reason, func(loan *Loan) {
+		loan.DisbursedAt = &disbursedAt
+	}, "disbursed_at")
+}
+
+// RecordRepayment stores the balance left after a payment has been allocated and closes
+// the loan once nothing is left to pay. The loan is the one read before its instalments were,
+// and the balance is only written while it is still the one read, so a penalty charged in
+// the meantime fails the repayment instead of being lost.
+func (m *LoanModel) RecordRepayment(loan *Loan, remainingBalance money.Amount, paidAt time.Time) (Loan, error) {
+	previousBalance := loan.RemainingBalance
+	loan.RemainingBalance = remainingBalance
+	loan.LastPaymentDate = &paidAt
+	loan.IsFullyPaid = loan.RemainingBalance <= 0
+
+	updated, err := m.Service.UpdateEntityColumns(loan, map[string]interface{}{"remaining_balance": previousBalance}, "remaining_balance", "last_payment_date", "is_fully_paid", "updated_at")
+	if err != nil {
 		return Loan{}, fmt.Errorf("failed to update loan: %v", err)
 	}
+	if !updated {
+		return Loan{}, fmt.Errorf("loan %d was changed by another request", loan.ID)
+	}
+
+	if loan.IsFullyPaid && CanTransition(loan.Status, LoanStatusClosed) {
+		return m.TransitionLoan(loan.ID, LoanStatusClosed, nil, "Loan fully repaid", nil)
+	}
 
 	return *loan, nil
 }
 
-func (m *LoanModel) RejectLoan(id, officerId uint, status string) (Loan, error) {
-	loan := &Loan{ID: uint(id)}
+func (m *LoanModel) GetLoansByStatus(status string) ([]Loan, error) {
+	var loans []Loan
 
-	_, err := m.Service.GetEntityByID(loan, uint(id))
+	result, err := m.Service.GetEntitiesByFields(&loans, map[string]interface{}{"status": status})
 	if err != nil {
-		return Loan{}, fmt.Errorf("loan not found: %v", err)
+		return nil, fmt.Errorf("failed to get loans: %v", err)
 	}
 
-	now := time.Now()
+	loansPtr, ok := result.(*[]Loan)
+	if !ok {
+		return nil, fmt.Errorf("unexpected result type: %T", result)
+	}
 
-	loan.Status = status
-	loan.RejectedAt = &now
-	loan.OfficerID = &officerId
+	return *loansPtr, nil
+}
 
-	if err := m.Service.UpdateEntity(loan); err != nil {
-		return Loan{}, fmt.Errorf("failed to update loan: %v", err)
+func (m *LoanModel) GetLoanStatusHistory(loanId uint) ([]LoanStatusHistory, error) {
+	var history []LoanStatusHistory
+
+	result, err := m.Service.GetOrderedEntitiesByField(&history, "loan_id", loanId, "id asc", "Actor")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get status history: %v", err)
 	}
 
-	return *loan, nil
+	return *result.(*[]LoanStatusHistory), nil
 }
@@ -0,0 +1,186 @@
+package models
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/kifangamukundi/gm/loan/loanrepository"
+	"github.com/kifangamukundi/gm/loan/money"
+	"github.com/kifangamukundi/gm/loan/services"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestService returns a service over a fresh sqlite database holding the tables of entities
+func newTestService(t *testing.T, entities ...interface{}) services.Service {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "loan.db")), &gorm.Config{
+		Logger:                                   logger.Discard,
+		DisableForeignKeyConstraintWhenMigrating: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	if err := db.AutoMigrate(entities...); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+
+	return services.NewEntityService(loanrepository.NewLoanRepository(db))
+}
+
+// racingService runs race after every entity it reads by ID, standing in for another request
+// that writes between a read and the write that follows it. Writes in race go through the
+// same transaction as the read.
+type racingService struct {
+	services.Service
+	race func(tx services.Service, entity interface{})
+}
+
+func (s racingService) GetEntityByID(entity interface{}, id uint) (interface{}, error) {
+	result, err := s.Service.GetEntityByID(entity, id)
+	if err == nil {
+		s.race(s.Service, entity)
+	}
+	return result, err
+}
+
+func (s racingService) WithTransaction(fn func(tx services.Service) error) error {
+	return s.Service.WithTransaction(func(tx services.Service) error {
+		return fn(racingService{Service: tx, race: s.race})
+	})
+}
+
+// createTestLoan stores a loan in status with the given balance
+func createTestLoan(t *testing.T, service services.Service, status string, balance money.Amount) *Loan {
+	t.Helper()
+
+	purpose := "Stock for the shop"
+	loan := &Loan{Amount: money.FromShillings(10000), Term: 2, Status: status, RemainingBalance: balance, LoanPurpose: &purpose}
+	if err := service.CreateEntity(loan); err != nil {
+		t.Fatalf("failed to create loan: %v", err)
+	}
+	return loan
+}
+
+func getTestLoan(t *testing.T, service services.Service, id uint) Loan {
+	t.Helper()
+
+	loan := Loan{ID: id}
+	if _, err := service.GetEntityByID(&loan, id); err != nil {
+		t.Fatalf("failed to get loan %d: %v", id, err)
+	}
+	return loan
+}
+
+func TestTransitionLoanKeepsColumnsItDoesNotOwn(t *testing.T) {
+	service := newTestService(t, &Loan{}, &LoanStatusHistory{}, &Collateral{})
+	loan := createTestLoan(t, service, LoanStatusInArrears, money.FromShillings(6000))
+
+	// A repayment lands between the transition reading the loan and writing it. The transition
+	// holds the balance it read, which must not overwrite the newer one.
+	if _, err := NewLoanModel(service).RecordRepayment(loan, money.FromShillings(2500), loan.CreatedAt); err != nil {
+		t.Fatalf("RecordRepayment returned %v", err)
+	}
+	defaulted, err := NewLoanModel(service).TransitionLoan(loan.ID, LoanStatusDefaulted, nil, "Declared in default", func(stale *Loan) {
+		stale.RemainingBalance = money.FromShillings(6000)
+		stale.LastPaymentDate = nil
+	})
+	if err != nil {
+		t.Fatalf("TransitionLoan returned %v", err)
+	}
+	if defaulted.Status != LoanStatusDefaulted {
+		t.Errorf("TransitionLoan returned a %s loan", defaulted.Status)
+	}
+
+	stored := getTestLoan(t, service, loan.ID)
+	if stored.Status != LoanStatusDefaulted {
+		t.Errorf("stored loan is %s, want %s", stored.Status, LoanStatusDefaulted)
+	}
+	if stored.RemainingBalance != money.FromShillings(2500) || stored.LastPaymentDate == nil {
+		t.Errorf("transition wrote back remaining balance %s and last payment %v", stored.RemainingBalance, stored.LastPaymentDate)
+	}
+}
+
+func TestTransitionLoanWritesTheColumnsApplySets(t *testing.T) {
+	service := newTestService(t, &Loan{}, &LoanStatusHistory{}, &Collateral{})
+	loan := createTestLoan(t, service, LoanStatusPending, 0)
+
+	if _, err := NewLoanModel(service).ApproveLoan(loan.ID, 4, 9); err != nil {
+		t.Fatalf("ApproveLoan returned %v", err)
+	}
+
+	stored := getTestLoan(t, service, loan.ID)
+	if stored.Status != LoanStatusApproved || stored.ApprovedAt == nil || stored.OfficerID == nil || *stored.OfficerID != 4 {
+		t.Errorf("approved loan is %s, approved at %v by officer %v", stored.Status, stored.ApprovedAt, stored.OfficerID)
+	}
+
+	// The loan is no longer pending, so a second approval racing the first loses
+	if _, err := NewLoanModel(service).ApproveLoan(loan.ID, 5, 10); !errors.Is(err, ErrIllegalTransition) {
+		t.Errorf("second ApproveLoan returned %v, want %v", err, ErrIllegalTransition)
+	}
+}
+
+func TestCanTransition(t *testing.T) {
+	tests := []struct {
+		from, to string
+		want     bool
+	}{
+		{from: LoanStatusPending, to: LoanStatusApproved, want: true},
+		{from: LoanStatusPending, to: LoanStatusCancelled, want: true},
+		{from: LoanStatusPending, to: LoanStatusActive, want: false},
+		{from: LoanStatusApproved, to: LoanStatusDisbursing, want: true},
+		{from: LoanStatusApproved, to: LoanStatusActive, want: false},
+		{from: LoanStatusApproved, to: LoanStatusApproved, want: false},
+		{from: LoanStatusDisbursing, to: LoanStatusActive, want: true},
+		{from: LoanStatusDisbursing, to: LoanStatusApproved, want: true},
+		{from: LoanStatusActive, to: LoanStatusClosed, want: true},
+		{from: LoanStatusActive, to: LoanStatusActive, want: false},
+		{from: LoanStatusActive, to: LoanStatusDefaulted, want: false},
+		{from: LoanStatusInArrears, to: LoanStatusDefaulted, want: true},
+		{from: LoanStatusInArrears, to: LoanStatusWrittenOff, want: true},
+		{from: LoanStatusDefaulted, to: LoanStatusInArrears, want: true},
+		{from: LoanStatusClosed, to: LoanStatusActive, want: false},
+		{from: LoanStatusRejected, to: LoanStatusApproved, want: false},
+		{from: LoanStatusWrittenOff, to: LoanStatusClosed, want: false},
+		{from: LoanStatusCancelled, to: LoanStatusPending, want: false},
+	}
+
+	for _, tt := range tests {
+		if got := CanTransition(tt.from, tt.to); got != tt.want {
+			t.Errorf("CanTransition(%s, %s) = %t, want %t", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestTransitionLoanRecordsHistory(t *testing.T) {
+	service := newTestService(t, &Loan{}, &LoanStatusHistory{}, &Collateral{})
+	loan := createTestLoan(t, service, LoanStatusPending, 0)
+	loanModel := NewLoanModel(service)
+
+	actorId := uint(9)
+	if _, err := loanModel.CancelLoan(loan.ID, actorId, "Wrong member"); err != nil {
+		t.Fatalf("CancelLoan returned %v", err)
+	}
+	if _, err := loanModel.TransitionLoan(loan.ID, LoanStatusApproved, nil, "Approved", nil); !errors.Is(err, ErrIllegalTransition) {
+		t.Errorf("approving a cancelled loan returned %v, want %v", err, ErrIllegalTransition)
+	}
+
+	history, err := loanModel.GetLoanStatusHistory(loan.ID)
+	if err != nil {
+		t.Fatalf("GetLoanStatusHistory returned %v", err)
+	}
+	if len(history) != 1 {
+		t.Fatalf("got %d history entries, want 1", len(history))
+	}
+	entry := history[0]
+	if entry.FromStatus != LoanStatusPending || entry.ToStatus != LoanStatusCancelled || entry.Reason != "Wrong member" || entry.ActorID == nil || *entry.ActorID != actorId {
+		t.Errorf("history entry = %+v", entry)
+	}
+	if stored := getTestLoan(t, service, loan.ID); stored.Status != LoanStatusCancelled || stored.CancelledAt == nil {
+		t.Errorf("cancelled loan is %s, cancelled at %v", stored.Status, stored.CancelledAt)
+	}
+}
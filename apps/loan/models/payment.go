@@ -1,20 +1,111 @@
 package models
 
-import "time"
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/kifangamukundi/gm/loan/money"
+	"github.com/kifangamukundi/gm/loan/services"
+)
+
+const (
+	PaymentStatusPending = "Pending"
+	PaymentStatusSuccess = "Success"
+	PaymentStatusFailed  = "Failed"
+
+	PaymentModeMpesa     = "mpesa"
+	PaymentModeRefinance = "refinance" // Settled from the principal of a top-up loan
+	PaymentModeGuarantee = "guarantee" // Collected from a guarantor of a defaulted loan
+)
+
+// ErrPaymentNotPending is returned when a payment's outcome has already been recorded
+var ErrPaymentNotPending = errors.New("payment is not pending")
 
 type Payment struct {
-	ID                uint      `gorm:"primaryKey"`
-	LoanID            uint      `gorm:"index"` // Foreign key to Loan
-	Loan              Loan      `gorm:"foreignKey:LoanID;constraint:onDelete:CASCADE"`
-	Amount            float64   `gorm:"not null"`                   // Payment amount
-	PhoneNumber       string    `gorm:"not null"`                   // Customer phone number
-	CheckoutRequestID string    `gorm:"uniqueIndex;not null"`       // M-Pesa STK Push ID
-	MerchantRequestID string    `gorm:"index"`                      // Merchant request ID from M-Pesa
-	Status            string    `gorm:"not null;default:'Pending'"` // Payment status (Pending, Success, Failed)
-	ResponseCode      string    `gorm:"not null"`                   // Response code from M-Pesa
-	ResponseDesc      string    `gorm:"not null"`                   // Response description
-	TransactionDesc   string    `gorm:"not null"`                   // Description of the transaction
-	PaymentMode       string    `gorm:"not null"`                   // Payment method (e.g., 'mpesa', 'bank', 'cash')
-	CreatedAt         time.Time `gorm:"not null"`
-	UpdatedAt         time.Time `gorm:"not null"`
+	ID                uint         `gorm:"primaryKey"`
+	LoanID            uint         `gorm:"index"` // Foreign key to Loan
+	Loan              Loan         `gorm:"foreignKey:LoanID;constraint:onDelete:CASCADE"`
+	Amount            money.Amount `gorm:"not null"`                   // Payment amount
+	PhoneNumber       string       `gorm:"not null"`                   // Customer phone number
+	CheckoutRequestID string       `gorm:"uniqueIndex;not null"`       // M-Pesa STK Push ID
+	MerchantRequestID string       `gorm:"index"`                      // Merchant request ID from M-Pesa
+	Status            string       `gorm:"not null;default:'Pending'"` // Payment status (Pending, Success, Failed)
+	ResponseCode      string       `gorm:"not null"`                   // Response code from M-Pesa
+	ResponseDesc      string       `gorm:"not null"`                   // Response description
+	TransactionDesc   string       `gorm:"not null"`                   // Description of the transaction
+	PaymentMode       string       `gorm:"not null"`                   // Payment method (e.g., 'mpesa', 'bank', 'cash', 'refinance')
+	CreatedAt         time.Time    `gorm:"not null"`
+	UpdatedAt         time.Time    `gorm:"not null"`
+
+	// STK Callback Result
+	ResultCode         *int       `gorm:"default:null"` // Result code from the STK callback
+	ResultDesc         string     // Result description from the STK callback
+	MpesaReceiptNumber string     `gorm:"index"`        // M-Pesa receipt for successful payments
+	PaidAt             *time.Time `gorm:"default:null"` // When the customer completed the payment
+}
+
+type PaymentModel struct {
+	Service services.Service
+}
+
+func NewPaymentModel(service services.Service) *PaymentModel {
+	return &PaymentModel{Service: service}
+}
+
+func (m *PaymentModel) CreatePayment(payment *Payment) error {
+	if err := m.Service.CreateEntity(payment); err != nil {
+		return fmt.Errorf("failed to create payment: %v", err)
+	}
+
+	return nil
+}
+
+func (m *PaymentModel) GetPaymentByField(field, value string) (*Payment, error) {
+	var payment Payment
+
+	result, err := m.Service.GetEntityByField(field, value, &payment)
+	if err != nil {
+		log.Printf("Error fetching payment by %s: %v", field, err)
+		return nil, err
+	}
+
+	return result.(*Payment), nil
+}
+
+// FinalizePayment stores the outcome reported by the STK push callback. For successful
+// payments amount is the value M-Pesa actually collected from the customer. Only a pending
+// payment can be finalized, so a replayed callback fails with ErrPaymentNotPending.
+func (m *PaymentModel) FinalizePayment(id uint, status string, amount money.Amount, receipt string, resultCode int, resultDesc string) (Payment, error) {
+	payment := &Payment{ID: id}
+
+	_, err := m.Service.GetEntityByID(payment, id)
+	if err != nil {
+		return Payment{}, fmt.Errorf("payment not found: %v", err)
+	}
+	if payment.Status != PaymentStatusPending {
+		return Payment{}, fmt.Errorf("%w: it is %s", ErrPaymentNotPending, payment.Status)
+	}
+
+	payment.Status = status
+	payment.ResultCode = &resultCode
+	payment.ResultDesc = resultDesc
+
+	if status == PaymentStatusSuccess {
+		now := time.Now()
+		payment.Amount = amount
+		payment.MpesaReceiptNumber = receipt
+		payment.PaidAt = &now
+	}
+
+	updated, err := m.Service.UpdateEntityIf(payment, map[string]interface{}{"status": PaymentStatusPending})
+	if err != nil {
+		return Payment{}, fmt.Errorf("failed to update payment: %v", err)
+	}
+	if !updated {
+		return Payment{}, fmt.Errorf("%w: it was finalized by another request", ErrPaymentNotPending)
+	}
+
+	return *payment, nil
 }
@@ -0,0 +1,211 @@
+package models
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/kifangamukundi/gm/loan/money"
+	"github.com/kifangamukundi/gm/loan/services"
+)
+
+// DefaultArrearsAfterDays is how many days late a loan may be before it is in arrears when its
+// product does not say
+const DefaultArrearsAfterDays = 1
+
+const (
+	PenaltyTypeNone             = "none"
+	PenaltyTypeFlat             = "flat"
+	PenaltyTypePercentOfArrears = "percent_of_arrears"
+	PenaltyTypePerDay           = "per_day"
+)
+
+// PenaltyCharge is a penalty accrued against an overdue instalment on a given day.
+// The unique index on instalment and day keeps a re-run of the daily job from charging twice.
+type PenaltyCharge struct {
+	ID           uint         `gorm:"primaryKey"`
+	LoanID       uint         `gorm:"index"` // Foreign key to Loan
+	Loan         Loan         `gorm:"foreignKey:LoanID;constraint:onDelete:CASCADE"`
+	InstalmentID uint         `gorm:"uniqueIndex:idx_penalty_instalment_day"` // Foreign key to Instalment
+	Instalment   Instalment   `gorm:"foreignKey:InstalmentID;constraint:onDelete:CASCADE"`
+	ChargeDate   string       `gorm:"uniqueIndex:idx_penalty_instalment_day;size:10;not null"` // Day the charge belongs to, YYYY-MM-DD
+	PenaltyType  string       `gorm:"not null"`
+	DaysOverdue  int          `gorm:"not null"`
+	Amount       money.Amount `gorm:"not null"`
+	CreatedAt    time.Time    `gorm:"not null"`
+}
+
+// PenaltyRule describes how late instalments are penalised.
+//   - flat charges Amount once when the instalment becomes late
+//   - percent_of_arrears charges Percent percent of the unpaid instalment once when it becomes late
+//   - per_day charges Amount for every day late
+//
+// Nothing is charged during the first GraceDays days and the total charged on one instalment
+// never exceeds Cap when Cap is set.
+type PenaltyRule struct {
+	Type             string
+	Amount           money.Amount
+	Percent          float64
+	Cap              money.Amount
+	GraceDays        int
+	ArrearsAfterDays int
+}
+
+type PenaltyModel struct {
+	Service services.Service
+}
+
+func NewPenaltyModel(service services.Service) *PenaltyModel {
+	return &PenaltyModel{Service: service}
+}
+
+// DaysOverdue returns the number of whole days between the due date and asOf
+func DaysOverdue(dueDate, asOf time.Time) int {
+	due := time.Date(dueDate.Year(), dueDate.Month(), dueDate.Day(), 0, 0, 0, 0, time.UTC)
+	day := time.Date(asOf.Year(), asOf.Month(), asOf.Day(), 0, 0, 0, 0, time.UTC)
+	return int(day.Sub(due).Hours() / 24)
+}
+
+// PenaltyDue returns the penalty to charge now on an instalment that is daysOverdue days late.
+// Per day penalties catch up on days the job did not run, so the result only depends on how
+// late the instalment is and how much penalty it already carries. Percentages are rounded
+// half away from zero to the cent.
+func (r PenaltyRule) PenaltyDue(instalment Instalment, daysOverdue int) money.Amount {
+	chargeableDays := daysOverdue - r.GraceDays
+	if chargeableDays <= 0 {
+		return 0
+	}
+
+	var amount money.Amount
+	switch r.Type {
+	case PenaltyTypeFlat:
+		if instalment.Penalty > 0 {
+			return 0
+		}
+		amount = r.Amount
+	case PenaltyTypePercentOfArrears:
+		if instalment.Penalty > 0 {
+			return 0
+		}
+		arrears := instalment.Balance() - instalment.Outstanding(ComponentPenalty)
+		amount = arrears.Percent(r.Percent)
+	case PenaltyTypePerDay:
+		amount = r.Amount*money.Amount(chargeableDays) - instalment.Penalty
+	default:
+		return 0
+	}
+
+	if r.Cap > 0 && instalment.Penalty+amount > r.Cap {
+		amount = r.Cap - instalment.Penalty
+	}
+	if amount <= 0 {
+		return 0
+	}
+
+	return amount
+}
+
+// GetOverdueInstalments returns unpaid instalments that fell due before asOf on active or
+// in arrears loans, with each loan and its product preloaded.
+func (m *PenaltyModel) GetOverdueInstalments(asOf time.Time) ([]Instalment, error) {
+	var instalments []Instalment
+
+	result, err := m.Service.GetOverdueInstalments(&instalments, asOf, []string{LoanStatusActive, LoanStatusInArrears}, nil, "Loan", "Loan.Product")
+	if err != nil {
+		return nil, err
+	}
+
+	instalmentsPtr, ok := result.(*[]Instalment)
+	if !ok {
+		return nil, fmt.Errorf("unexpected result type: %T", result)
+	}
+
+	return *instalmentsPtr, nil
+}
+
+// ChargePenalty accrues a penalty for the given day on the instalment and its loan balance and
+// posts it to the ledger, all in one transaction. It returns nil without charging when the
+// instalment was already charged that day.
+func (m *PenaltyModel) ChargePenalty(instalmentId uint, day string, amount money.Amount, daysOverdue int, penaltyType string) (*PenaltyCharge, error) {
+	var charge *PenaltyCharge
+
+	err := m.Service.WithTransaction(func(tx services.Service) error {
+		charged, err := tx.CountEntities(&PenaltyCharge{}, map[string]interface{}{"instalment_id": instalmentId, "charge_date": day})
+		if err != nil {
+			return err
+		}
+		if charged > 0 {
+			return nil
+		}
+
+		instalment := &Instalment{ID: instalmentId}
+		if _, err := tx.GetEntityByID(instalment, instalmentId); err != nil {
+			return fmt.Errorf("instalment not found: %v", err)
+		}
+
+		record := PenaltyCharge{
+			LoanID:       instalment.LoanID,
+			InstalmentID: instalment.ID,
+			ChargeDate:   day,
+			PenaltyType:  penaltyType,
+			DaysOverdue:  daysOverdue,
+			Amount:       amount,
+		}
+		if err := tx.CreateEntity(&record); err != nil {
+			return fmt.Errorf("failed to record penalty: %v", err)
+		}
+
+		previous := instalment.Penalty
+		instalment.Penalty += amount
+		updated, err := tx.UpdateEntityColumns(instalment, map[string]interface{}{"penalty": previous}, "penalty", "updated_at")
+		if err != nil {
+			return fmt.Errorf("failed to update instalment: %v", err)
+		}
+		if !updated {
+			return fmt.Errorf("instalment %d was changed by another request", instalmentId)
+		}
+
+		loan := &Loan{ID: instalment.LoanID}
+		if _, err := tx.GetEntityByID(loan, instalment.LoanID); err != nil {
+			return fmt.Errorf("loan not found: %v", err)
+		}
+
+		previousBalance := loan.RemainingBalance
+		loan.RemainingBalance += amount
+		updated, err = tx.UpdateEntityColumns(loan, map[string]interface{}{"remaining_balance": previousBalance}, "remaining_balance", "updated_at")
+		if err != nil {
+			return fmt.Errorf("failed to update loan: %v", err)
+		}
+		if !updated {
+			return fmt.Errorf("loan %d was changed by another request", loan.ID)
+		}
+
+		if err := NewLedgerModel(tx).PostPenalty(record); err != nil {
+			return fmt.Errorf("error posting penalty to the ledger: %v", err)
+		}
+
+		charge = &record
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return charge, nil
+}
+
+func (m *PenaltyModel) GetLoanPenalties(loanId uint) ([]PenaltyCharge, error) {
+	var charges []PenaltyCharge
+
+	result, err := m.Service.GetAllEntititiesByFieldWithPreload(&charges, "loan_id", strconv.Itoa(int(loanId)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get penalties: %v", err)
+	}
+
+	chargesPtr, ok := result.(*[]PenaltyCharge)
+	if !ok {
+		return nil, fmt.Errorf("unexpected result type: %T", result)
+	}
+
+	return *chargesPtr, nil
+}
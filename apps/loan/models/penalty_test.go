@@ -0,0 +1,95 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/kifangamukundi/gm/loan/money"
+	"github.com/kifangamukundi/gm/loan/services"
+)
+
+func TestPenaltyDue(t *testing.T) {
+	unpaid := Instalment{Principal: 10000, Interest: 1000, PrincipalPaid: 1000}
+	penalised := func(penalty money.Amount) Instalment {
+		instalment := unpaid
+		instalment.Penalty = penalty
+		return instalment
+	}
+
+	tests := []struct {
+		name        string
+		rule        PenaltyRule
+		instalment  Instalment
+		daysOverdue int
+		want        money.Amount
+	}{
+		{name: "no penalty", rule: PenaltyRule{Type: PenaltyTypeNone, Amount: 500}, instalment: unpaid, daysOverdue: 10, want: 0},
+		{name: "not late", rule: PenaltyRule{Type: PenaltyTypeFlat, Amount: 500}, instalment: unpaid, daysOverdue: 0, want: 0},
+		{name: "within grace", rule: PenaltyRule{Type: PenaltyTypeFlat, Amount: 500, GraceDays: 3}, instalment: unpaid, daysOverdue: 3, want: 0},
+		{name: "flat once late", rule: PenaltyRule{Type: PenaltyTypeFlat, Amount: 500, GraceDays: 3}, instalment: unpaid, daysOverdue: 4, want: 500},
+		{name: "flat already charged", rule: PenaltyRule{Type: PenaltyTypeFlat, Amount: 500}, instalment: penalised(500), daysOverdue: 9, want: 0},
+		{name: "percent of arrears", rule: PenaltyRule{Type: PenaltyTypePercentOfArrears, Percent: 5}, instalment: unpaid, daysOverdue: 1, want: 500},
+		{name: "percent rounds half away from zero", rule: PenaltyRule{Type: PenaltyTypePercentOfArrears, Percent: 2.5}, instalment: Instalment{Principal: 333}, daysOverdue: 1, want: 8},
+		{name: "percent already charged", rule: PenaltyRule{Type: PenaltyTypePercentOfArrears, Percent: 5}, instalment: penalised(500), daysOverdue: 2, want: 0},
+		{name: "per day after grace", rule: PenaltyRule{Type: PenaltyTypePerDay, Amount: 100, GraceDays: 2}, instalment: unpaid, daysOverdue: 5, want: 300},
+		{name: "per day catches up", rule: PenaltyRule{Type: PenaltyTypePerDay, Amount: 100, GraceDays: 2}, instalment: penalised(100), daysOverdue: 5, want: 200},
+		{name: "per day up to the cap", rule: PenaltyRule{Type: PenaltyTypePerDay, Amount: 100, Cap: 250}, instalment: penalised(100), daysOverdue: 5, want: 150},
+		{name: "cap reached", rule: PenaltyRule{Type: PenaltyTypePerDay, Amount: 100, Cap: 250}, instalment: penalised(250), daysOverdue: 9, want: 0},
+		{name: "flat capped", rule: PenaltyRule{Type: PenaltyTypeFlat, Amount: 500, Cap: 300}, instalment: unpaid, daysOverdue: 1, want: 300},
+	}
+
+	for _, tt := range tests {
+		if got := tt.rule.PenaltyDue(tt.instalment, tt.daysOverdue); got != tt.want {
+			t.Errorf("%s: PenaltyDue = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestChargePenaltyKeepsAConcurrentPayment(t *testing.T) {
+	service := newTestService(t, append(ledgerEntities, &Loan{}, &Instalment{}, &PenaltyCharge{})...)
+	seedTestAccounts(t, service)
+	loan := createTestLoan(t, service, LoanStatusInArrears, 11000)
+	instalment := Instalment{LoanID: loan.ID, Number: 1, Principal: 10000, Interest: 1000}
+	if err := service.CreateEntity(&instalment); err != nil {
+		t.Fatalf("failed to create instalment: %v", err)
+	}
+
+	// A payment clears the interest after the penalty job has read the instalment
+	raced := false
+	racing := racingService{Service: service, race: func(tx services.Service, entity interface{}) {
+		read, ok := entity.(*Instalment)
+		if !ok || raced {
+			return
+		}
+		raced = true
+		paid := *read
+		paid.InterestPaid, paid.Status = 1000, InstalmentStatusPartiallyPaid
+		if _, err := tx.UpdateEntityColumns(&paid, nil, "interest_paid", "status"); err != nil {
+			t.Fatalf("failed to pay instalment: %v", err)
+		}
+	}}
+
+	charge, err := NewPenaltyModel(racing).ChargePenalty(instalment.ID, "2026-03-02", 500, 1, PenaltyTypeFlat)
+	if err != nil || charge == nil {
+		t.Fatalf("ChargePenalty returned %v, %v", charge, err)
+	}
+	if again, err := NewPenaltyModel(service).ChargePenalty(instalment.ID, "2026-03-02", 500, 1, PenaltyTypeFlat); err != nil || again != nil {
+		t.Errorf("second charge on the same day returned %v, %v", again, err)
+	}
+
+	stored := Instalment{ID: instalment.ID}
+	if _, err := service.GetEntityByID(&stored, instalment.ID); err != nil {
+		t.Fatalf("failed to get instalment: %v", err)
+	}
+	if stored.Penalty != 500 {
+		t.Errorf("instalment penalty = %s, want 5.00", stored.Penalty)
+	}
+	if stored.InterestPaid != 1000 || stored.Status != InstalmentStatusPartiallyPaid {
+		t.Errorf("penalty reverted the payment: interest paid %s, status %q", stored.InterestPaid, stored.Status)
+	}
+	if balance := getTestLoan(t, service, loan.ID).RemainingBalance; balance != 11500 {
+		t.Errorf("loan balance = %s, want 115.00", balance)
+	}
+	if balances := ledgerBalances(t, service); balances[AccountPenaltyIncome] != 500 {
+		t.Errorf("penalty income = %s, want 5.00", balances[AccountPenaltyIncome])
+	}
+}
@@ -0,0 +1,357 @@
+package models
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/kifangamukundi/gm/loan/money"
+	"github.com/kifangamukundi/gm/loan/services"
+)
+
+// ParThresholds are the days past due at which portfolio at risk is reported, PAR1 to PAR90
+var ParThresholds = []int{1, 30, 60, 90}
+
+// agingBuckets are the arrears aging ranges, inclusive of both ends. The last is open ended.
+var agingBuckets = []AgingBucket{
+	{Name: "current", MinDays: 0, MaxDays: 0},
+	{Name: "1-30", MinDays: 1, MaxDays: 30},
+	{Name: "31-60", MinDays: 31, MaxDays: 60},
+	{Name: "61-90", MinDays: 61, MaxDays: 90},
+	{Name: "91-180", MinDays: 91, MaxDays: 180},
+	{Name: "180+", MinDays: 181},
+}
+
+// PortfolioFilter narrows the portfolio reports. Zero IDs and nil dates are not applied; From
+// and To bound the day the loans were disbursed and both days are included.
+type PortfolioFilter struct {
+	AgentID   uint
+	OfficerID uint
+	GroupID   uint
+	RegionID  uint
+	From      *time.Time
+	To        *time.Time
+}
+
+// PortfolioLoan is one loan of the portfolio with what it still owes as at the report date
+type PortfolioLoan struct {
+	LoanID      uint
+	MemberID    uint
+	AgentID     uint
+	GroupID     uint
+	OfficerID   *uint
+	Status      string
+	DisbursedAt *time.Time
+	Outstanding money.Amount // Principal not yet repaid
+	Arrears     money.Amount // Everything owed on instalments already past due
+	DaysPastDue int          // Age of the oldest unpaid instalment
+	Bucket      string
+}
+
+// ParRatio is the share of outstanding principal held by loans at least Threshold days past due
+type ParRatio struct {
+	Threshold   int
+	Loans       int
+	Outstanding money.Amount
+	Ratio       float64 // Percent of the portfolio's outstanding principal
+}
+
+// AgingBucket totals the loans whose oldest unpaid instalment is MinDays to MaxDays past due
+type AgingBucket struct {
+	Name        string
+	MinDays     int
+	MaxDays     int
+	Loans       int
+	Outstanding money.Amount
+	Arrears     money.Amount
+	Share       float64 // Percent of the portfolio's outstanding principal
+}
+
+// PortfolioReport is the quality of the loans in the portfolio as at AsOf
+type PortfolioReport struct {
+	AsOf        time.Time
+	Filter      PortfolioFilter
+	LoanCount   int
+	Outstanding money.Amount
+	Arrears     money.Amount
+	Par         []ParRatio
+	Aging       []AgingBucket
+	Loans       []PortfolioLoan
+}
+
+type PortfolioModel struct {
+	Service services.Service
+}
+
+func NewPortfolioModel(service services.Service) *PortfolioModel {
+	return &PortfolioModel{Service: service}
+}
+
+// disbursedStatuses are the statuses a loan can be in once it has been paid out
+var disbursedStatuses = []string{LoanStatusActive, LoanStatusInArrears, LoanStatusDefaulted, LoanStatusClosed, LoanStatusWrittenOff}
+
+// portfolioLoans returns the loans that were in the portfolio at asOf and match the filter,
+// with the status each was in at the time
+func (m *PortfolioModel) portfolioLoans(filter PortfolioFilter, asOf time.Time) ([]Loan, error) {
+	conditions := map[string]interface{}{"status": disbursedStatuses}
+	if filter.AgentID != 0 {
+		conditions["agent_id"] = filter.AgentID
+	}
+	if filter.OfficerID != 0 {
+		conditions["officer_id"] = filter.OfficerID
+	}
+	if filter.GroupID != 0 {
+		conditions["group_id"] = filter.GroupID
+	}
+
+	if filter.RegionID != 0 {
+		var agents []Agent
+		result, err := m.Service.GetEntitiesByFields(&agents, map[string]interface{}{"region_id": filter.RegionID})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get region agents: %v", err)
+		}
+
+		agentIds := []uint{}
+		for _, agent := range *result.(*[]Agent) {
+			if filter.AgentID == 0 || agent.ID == filter.AgentID {
+				agentIds = append(agentIds, agent.ID)
+			}
+		}
+		if len(agentIds) == 0 {
+			return []Loan{}, nil
+		}
+		conditions["agent_id"] = agentIds
+	}
+
+	var loans []Loan
+	result, err := m.Service.GetEntitiesByFields(&loans, conditions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get portfolio loans: %v", err)
+	}
+
+	disbursed := []Loan{}
+	loanIds := []uint{}
+	for _, loan := range *result.(*[]Loan) {
+		if loan.DisbursedAt == nil || loan.DisbursedAt.After(asOf) {
+			continue
+		}
+		if filter.From != nil && DaysOverdue(*filter.From, *loan.DisbursedAt) < 0 {
+			continue
+		}
+		if filter.To != nil && DaysOverdue(*loan.DisbursedAt, *filter.To) < 0 {
+			continue
+		}
+		disbursed = append(disbursed, loan)
+		loanIds = append(loanIds, loan.ID)
+	}
+	if len(loanIds) == 0 {
+		return []Loan{}, nil
+	}
+
+	// Rewind each loan to the last status it entered on or before asOf
+	var history []LoanStatusHistory
+	result, err = m.Service.GetEntitiesByFields(&history, map[string]interface{}{"loan_id": loanIds})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get loan status history: %v", err)
+	}
+
+	statuses := map[uint]LoanStatusHistory{}
+	for _, entry := range *result.(*[]LoanStatusHistory) {
+		if entry.CreatedAt.After(asOf) {
+			continue
+		}
+		if latest, ok := statuses[entry.LoanID]; !ok || entry.ID > latest.ID {
+			statuses[entry.LoanID] = entry
+		}
+	}
+
+	matching := []Loan{}
+	for _, loan := range disbursed {
+		if entry, ok := statuses[loan.ID]; ok {
+			loan.Status = entry.ToStatus
+		}
+		if slices.Contains(PortfolioStatuses, loan.Status) {
+			matching = append(matching, loan)
+		}
+	}
+
+	sort.Slice(matching, func(i, j int) bool {
+		return matching[i].ID < matching[j].ID
+	})
+
+	return matching, nil
+}
+
+// schedulesAsOf returns the instalments of each loan as they stood at asOf, counting only the
+// penalties charged and allocations made by then.
+func (m *PortfolioModel) schedulesAsOf(loanIds []uint, asOf time.Time) (map[uint][]Instalment, error) {
+	schedules := map[uint][]Instalment{}
+	if len(loanIds) == 0 {
+		return schedules, nil
+	}
+	byLoan := map[string]interface{}{"loan_id": loanIds}
+
+	var restructures []LoanRestructure
+	result, err := m.Service.GetEntitiesByFields(&restructures, map[string]interface{}{"loan_id": loanIds, "status": RestructureStatusApproved})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get restructures: %v", err)
+	}
+	snapshots := map[uint]InstalmentSnapshot{}
+	for _, restructure := range *result.(*[]LoanRestructure) {
+		if restructure.DecidedAt == nil || !restructure.DecidedAt.After(asOf) {
+			continue
+		}
+		for _, snapshot := range restructure.OriginalSchedule {
+			snapshots[snapshot.ID] = snapshot
+		}
+	}
+
+	var charges []PenaltyCharge
+	result, err = m.Service.GetEntitiesByFields(&charges, byLoan)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get penalty charges: %v", err)
+	}
+	laterPenalties := map[uint]money.Amount{}
+	for _, charge := range *result.(*[]PenaltyCharge) {
+		if charge.CreatedAt.After(asOf) {
+			laterPenalties[charge.InstalmentID] += charge.Amount
+		}
+	}
+
+	var allocations []PaymentAllocation
+	result, err = m.Service.GetEntitiesByFields(&allocations, byLoan)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get allocations: %v", err)
+	}
+	paid := map[uint][]PaymentAllocation{}
+	for _, allocation := range *result.(*[]PaymentAllocation) {
+		if allocation.InstalmentID != nil && !allocation.CreatedAt.After(asOf) {
+			paid[*allocation.InstalmentID] = append(paid[*allocation.InstalmentID], allocation)
+		}
+	}
+
+	var instalments []Instalment
+	result, err = m.Service.GetEntitiesByFields(&instalments, byLoan)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get instalments: %v", err)
+	}
+
+	for _, instalment := range *result.(*[]Instalment) {
+		if instalment.CreatedAt.After(asOf) {
+			continue
+		}
+		if instalment.Status == InstalmentStatusRestructured {
+			snapshot, ok := snapshots[instalment.ID]
+			if !ok {
+				continue
+			}
+			instalment.Principal, instalment.Interest, instalment.Fees, instalment.Penalty = snapshot.Principal, snapshot.Interest, snapshot.Fees, snapshot.Penalty
+		}
+
+		instalment.Penalty = max(instalment.Penalty-laterPenalties[instalment.ID], 0)
+		instalment.PenaltyPaid, instalment.FeesPaid, instalment.InterestPaid, instalment.PrincipalPaid = 0, 0, 0, 0
+		for _, allocation := range paid[instalment.ID] {
+			instalment.pay(allocation.Component, allocation.Amount)
+		}
+
+		schedules[instalment.LoanID] = append(schedules[instalment.LoanID], instalment)
+	}
+
+	return schedules, nil
+}
+
+// agingBucket names the bucket a loan daysPastDue falls into
+func agingBucket(daysPastDue int) string {
+	for i := len(agingBuckets) - 1; i > 0; i-- {
+		if daysPastDue >= agingBuckets[i].MinDays {
+			return agingBuckets[i].Name
+		}
+	}
+	return agingBuckets[0].Name
+}
+
+// percentOf returns part as a percentage of whole, 0 when whole is nothing
+func percentOf(part, whole money.Amount) float64 {
+	if whole <= 0 {
+		return 0
+	}
+	return float64(part) / float64(whole) * 100
+}
+
+// Report computes portfolio at risk and arrears aging over the loans matching the filter
+// that were in the portfolio at asOf, from their repayment schedules as they stood then
+func (m *PortfolioModel) Report(filter PortfolioFilter, asOf time.Time) (PortfolioReport, error) {
+	report := PortfolioReport{AsOf: asOf, Filter: filter, Par: []ParRatio{}, Aging: []AgingBucket{}, Loans: []PortfolioLoan{}}
+
+	loans, err := m.portfolioLoans(filter, asOf)
+	if err != nil {
+		return report, err
+	}
+
+	loanIds := make([]uint, 0, len(loans))
+	for _, loan := range loans {
+		loanIds = append(loanIds, loan.ID)
+	}
+
+	schedules, err := m.schedulesAsOf(loanIds, asOf)
+	if err != nil {
+		return report, err
+	}
+
+	for _, loan := range loans {
+		instalments := schedules[loan.ID]
+
+		row := PortfolioLoan{
+			LoanID:      loan.ID,
+			MemberID:    loan.MemberID,
+			AgentID:     loan.AgentID,
+			GroupID:     loan.GroupID,
+			OfficerID:   loan.OfficerID,
+			Status:      loan.Status,
+			DisbursedAt: loan.DisbursedAt,
+		}
+		for _, instalment := range instalments {
+			row.Outstanding += instalment.Outstanding(ComponentPrincipal)
+			if instalment.Balance() <= 0 {
+				continue
+			}
+			if overdue := DaysOverdue(instalment.DueDate, asOf); overdue > 0 {
+				row.Arrears += instalment.Balance()
+				row.DaysPastDue = max(row.DaysPastDue, overdue)
+			}
+		}
+		row.Bucket = agingBucket(row.DaysPastDue)
+
+		report.LoanCount++
+		report.Outstanding += row.Outstanding
+		report.Arrears += row.Arrears
+		report.Loans = append(report.Loans, row)
+	}
+
+	for _, threshold := range ParThresholds {
+		ratio := ParRatio{Threshold: threshold}
+		for _, row := range report.Loans {
+			if row.DaysPastDue >= threshold {
+				ratio.Loans++
+				ratio.Outstanding += row.Outstanding
+			}
+		}
+		ratio.Ratio = percentOf(ratio.Outstanding, report.Outstanding)
+		report.Par = append(report.Par, ratio)
+	}
+
+	for _, bucket := range agingBuckets {
+		for _, row := range report.Loans {
+			if row.Bucket == bucket.Name {
+				bucket.Loans++
+				bucket.Outstanding += row.Outstanding
+				bucket.Arrears += row.Arrears
+			}
+		}
+		bucket.Share = percentOf(bucket.Outstanding, report.Outstanding)
+		report.Aging = append(report.Aging, bucket)
+	}
+
+	return report, nil
+}
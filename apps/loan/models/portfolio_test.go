@@ -0,0 +1,120 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kifangamukundi/gm/loan/money"
+)
+
+func TestAgingBucket(t *testing.T) {
+	tests := []struct {
+		daysPastDue int
+		want        string
+	}{
+		{daysPastDue: -3, want: "current"},
+		{daysPastDue: 0, want: "current"},
+		{daysPastDue: 1, want: "1-30"},
+		{daysPastDue: 30, want: "1-30"},
+		{daysPastDue: 31, want: "31-60"},
+		{daysPastDue: 60, want: "31-60"},
+		{daysPastDue: 61, want: "61-90"},
+		{daysPastDue: 90, want: "61-90"},
+		{daysPastDue: 91, want: "91-180"},
+		{daysPastDue: 180, want: "91-180"},
+		{daysPastDue: 181, want: "180+"},
+		{daysPastDue: 1000, want: "180+"},
+	}
+
+	for _, tt := range tests {
+		if got := agingBucket(tt.daysPastDue); got != tt.want {
+			t.Errorf("agingBucket(%d) = %q, want %q", tt.daysPastDue, got, tt.want)
+		}
+	}
+}
+
+func TestPortfolioReportAsOf(t *testing.T) {
+	service := newTestService(t, &Agent{}, &Loan{}, &LoanStatusHistory{}, &Instalment{}, &LoanRestructure{}, &PenaltyCharge{}, &PaymentAllocation{})
+	day := func(month time.Month, d int) time.Time { return time.Date(2026, month, d, 0, 0, 0, 0, time.UTC) }
+	at := func(d time.Time) *time.Time { return &d }
+	purpose := "Stock for the shop"
+
+	// The first loan was disbursed in January, ran late and was closed on 25 March. The second
+	// was disbursed on 15 March.
+	closed := Loan{Amount: 100000, Term: 2, Status: LoanStatusClosed, DisbursedAt: at(day(1, 1)), LoanPurpose: &purpose}
+	active := Loan{Amount: 100000, Term: 2, Status: LoanStatusActive, DisbursedAt: at(day(3, 15)), LoanPurpose: &purpose}
+	for _, loan := range []*Loan{&closed, &active} {
+		if err := service.CreateEntity(loan); err != nil {
+			t.Fatalf("failed to create loan: %v", err)
+		}
+	}
+
+	history := []LoanStatusHistory{
+		{LoanID: closed.ID, FromStatus: LoanStatusDisbursing, ToStatus: LoanStatusActive, CreatedAt: day(1, 1)},
+		{LoanID: closed.ID, FromStatus: LoanStatusActive, ToStatus: LoanStatusClosed, CreatedAt: day(3, 25)},
+		{LoanID: active.ID, FromStatus: LoanStatusDisbursing, ToStatus: LoanStatusActive, CreatedAt: day(3, 15)},
+	}
+	for i := range history {
+		if err := service.CreateEntity(&history[i]); err != nil {
+			t.Fatalf("failed to create history: %v", err)
+		}
+	}
+
+	schedule := []struct {
+		instalment Instalment
+		paidAt     time.Time
+	}{
+		{instalment: Instalment{LoanID: closed.ID, Number: 1, DueDate: day(2, 1), CreatedAt: day(1, 1)}, paidAt: day(3, 10)},
+		{instalment: Instalment{LoanID: closed.ID, Number: 2, DueDate: day(3, 1), CreatedAt: day(1, 1)}, paidAt: day(3, 25)},
+		{instalment: Instalment{LoanID: active.ID, Number: 1, DueDate: day(4, 15), CreatedAt: day(3, 15)}},
+		{instalment: Instalment{LoanID: active.ID, Number: 2, DueDate: day(5, 15), CreatedAt: day(3, 15)}},
+	}
+	for _, entry := range schedule {
+		instalment := entry.instalment
+		instalment.Principal, instalment.Interest = 50000, 5000
+		instalment.TotalDue = instalment.Principal + instalment.Interest
+		if !entry.paidAt.IsZero() {
+			instalment.PrincipalPaid, instalment.InterestPaid, instalment.Status = 50000, 5000, InstalmentStatusPaid
+		}
+		if err := service.CreateEntity(&instalment); err != nil {
+			t.Fatalf("failed to create instalment: %v", err)
+		}
+		if entry.paidAt.IsZero() {
+			continue
+		}
+		for component, amount := range map[string]money.Amount{ComponentPrincipal: 50000, ComponentInterest: 5000} {
+			allocation := PaymentAllocation{LoanID: instalment.LoanID, InstalmentID: &instalment.ID, Component: component, Amount: amount, CreatedAt: entry.paidAt}
+			if err := service.CreateEntity(&allocation); err != nil {
+				t.Fatalf("failed to create allocation: %v", err)
+			}
+		}
+	}
+
+	tests := []struct {
+		asOf        time.Time
+		loans       int
+		outstanding money.Amount
+		arrears     money.Amount
+		par1        int
+	}{
+		{asOf: day(2, 20), loans: 1, outstanding: 100000, arrears: 55000, par1: 1},
+		{asOf: day(3, 20), loans: 2, outstanding: 150000, arrears: 55000, par1: 1},
+		{asOf: day(3, 31), loans: 1, outstanding: 100000},
+	}
+
+	model := NewPortfolioModel(service)
+	for _, tt := range tests {
+		report, err := model.Report(PortfolioFilter{}, tt.asOf)
+		if err != nil {
+			t.Fatalf("Report returned %v", err)
+		}
+		date := tt.asOf.Format(time.DateOnly)
+		if report.LoanCount != tt.loans || report.Outstanding != tt.outstanding || report.Arrears != tt.arrears {
+			t.Errorf("as of %s: %d loans owe %s with %s in arrears, want %d owing %s with %s", date,
+				report.LoanCount, report.Outstanding, report.Arrears, tt.loans, tt.outstanding, tt.arrears)
+		}
+		if report.Par[0].Loans != tt.par1 {
+			t.Errorf("as of %s: %d loans at risk, want %d", date, report.Par[0].Loans, tt.par1)
+		}
+	}
+}
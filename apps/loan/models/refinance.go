@@ -0,0 +1,246 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/kifangamukundi/gm/loan/money"
+	"github.com/kifangamukundi/gm/loan/services"
+)
+
+var (
+	ErrNotRefinanceable = errors.New("loan cannot be topped up")
+	ErrTopUpTooSmall    = errors.New("top-up does not cover the outstanding balance")
+	ErrTopUpNotEligible = errors.New("member is not eligible for the top-up")
+)
+
+type RefinanceModel struct {
+	Service services.Service
+}
+
+func NewRefinanceModel(service services.Service) *RefinanceModel {
+	return &RefinanceModel{Service: service}
+}
+
+// Payoff quotes what it costs to close the loan at asOf, after the product's early
+// settlement rebate
+func (m *RefinanceModel) Payoff(loan *Loan, asOf time.Time) (SettlementQuote, error) {
+	instalments, err := NewScheduleModel(m.Service).GetLoanSchedule(loan.ID)
+	if err != nil {
+		return SettlementQuote{}, err
+	}
+
+	return QuoteSettlement(loan, instalments, settlementRebateRate(loan), asOf), nil
+}
+
+// CreateTopUp stores topUp as a new loan that settles the active loan previous once paid out.
+// The member must be eligible for it and it must be more than the payoff of previous.
+func (m *RefinanceModel) CreateTopUp(previous *Loan, topUp *Loan, product *LoanProduct, asOf time.Time) error {
+	if previous.Status != LoanStatusActive {
+		return fmt.Errorf("%w: loan is %s", ErrNotRefinanceable, previous.Status)
+	}
+
+	var open []Loan
+	result, err := m.Service.GetEntitiesByFields(&open, map[string]interface{}{"refinanced_loan_id": previous.ID})
+	if err != nil {
+		return fmt.Errorf("failed to get top-ups: %v", err)
+	}
+	for _, existing := range *result.(*[]Loan) {
+		switch existing.Status {
+		case LoanStatusPending, LoanStatusApproved, LoanStatusDisbursing:
+			return fmt.Errorf("%w: top-up loan %d is already %s", ErrNotRefinanceable, existing.ID, existing.Status)
+		}
+	}
+
+	eligibility, err := NewEligibilityModel(m.Service).CheckEligibility(previous.MemberID, product, topUp.Amount, asOf)
+	if err != nil {
+		return err
+	}
+	if !eligibility.Eligible {
+		return fmt.Errorf("%w: %s", ErrTopUpNotEligible, strings.Join(eligibility.Reasons, "; "))
+	}
+
+	payoff, err := m.Payoff(previous, asOf)
+	if err != nil {
+		return err
+	}
+	if topUp.Amount <= payoff.Total {
+		return fmt.Errorf("%w: %s is needed to settle it", ErrTopUpTooSmall, payoff.Total)
+	}
+
+	topUp.RefinancedLoanID = &previous.ID
+	topUp.MemberID = previous.MemberID
+	topUp.GroupID = previous.GroupID
+	topUp.AgentID = previous.AgentID
+
+	if err := m.Service.CreateEntity(topUp); err != nil {
+		return fmt.Errorf("failed to create loan: %v", err)
+	}
+
+	return nil
+}
+
+// NetDisbursement is what to pay out for the loan at asOf. A top-up pays out its principal
+// less the payoff of the loan it settles, in whole shillings.
+func (m *RefinanceModel) NetDisbursement(loan *Loan, asOf time.Time) (money.Amount, error) {
+	if loan.RefinancedLoanID == nil {
+		return loan.Amount, nil
+	}
+
+	previous, err := NewLoanModel(m.Service).GetLoanByFieldPreloaded("id", fmt.Sprintf("%d", *loan.RefinancedLoanID))
+	if err != nil {
+		return 0, fmt.Errorf("refinanced loan not found: %v", err)
+	}
+
+	payoff, err := m.Payoff(previous, asOf)
+	if err != nil {
+		return 0, err
+	}
+
+	net := money.FromShillings((loan.Amount - payoff.Total).Shillings())
+	if net <= 0 {
+		return 0, fmt.Errorf("%w: %s is needed to settle loan %d", ErrTopUpTooSmall, payoff.Total, previous.ID)
+	}
+
+	return net, nil
+}
+
+// SettleRefinancedLoan pays what a top-up did not pay out into the loan it refinances, with
+// its settlement rebate when it covers the payoff, and closes it once nothing is owed.
+func (m *RefinanceModel) SettleRefinancedLoan(topUp Loan, settlement money.Amount, settledAt time.Time) (*Payment, error) {
+	if topUp.RefinancedLoanID == nil || settlement <= 0 {
+		return nil, nil
+	}
+
+	loanModel := NewLoanModel(m.Service)
+	previous, err := loanModel.GetLoanByFieldPreloaded("id", fmt.Sprintf("%d", *topUp.RefinancedLoanID))
+	if err != nil {
+		return nil, fmt.Errorf("refinanced loan not found: %v", err)
+	}
+
+	payment := Payment{
+		LoanID:            previous.ID,
+		Amount:            settlement,
+		CheckoutRequestID: fmt.Sprintf("refinance-%d", topUp.ID),
+		Status:            PaymentStatusSuccess,
+		ResponseCode:      "0",
+		ResponseDesc:      "Settled by top-up",
+		TransactionDesc:   fmt.Sprintf("Settlement from top-up loan %d", topUp.ID),
+		PaymentMode:       PaymentModeRefinance,
+		ResultDesc:        "Settled by top-up",
+		PaidAt:            &settledAt,
+	}
+	if err := m.Service.CreateEntity(&payment); err != nil {
+		return nil, fmt.Errorf("failed to record settlement: %v", err)
+	}
+
+	instalments, err := NewScheduleModel(m.Service).GetLoanSchedule(previous.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	quote := QuoteSettlement(previous, instalments, settlementRebateRate(previous), settledAt)
+	if settlement >= quote.Total {
+		quote.ValidUntil = time.Date(settledAt.Year(), settledAt.Month(), settledAt.Day(), 23, 59, 59, 0, settledAt.Location())
+		if err := m.Service.CreateEntity(&quote); err != nil {
+			return nil, fmt.Errorf("failed to create settlement quote: %v", err)
+		}
+		if _, err := NewSettlementModel(m.Service).ApplySettlementQuote(previous, payment, instalments); err != nil {
+			return nil, err
+		}
+	} else {
+		log.Printf("Top-up loan %d settles %s of the %s payoff of loan %d\n", topUp.ID, settlement, quote.Total, previous.ID)
+	}
+
+	allocations, balance, err := NewAllocationModel(m.Service).ApplyPayment(payment, previous.Product.RepaymentOrder(), instalments)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, allocation := range allocations {
+		if allocation.Component == ComponentOverpayment {
+			log.Printf("Top-up loan %d overpaid loan %d by %s\n", topUp.ID, previous.ID, allocation.Amount)
+		}
+	}
+
+	if err := NewLedgerModel(m.Service).PostRefinance(payment, topUp.ID, allocations); err != nil {
+		return nil, fmt.Errorf("error posting settlement to the ledger: %v", err)
+	}
+
+	previousBalance := previous.RemainingBalance
+	previous.RemainingBalance = balance
+	previous.LastPaymentDate = &settledAt
+	previous.IsFullyPaid = balance <= 0
+	updated, err := m.Service.UpdateEntityColumns(previous, map[string]interface{}{"remaining_balance": previousBalance}, "remaining_balance", "last_payment_date", "is_fully_paid", "updated_at")
+	if err != nil {
+		return nil, fmt.Errorf("failed to update loan: %v", err)
+	}
+	if !updated {
+		return nil, fmt.Errorf("loan %d was changed by another request", previous.ID)
+	}
+
+	if previous.IsFullyPaid && CanTransition(previous.Status, LoanStatusClosed) {
+		// The top-up takes over the debt, so it keeps the security rather than releasing it
+		if err := transferCollateral(m.Service, previous.ID, topUp.ID); err != nil {
+			return nil, err
+		}
+
+		reason := fmt.Sprintf("Settled by top-up loan %d", topUp.ID)
+		if _, err := loanModel.TransitionLoan(previous.ID, LoanStatusClosed, nil, reason, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return &payment, nil
+}
+
+// GetRefinanceChain returns every loan linked to the given one by top-ups, from the first
+// loan that was refinanced to the latest top-up.
+func (m *RefinanceModel) GetRefinanceChain(loanId uint) ([]Loan, error) {
+	loan := &Loan{ID: loanId}
+	if _, err := m.Service.GetEntityByID(loan, loanId); err != nil {
+		return nil, fmt.Errorf("loan not found: %v", err)
+	}
+
+	chain := []Loan{*loan}
+	seen := map[uint]bool{loan.ID: true}
+
+	for current := *loan; current.RefinancedLoanID != nil && !seen[*current.RefinancedLoanID]; {
+		previous := Loan{ID: *current.RefinancedLoanID}
+		if _, err := m.Service.GetEntityByID(&previous, previous.ID); err != nil {
+			return nil, fmt.Errorf("loan not found: %v", err)
+		}
+		seen[previous.ID] = true
+		chain = append([]Loan{previous}, chain...)
+		current = previous
+	}
+
+	for current := *loan; ; {
+		var topUps []Loan
+		result, err := m.Service.GetEntitiesByFields(&topUps, map[string]interface{}{"refinanced_loan_id": current.ID})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get top-ups: %v", err)
+		}
+
+		// Only a disbursed top-up continues the chain, pending or failed ones never settled it
+		var next *Loan
+		for _, topUp := range *result.(*[]Loan) {
+			if topUp.DisbursedAt != nil && !seen[topUp.ID] {
+				next = &topUp
+				break
+			}
+		}
+		if next == nil {
+			break
+		}
+
+		seen[next.ID] = true
+		chain = append(chain, *next)
+		current = *next
+	}
+
+	return chain, nil
+}
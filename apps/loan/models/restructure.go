@@ -0,0 +1,406 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/kifangamukundi/gm/loan/money"
+	"github.com/kifangamukundi/gm/loan/services"
+)
+
+const (
+	RestructureStatusPending  = "pending"
+	RestructureStatusApproved = "approved"
+	RestructureStatusRejected = "rejected"
+
+	ArrearsCapitalize = "capitalize"
+	ArrearsWaive      = "waive"
+)
+
+var (
+	ErrRestructureNotPending = errors.New("restructure is not pending")
+	ErrSelfApproval          = errors.New("a request cannot be approved by the user who made it")
+)
+
+// InstalmentSnapshot is an instalment as it stood when its loan was restructured
+type InstalmentSnapshot struct {
+	ID             uint         `json:"ID"`
+	Number         int          `json:"Number"`
+	DueDate        time.Time    `json:"DueDate"`
+	Principal      money.Amount `json:"Principal"`
+	Interest       money.Amount `json:"Interest"`
+	Fees           money.Amount `json:"Fees"`
+	TotalDue       money.Amount `json:"TotalDue"`
+	OpeningBalance money.Amount `json:"OpeningBalance"`
+	ClosingBalance money.Amount `json:"ClosingBalance"`
+	Status         string       `json:"Status"`
+	Penalty        money.Amount `json:"Penalty"`
+	PenaltyPaid    money.Amount `json:"PenaltyPaid"`
+	FeesPaid       money.Amount `json:"FeesPaid"`
+	InterestPaid   money.Amount `json:"InterestPaid"`
+	PrincipalPaid  money.Amount `json:"PrincipalPaid"`
+	PaidAt         *time.Time   `json:"PaidAt"`
+}
+
+// LoanRestructure is a request, approved by a second user, to replace the unpaid instalments
+// of a loan that has fallen behind with a new schedule. Arrears are capitalised or waived.
+type LoanRestructure struct {
+	ID               uint    `gorm:"primaryKey"`
+	LoanID           uint    `gorm:"index"` // Foreign key to Loan
+	Loan             Loan    `gorm:"foreignKey:LoanID;constraint:onDelete:CASCADE"`
+	Status           string  `gorm:"not null;default:'pending';index"` // pending, approved, rejected
+	ArrearsTreatment string  `gorm:"not null"`                         // capitalize, waive
+	Term             int     `gorm:"not null"`                         // Periods in the new schedule
+	InterestRate     float64 `gorm:"not null"`                         // Annual percentage on the new schedule
+	RatePerPeriod    bool    `gorm:"not null;default:false"`           // InterestRate is charged per period, for requests made before rates became annual
+	GracePeriod      int     `gorm:"not null;default:0"`               // Periods before the first new instalment falls due
+	Reason           string  `gorm:"not null;default:''"`
+
+	RequestedByID  uint       `gorm:"index"`
+	RequestedBy    User       `gorm:"foreignKey:RequestedByID;constraint:onDelete:RESTRICT"`
+	DecidedByID    *uint      `gorm:"index;default:null"` // User who approved or rejected the request
+	DecidedBy      *User      `gorm:"foreignKey:DecidedByID;constraint:onDelete:SET NULL"`
+	DecisionReason string     `gorm:"not null;default:''"`
+	DecidedAt      *time.Time `gorm:"default:null"`
+
+	// Filled in when the restructure is applied
+	PreviousTerm         int                  `gorm:"not null;default:0"`
+	PreviousRate         float64              `gorm:"not null;default:0"`
+	PreviousPerPeriod    bool                 `gorm:"not null;default:false"` // PreviousRate was charged per period
+	PreviousDueDate      *time.Time           `gorm:"default:null"`
+	OutstandingPrincipal money.Amount         `gorm:"not null;default:0"` // Unpaid principal on the replaced instalments
+	ArrearsInterest      money.Amount         `gorm:"not null;default:0"` // Overdue interest on the replaced instalments
+	ArrearsFees          money.Amount         `gorm:"not null;default:0"`
+	ArrearsPenalty       money.Amount         `gorm:"not null;default:0"`
+	Capitalized          money.Amount         `gorm:"not null;default:0"` // Arrears added to the new principal
+	Waived               money.Amount         `gorm:"not null;default:0"` // Arrears forgiven
+	UnearnedInterest     money.Amount         `gorm:"not null;default:0"` // Interest not yet due that the new schedule replaces
+	UnearnedFees         money.Amount         `gorm:"not null;default:0"` // Fees not yet due, carried into the new schedule
+	NewPrincipal         money.Amount         `gorm:"not null;default:0"`
+	NewInterest          money.Amount         `gorm:"not null;default:0"`
+	OriginalSchedule     []InstalmentSnapshot `gorm:"type:jsonb;serializer:json"`
+
+	CreatedAt time.Time `gorm:"not null"`
+	UpdatedAt time.Time `gorm:"not null"`
+}
+
+type RestructureModel struct {
+	Service services.Service
+}
+
+func NewRestructureModel(service services.Service) *RestructureModel {
+	return &RestructureModel{Service: service}
+}
+
+// canRestructure reports whether a loan in the given status may be restructured
+func canRestructure(status string) bool {
+	return status == LoanStatusActive || status == LoanStatusInArrears
+}
+
+// RequestRestructure records a restructure request for approval. A loan may only have one
+// pending request at a time.
+func (m *RestructureModel) RequestRestructure(restructure *LoanRestructure) error {
+	loan := &Loan{ID: restructure.LoanID}
+	if _, err := m.Service.GetEntityByID(loan, restructure.LoanID); err != nil {
+		return fmt.Errorf("loan not found: %v", err)
+	}
+	if !canRestructure(loan.Status) {
+		return fmt.Errorf("%w: loan is %s and cannot be restructured", ErrIllegalTransition, loan.Status)
+	}
+
+	pending, err := m.Service.CountEntities(&LoanRestructure{}, map[string]interface{}{"loan_id": loan.ID, "status": RestructureStatusPending})
+	if err != nil {
+		return err
+	}
+	if pending > 0 {
+		return fmt.Errorf("%w: loan %d already has a pending restructure", ErrConcurrentTransition, loan.ID)
+	}
+
+	restructure.Status = RestructureStatusPending
+	if err := m.Service.CreateEntity(restructure); err != nil {
+		return fmt.Errorf("failed to create restructure: %v", err)
+	}
+
+	return nil
+}
+
+func (m *RestructureModel) GetRestructureByField(field, value string) (*LoanRestructure, error) {
+	var restructure LoanRestructure
+
+	result, err := m.Service.GetEntityByFieldWithPreload(&restructure, field, value, "RequestedBy", "DecidedBy")
+	if err != nil {
+		log.Printf("Error fetching restructure by %s: %v", field, err)
+		return nil, err
+	}
+
+	return result.(*LoanRestructure), nil
+}
+
+// GetLoanRestructures returns every restructure requested on the loan, oldest first.
+func (m *RestructureModel) GetLoanRestructures(loanId uint) ([]LoanRestructure, error) {
+	var restructures []LoanRestructure
+
+	result, err := m.Service.GetOrderedEntitiesByField(&restructures, "loan_id", loanId, "id asc", "RequestedBy", "DecidedBy")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get restructures: %v", err)
+	}
+
+	return *result.(*[]LoanRestructure), nil
+}
+
+// RejectRestructure closes a pending request without changing the loan.
+func (m *RestructureModel) RejectRestructure(id, userId uint, reason string) (LoanRestructure, error) {
+	restructure := &LoanRestructure{ID: id}
+
+	if _, err := m.Service.GetEntityByID(restructure, id); err != nil {
+		return LoanRestructure{}, fmt.Errorf("restructure not found: %v", err)
+	}
+	if restructure.Status != RestructureStatusPending {
+		return LoanRestructure{}, fmt.Errorf("%w: it is %s", ErrRestructureNotPending, restructure.Status)
+	}
+
+	now := time.Now()
+	restructure.Status = RestructureStatusRejected
+	restructure.DecidedByID = &userId
+	restructure.DecisionReason = reason
+	restructure.DecidedAt = &now
+
+	updated, err := m.Service.UpdateEntityIf(restructure, map[string]interface{}{"status": RestructureStatusPending})
+	if err != nil {
+		return LoanRestructure{}, fmt.Errorf("failed to update restructure: %v", err)
+	}
+	if !updated {
+		return LoanRestructure{}, fmt.Errorf("%w: it was decided by another request", ErrRestructureNotPending)
+	}
+
+	return *restructure, nil
+}
+
+// PlanRestructure works out the new schedule as of asOf and fills in the amounts the
+// restructure replaces. Fees not yet due are carried into the new schedule.
+func PlanRestructure(loan *Loan, instalments []Instalment, restructure *LoanRestructure, asOf time.Time) ([]Instalment, error) {
+	restructure.OriginalSchedule = make([]InstalmentSnapshot, 0, len(instalments))
+	restructure.OutstandingPrincipal = money.Zero
+	restructure.ArrearsInterest, restructure.ArrearsFees, restructure.ArrearsPenalty = money.Zero, money.Zero, money.Zero
+	restructure.UnearnedInterest, restructure.UnearnedFees = money.Zero, money.Zero
+
+	replaced := 0
+	for _, instalment := range instalments {
+		restructure.OriginalSchedule = append(restructure.OriginalSchedule, snapshotInstalment(instalment))
+
+		if instalment.Balance() <= 0 {
+			continue
+		}
+		replaced++
+
+		restructure.OutstandingPrincipal += instalment.Outstanding(ComponentPrincipal)
+		restructure.ArrearsPenalty += instalment.Outstanding(ComponentPenalty)
+
+		if instalment.DueDate.After(asOf) {
+			restructure.UnearnedInterest += instalment.Outstanding(ComponentInterest)
+			restructure.UnearnedFees += instalment.Outstanding(ComponentFees)
+		} else {
+			restructure.ArrearsInterest += instalment.Outstanding(ComponentInterest)
+			restructure.ArrearsFees += instalment.Outstanding(ComponentFees)
+		}
+	}
+
+	if replaced == 0 {
+		return nil, fmt.Errorf("loan %d has nothing outstanding to restructure", loan.ID)
+	}
+
+	arrears := restructure.ArrearsInterest + restructure.ArrearsFees + restructure.ArrearsPenalty
+	restructure.Capitalized, restructure.Waived = money.Zero, money.Zero
+
+	switch restructure.ArrearsTreatment {
+	case ArrearsCapitalize:
+		restructure.Capitalized = arrears
+	case ArrearsWaive:
+		restructure.Waived = arrears
+	default:
+		return nil, fmt.Errorf("unsupported arrears treatment: %s", restructure.ArrearsTreatment)
+	}
+
+	restructure.NewPrincipal = restructure.OutstandingPrincipal + restructure.Capitalized
+
+	schedule, err := BuildSchedule(ScheduleTerms{
+		Principal:     restructure.NewPrincipal,
+		Rate:          restructure.InterestRate,
+		RatePerPeriod: restructure.RatePerPeriod,
+		Periods:       restructure.Term,
+		Method:        loan.InterestMethod,
+		Frequency:     loan.RepaymentFrequency,
+		Fees:          restructure.UnearnedFees,
+		GracePeriods:  restructure.GracePeriod,
+		StartDate:     asOf,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to build schedule: %v", err)
+	}
+
+	restructure.NewInterest = money.Zero
+	for _, instalment := range schedule {
+		restructure.NewInterest += instalment.Interest
+	}
+
+	return schedule, nil
+}
+
+func snapshotInstalment(instalment Instalment) InstalmentSnapshot {
+	return InstalmentSnapshot{
+		ID:             instalment.ID,
+		Number:         instalment.Number,
+		DueDate:        instalment.DueDate,
+		Principal:      instalment.Principal,
+		Interest:       instalment.Interest,
+		Fees:           instalment.Fees,
+		TotalDue:       instalment.TotalDue,
+		OpeningBalance: instalment.OpeningBalance,
+		ClosingBalance: instalment.ClosingBalance,
+		Status:         instalment.Status,
+		Penalty:        instalment.Penalty,
+		PenaltyPaid:    instalment.PenaltyPaid,
+		FeesPaid:       instalment.FeesPaid,
+		InterestPaid:   instalment.InterestPaid,
+		PrincipalPaid:  instalment.PrincipalPaid,
+		PaidAt:         instalment.PaidAt,
+	}
+}
+
+// ApproveRestructure replaces the unpaid instalments with the new schedule on behalf of a
+// user other than the requester and posts the change to the ledger.
+func (m *RestructureModel) ApproveRestructure(id, approverId uint, asOf time.Time) (LoanRestructure, error) {
+	restructure := &LoanRestructure{ID: id}
+
+	err := m.Service.WithTransaction(func(tx services.Service) error {
+		if _, err := tx.GetEntityByID(restructure, id); err != nil {
+			return fmt.Errorf("restructure not found: %v", err)
+		}
+		if restructure.Status != RestructureStatusPending {
+			return fmt.Errorf("%w: it is %s", ErrRestructureNotPending, restructure.Status)
+		}
+		if restructure.RequestedByID == approverId {
+			return ErrSelfApproval
+		}
+
+		loan := &Loan{ID: restructure.LoanID}
+		if _, err := tx.GetEntityByID(loan, restructure.LoanID); err != nil {
+			return fmt.Errorf("loan not found: %v", err)
+		}
+		if !canRestructure(loan.Status) {
+			return fmt.Errorf("%w: loan is %s and cannot be restructured", ErrIllegalTransition, loan.Status)
+		}
+
+		instalments, err := NewScheduleModel(tx).GetLoanSchedule(loan.ID)
+		if err != nil {
+			return err
+		}
+
+		schedule, err := PlanRestructure(loan, instalments, restructure, asOf)
+		if err != nil {
+			return err
+		}
+
+		lastNumber := 0
+		for i := range instalments {
+			instalment := &instalments[i]
+			lastNumber = instalment.Number
+
+			if instalment.Balance() <= 0 {
+				continue
+			}
+
+			previous := *instalment
+
+			// Keep what was collected so allocations still add up, the rest moves to the new schedule
+			instalment.Principal = instalment.PrincipalPaid
+			instalment.Interest = instalment.InterestPaid
+			instalment.Fees = instalment.FeesPaid
+			instalment.Penalty = instalment.PenaltyPaid
+			instalment.TotalDue = instalment.Principal + instalment.Interest + instalment.Fees
+			instalment.ClosingBalance = instalment.OpeningBalance - instalment.Principal
+			instalment.Status = InstalmentStatusRestructured
+			instalment.RestructureID = &restructure.ID
+
+			// Only while nothing was paid or charged on the instalment since it was read
+			updated, err := tx.UpdateEntityColumns(instalment, map[string]interface{}{
+				"penalty":        previous.Penalty,
+				"penalty_paid":   previous.PenaltyPaid,
+				"fees_paid":      previous.FeesPaid,
+				"interest_paid":  previous.InterestPaid,
+				"principal_paid": previous.PrincipalPaid,
+				"status":         previous.Status,
+			}, "principal", "interest", "fees", "penalty", "total_due", "closing_balance", "status", "restructure_id", "updated_at")
+			if err != nil {
+				return fmt.Errorf("failed to update instalment: %v", err)
+			}
+			if !updated {
+				return fmt.Errorf("instalment %d was changed by another request", instalment.ID)
+			}
+		}
+
+		total := money.Zero
+		for i := range schedule {
+			schedule[i].LoanID = loan.ID
+			schedule[i].Number = lastNumber + i + 1
+			if err := tx.CreateEntity(&schedule[i]); err != nil {
+				return fmt.Errorf("failed to create instalment: %v", err)
+			}
+			total += schedule[i].TotalDue
+		}
+
+		restructure.PreviousTerm = loan.Term
+		restructure.PreviousRate = loan.Interest
+		restructure.PreviousPerPeriod = loan.RatePerPeriod
+		restructure.PreviousDueDate = loan.DueDate
+
+		dueDate := schedule[len(schedule)-1].DueDate
+		loan.Interest = restructure.InterestRate
+		loan.RatePerPeriod = restructure.RatePerPeriod
+		loan.Term = lastNumber + len(schedule)
+		loan.DueDate = &dueDate
+		previousBalance := loan.RemainingBalance
+		loan.RemainingBalance = total
+
+		updated, err := tx.UpdateEntityColumns(loan, map[string]interface{}{"remaining_balance": previousBalance}, "interest", "rate_per_period", "term", "due_date", "remaining_balance", "updated_at")
+		if err != nil {
+			return fmt.Errorf("failed to update loan: %v", err)
+		}
+		if !updated {
+			return fmt.Errorf("loan %d was changed by another request", loan.ID)
+		}
+
+		decidedAt := time.Now()
+		restructure.Status = RestructureStatusApproved
+		restructure.DecidedByID = &approverId
+		restructure.DecidedAt = &decidedAt
+
+		updated, err = tx.UpdateEntityIf(restructure, map[string]interface{}{"status": RestructureStatusPending})
+		if err != nil {
+			return fmt.Errorf("failed to update restructure: %v", err)
+		}
+		if !updated {
+			return fmt.Errorf("%w: it was decided by another request", ErrRestructureNotPending)
+		}
+
+		if err := NewLedgerModel(tx).PostRestructure(*restructure); err != nil {
+			return fmt.Errorf("error posting restructure to the ledger: %v", err)
+		}
+
+		if loan.Status == LoanStatusInArrears {
+			reason := fmt.Sprintf("Restructured over %d periods", restructure.Term)
+			if _, err := NewLoanModel(tx).TransitionLoan(loan.ID, LoanStatusActive, &approverId, reason, nil); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return LoanRestructure{}, err
+	}
+
+	return *restructure, nil
+}
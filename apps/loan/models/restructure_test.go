@@ -0,0 +1,154 @@
+package models
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/kifangamukundi/gm/loan/money"
+	"github.com/kifangamukundi/gm/loan/services"
+)
+
+func TestPlanRestructure(t *testing.T) {
+	asOf := time.Date(2026, 2, 15, 0, 0, 0, 0, time.UTC)
+	loan := &Loan{ID: 5, InterestMethod: InterestMethodFlat, RepaymentFrequency: FrequencyMonthly}
+
+	schedule := func() []Instalment {
+		return []Instalment{
+			{ID: 1, Number: 1, DueDate: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), Principal: 100000, Interest: 10000, PrincipalPaid: 100000, InterestPaid: 10000, Status: InstalmentStatusPaid},
+			{ID: 2, Number: 2, DueDate: time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC), Principal: 100000, Interest: 10000, Fees: 5000, Penalty: 3000, PrincipalPaid: 20000},
+			{ID: 3, Number: 3, DueDate: time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), Principal: 100000, Interest: 10000, Fees: 5000},
+		}
+	}
+
+	tests := []struct {
+		name            string
+		treatment       string
+		wantPrincipal   money.Amount
+		wantCapitalized money.Amount
+		wantWaived      money.Amount
+		wantInterest    money.Amount
+	}{
+		{name: "capitalise arrears", treatment: ArrearsCapitalize, wantPrincipal: 198000, wantCapitalized: 18000, wantInterest: 3960},
+		{name: "waive arrears", treatment: ArrearsWaive, wantPrincipal: 180000, wantWaived: 18000, wantInterest: 3600},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			restructure := &LoanRestructure{Term: 2, InterestRate: 12, ArrearsTreatment: tt.treatment}
+
+			newSchedule, err := PlanRestructure(loan, schedule(), restructure, asOf)
+			if err != nil {
+				t.Fatalf("PlanRestructure returned %v", err)
+			}
+
+			if restructure.OutstandingPrincipal != 180000 {
+				t.Errorf("OutstandingPrincipal = %s, want 1800.00", restructure.OutstandingPrincipal)
+			}
+			if restructure.ArrearsInterest != 10000 || restructure.ArrearsFees != 5000 || restructure.ArrearsPenalty != 3000 {
+				t.Errorf("arrears = %s interest, %s fees, %s penalty", restructure.ArrearsInterest, restructure.ArrearsFees, restructure.ArrearsPenalty)
+			}
+			if restructure.UnearnedInterest != 10000 || restructure.UnearnedFees != 5000 {
+				t.Errorf("unearned = %s interest, %s fees", restructure.UnearnedInterest, restructure.UnearnedFees)
+			}
+			if restructure.NewPrincipal != tt.wantPrincipal || restructure.Capitalized != tt.wantCapitalized || restructure.Waived != tt.wantWaived {
+				t.Errorf("new principal %s, capitalised %s, waived %s", restructure.NewPrincipal, restructure.Capitalized, restructure.Waived)
+			}
+			if restructure.NewInterest != tt.wantInterest {
+				t.Errorf("NewInterest = %s, want %s", restructure.NewInterest, tt.wantInterest)
+			}
+			if len(restructure.OriginalSchedule) != 3 {
+				t.Errorf("snapshot has %d instalments, want 3", len(restructure.OriginalSchedule))
+			}
+
+			if len(newSchedule) != 2 {
+				t.Fatalf("new schedule has %d instalments, want 2", len(newSchedule))
+			}
+			principal, fees := money.Zero, money.Zero
+			for _, instalment := range newSchedule {
+				principal += instalment.Principal
+				fees += instalment.Fees
+			}
+			if principal != restructure.NewPrincipal {
+				t.Errorf("new schedule repays %s of %s principal", principal, restructure.NewPrincipal)
+			}
+			if fees != restructure.UnearnedFees {
+				t.Errorf("new schedule carries %s of %s unearned fees", fees, restructure.UnearnedFees)
+			}
+			if want := time.Date(2026, 3, 15, 0, 0, 0, 0, time.UTC); !newSchedule[0].DueDate.Equal(want) {
+				t.Errorf("first instalment due %s, want %s", newSchedule[0].DueDate, want)
+			}
+		})
+	}
+}
+
+func TestPlanRestructureRejects(t *testing.T) {
+	asOf := time.Date(2026, 2, 15, 0, 0, 0, 0, time.UTC)
+	loan := &Loan{ID: 5, InterestMethod: InterestMethodFlat, RepaymentFrequency: FrequencyMonthly}
+	owing := []Instalment{{ID: 1, DueDate: asOf.AddDate(0, 0, -5), Principal: 1000}}
+	paid := []Instalment{{ID: 1, DueDate: asOf.AddDate(0, 0, -5), Principal: 1000, PrincipalPaid: 1000}}
+
+	tests := []struct {
+		name        string
+		instalments []Instalment
+		restructure LoanRestructure
+	}{
+		{name: "nothing outstanding", instalments: paid, restructure: LoanRestructure{Term: 2, ArrearsTreatment: ArrearsWaive}},
+		{name: "unknown arrears treatment", instalments: owing, restructure: LoanRestructure{Term: 2, ArrearsTreatment: "forget"}},
+		{name: "no term", instalments: owing, restructure: LoanRestructure{Term: 0, ArrearsTreatment: ArrearsWaive}},
+	}
+
+	for _, tt := range tests {
+		if _, err := PlanRestructure(loan, tt.instalments, &tt.restructure, asOf); err == nil {
+			t.Errorf("%s: PlanRestructure succeeded, want an error", tt.name)
+		}
+	}
+}
+
+func TestApproveRestructureFailsOnAConcurrentPenalty(t *testing.T) {
+	service := newTestService(t, append(ledgerEntities, &User{}, &Loan{}, &Instalment{}, &PenaltyCharge{}, &LoanRestructure{}, &LoanStatusHistory{})...)
+	seedTestAccounts(t, service)
+	loan := createTestLoan(t, service, LoanStatusInArrears, 22000)
+	for number := 1; number <= 2; number++ {
+		dueDate := time.Date(2026, time.Month(number), 1, 0, 0, 0, 0, time.UTC)
+		instalment := Instalment{LoanID: loan.ID, Number: number, DueDate: dueDate, Principal: 10000, Interest: 1000}
+		if err := service.CreateEntity(&instalment); err != nil {
+			t.Fatalf("failed to create instalment: %v", err)
+		}
+	}
+
+	restructure := LoanRestructure{LoanID: loan.ID, ArrearsTreatment: ArrearsCapitalize, Term: 3, InterestRate: 12, RequestedByID: 1}
+	if err := NewRestructureModel(service).RequestRestructure(&restructure); err != nil {
+		t.Fatalf("RequestRestructure returned %v", err)
+	}
+
+	// The penalty job charges the first instalment once the approval has read the loan
+	raced := false
+	racing := racingService{Service: service, race: func(tx services.Service, entity interface{}) {
+		read, ok := entity.(*Loan)
+		if !ok || raced {
+			return
+		}
+		raced = true
+		instalments, err := NewScheduleModel(tx).GetLoanSchedule(read.ID)
+		if err != nil {
+			t.Fatalf("GetLoanSchedule returned %v", err)
+		}
+		if _, err := NewPenaltyModel(tx).ChargePenalty(instalments[0].ID, "2026-02-15", 500, 45, PenaltyTypeFlat); err != nil {
+			t.Fatalf("ChargePenalty returned %v", err)
+		}
+	}}
+
+	asOf := time.Date(2026, 2, 15, 0, 0, 0, 0, time.UTC)
+	if _, err := NewRestructureModel(racing).ApproveRestructure(restructure.ID, 2, asOf); err == nil {
+		t.Fatal("ApproveRestructure over a stale balance succeeded, want an error")
+	}
+
+	stored, err := NewRestructureModel(service).GetRestructureByField("id", fmt.Sprintf("%d", restructure.ID))
+	if err != nil {
+		t.Fatalf("GetRestructureByField returned %v", err)
+	}
+	if stored.Status != RestructureStatusPending {
+		t.Errorf("restructure is %s, want it left %s", stored.Status, RestructureStatusPending)
+	}
+}
@@ -0,0 +1,460 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/kifangamukundi/gm/loan/money"
+	"github.com/kifangamukundi/gm/loan/services"
+)
+
+const (
+	SavingsTransactionDeposit    = "deposit"
+	SavingsTransactionWithdrawal = "withdrawal"
+
+	SavingsChannelMpesa     = "mpesa"
+	SavingsChannelCash      = "cash"
+	SavingsChannelGuarantee = "guarantee" // Taken to repay a loan the member guaranteed with their savings
+
+	SavingsStatusPending   = "pending" // Waiting for the M-Pesa result
+	SavingsStatusCompleted = "completed"
+	SavingsStatusFailed    = "failed"
+)
+
+var (
+	ErrInsufficientSavings = errors.New("insufficient savings")
+	ErrSavingsNotPending   = errors.New("savings transaction is not pending")
+)
+
+// releasedPledgeStatuses are the statuses of loans whose savings pledges no longer hold
+// their guarantors' savings
+var releasedPledgeStatuses = map[string]bool{
+	LoanStatusClosed:    true,
+	LoanStatusRejected:  true,
+	LoanStatusCancelled: true,
+}
+
+// SavingsAccount holds a member's savings. It is opened with the member's first deposit.
+type SavingsAccount struct {
+	ID            uint         `gorm:"primaryKey"`
+	MemberID      uint         `gorm:"uniqueIndex"` // Foreign key to Member
+	Member        Member       `gorm:"foreignKey:MemberID;constraint:onDelete:RESTRICT"`
+	AccountNumber string       `gorm:"uniqueIndex;not null"`
+	Balance       money.Amount `gorm:"not null;default:0"` // Completed deposits less withdrawals, including those still being paid out
+
+	CreatedAt time.Time `gorm:"not null"`
+	UpdatedAt time.Time `gorm:"not null"`
+}
+
+// SavingsTransaction is a deposit into or a withdrawal from a savings account. M-Pesa
+// transactions stay pending until their callback arrives; cash is completed when recorded.
+type SavingsTransaction struct {
+	ID          uint           `gorm:"primaryKey"`
+	AccountID   uint           `gorm:"index"` // Foreign key to SavingsAccount
+	Account     SavingsAccount `gorm:"foreignKey:AccountID;constraint:onDelete:CASCADE"`
+	MemberID    uint           `gorm:"index"`
+	Type        string         `gorm:"not null;index"` // deposit, withdrawal
+	Channel     string         `gorm:"not null"`       // mpesa, cash
+	Amount      money.Amount   `gorm:"not null"`
+	Status      string         `gorm:"not null;default:'pending';index"`
+	Reference   string         `gorm:"not null;default:'';index"` // Cash receipt or M-Pesa receipt number
+	PhoneNumber string         `gorm:"not null;default:''"`
+
+	CheckoutRequestID        string `gorm:"index"` // STK push of an M-Pesa deposit
+	OriginatorConversationID string `gorm:"index"` // B2C payout of an M-Pesa withdrawal
+	ResultDesc               string
+
+	ContributionScheduleID *uint                 `gorm:"index;default:null"` // Set when a deposit is the member's group contribution
+	ContributionSchedule   *ContributionSchedule `gorm:"foreignKey:ContributionScheduleID;constraint:onDelete:SET NULL"`
+
+	RecordedByID *uint      `gorm:"index;default:null"`
+	RecordedBy   *User      `gorm:"foreignKey:RecordedByID;constraint:onDelete:SET NULL"`
+	CompletedAt  *time.Time `gorm:"default:null"`
+
+	CreatedAt time.Time `gorm:"not null"`
+	UpdatedAt time.Time `gorm:"not null"`
+}
+
+// SavingsBalance is a member's savings with the part pledged against other members' loans
+type SavingsBalance struct {
+	MemberID  uint
+	Account   *SavingsAccount // nil until the member's first deposit
+	Balance   money.Amount
+	Held      money.Amount // Savings pledged to guarantees that are still in force
+	Available money.Amount // What the member may withdraw
+}
+
+type SavingsModel struct {
+	Service services.Service
+}
+
+func NewSavingsModel(service services.Service) *SavingsModel {
+	return &SavingsModel{Service: service}
+}
+
+// SavingsAccountNumber is the account number of the member's savings account
+func SavingsAccountNumber(memberId uint) string {
+	return fmt.Sprintf("SAV%08d", memberId)
+}
+
+// GetMemberAccount returns the member's savings account, or nil when they have none yet
+func (m *SavingsModel) GetMemberAccount(memberId uint) (*SavingsAccount, error) {
+	var accounts []SavingsAccount
+
+	result, err := m.Service.GetEntitiesByFields(&accounts, map[string]interface{}{"member_id": memberId})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get savings account: %v", err)
+	}
+	if found := *result.(*[]SavingsAccount); len(found) > 0 {
+		return &found[0], nil
+	}
+
+	return nil, nil
+}
+
+// openAccount returns the member's savings account, opening it if they have none
+func (m *SavingsModel) openAccount(memberId uint) (*SavingsAccount, error) {
+	account, err := m.GetMemberAccount(memberId)
+	if err != nil || account != nil {
+		return account, err
+	}
+
+	account = &SavingsAccount{MemberID: memberId, AccountNumber: SavingsAccountNumber(memberId)}
+	if err := m.Service.CreateEntity(account); err != nil {
+		return nil, fmt.Errorf("failed to open savings account: %v", err)
+	}
+
+	return account, nil
+}
+
+// HeldSavings totals what is left on the member's accepted savings pledges, which stay in
+// force until the guaranteed loan is closed
+func (m *SavingsModel) HeldSavings(memberId uint) (money.Amount, error) {
+	var guarantees []LoanGuarantee
+
+	result, err := m.Service.GetEntitiesByFields(&guarantees, map[string]interface{}{
+		"guarantor_id": memberId,
+		"pledge_type":  PledgeTypeSavings,
+		"status":       []string{GuaranteeStatusAccepted, GuaranteeStatusCalled},
+	})
+	if err != nil {
+		return money.Zero, fmt.Errorf("failed to get savings pledges: %v", err)
+	}
+
+	held := money.Zero
+	for _, guarantee := range *result.(*[]LoanGuarantee) {
+		loan := &Loan{ID: guarantee.LoanID}
+		if _, err := m.Service.GetEntityByID(loan, guarantee.LoanID); err != nil {
+			return money.Zero, fmt.Errorf("loan not found: %v", err)
+		}
+		if !releasedPledgeStatuses[loan.Status] {
+			held += guarantee.Remaining()
+		}
+	}
+
+	return held, nil
+}
+
+// GetSavingsBalance returns the member's savings, what is held by their pledges and what
+// they may withdraw
+func (m *SavingsModel) GetSavingsBalance(memberId uint) (SavingsBalance, error) {
+	balance := SavingsBalance{MemberID: memberId}
+
+	account, err := m.GetMemberAccount(memberId)
+	if err != nil {
+		return balance, err
+	}
+	if account != nil {
+		balance.Account = account
+		balance.Balance = account.Balance
+	}
+
+	if balance.Held, err = m.HeldSavings(memberId); err != nil {
+		return balance, err
+	}
+	balance.Available = max(balance.Balance-balance.Held, money.Zero)
+
+	return balance, nil
+}
+
+// adjustBalance adds change to the account balance unless another request changed it first
+func adjustBalance(tx services.Service, account *SavingsAccount, change money.Amount) error {
+	previous := account.Balance
+	account.Balance += change
+
+	updated, err := tx.UpdateEntityIf(account, map[string]interface{}{"balance": previous})
+	if err != nil {
+		return fmt.Errorf("failed to update savings account: %v", err)
+	}
+	if !updated {
+		return fmt.Errorf("savings account %s was changed by another request, try again", account.AccountNumber)
+	}
+
+	return nil
+}
+
+// completeSavingsTransaction marks the transaction completed and posts it to the ledger,
+// crediting deposits to the account. Withdrawals left the balance when they were recorded.
+func completeSavingsTransaction(tx services.Service, transaction *SavingsTransaction, account *SavingsAccount, completedAt time.Time) error {
+	transaction.Status = SavingsStatusCompleted
+	transaction.CompletedAt = &completedAt
+
+	if transaction.ID == 0 {
+		if err := tx.CreateEntity(transaction); err != nil {
+			return fmt.Errorf("failed to record savings transaction: %v", err)
+		}
+	} else {
+		updated, err := tx.UpdateEntityIf(transaction, map[string]interface{}{"status": SavingsStatusPending})
+		if err != nil {
+			return fmt.Errorf("failed to update savings transaction: %v", err)
+		}
+		if !updated {
+			return fmt.Errorf("%w: it was completed by another request", ErrSavingsNotPending)
+		}
+	}
+
+	if transaction.Type == SavingsTransactionDeposit {
+		if err := adjustBalance(tx, account, transaction.Amount); err != nil {
+			return err
+		}
+	}
+
+	if err := NewLedgerModel(tx).PostSavings(*transaction); err != nil {
+		return fmt.Errorf("error posting savings to the ledger: %v", err)
+	}
+
+	return nil
+}
+
+// transferToLoan takes a called savings pledge out of the guarantor's savings. It is recorded
+// as a completed withdrawal whose ledger posting is the repayment it funds. Savings held by
+// the guarantor's other pledges cannot be taken.
+func transferToLoan(tx services.Service, guarantee *LoanGuarantee, amount money.Amount, recordedById uint, transferredAt time.Time) (SavingsTransaction, error) {
+	savingsModel := NewSavingsModel(tx)
+
+	account, err := savingsModel.GetMemberAccount(guarantee.GuarantorID)
+	if err != nil {
+		return SavingsTransaction{}, err
+	}
+
+	held, err := savingsModel.HeldSavings(guarantee.GuarantorID)
+	if err != nil {
+		return SavingsTransaction{}, err
+	}
+	otherHolds := max(held-guarantee.Remaining(), money.Zero)
+
+	available := money.Zero
+	if account != nil {
+		available = max(account.Balance-otherHolds, money.Zero)
+	}
+	if amount > available {
+		return SavingsTransaction{}, fmt.Errorf("%w: guarantor has %s in savings not held by other pledges", ErrInsufficientSavings, available)
+	}
+
+	if err := adjustBalance(tx, account, -amount); err != nil {
+		return SavingsTransaction{}, err
+	}
+
+	transaction := SavingsTransaction{
+		AccountID:    account.ID,
+		MemberID:     guarantee.GuarantorID,
+		Type:         SavingsTransactionWithdrawal,
+		Channel:      SavingsChannelGuarantee,
+		Amount:       amount,
+		Status:       SavingsStatusCompleted,
+		Reference:    fmt.Sprintf("LOAN-%d", guarantee.LoanID),
+		RecordedByID: &recordedById,
+		CompletedAt:  &transferredAt,
+	}
+	if err := tx.CreateEntity(&transaction); err != nil {
+		return SavingsTransaction{}, fmt.Errorf("failed to record savings transfer: %v", err)
+	}
+
+	return transaction, nil
+}
+
+// RecordDeposit opens the member's account if needed and records a deposit into it. Cash is
+// credited straight away; M-Pesa deposits wait for CompleteSavingsTransaction.
+func (m *SavingsModel) RecordDeposit(transaction *SavingsTransaction) error {
+	if transaction.Amount <= 0 {
+		return fmt.Errorf("deposit amount must be positive")
+	}
+
+	return m.Service.WithTransaction(func(tx services.Service) error {
+		account, err := NewSavingsModel(tx).openAccount(transaction.MemberID)
+		if err != nil {
+			return err
+		}
+
+		if transaction.ContributionScheduleID != nil {
+			if err := NewSavingsModel(tx).checkContribution(*transaction.ContributionScheduleID, transaction.MemberID); err != nil {
+				return err
+			}
+		}
+
+		transaction.AccountID = account.ID
+		transaction.Type = SavingsTransactionDeposit
+
+		if transaction.Channel == SavingsChannelCash {
+			return completeSavingsTransaction(tx, transaction, account, time.Now())
+		}
+
+		transaction.Status = SavingsStatusPending
+		if err := tx.CreateEntity(transaction); err != nil {
+			return fmt.Errorf("failed to record savings deposit: %v", err)
+		}
+		return nil
+	})
+}
+
+// RecordWithdrawal takes a withdrawal out of what the member may withdraw. M-Pesa withdrawals
+// stay pending until the payout's result arrives.
+func (m *SavingsModel) RecordWithdrawal(transaction *SavingsTransaction) error {
+	if transaction.Amount <= 0 {
+		return fmt.Errorf("withdrawal amount must be positive")
+	}
+
+	return m.Service.WithTransaction(func(tx services.Service) error {
+		balance, err := NewSavingsModel(tx).GetSavingsBalance(transaction.MemberID)
+		if err != nil {
+			return err
+		}
+		if balance.Account == nil || transaction.Amount > balance.Available {
+			return fmt.Errorf("%w: %s is available, %s is held by savings pledges", ErrInsufficientSavings, balance.Available, balance.Held)
+		}
+
+		account := balance.Account
+		if err := adjustBalance(tx, account, -transaction.Amount); err != nil {
+			return err
+		}
+
+		transaction.AccountID = account.ID
+		transaction.Type = SavingsTransactionWithdrawal
+		transaction.ContributionScheduleID = nil
+
+		if transaction.Channel == SavingsChannelCash {
+			return completeSavingsTransaction(tx, transaction, account, time.Now())
+		}
+
+		transaction.Status = SavingsStatusPending
+		if err := tx.CreateEntity(transaction); err != nil {
+			return fmt.Errorf("failed to record savings withdrawal: %v", err)
+		}
+		return nil
+	})
+}
+
+func (m *SavingsModel) GetSavingsTransactionByField(field, value string) (*SavingsTransaction, error) {
+	var transaction SavingsTransaction
+
+	result, err := m.Service.GetEntityByField(field, value, &transaction)
+	if err != nil {
+		log.Printf("Error fetching savings transaction by %s: %v", field, err)
+		return nil, err
+	}
+
+	return result.(*SavingsTransaction), nil
+}
+
+// SetPayoutReference stores the conversation M-Pesa accepted a withdrawal's payout under, so
+// the B2C result can be matched to it
+func (m *SavingsModel) SetPayoutReference(id uint, originatorConversationId string) error {
+	transaction := &SavingsTransaction{ID: id}
+	if _, err := m.Service.GetEntityByID(transaction, id); err != nil {
+		return fmt.Errorf("savings transaction not found: %v", err)
+	}
+
+	transaction.OriginatorConversationID = originatorConversationId
+	if err := m.Service.UpdateEntity(transaction); err != nil {
+		return fmt.Errorf("failed to update savings transaction: %v", err)
+	}
+
+	return nil
+}
+
+// CompleteSavingsTransaction applies the successful M-Pesa result of a pending transaction.
+// For deposits amount is what M-Pesa actually collected.
+func (m *SavingsModel) CompleteSavingsTransaction(id uint, amount money.Amount, receipt, resultDesc string) (SavingsTransaction, error) {
+	var transaction SavingsTransaction
+
+	err := m.Service.WithTransaction(func(tx services.Service) error {
+		transaction = SavingsTransaction{ID: id}
+		if _, err := tx.GetEntityByID(&transaction, id); err != nil {
+			return fmt.Errorf("savings transaction not found: %v", err)
+		}
+		if transaction.Status != SavingsStatusPending {
+			return fmt.Errorf("%w: it is %s", ErrSavingsNotPending, transaction.Status)
+		}
+
+		account := &SavingsAccount{ID: transaction.AccountID}
+		if _, err := tx.GetEntityByID(account, transaction.AccountID); err != nil {
+			return fmt.Errorf("savings account not found: %v", err)
+		}
+
+		if transaction.Type == SavingsTransactionDeposit && amount > 0 {
+			transaction.Amount = amount
+		}
+		transaction.Reference = receipt
+		transaction.ResultDesc = resultDesc
+
+		return completeSavingsTransaction(tx, &transaction, account, time.Now())
+	})
+	if err != nil {
+		return SavingsTransaction{}, err
+	}
+
+	return transaction, nil
+}
+
+// FailSavingsTransaction records that M-Pesa did not complete a pending transaction and
+// returns a failed withdrawal to the member's balance
+func (m *SavingsModel) FailSavingsTransaction(id uint, resultDesc string) (SavingsTransaction, error) {
+	var transaction SavingsTransaction
+
+	err := m.Service.WithTransaction(func(tx services.Service) error {
+		transaction = SavingsTransaction{ID: id}
+		if _, err := tx.GetEntityByID(&transaction, id); err != nil {
+			return fmt.Errorf("savings transaction not found: %v", err)
+		}
+
+		transaction.Status = SavingsStatusFailed
+		transaction.ResultDesc = resultDesc
+
+		updated, err := tx.UpdateEntityIf(&transaction, map[string]interface{}{"status": SavingsStatusPending})
+		if err != nil {
+			return fmt.Errorf("failed to update savings transaction: %v", err)
+		}
+		if !updated {
+			return fmt.Errorf("%w: it was completed by another request", ErrSavingsNotPending)
+		}
+
+		if transaction.Type != SavingsTransactionWithdrawal {
+			return nil
+		}
+
+		account := &SavingsAccount{ID: transaction.AccountID}
+		if _, err := tx.GetEntityByID(account, transaction.AccountID); err != nil {
+			return fmt.Errorf("savings account not found: %v", err)
+		}
+		return adjustBalance(tx, account, transaction.Amount)
+	})
+	if err != nil {
+		return SavingsTransaction{}, err
+	}
+
+	return transaction, nil
+}
+
+// GetMemberTransactions returns the member's deposits and withdrawals, newest first
+func (m *SavingsModel) GetMemberTransactions(memberId uint) ([]SavingsTransaction, error) {
+	var transactions []SavingsTransaction
+
+	result, err := m.Service.GetOrderedEntitiesByField(&transactions, "member_id", memberId, "id desc", "RecordedBy")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get savings transactions: %v", err)
+	}
+
+	return *result.(*[]SavingsTransaction), nil
+}
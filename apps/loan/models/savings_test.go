@@ -0,0 +1,129 @@
+package models
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kifangamukundi/gm/loan/money"
+	"github.com/kifangamukundi/gm/loan/services"
+)
+
+// savingsEntities are the tables guarantees and savings need besides the ledger's
+var savingsEntities = append([]interface{}{&User{}, &Group{}, &Member{}, &Loan{}, &LoanGuarantee{}, &GuaranteeRecovery{},
+	&SavingsAccount{}, &SavingsTransaction{}, &ContributionSchedule{}}, ledgerEntities...)
+
+// createGroupMembers stores a group with a borrower and a guarantor in it
+func createGroupMembers(t *testing.T, service services.Service) (Group, Member, Member) {
+	t.Helper()
+
+	group := Group{GroupName: "Test group"}
+	if err := service.CreateEntity(&group); err != nil {
+		t.Fatalf("failed to create group: %v", err)
+	}
+
+	members := []Member{{UserID: 1, Groups: []Group{group}}, {UserID: 2, Groups: []Group{group}}}
+	for i := range members {
+		if err := service.CreateEntity(&members[i]); err != nil {
+			t.Fatalf("failed to create member: %v", err)
+		}
+	}
+
+	return group, members[0], members[1]
+}
+
+// createGroupLoan stores a loan in status for the borrower in the group
+func createGroupLoan(t *testing.T, service services.Service, group Group, borrower Member, status string) Loan {
+	t.Helper()
+
+	purpose := "Stock for the shop"
+	loan := Loan{Amount: money.FromShillings(20000), Term: 2, Status: status, GroupID: group.ID, MemberID: borrower.ID, LoanPurpose: &purpose}
+	if err := service.CreateEntity(&loan); err != nil {
+		t.Fatalf("failed to create loan: %v", err)
+	}
+	return loan
+}
+
+func depositSavings(t *testing.T, service services.Service, member Member, amount money.Amount) {
+	t.Helper()
+
+	deposit := SavingsTransaction{MemberID: member.ID, Channel: SavingsChannelCash, Amount: amount, Reference: "Cash"}
+	if err := NewSavingsModel(service).RecordDeposit(&deposit); err != nil {
+		t.Fatalf("RecordDeposit returned %v", err)
+	}
+}
+
+func TestSavingsPledgesNeedAvailableSavings(t *testing.T) {
+	service := newTestService(t, savingsEntities...)
+	seedTestAccounts(t, service)
+	group, borrower, guarantor := createGroupMembers(t, service)
+	first := createGroupLoan(t, service, group, borrower, LoanStatusPending)
+	second := createGroupLoan(t, service, group, borrower, LoanStatusPending)
+	depositSavings(t, service, guarantor, money.FromShillings(10000))
+	model := NewGuaranteeModel(service)
+
+	tooMuch := LoanGuarantee{LoanID: first.ID, GuarantorID: guarantor.ID, PledgeType: PledgeTypeSavings, Amount: money.FromShillings(12000)}
+	if err := model.AddGuarantee(&tooMuch); !errors.Is(err, ErrInsufficientSavings) {
+		t.Fatalf("pledging more than the savings returned %v, want %v", err, ErrInsufficientSavings)
+	}
+
+	pledges := []LoanGuarantee{
+		{LoanID: first.ID, GuarantorID: guarantor.ID, PledgeType: PledgeTypeSavings, Amount: money.FromShillings(6000)},
+		{LoanID: second.ID, GuarantorID: guarantor.ID, PledgeType: PledgeTypeSavings, Amount: money.FromShillings(6000)},
+	}
+	for i := range pledges {
+		if err := model.AddGuarantee(&pledges[i]); err != nil {
+			t.Fatalf("AddGuarantee returned %v", err)
+		}
+	}
+
+	// Accepting the first pledge holds its savings, which leaves too little for the second
+	if _, err := model.RespondToGuarantee(pledges[0].ID, guarantor.UserID, true, ""); err != nil {
+		t.Fatalf("accepting the first pledge returned %v", err)
+	}
+	if _, err := model.RespondToGuarantee(pledges[1].ID, guarantor.UserID, true, ""); !errors.Is(err, ErrInsufficientSavings) {
+		t.Fatalf("accepting the second pledge returned %v, want %v", err, ErrInsufficientSavings)
+	}
+
+	balance, err := NewSavingsModel(service).GetSavingsBalance(guarantor.ID)
+	if err != nil {
+		t.Fatalf("GetSavingsBalance returned %v", err)
+	}
+	if balance.Held != money.FromShillings(6000) || balance.Available != money.FromShillings(4000) {
+		t.Errorf("savings hold %s with %s available, want 6000.00 and 4000.00", balance.Held, balance.Available)
+	}
+}
+
+func TestTransferToLoanLeavesOtherPledgesHeld(t *testing.T) {
+	service := newTestService(t, savingsEntities...)
+	seedTestAccounts(t, service)
+	group, borrower, guarantor := createGroupMembers(t, service)
+	defaulted := createGroupLoan(t, service, group, borrower, LoanStatusDefaulted)
+	active := createGroupLoan(t, service, group, borrower, LoanStatusActive)
+	depositSavings(t, service, guarantor, money.FromShillings(8000))
+
+	// Pledges accepted before they were checked against savings hold more than the guarantor has
+	called := LoanGuarantee{LoanID: defaulted.ID, GuarantorID: guarantor.ID, PledgeType: PledgeTypeSavings, Amount: money.FromShillings(6000), Status: GuaranteeStatusAccepted}
+	held := LoanGuarantee{LoanID: active.ID, GuarantorID: guarantor.ID, PledgeType: PledgeTypeSavings, Amount: money.FromShillings(4000), Status: GuaranteeStatusAccepted}
+	for _, guarantee := range []*LoanGuarantee{&called, &held} {
+		if err := service.CreateEntity(guarantee); err != nil {
+			t.Fatalf("failed to create guarantee: %v", err)
+		}
+	}
+
+	now := time.Now()
+	if _, err := transferToLoan(service, &called, money.FromShillings(6000), 1, now); !errors.Is(err, ErrInsufficientSavings) {
+		t.Fatalf("transferring savings held by another pledge returned %v, want %v", err, ErrInsufficientSavings)
+	}
+	if _, err := transferToLoan(service, &called, money.FromShillings(4000), 1, now); err != nil {
+		t.Fatalf("transferToLoan returned %v", err)
+	}
+
+	account, err := NewSavingsModel(service).GetMemberAccount(guarantor.ID)
+	if err != nil {
+		t.Fatalf("GetMemberAccount returned %v", err)
+	}
+	if account.Balance != money.FromShillings(4000) {
+		t.Errorf("savings balance = %s, want the 4000.00 the other pledge holds", account.Balance)
+	}
+}
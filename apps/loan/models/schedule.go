@@ -0,0 +1,261 @@
+package models
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/kifangamukundi/gm/loan/money"
+	"github.com/kifangamukundi/gm/loan/services"
+)
+
+const (
+	InterestMethodFlat            = "flat"
+	InterestMethodReducingBalance = "reducing_balance"
+
+	FrequencyDaily   = "daily"
+	FrequencyWeekly  = "weekly"
+	FrequencyMonthly = "monthly"
+
+	InstalmentStatusPending       = "pending"
+	InstalmentStatusPartiallyPaid = "partially_paid"
+	InstalmentStatusPaid          = "paid"
+	InstalmentStatusRestructured  = "restructured"
+)
+
+type Instalment struct {
+	ID             uint         `gorm:"primaryKey"`
+	LoanID         uint         `gorm:"index"` // Foreign key to Loan
+	Loan           Loan         `gorm:"foreignKey:LoanID;constraint:onDelete:CASCADE"`
+	Number         int          `gorm:"not null"`                   // 1-based position in the schedule
+	DueDate        time.Time    `gorm:"not null;index"`             // Date the instalment falls due
+	Principal      money.Amount `gorm:"not null;default:0"`         // Principal portion
+	Interest       money.Amount `gorm:"not null;default:0"`         // Interest portion
+	Fees           money.Amount `gorm:"not null;default:0"`         // Fees portion
+	TotalDue       money.Amount `gorm:"not null;default:0"`         // Principal + Interest + Fees
+	OpeningBalance money.Amount `gorm:"not null;default:0"`         // Principal outstanding before this instalment
+	ClosingBalance money.Amount `gorm:"not null;default:0"`         // Principal outstanding after this instalment
+	Status         string       `gorm:"not null;default:'pending'"` // pending, partially_paid, paid, restructured
+	CreatedAt      time.Time    `gorm:"not null"`
+	UpdatedAt      time.Time    `gorm:"not null"`
+
+	// Penalties and amounts collected so far, see PaymentAllocation
+	Penalty       money.Amount `gorm:"not null;default:0"` // Penalties charged against this instalment
+	PenaltyPaid   money.Amount `gorm:"not null;default:0"`
+	FeesPaid      money.Amount `gorm:"not null;default:0"`
+	InterestPaid  money.Amount `gorm:"not null;default:0"`
+	PrincipalPaid money.Amount `gorm:"not null;default:0"`
+	PaidAt        *time.Time   `gorm:"default:null"` // When the instalment was cleared
+
+	// Set when the unpaid part of the instalment was moved to a new schedule
+	RestructureID *uint `gorm:"index;default:null"`
+}
+
+// ScheduleTerms are the inputs needed to build a repayment schedule.
+// Rate is the annual interest percentage, charged pro rata for each
+// repayment period (see PeriodRate), unless RatePerPeriod says it is
+// already the percentage per period. Fees is the total fee amount spread
+// evenly across the instalments and GracePeriods pushes the first due
+// date back by that many periods.
+type ScheduleTerms struct {
+	Principal     money.Amount
+	Rate          float64
+	RatePerPeriod bool
+	Periods       int
+	Method        string
+	Frequency     string
+	Fees          money.Amount
+	GracePeriods  int
+	StartDate     time.Time
+}
+
+type ScheduleModel struct {
+	Service services.Service
+}
+
+func NewScheduleModel(service services.Service) *ScheduleModel {
+	return &ScheduleModel{Service: service}
+}
+
+// PeriodsPerYear returns how many repayment periods of the given frequency make up a year.
+func PeriodsPerYear(frequency string) int {
+	switch frequency {
+	case FrequencyDaily:
+		return 365
+	case FrequencyWeekly:
+		return 52
+	default:
+		return 12
+	}
+}
+
+// PeriodRate converts an annual interest percentage to the percentage charged per repayment period.
+func PeriodRate(annualRate float64, frequency string) float64 {
+	return annualRate / float64(PeriodsPerYear(frequency))
+}
+
+// NextDueDate returns the date that lies the given number of repayment periods after from.
+func NextDueDate(from time.Time, frequency string, periods int) time.Time {
+	switch frequency {
+	case FrequencyDaily:
+		return from.AddDate(0, 0, periods)
+	case FrequencyWeekly:
+		return from.AddDate(0, 0, 7*periods)
+	default:
+		// Clamp to the last day of the month so a loan taken on the 31st stays on month ends
+		year, month, day := from.Date()
+		lastDay := time.Date(year, month+time.Month(periods)+1, 0, 0, 0, 0, 0, from.Location()).Day()
+		if day > lastDay {
+			day = lastDay
+		}
+		return time.Date(year, month+time.Month(periods), day, from.Hour(), from.Minute(), from.Second(), from.Nanosecond(), from.Location())
+	}
+}
+
+// BuildSchedule computes the instalments for the given terms without persisting them.
+// Every per period amount is rounded half away from zero to the cent, and the rounding
+// differences are absorbed by the final instalment so the schedule always sums back to
+// the principal and fees exactly.
+func BuildSchedule(terms ScheduleTerms) ([]Instalment, error) {
+	if terms.Principal <= 0 {
+		return nil, fmt.Errorf("principal must be greater than zero")
+	}
+	if terms.Periods <= 0 {
+		return nil, fmt.Errorf("term must be at least one period")
+	}
+	if terms.Rate < 0 {
+		return nil, fmt.Errorf("interest rate cannot be negative")
+	}
+
+	periodRate := terms.Rate
+	if !terms.RatePerPeriod {
+		periodRate = PeriodRate(terms.Rate, terms.Frequency)
+	}
+	rate := periodRate / 100
+	n := terms.Periods
+	principal := terms.Principal
+	feePerPeriod := terms.Fees.Div(n)
+
+	if terms.Method != InterestMethodFlat && terms.Method != InterestMethodReducingBalance {
+		return nil, fmt.Errorf("unsupported interest method: %s", terms.Method)
+	}
+
+	// Equal instalment (annuity) amount used by the reducing balance method
+	instalmentAmount := principal.Div(n)
+	if rate > 0 {
+		instalmentAmount = principal.Mul(rate / (1 - math.Pow(1+rate, float64(-n))))
+	}
+
+	flatInterest := principal.Percent(periodRate)
+	flatPrincipal := principal.Div(n)
+
+	instalments := make([]Instalment, 0, n)
+	balance := principal
+	feesCharged := money.Zero
+
+	for i := 1; i <= n; i++ {
+		var interest, principalPart money.Amount
+
+		if terms.Method == InterestMethodReducingBalance {
+			interest = balance.Percent(periodRate)
+			principalPart = instalmentAmount - interest
+		} else {
+			interest = flatInterest
+			principalPart = flatPrincipal
+		}
+
+		if principalPart > balance {
+			principalPart = balance
+		}
+
+		fees := feePerPeriod
+		if i == n {
+			principalPart = balance
+			fees = terms.Fees - feesCharged
+		}
+
+		closing := balance - principalPart
+
+		instalments = append(instalments, Instalment{
+			Number:         i,
+			DueDate:        NextDueDate(terms.StartDate, terms.Frequency, terms.GracePeriods+i),
+			Principal:      principalPart,
+			Interest:       interest,
+			Fees:           fees,
+			TotalDue:       principalPart + interest + fees,
+			OpeningBalance: balance,
+			ClosingBalance: closing,
+			Status:         InstalmentStatusPending,
+		})
+
+		balance = closing
+		feesCharged += fees
+	}
+
+	return instalments, nil
+}
+
+// TermsForLoan derives the schedule terms from a loan starting at the given date.
+func TermsForLoan(loan *Loan, start time.Time) ScheduleTerms {
+	return ScheduleTerms{
+		Principal:     loan.Amount,
+		Rate:          loan.Interest,
+		RatePerPeriod: loan.RatePerPeriod,
+		Periods:       loan.Term,
+		Method:        loan.InterestMethod,
+		Frequency:     loan.RepaymentFrequency,
+		Fees:          loan.Fees,
+		GracePeriods:  loan.GracePeriod,
+		StartDate:     start,
+	}
+}
+
+// CreateSchedule replaces any existing schedule for the loan with a freshly generated one
+// and keeps the loan's DueDate and RemainingBalance consistent with it.
+func (m *ScheduleModel) CreateSchedule(loan *Loan, start time.Time) ([]Instalment, error) {
+	instalments, err := BuildSchedule(TermsForLoan(loan, start))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build schedule: %v", err)
+	}
+
+	existing, err := m.GetLoanSchedule(loan.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, instalment := range existing {
+		if err := m.Service.HardDeleteEntity(&Instalment{}, instalment.ID, "instalment"); err != nil {
+			return nil, fmt.Errorf("failed to clear schedule: %v", err)
+		}
+	}
+
+	total := money.Zero
+	for i := range instalments {
+		instalments[i].LoanID = loan.ID
+		if err := m.Service.CreateEntity(&instalments[i]); err != nil {
+			return nil, fmt.Errorf("failed to create instalment: %v", err)
+		}
+		total += instalments[i].TotalDue
+	}
+
+	dueDate := instalments[len(instalments)-1].DueDate
+	loan.DueDate = &dueDate
+	loan.RemainingBalance = total
+
+	if err := NewLoanModel(m.Service).UpdateLoanColumns(loan, "due_date", "remaining_balance"); err != nil {
+		return nil, err
+	}
+
+	return instalments, nil
+}
+
+func (m *ScheduleModel) GetLoanSchedule(loanId uint) ([]Instalment, error) {
+	var instalments []Instalment
+
+	result, err := m.Service.GetOrderedEntitiesByField(&instalments, "loan_id", loanId, "number asc")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get schedule: %v", err)
+	}
+
+	return *result.(*[]Instalment), nil
+}
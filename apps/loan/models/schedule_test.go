@@ -0,0 +1,124 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kifangamukundi/gm/loan/money"
+)
+
+func TestBuildSchedule(t *testing.T) {
+	start := time.Date(2026, 1, 31, 9, 0, 0, 0, time.UTC)
+
+	type row struct {
+		principal, interest, fees money.Amount
+		dueDate                   time.Time
+	}
+
+	tests := []struct {
+		name  string
+		terms ScheduleTerms
+		want  []row
+	}{
+		{
+			name:  "flat with fees left over on the last instalment",
+			terms: ScheduleTerms{Principal: money.FromShillings(12000), Rate: 12, Periods: 3, Method: InterestMethodFlat, Frequency: FrequencyMonthly, Fees: money.FromShillings(100), StartDate: start},
+			want: []row{
+				{principal: money.FromShillings(4000), interest: money.FromShillings(120), fees: 3333, dueDate: time.Date(2026, 2, 28, 9, 0, 0, 0, time.UTC)},
+				{principal: money.FromShillings(4000), interest: money.FromShillings(120), fees: 3333, dueDate: time.Date(2026, 3, 31, 9, 0, 0, 0, time.UTC)},
+				{principal: money.FromShillings(4000), interest: money.FromShillings(120), fees: 3334, dueDate: time.Date(2026, 4, 30, 9, 0, 0, 0, time.UTC)},
+			},
+		},
+		{
+			name:  "reducing balance annuity",
+			terms: ScheduleTerms{Principal: money.FromShillings(1000), Rate: 12, Periods: 2, Method: InterestMethodReducingBalance, Frequency: FrequencyMonthly, StartDate: start},
+			want: []row{
+				{principal: 49751, interest: 1000, dueDate: time.Date(2026, 2, 28, 9, 0, 0, 0, time.UTC)},
+				{principal: 50249, interest: 502, dueDate: time.Date(2026, 3, 31, 9, 0, 0, 0, time.UTC)},
+			},
+		},
+		{
+			name:  "interest free with rounding on the last instalment",
+			terms: ScheduleTerms{Principal: money.FromShillings(1000), Rate: 0, Periods: 3, Method: InterestMethodReducingBalance, Frequency: FrequencyWeekly, StartDate: start},
+			want: []row{
+				{principal: 33333, dueDate: start.AddDate(0, 0, 7)},
+				{principal: 33333, dueDate: start.AddDate(0, 0, 14)},
+				{principal: 33334, dueDate: start.AddDate(0, 0, 21)},
+			},
+		},
+		{
+			name:  "rate charged per period on a loan made before rates became annual",
+			terms: ScheduleTerms{Principal: money.FromShillings(1000), Rate: 5, RatePerPeriod: true, Periods: 2, Method: InterestMethodFlat, Frequency: FrequencyMonthly, StartDate: start},
+			want: []row{
+				{principal: money.FromShillings(500), interest: money.FromShillings(50), dueDate: time.Date(2026, 2, 28, 9, 0, 0, 0, time.UTC)},
+				{principal: money.FromShillings(500), interest: money.FromShillings(50), dueDate: time.Date(2026, 3, 31, 9, 0, 0, 0, time.UTC)},
+			},
+		},
+		{
+			name:  "grace periods push the first due date back",
+			terms: ScheduleTerms{Principal: money.FromShillings(700), Rate: 36.5, Periods: 1, Method: InterestMethodFlat, Frequency: FrequencyDaily, GracePeriods: 2, StartDate: start},
+			want: []row{
+				{principal: money.FromShillings(700), interest: 70, dueDate: start.AddDate(0, 0, 3)},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instalments, err := BuildSchedule(tt.terms)
+			if err != nil {
+				t.Fatalf("BuildSchedule returned %v", err)
+			}
+			if len(instalments) != len(tt.want) {
+				t.Fatalf("got %d instalments, want %d", len(instalments), len(tt.want))
+			}
+
+			balance := tt.terms.Principal
+			for i, instalment := range instalments {
+				want := tt.want[i]
+				if instalment.Number != i+1 {
+					t.Errorf("instalment %d has number %d", i+1, instalment.Number)
+				}
+				if instalment.Principal != want.principal || instalment.Interest != want.interest || instalment.Fees != want.fees {
+					t.Errorf("instalment %d = %s principal, %s interest, %s fees, want %s, %s, %s", i+1,
+						instalment.Principal, instalment.Interest, instalment.Fees, want.principal, want.interest, want.fees)
+				}
+				if instalment.TotalDue != instalment.Principal+instalment.Interest+instalment.Fees {
+					t.Errorf("instalment %d total %s does not add up", i+1, instalment.TotalDue)
+				}
+				if !instalment.DueDate.Equal(want.dueDate) {
+					t.Errorf("instalment %d due %s, want %s", i+1, instalment.DueDate, want.dueDate)
+				}
+				if instalment.OpeningBalance != balance || instalment.ClosingBalance != balance-instalment.Principal {
+					t.Errorf("instalment %d balances %s to %s, want %s to %s", i+1, instalment.OpeningBalance, instalment.ClosingBalance, balance, balance-instalment.Principal)
+				}
+				balance = instalment.ClosingBalance
+			}
+			if balance != 0 {
+				t.Errorf("schedule leaves %s of principal unpaid", balance)
+			}
+		})
+	}
+}
+
+func TestBuildScheduleRejectsInvalidTerms(t *testing.T) {
+	valid := ScheduleTerms{Principal: money.FromShillings(1000), Rate: 10, Periods: 3, Method: InterestMethodFlat, Frequency: FrequencyMonthly}
+
+	tests := []struct {
+		name   string
+		change func(*ScheduleTerms)
+	}{
+		{name: "no principal", change: func(terms *ScheduleTerms) { terms.Principal = 0 }},
+		{name: "no periods", change: func(terms *ScheduleTerms) { terms.Periods = 0 }},
+		{name: "negative rate", change: func(terms *ScheduleTerms) { terms.Rate = -1 }},
+		{name: "unknown method", change: func(terms *ScheduleTerms) { terms.Method = "compound" }},
+	}
+
+	for _, tt := range tests {
+		terms := valid
+		tt.change(&terms)
+		if _, err := BuildSchedule(terms); err == nil {
+			t.Errorf("%s: BuildSchedule succeeded, want an error", tt.name)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/kifangamukundi/gm/loan/money"
+	"github.com/kifangamukundi/gm/loan/services"
+)
+
+const (
+	SettlementQuoteOpen    = "open"
+	SettlementQuoteSettled = "settled"
+
+	SettlementQuoteMaxDays = 30 // Furthest ahead a quote may be valid
+)
+
+var ErrNotSettleable = errors.New("loan cannot be settled")
+
+// SettlementQuote is what it costs to close a loan early, worked out at AsOf and honoured for
+// payments of at least Total made up to ValidUntil.
+type SettlementQuote struct {
+	ID         uint      `gorm:"primaryKey"`
+	LoanID     uint      `gorm:"index"` // Foreign key to Loan
+	Loan       Loan      `gorm:"foreignKey:LoanID;constraint:onDelete:CASCADE"`
+	AsOf       time.Time `gorm:"not null"`       // When the amounts were worked out
+	ValidUntil time.Time `gorm:"not null;index"` // Last moment a payment settles the loan at Total
+
+	Principal         money.Amount `gorm:"not null;default:0"` // Principal still outstanding
+	AccruedInterest   money.Amount `gorm:"not null;default:0"` // Unpaid interest due or accrued by AsOf
+	UnaccruedInterest money.Amount `gorm:"not null;default:0"` // Unpaid interest for the time after AsOf
+	Fees              money.Amount `gorm:"not null;default:0"`
+	Penalties         money.Amount `gorm:"not null;default:0"`
+	RebateRate        float64      `gorm:"not null;default:0"` // Percentage of UnaccruedInterest waived
+	Rebate            money.Amount `gorm:"not null;default:0"`
+	Total             money.Amount `gorm:"not null;default:0"` // Amount that closes the loan
+
+	Status      string     `gorm:"not null;default:'open';index"` // open, settled
+	PaymentID   *uint      `gorm:"index;default:null"`            // Payment that settled the loan
+	Payment     *Payment   `gorm:"foreignKey:PaymentID;constraint:onDelete:SET NULL"`
+	SettledAt   *time.Time `gorm:"default:null"`
+	CreatedByID *uint      `gorm:"index;default:null"`
+	CreatedBy   *User      `gorm:"foreignKey:CreatedByID;constraint:onDelete:SET NULL"`
+
+	CreatedAt time.Time `gorm:"not null"`
+	UpdatedAt time.Time `gorm:"not null"`
+}
+
+type SettlementModel struct {
+	Service services.Service
+}
+
+func NewSettlementModel(service services.Service) *SettlementModel {
+	return &SettlementModel{Service: service}
+}
+
+// canSettle reports whether a loan in the given status may be settled early
+func canSettle(status string) bool {
+	return CanTransition(status, LoanStatusClosed)
+}
+
+// unaccruedInterest returns, for each instalment, the unpaid interest for the time after asOf.
+// Interest accrues evenly from the previous due date, or the disbursement, to the due date.
+func unaccruedInterest(loan *Loan, instalments []Instalment, asOf time.Time) []money.Amount {
+	unaccrued := make([]money.Amount, len(instalments))
+
+	start := asOf
+	if loan.DisbursedAt != nil {
+		start = *loan.DisbursedAt
+	}
+
+	for i := range instalments {
+		instalment := &instalments[i]
+		if instalment.Status == InstalmentStatusRestructured {
+			continue
+		}
+
+		periodStart := start
+		start = instalment.DueDate
+
+		outstanding := instalment.Outstanding(ComponentInterest)
+		if outstanding <= 0 || !instalment.DueDate.After(asOf) {
+			continue
+		}
+
+		if !periodStart.Before(asOf) || !periodStart.Before(instalment.DueDate) {
+			unaccrued[i] = outstanding
+			continue
+		}
+
+		elapsed := float64(asOf.Sub(periodStart)) / float64(instalment.DueDate.Sub(periodStart))
+		accrued := instalment.Interest.Mul(elapsed) - instalment.InterestPaid
+		if accrued < 0 {
+			accrued = 0
+		}
+		unaccrued[i] = outstanding - money.Min(accrued, outstanding)
+	}
+
+	return unaccrued
+}
+
+// settlementRebateRate is the percentage of unaccrued interest the loan's product waives on
+// early settlement
+func settlementRebateRate(loan *Loan) float64 {
+	if loan.Product == nil {
+		return 0
+	}
+	return loan.Product.SettlementRebate
+}
+
+// settlementRebates returns the rebate earned on each instalment when the loan is settled
+// at asOf with the given rebate percentage
+func settlementRebates(loan *Loan, instalments []Instalment, asOf time.Time, rate float64) []money.Amount {
+	rebates := unaccruedInterest(loan, instalments, asOf)
+	for i := range rebates {
+		rebates[i] = rebates[i].Percent(rate)
+	}
+	return rebates
+}
+
+// QuoteSettlement works out what it costs to close the loan at asOf
+func QuoteSettlement(loan *Loan, instalments []Instalment, rebateRate float64, asOf time.Time) SettlementQuote {
+	quote := SettlementQuote{
+		LoanID:     loan.ID,
+		AsOf:       asOf,
+		RebateRate: rebateRate,
+		Status:     SettlementQuoteOpen,
+	}
+
+	interest := money.Zero
+	for _, instalment := range instalments {
+		quote.Principal += instalment.Outstanding(ComponentPrincipal)
+		quote.Fees += instalment.Outstanding(ComponentFees)
+		quote.Penalties += instalment.Outstanding(ComponentPenalty)
+		interest += instalment.Outstanding(ComponentInterest)
+	}
+
+	quote.UnaccruedInterest = money.Sum(unaccruedInterest(loan, instalments, asOf)...)
+	quote.AccruedInterest = interest - quote.UnaccruedInterest
+	quote.Rebate = money.Sum(settlementRebates(loan, instalments, asOf, rebateRate)...)
+	quote.Total = quote.Principal + interest + quote.Fees + quote.Penalties - quote.Rebate
+
+	return quote
+}
+
+// CreateSettlementQuote quotes the cost of closing the loan now, valid until the end of
+// validUntil's day.
+func (m *SettlementModel) CreateSettlementQuote(loanId uint, validUntil time.Time, createdById *uint, asOf time.Time) (SettlementQuote, error) {
+	loan, err := NewLoanModel(m.Service).GetLoanByFieldPreloaded("id", fmt.Sprintf("%d", loanId))
+	if err != nil {
+		return SettlementQuote{}, fmt.Errorf("loan not found: %v", err)
+	}
+	if !canSettle(loan.Status) {
+		return SettlementQuote{}, fmt.Errorf("%w: loan is %s", ErrNotSettleable, loan.Status)
+	}
+
+	endOfDay := time.Date(validUntil.Year(), validUntil.Month(), validUntil.Day(), 23, 59, 59, 0, asOf.Location())
+	if endOfDay.Before(asOf) || endOfDay.After(asOf.AddDate(0, 0, SettlementQuoteMaxDays)) {
+		return SettlementQuote{}, fmt.Errorf("%w: quotes may be valid for up to %d days", ErrNotSettleable, SettlementQuoteMaxDays)
+	}
+
+	instalments, err := NewScheduleModel(m.Service).GetLoanSchedule(loan.ID)
+	if err != nil {
+		return SettlementQuote{}, err
+	}
+
+	quote := QuoteSettlement(loan, instalments, settlementRebateRate(loan), asOf)
+	quote.ValidUntil = endOfDay
+	quote.CreatedByID = createdById
+
+	if err := m.Service.CreateEntity(&quote); err != nil {
+		return SettlementQuote{}, fmt.Errorf("failed to create settlement quote: %v", err)
+	}
+
+	return quote, nil
+}
+
+// GetLoanSettlementQuotes returns every quote made for the loan, newest first.
+func (m *SettlementModel) GetLoanSettlementQuotes(loanId uint) ([]SettlementQuote, error) {
+	var quotes []SettlementQuote
+
+	result, err := m.Service.GetOrderedEntitiesByField(&quotes, "loan_id", loanId, "id desc", "CreatedBy")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get settlement quotes: %v", err)
+	}
+
+	return *result.(*[]SettlementQuote), nil
+}
+
+// ApplySettlementQuote waives the rebate of the open quote the payment covers on the
+// instalments and in the ledger. It returns nil when no quote matches.
+func (m *SettlementModel) ApplySettlementQuote(loan *Loan, payment Payment, instalments []Instalment) (*SettlementQuote, error) {
+	paidAt := payment.UpdatedAt
+	if payment.PaidAt != nil {
+		paidAt = *payment.PaidAt
+	}
+
+	quotes, err := m.GetLoanSettlementQuotes(loan.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var quote *SettlementQuote
+	for i := range quotes {
+		candidate := &quotes[i]
+		if candidate.Status == SettlementQuoteOpen && !paidAt.After(candidate.ValidUntil) && payment.Amount >= candidate.Total {
+			quote = candidate
+			break
+		}
+	}
+	if quote == nil {
+		return nil, nil
+	}
+
+	waived := map[string]money.Amount{}
+	before := make([]Instalment, len(instalments))
+	copy(before, instalments)
+
+	// The quote fixed the rebate, so exactly that much interest is waived, latest first
+	rebate := quote.Rebate
+	for i := len(instalments) - 1; i >= 0 && rebate > 0; i-- {
+		if instalments[i].Status == InstalmentStatusRestructured {
+			continue
+		}
+		waiver := money.Min(instalments[i].Outstanding(ComponentInterest), rebate)
+		if waiver <= 0 {
+			continue
+		}
+		instalments[i].Interest -= waiver
+		instalments[i].TotalDue -= waiver
+		rebate -= waiver
+		waived[ComponentInterest] += waiver
+	}
+
+	// The quote fixed the penalties, so anything charged since is waived, latest first
+	penalties := money.Zero
+	for _, instalment := range instalments {
+		penalties += instalment.Outstanding(ComponentPenalty)
+	}
+	for i := len(instalments) - 1; i >= 0 && penalties > quote.Penalties; i-- {
+		excess := money.Min(instalments[i].Outstanding(ComponentPenalty), penalties-quote.Penalties)
+		if excess <= 0 {
+			continue
+		}
+		instalments[i].Penalty -= excess
+		penalties -= excess
+		waived[ComponentPenalty] += excess
+	}
+
+	for i := range instalments {
+		previous := before[i]
+		if instalments[i].Interest == previous.Interest && instalments[i].Penalty == previous.Penalty {
+			continue
+		}
+
+		// Only the waived amounts are written, and only while the instalment is as it was read
+		updated, err := m.Service.UpdateEntityColumns(&instalments[i], map[string]interface{}{
+			"interest":      previous.Interest,
+			"interest_paid": previous.InterestPaid,
+			"penalty":       previous.Penalty,
+			"penalty_paid":  previous.PenaltyPaid,
+		}, "interest", "total_due", "penalty", "updated_at")
+		if err != nil {
+			return nil, fmt.Errorf("failed to update instalment: %v", err)
+		}
+		if !updated {
+			return nil, fmt.Errorf("instalment %d was changed by another request", instalments[i].ID)
+		}
+	}
+
+	description := fmt.Sprintf("Early settlement rebate on loan %d", loan.ID)
+	if err := NewLedgerModel(m.Service).PostSettlementRebate(quote.ID, loan.ID, waived, description); err != nil {
+		return nil, fmt.Errorf("error posting settlement rebate to the ledger: %v", err)
+	}
+
+	quote.Status = SettlementQuoteSettled
+	quote.PaymentID = &payment.ID
+	quote.SettledAt = &paidAt
+	quote.CreatedBy = nil
+
+	updated, err := m.Service.UpdateEntityIf(quote, map[string]interface{}{"status": SettlementQuoteOpen})
+	if err != nil {
+		return nil, fmt.Errorf("failed to update settlement quote: %v", err)
+	}
+	if !updated {
+		return nil, fmt.Errorf("settlement quote %d was settled by another payment", quote.ID)
+	}
+
+	return quote, nil
+}
@@ -0,0 +1,171 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kifangamukundi/gm/loan/money"
+)
+
+func settlementSchedule() (*Loan, []Instalment) {
+	disbursedAt := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	loan := &Loan{ID: 3, DisbursedAt: &disbursedAt}
+
+	return loan, []Instalment{
+		{ID: 1, Number: 1, DueDate: time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC), Principal: 10000, Interest: 3100},
+		{ID: 2, Number: 2, DueDate: time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), Principal: 10000, Interest: 2800},
+		{ID: 3, Number: 3, DueDate: time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC), Principal: 10000, Interest: 3100},
+	}
+}
+
+func TestUnaccruedInterest(t *testing.T) {
+	tests := []struct {
+		name   string
+		asOf   time.Time
+		change func([]Instalment)
+		want   []money.Amount
+	}{
+		{
+			name: "part way through the first period",
+			asOf: time.Date(2026, 1, 16, 0, 0, 0, 0, time.UTC),
+			want: []money.Amount{1600, 2800, 3100},
+		},
+		{
+			name: "nothing unaccrued once due",
+			asOf: time.Date(2026, 2, 10, 0, 0, 0, 0, time.UTC),
+			want: []money.Amount{0, 1900, 3100},
+		},
+		{
+			name:   "interest paid ahead counts as accrued",
+			asOf:   time.Date(2026, 2, 10, 0, 0, 0, 0, time.UTC),
+			change: func(instalments []Instalment) { instalments[1].InterestPaid = 1000 },
+			want:   []money.Amount{0, 1800, 3100},
+		},
+		{
+			name:   "restructured instalments are ignored",
+			asOf:   time.Date(2026, 1, 16, 0, 0, 0, 0, time.UTC),
+			change: func(instalments []Instalment) { instalments[2].Status = InstalmentStatusRestructured },
+			want:   []money.Amount{1600, 2800, 0},
+		},
+		{
+			name: "after the last due date",
+			asOf: time.Date(2026, 5, 1, 0, 0, 0, 0, time.UTC),
+			want: []money.Amount{0, 0, 0},
+		},
+	}
+
+	for _, tt := range tests {
+		loan, instalments := settlementSchedule()
+		if tt.change != nil {
+			tt.change(instalments)
+		}
+		if got := unaccruedInterest(loan, instalments, tt.asOf); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: unaccruedInterest = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestQuoteSettlement(t *testing.T) {
+	tests := []struct {
+		name       string
+		rebateRate float64
+		asOf       time.Time
+		change     func([]Instalment)
+		want       SettlementQuote
+	}{
+		{
+			name:       "half the unaccrued interest waived",
+			rebateRate: 50,
+			asOf:       time.Date(2026, 1, 16, 0, 0, 0, 0, time.UTC),
+			want:       SettlementQuote{Principal: 30000, AccruedInterest: 1500, UnaccruedInterest: 7500, Rebate: 3750, Total: 35250},
+		},
+		{
+			name:       "no rebate",
+			rebateRate: 0,
+			asOf:       time.Date(2026, 1, 16, 0, 0, 0, 0, time.UTC),
+			want:       SettlementQuote{Principal: 30000, AccruedInterest: 1500, UnaccruedInterest: 7500, Total: 39000},
+		},
+		{
+			name:       "penalties, fees and payments",
+			rebateRate: 100,
+			asOf:       time.Date(2026, 2, 10, 0, 0, 0, 0, time.UTC),
+			change: func(instalments []Instalment) {
+				instalments[0].Fees, instalments[0].Penalty = 500, 200
+				instalments[0].PrincipalPaid, instalments[0].InterestPaid = 4000, 3100
+			},
+			want: SettlementQuote{Principal: 26000, AccruedInterest: 900, UnaccruedInterest: 5000, Fees: 500, Penalties: 200, Rebate: 5000, Total: 27600},
+		},
+	}
+
+	for _, tt := range tests {
+		loan, instalments := settlementSchedule()
+		if tt.change != nil {
+			tt.change(instalments)
+		}
+
+		got := QuoteSettlement(loan, instalments, tt.rebateRate, tt.asOf)
+		tt.want.LoanID, tt.want.AsOf, tt.want.RebateRate, tt.want.Status = loan.ID, tt.asOf, tt.rebateRate, SettlementQuoteOpen
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: QuoteSettlement = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestApplySettlementQuoteWaivesTheQuotedRebate(t *testing.T) {
+	service := newTestService(t, append(ledgerEntities, &User{}, &Loan{}, &Instalment{}, &Payment{}, &SettlementQuote{})...)
+	seedTestAccounts(t, service)
+
+	fixture, instalments := settlementSchedule()
+	loan := createTestLoan(t, service, LoanStatusActive, 39000)
+	loan.DisbursedAt = fixture.DisbursedAt
+	for i := range instalments {
+		instalments[i].ID, instalments[i].LoanID = 0, loan.ID
+		if err := service.CreateEntity(&instalments[i]); err != nil {
+			t.Fatalf("failed to create instalment: %v", err)
+		}
+	}
+
+	asOf := time.Date(2026, 1, 16, 0, 0, 0, 0, time.UTC)
+	quote := QuoteSettlement(loan, instalments, 50, asOf)
+	quote.ValidUntil = asOf.AddDate(0, 0, 7)
+	if err := service.CreateEntity(&quote); err != nil {
+		t.Fatalf("failed to create quote: %v", err)
+	}
+
+	// Interest paid on the last instalment after the quote changes what a fresh quote would
+	// rebate, but not what this one promised
+	instalments[2].InterestPaid = 3000
+	if _, err := service.UpdateEntityColumns(&instalments[2], nil, "interest_paid"); err != nil {
+		t.Fatalf("failed to pay instalment: %v", err)
+	}
+
+	paidAt := asOf.AddDate(0, 0, 2)
+	payment := Payment{LoanID: loan.ID, Amount: quote.Total, Status: PaymentStatusSuccess, PaidAt: &paidAt}
+	if err := service.CreateEntity(&payment); err != nil {
+		t.Fatalf("failed to create payment: %v", err)
+	}
+
+	applied, err := NewSettlementModel(service).ApplySettlementQuote(loan, payment, instalments)
+	if err != nil || applied == nil {
+		t.Fatalf("ApplySettlementQuote returned %v, %v", applied, err)
+	}
+
+	schedule, err := NewScheduleModel(service).GetLoanSchedule(loan.ID)
+	if err != nil {
+		t.Fatalf("GetLoanSchedule returned %v", err)
+	}
+	interest := money.Zero
+	for _, instalment := range schedule {
+		interest += instalment.Interest
+	}
+	if waived := 9000 - interest; waived != quote.Rebate {
+		t.Errorf("waived %s of interest, want the quoted %s", waived, quote.Rebate)
+	}
+	if schedule[2].InterestPaid != 3000 {
+		t.Errorf("waiver wrote back %s interest paid on the last instalment, want 30.00", schedule[2].InterestPaid)
+	}
+	if balances := ledgerBalances(t, service); balances[AccountWaivers] != quote.Rebate {
+		t.Errorf("posted %s in waivers, want %s", balances[AccountWaivers], quote.Rebate)
+	}
+}
@@ -0,0 +1,498 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/kifangamukundi/gm/loan/money"
+	"github.com/kifangamukundi/gm/loan/services"
+)
+
+const (
+	WriteOffStatusPending  = "pending"
+	WriteOffStatusApproved = "approved"
+	WriteOffStatusRejected = "rejected"
+
+	DefaultWriteOffAfterDays = 180 // Days past due before a loan may be written off when its product does not say
+)
+
+var (
+	ErrNotWriteOffEligible = errors.New("loan cannot be written off")
+	ErrWriteOffNotPending  = errors.New("write-off is not pending")
+)
+
+// PortfolioStatuses are the loans that make up the active portfolio. Written-off loans are
+// tracked through their write-offs and recoveries instead.
+var PortfolioStatuses = []string{LoanStatusActive, LoanStatusInArrears, LoanStatusDefaulted}
+
+// LoanWriteOff is a request, approved by a second user, to move what is still owed on a loan
+// long past due to loan losses. The amounts are what was outstanding at approval.
+type LoanWriteOff struct {
+	ID          uint   `gorm:"primaryKey"`
+	LoanID      uint   `gorm:"index"` // Foreign key to Loan
+	Loan        Loan   `gorm:"foreignKey:LoanID;constraint:onDelete:CASCADE"`
+	Status      string `gorm:"not null;default:'pending';index"` // pending, approved, rejected
+	DaysPastDue int    `gorm:"not null;default:0"`               // Age of the oldest unpaid instalment when requested
+	Reason      string `gorm:"not null;default:''"`
+
+	RequestedByID  uint       `gorm:"index"`
+	RequestedBy    User       `gorm:"foreignKey:RequestedByID;constraint:onDelete:RESTRICT"`
+	DecidedByID    *uint      `gorm:"index;default:null"` // User who approved or rejected the request
+	DecidedBy      *User      `gorm:"foreignKey:DecidedByID;constraint:onDelete:SET NULL"`
+	DecisionReason string     `gorm:"not null;default:''"`
+	DecidedAt      *time.Time `gorm:"default:null;index"`
+
+	// Filled in when the write-off is approved
+	Principal money.Amount `gorm:"not null;default:0"`
+	Interest  money.Amount `gorm:"not null;default:0"`
+	Fees      money.Amount `gorm:"not null;default:0"`
+	Penalty   money.Amount `gorm:"not null;default:0"`
+	Amount    money.Amount `gorm:"not null;default:0"` // Total written off
+
+	Recoveries []LoanRecovery `gorm:"foreignKey:WriteOffID"`
+
+	CreatedAt time.Time `gorm:"not null"`
+	UpdatedAt time.Time `gorm:"not null"`
+}
+
+// LoanRecovery is money collected on a loan after it was written off
+type LoanRecovery struct {
+	ID           uint         `gorm:"primaryKey"`
+	LoanID       uint         `gorm:"index"` // Foreign key to Loan
+	Loan         Loan         `gorm:"foreignKey:LoanID;constraint:onDelete:CASCADE"`
+	WriteOffID   uint         `gorm:"index"` // Write-off the money was recovered against
+	Amount       money.Amount `gorm:"not null"`
+	PaymentID    *uint        `gorm:"uniqueIndex;default:null"` // M-Pesa payment the money came in on, if any
+	Payment      *Payment     `gorm:"foreignKey:PaymentID;constraint:onDelete:SET NULL"`
+	Reference    string       `gorm:"not null;default:''"`
+	RecordedByID *uint        `gorm:"index;default:null"` // User who recorded a recovery made outside M-Pesa
+	RecordedBy   *User        `gorm:"foreignKey:RecordedByID;constraint:onDelete:SET NULL"`
+	RecoveredAt  time.Time    `gorm:"not null;index"`
+
+	CreatedAt time.Time `gorm:"not null"`
+}
+
+// AgentTotal is a count of loans and an amount summed over them for one agent
+type AgentTotal struct {
+	AgentID uint
+	Count   int64
+	Amount  money.Amount
+}
+
+// WriteOffReportRow is the portfolio, write-offs and recoveries of one agent or region
+type WriteOffReportRow struct {
+	AgentID           uint
+	AgentName         string
+	RegionID          uint
+	RegionName        string
+	ActiveLoans       int64
+	ActiveOutstanding money.Amount
+	WrittenOffLoans   int64
+	WrittenOff        money.Amount
+	Recovered         money.Amount
+	NetLoss           money.Amount
+}
+
+func (row *WriteOffReportRow) add(other WriteOffReportRow) {
+	row.ActiveLoans += other.ActiveLoans
+	row.ActiveOutstanding += other.ActiveOutstanding
+	row.WrittenOffLoans += other.WrittenOffLoans
+	row.WrittenOff += other.WrittenOff
+	row.Recovered += other.Recovered
+	row.NetLoss += other.NetLoss
+}
+
+// WriteOffReport totals write-offs approved and recoveries collected in [From, To) per agent
+// and per region. The active portfolio is as it stands now.
+type WriteOffReport struct {
+	From    *time.Time
+	To      *time.Time
+	Agents  []WriteOffReportRow
+	Regions []WriteOffReportRow
+	Totals  WriteOffReportRow
+}
+
+type WriteOffModel struct {
+	Service services.Service
+}
+
+func NewWriteOffModel(service services.Service) *WriteOffModel {
+	return &WriteOffModel{Service: service}
+}
+
+// canWriteOff reports whether a loan in the given status may be written off
+func canWriteOff(status string) bool {
+	return status == LoanStatusInArrears || status == LoanStatusDefaulted
+}
+
+// DaysPastDue is how many days the oldest unpaid instalment of the loan is overdue at asOf
+func (m *WriteOffModel) DaysPastDue(loanId uint, asOf time.Time) (int, error) {
+	instalments, err := NewScheduleModel(m.Service).GetLoanSchedule(loanId)
+	if err != nil {
+		return 0, err
+	}
+
+	days := 0
+	for _, instalment := range instalments {
+		if instalment.Status == InstalmentStatusRestructured || instalment.Balance() <= 0 {
+			continue
+		}
+		if overdue := DaysOverdue(instalment.DueDate, asOf); overdue > days {
+			days = overdue
+		}
+	}
+
+	return days, nil
+}
+
+// checkWriteOff fails unless the loan may be written off at asOf under its product's threshold
+func (m *WriteOffModel) checkWriteOff(loan *Loan, asOf time.Time) (int, error) {
+	if !canWriteOff(loan.Status) {
+		return 0, fmt.Errorf("%w: loan is %s", ErrNotWriteOffEligible, loan.Status)
+	}
+
+	days, err := m.DaysPastDue(loan.ID, asOf)
+	if err != nil {
+		return 0, err
+	}
+
+	threshold := loan.Product.WriteOffThreshold()
+	if days < threshold {
+		return 0, fmt.Errorf("%w: loan is %d days past due, write-offs need %d", ErrNotWriteOffEligible, days, threshold)
+	}
+
+	return days, nil
+}
+
+// RequestWriteOff records a write-off request for a loan at least its product's
+// WriteOffAfterDays past due. A loan may only have one pending request.
+func (m *WriteOffModel) RequestWriteOff(writeOff *LoanWriteOff, asOf time.Time) error {
+	loan, err := NewLoanModel(m.Service).GetLoanByFieldPreloaded("id", fmt.Sprintf("%d", writeOff.LoanID))
+	if err != nil {
+		return fmt.Errorf("loan not found: %v", err)
+	}
+
+	days, err := m.checkWriteOff(loan, asOf)
+	if err != nil {
+		return err
+	}
+
+	pending, err := m.Service.CountEntities(&LoanWriteOff{}, map[string]interface{}{"loan_id": loan.ID, "status": WriteOffStatusPending})
+	if err != nil {
+		return err
+	}
+	if pending > 0 {
+		return fmt.Errorf("%w: loan %d already has a pending write-off", ErrConcurrentTransition, loan.ID)
+	}
+
+	writeOff.Status = WriteOffStatusPending
+	writeOff.DaysPastDue = days
+	if err := m.Service.CreateEntity(writeOff); err != nil {
+		return fmt.Errorf("failed to create write-off: %v", err)
+	}
+
+	return nil
+}
+
+func (m *WriteOffModel) GetWriteOffByField(field, value string) (*LoanWriteOff, error) {
+	var writeOff LoanWriteOff
+
+	result, err := m.Service.GetEntityByFieldWithPreload(&writeOff, field, value, "RequestedBy", "DecidedBy", "Recoveries")
+	if err != nil {
+		log.Printf("Error fetching write-off by %s: %v", field, err)
+		return nil, err
+	}
+
+	return result.(*LoanWriteOff), nil
+}
+
+// GetLoanWriteOffs returns every write-off requested on the loan with its recoveries, oldest first.
+func (m *WriteOffModel) GetLoanWriteOffs(loanId uint) ([]LoanWriteOff, error) {
+	var writeOffs []LoanWriteOff
+
+	result, err := m.Service.GetOrderedEntitiesByField(&writeOffs, "loan_id", loanId, "id asc", "RequestedBy", "DecidedBy", "Recoveries")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get write-offs: %v", err)
+	}
+
+	return *result.(*[]LoanWriteOff), nil
+}
+
+// approvedWriteOff returns the loan's approved write-off
+func (m *WriteOffModel) approvedWriteOff(loanId uint) (*LoanWriteOff, error) {
+	writeOffs, err := m.GetLoanWriteOffs(loanId)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range writeOffs {
+		if writeOffs[i].Status == WriteOffStatusApproved {
+			return &writeOffs[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("loan %d has not been written off", loanId)
+}
+
+// RejectWriteOff closes a pending request without changing the loan.
+func (m *WriteOffModel) RejectWriteOff(id, userId uint, reason string) (LoanWriteOff, error) {
+	writeOff := &LoanWriteOff{ID: id}
+
+	if _, err := m.Service.GetEntityByID(writeOff, id); err != nil {
+		return LoanWriteOff{}, fmt.Errorf("write-off not found: %v", err)
+	}
+	if writeOff.Status != WriteOffStatusPending {
+		return LoanWriteOff{}, fmt.Errorf("%w: it is %s", ErrWriteOffNotPending, writeOff.Status)
+	}
+
+	now := time.Now()
+	writeOff.Status = WriteOffStatusRejected
+	writeOff.DecidedByID = &userId
+	writeOff.DecisionReason = reason
+	writeOff.DecidedAt = &now
+
+	updated, err := m.Service.UpdateEntityIf(writeOff, map[string]interface{}{"status": WriteOffStatusPending})
+	if err != nil {
+		return LoanWriteOff{}, fmt.Errorf("failed to update write-off: %v", err)
+	}
+	if !updated {
+		return LoanWriteOff{}, fmt.Errorf("%w: it was decided by another request", ErrWriteOffNotPending)
+	}
+
+	return *writeOff, nil
+}
+
+// ApproveWriteOff writes off the loan on behalf of a user other than the requester and posts
+// what is outstanding to loan losses.
+func (m *WriteOffModel) ApproveWriteOff(id, approverId uint, asOf time.Time) (LoanWriteOff, error) {
+	writeOff := &LoanWriteOff{ID: id}
+
+	err := m.Service.WithTransaction(func(tx services.Service) error {
+		if _, err := tx.GetEntityByID(writeOff, id); err != nil {
+			return fmt.Errorf("write-off not found: %v", err)
+		}
+		if writeOff.Status != WriteOffStatusPending {
+			return fmt.Errorf("%w: it is %s", ErrWriteOffNotPending, writeOff.Status)
+		}
+		if writeOff.RequestedByID == approverId {
+			return ErrSelfApproval
+		}
+
+		loan, err := NewLoanModel(tx).GetLoanByFieldPreloaded("id", fmt.Sprintf("%d", writeOff.LoanID))
+		if err != nil {
+			return fmt.Errorf("loan not found: %v", err)
+		}
+		if _, err := NewWriteOffModel(tx).checkWriteOff(loan, asOf); err != nil {
+			return err
+		}
+
+		instalments, err := NewScheduleModel(tx).GetLoanSchedule(loan.ID)
+		if err != nil {
+			return err
+		}
+
+		amounts := map[string]money.Amount{}
+		for _, instalment := range instalments {
+			for _, component := range DefaultAllocationOrder {
+				amounts[component] += instalment.Outstanding(component)
+			}
+		}
+
+		writeOff.Principal = amounts[ComponentPrincipal]
+		writeOff.Interest = amounts[ComponentInterest]
+		writeOff.Fees = amounts[ComponentFees]
+		writeOff.Penalty = amounts[ComponentPenalty]
+		writeOff.Amount = writeOff.Principal + writeOff.Interest + writeOff.Fees + writeOff.Penalty
+
+		decidedAt := time.Now()
+		writeOff.Status = WriteOffStatusApproved
+		writeOff.DecidedByID = &approverId
+		writeOff.DecidedAt = &decidedAt
+
+		updated, err := tx.UpdateEntityIf(writeOff, map[string]interface{}{"status": WriteOffStatusPending})
+		if err != nil {
+			return fmt.Errorf("failed to update write-off: %v", err)
+		}
+		if !updated {
+			return fmt.Errorf("%w: it was decided by another request", ErrWriteOffNotPending)
+		}
+
+		description := fmt.Sprintf("Write-off of loan %d, %d days past due", loan.ID, writeOff.DaysPastDue)
+		if err := NewLedgerModel(tx).PostWriteOff(writeOff.ID, loan.ID, amounts, description); err != nil {
+			return fmt.Errorf("error posting write-off to the ledger: %v", err)
+		}
+
+		reason := fmt.Sprintf("Written off at %d days past due", writeOff.DaysPastDue)
+		if _, err := NewLoanModel(tx).TransitionLoan(loan.ID, LoanStatusWrittenOff, &approverId, reason, nil); err != nil {
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return LoanWriteOff{}, err
+	}
+
+	return *writeOff, nil
+}
+
+// RecordRecovery records money collected on a written-off loan, from an M-Pesa payment or,
+// when paymentId is nil, by other means. Anything beyond the write-off is a customer deposit.
+func (m *WriteOffModel) RecordRecovery(loanId uint, amount money.Amount, paymentId *uint, reference string, recordedById *uint, recoveredAt time.Time) (LoanRecovery, error) {
+	var recovery LoanRecovery
+
+	err := m.Service.WithTransaction(func(tx services.Service) error {
+		loan := &Loan{ID: loanId}
+		if _, err := tx.GetEntityByID(loan, loanId); err != nil {
+			return fmt.Errorf("loan not found: %v", err)
+		}
+		if loan.Status != LoanStatusWrittenOff {
+			return fmt.Errorf("%w: loan is %s", ErrIllegalTransition, loan.Status)
+		}
+
+		writeOff, err := NewWriteOffModel(tx).approvedWriteOff(loanId)
+		if err != nil {
+			return err
+		}
+
+		recovered := money.Zero
+		for _, previous := range writeOff.Recoveries {
+			recovered += previous.Amount
+		}
+
+		applied := money.Min(amount, writeOff.Amount-recovered)
+		if applied < 0 {
+			applied = 0
+		}
+		excess := amount - applied
+		if excess > 0 {
+			log.Printf("Recovery of %s on loan %d is %s more than was written off\n", amount, loanId, excess)
+		}
+
+		recovery = LoanRecovery{
+			LoanID:       loanId,
+			WriteOffID:   writeOff.ID,
+			Amount:       applied,
+			PaymentID:    paymentId,
+			Reference:    reference,
+			RecordedByID: recordedById,
+			RecoveredAt:  recoveredAt,
+		}
+		if err := tx.CreateEntity(&recovery); err != nil {
+			return fmt.Errorf("failed to record recovery: %v", err)
+		}
+
+		if err := NewLedgerModel(tx).PostRecovery(recovery, excess); err != nil {
+			return fmt.Errorf("error posting recovery to the ledger: %v", err)
+		}
+
+		previousBalance := loan.RemainingBalance
+		loan.RemainingBalance -= money.Min(applied, loan.RemainingBalance)
+		loan.LastPaymentDate = &recoveredAt
+		updated, err := tx.UpdateEntityColumns(loan, map[string]interface{}{"remaining_balance": previousBalance}, "remaining_balance", "last_payment_date", "updated_at")
+		if err != nil {
+			return fmt.Errorf("failed to update loan: %v", err)
+		}
+		if !updated {
+			return fmt.Errorf("loan %d was changed by another request", loan.ID)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return LoanRecovery{}, err
+	}
+
+	return recovery, nil
+}
+
+// sumByAgent totals rows of table per agent, see services.Service.SumByAgent
+func (m *WriteOffModel) sumByAgent(table, amountColumn, dateColumn string, conditions map[string]interface{}, from, to *time.Time) (map[uint]AgentTotal, error) {
+	var totals []AgentTotal
+
+	if err := m.Service.SumByAgent(&totals, table, amountColumn, dateColumn, conditions, from, to); err != nil {
+		return nil, err
+	}
+
+	byAgent := make(map[uint]AgentTotal, len(totals))
+	for _, total := range totals {
+		byAgent[total.AgentID] = total
+	}
+
+	return byAgent, nil
+}
+
+// Report totals the amounts written off and recovered in [from, to) per agent and per
+// region, next to each agent's active portfolio. Either bound may be nil.
+func (m *WriteOffModel) Report(from, to *time.Time) (WriteOffReport, error) {
+	report := WriteOffReport{From: from, To: to, Agents: []WriteOffReportRow{}, Regions: []WriteOffReportRow{}}
+
+	portfolio, err := m.sumByAgent("loans", "remaining_balance", "", map[string]interface{}{"loans.status": PortfolioStatuses}, nil, nil)
+	if err != nil {
+		return report, err
+	}
+	writtenOff, err := m.sumByAgent("loan_write_offs", "amount", "decided_at", map[string]interface{}{"loan_write_offs.status": WriteOffStatusApproved}, from, to)
+	if err != nil {
+		return report, err
+	}
+	recovered, err := m.sumByAgent("loan_recoveries", "amount", "recovered_at", map[string]interface{}{}, from, to)
+	if err != nil {
+		return report, err
+	}
+
+	agentIds := map[uint]bool{}
+	for _, totals := range []map[uint]AgentTotal{portfolio, writtenOff, recovered} {
+		for agentId := range totals {
+			agentIds[agentId] = true
+		}
+	}
+
+	regions := map[uint]*WriteOffReportRow{}
+	for agentId := range agentIds {
+		row := WriteOffReportRow{
+			AgentID:           agentId,
+			ActiveLoans:       portfolio[agentId].Count,
+			ActiveOutstanding: portfolio[agentId].Amount,
+			WrittenOffLoans:   writtenOff[agentId].Count,
+			WrittenOff:        writtenOff[agentId].Amount,
+			Recovered:         recovered[agentId].Amount,
+		}
+		row.NetLoss = row.WrittenOff - row.Recovered
+
+		var agent Agent
+		if result, err := m.Service.GetEntityByFieldWithPreload(&agent, "id", fmt.Sprintf("%d", agentId), "User", "Region"); err != nil {
+			log.Printf("Error fetching agent %d for the write-off report: %v", agentId, err)
+		} else {
+			agent = *result.(*Agent)
+			row.AgentName = agent.User.FirstName + " " + agent.User.LastName
+			row.RegionID = agent.RegionID
+			row.RegionName = agent.Region.RegionName
+		}
+
+		report.Agents = append(report.Agents, row)
+		report.Totals.add(row)
+
+		region, ok := regions[row.RegionID]
+		if !ok {
+			region = &WriteOffReportRow{RegionID: row.RegionID, RegionName: row.RegionName}
+			regions[row.RegionID] = region
+		}
+		region.add(row)
+	}
+
+	for _, region := range regions {
+		report.Regions = append(report.Regions, *region)
+	}
+
+	sort.Slice(report.Agents, func(i, j int) bool {
+		return report.Agents[i].AgentID < report.Agents[j].AgentID
+	})
+	sort.Slice(report.Regions, func(i, j int) bool {
+		return report.Regions[i].RegionID < report.Regions[j].RegionID
+	})
+
+	return report, nil
+}
@@ -0,0 +1,92 @@
+package models
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kifangamukundi/gm/loan/money"
+)
+
+func TestWriteOffAndRecoveries(t *testing.T) {
+	service := newTestService(t, append(ledgerEntities, &User{}, &Agent{}, &Group{}, &Member{}, &LoanProduct{}, &Loan{}, &Instalment{},
+		&LoanStatusHistory{}, &Collateral{}, &LoanWriteOff{}, &LoanRecovery{})...)
+	seedTestAccounts(t, service)
+
+	product := LoanProduct{ProductName: "Test product", InterestRate: 12, MaxAmount: 100000, MinTerm: 1, MaxTerm: 6, WriteOffAfterDays: 90}
+	if err := service.CreateEntity(&product); err != nil {
+		t.Fatalf("failed to create product: %v", err)
+	}
+	loan := createTestLoan(t, service, LoanStatusDefaulted, 18500)
+	if _, err := service.UpdateEntityColumns(&Loan{ID: loan.ID, ProductID: &product.ID}, nil, "product_id"); err != nil {
+		t.Fatalf("failed to set the product: %v", err)
+	}
+
+	// 160.00 principal, 20.00 interest and a 5.00 penalty are still owed
+	instalments := []Instalment{
+		{LoanID: loan.ID, Number: 1, DueDate: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), Principal: 10000, Interest: 1000, Penalty: 500, PrincipalPaid: 4000},
+		{LoanID: loan.ID, Number: 2, DueDate: time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC), Principal: 10000, Interest: 1000},
+	}
+	for i := range instalments {
+		if err := service.CreateEntity(&instalments[i]); err != nil {
+			t.Fatalf("failed to create instalment: %v", err)
+		}
+	}
+
+	model := NewWriteOffModel(service)
+	early := LoanWriteOff{LoanID: loan.ID, RequestedByID: 1, Reason: "Member has moved away"}
+	if err := model.RequestWriteOff(&early, time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)); !errors.Is(err, ErrNotWriteOffEligible) {
+		t.Fatalf("writing off a loan 59 days past due returned %v, want %v", err, ErrNotWriteOffEligible)
+	}
+
+	asOf := time.Date(2026, 4, 11, 0, 0, 0, 0, time.UTC)
+	writeOff := LoanWriteOff{LoanID: loan.ID, RequestedByID: 1, Reason: "Member has moved away"}
+	if err := model.RequestWriteOff(&writeOff, asOf); err != nil {
+		t.Fatalf("RequestWriteOff returned %v", err)
+	}
+	if writeOff.DaysPastDue != 100 {
+		t.Errorf("write-off requested at %d days past due, want 100", writeOff.DaysPastDue)
+	}
+	second := LoanWriteOff{LoanID: loan.ID, RequestedByID: 2, Reason: "Member has moved away"}
+	if err := model.RequestWriteOff(&second, asOf); !errors.Is(err, ErrConcurrentTransition) {
+		t.Errorf("second request returned %v, want %v", err, ErrConcurrentTransition)
+	}
+	if _, err := model.RecordRecovery(loan.ID, 5000, nil, "Cash", nil, asOf); !errors.Is(err, ErrIllegalTransition) {
+		t.Errorf("recovering before the write-off returned %v, want %v", err, ErrIllegalTransition)
+	}
+
+	if _, err := model.ApproveWriteOff(writeOff.ID, 1, asOf); !errors.Is(err, ErrSelfApproval) {
+		t.Fatalf("approving your own write-off returned %v, want %v", err, ErrSelfApproval)
+	}
+	approved, err := model.ApproveWriteOff(writeOff.ID, 2, asOf)
+	if err != nil {
+		t.Fatalf("ApproveWriteOff returned %v", err)
+	}
+	if approved.Principal != 16000 || approved.Interest != 2000 || approved.Penalty != 500 || approved.Amount != 18500 {
+		t.Errorf("wrote off %s principal, %s interest, %s penalty, %s in all", approved.Principal, approved.Interest, approved.Penalty, approved.Amount)
+	}
+	if stored := getTestLoan(t, service, loan.ID); stored.Status != LoanStatusWrittenOff {
+		t.Errorf("loan is %s, want %s", stored.Status, LoanStatusWrittenOff)
+	}
+	if _, err := model.RejectWriteOff(writeOff.ID, 3, "Too late"); !errors.Is(err, ErrWriteOffNotPending) {
+		t.Errorf("rejecting an approved write-off returned %v, want %v", err, ErrWriteOffNotPending)
+	}
+
+	// The second recovery is 15.00 more than is left, which is held for the member
+	for _, amount := range []money.Amount{5000, 15000} {
+		if _, err := model.RecordRecovery(loan.ID, amount, nil, "Cash", nil, asOf); err != nil {
+			t.Fatalf("RecordRecovery returned %v", err)
+		}
+	}
+	if stored := getTestLoan(t, service, loan.ID); stored.RemainingBalance != 0 {
+		t.Errorf("remaining balance = %s, want 0.00", stored.RemainingBalance)
+	}
+
+	want := map[string]money.Amount{AccountLoanLosses: 18500, AccountRecoveries: 18500, AccountCustomerDeposits: 1500, AccountCash: 20000}
+	balances := ledgerBalances(t, service)
+	for code, amount := range want {
+		if balances[code] != amount {
+			t.Errorf("account %s = %s, want %s", code, balances[code], amount)
+		}
+	}
+}
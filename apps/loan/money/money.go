@@ -0,0 +1,175 @@
+package money
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Currency is the currency every Amount is held in
+const Currency = "KES"
+
+// Amount is a sum of money held as a whole number of cents so that adding, subtracting
+// and comparing amounts is exact. Amounts are stored as bigint columns and read and
+// written as decimal numbers with two places in JSON, e.g. 1250.50.
+//
+// Rounding only happens where an amount is derived from a rate or split into parts, and
+// always rounds half away from zero to the nearest cent, see Round.
+type Amount int64
+
+const (
+	Zero     Amount = 0
+	Cent     Amount = 1
+	Shilling Amount = 100
+)
+
+// FromShillings returns an amount of whole shillings
+func FromShillings(shillings int64) Amount {
+	return Amount(shillings) * Shilling
+}
+
+// Round converts a value in cents to an Amount, rounding half away from zero
+func Round(cents float64) Amount {
+	return Amount(math.Round(cents))
+}
+
+// FromFloat converts a value in shillings, such as an amount reported by M-Pesa, to the
+// nearest cent. Use Parse for text so no precision is lost on the way in.
+func FromFloat(shillings float64) Amount {
+	return Round(shillings * float64(Shilling))
+}
+
+// Parse reads a decimal amount in shillings such as "1250", "1250.5" or "-3.75".
+// More than two decimal places is an error rather than being silently rounded.
+func Parse(value string) (Amount, error) {
+	value = strings.TrimSpace(value)
+	negative := strings.HasPrefix(value, "-")
+	digits := strings.TrimPrefix(value, "-")
+
+	whole, fraction, hasFraction := strings.Cut(digits, ".")
+	if whole == "" || (hasFraction && fraction == "") {
+		return 0, fmt.Errorf("invalid amount %q", value)
+	}
+	if len(fraction) > 2 {
+		return 0, fmt.Errorf("amount %q has more than two decimal places", value)
+	}
+
+	shillings, err := strconv.ParseUint(whole, 10, 63)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q", value)
+	}
+
+	cents := uint64(0)
+	if fraction != "" {
+		fraction += strings.Repeat("0", 2-len(fraction))
+		if cents, err = strconv.ParseUint(fraction, 10, 8); err != nil {
+			return 0, fmt.Errorf("invalid amount %q", value)
+		}
+	}
+
+	if shillings > math.MaxInt64/uint64(Shilling)-1 {
+		return 0, fmt.Errorf("amount %q is too large", value)
+	}
+
+	amount := Amount(shillings)*Shilling + Amount(cents)
+	if negative {
+		amount = -amount
+	}
+	return amount, nil
+}
+
+// Cents returns the amount as a number of cents
+func (a Amount) Cents() int64 {
+	return int64(a)
+}
+
+// Float64 returns the amount in shillings for display and for APIs that take floats
+func (a Amount) Float64() float64 {
+	return float64(a) / float64(Shilling)
+}
+
+// Shillings returns the whole shillings in the amount, dropping any cents
+func (a Amount) Shillings() int64 {
+	return int64(a / Shilling)
+}
+
+// IsWhole reports whether the amount has no cents, as M-Pesa only moves whole shillings
+func (a Amount) IsWhole() bool {
+	return a%Shilling == 0
+}
+
+// Percent returns rate percent of the amount, rounded to the nearest cent
+func (a Amount) Percent(rate float64) Amount {
+	return Round(float64(a) * rate / 100)
+}
+
+// Mul returns the amount multiplied by factor, rounded to the nearest cent
+func (a Amount) Mul(factor float64) Amount {
+	return Round(float64(a) * factor)
+}
+
+// Div returns the amount divided into n equal parts, rounded to the nearest cent. The
+// parts may not add back up to the amount; callers put the difference on the last part.
+func (a Amount) Div(n int) Amount {
+	return Round(float64(a) / float64(n))
+}
+
+// Min returns the smaller of two amounts
+func Min(a, b Amount) Amount {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// Sum adds amounts together
+func Sum(amounts ...Amount) Amount {
+	total := Zero
+	for _, amount := range amounts {
+		total += amount
+	}
+	return total
+}
+
+// String formats the amount in shillings with two decimal places. Exporters and log
+// lines rely on it.
+func (a Amount) String() string {
+	sign := ""
+	cents := int64(a)
+	if cents < 0 {
+		sign = "-"
+		cents = -cents
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, cents/int64(Shilling), cents%int64(Shilling))
+}
+
+// MarshalJSON writes the amount as a JSON number in shillings with two decimal places
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalJSON accepts a number or a string in shillings
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+
+	value := string(bytes.Trim(data, `"`))
+	if strings.ContainsAny(value, "eE") {
+		shillings, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid amount %s", data)
+		}
+		value = strconv.FormatFloat(shillings, 'f', -1, 64)
+	}
+
+	amount, err := Parse(value)
+	if err != nil {
+		return err
+	}
+
+	*a = amount
+	return nil
+}
@@ -0,0 +1,101 @@
+package money
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    Amount
+		wantErr bool
+	}{
+		{value: "1250", want: 125000},
+		{value: "1250.5", want: 125050},
+		{value: "1250.05", want: 125005},
+		{value: " 0.99 ", want: 99},
+		{value: "-3.75", want: -375},
+		{value: "0", want: 0},
+		{value: "1.234", wantErr: true},
+		{value: "1.", wantErr: true},
+		{value: ".5", wantErr: true},
+		{value: "", wantErr: true},
+		{value: "12a", wantErr: true},
+		{value: "1.-5", wantErr: true},
+		{value: "92233720368547758", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := Parse(tt.value)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("Parse(%q) = %d, want an error", tt.value, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Parse(%q) returned %v", tt.value, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Parse(%q) = %d, want %d", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestPercent(t *testing.T) {
+	tests := []struct {
+		amount Amount
+		rate   float64
+		want   Amount
+	}{
+		{amount: FromShillings(1000), rate: 10, want: FromShillings(100)},
+		{amount: FromShillings(1000), rate: 0, want: 0},
+		{amount: 1, rate: 50, want: 1},   // Half a cent rounds away from zero
+		{amount: -1, rate: 50, want: -1}, // Also for negative amounts
+		{amount: 333, rate: 33.3333, want: 111},
+		{amount: FromShillings(2500), rate: 2.5, want: 6250},
+	}
+
+	for _, tt := range tests {
+		if got := tt.amount.Percent(tt.rate); got != tt.want {
+			t.Errorf("%s.Percent(%g) = %d, want %d", tt.amount, tt.rate, got, tt.want)
+		}
+	}
+}
+
+func TestMul(t *testing.T) {
+	tests := []struct {
+		amount Amount
+		factor float64
+		want   Amount
+	}{
+		{amount: FromShillings(100), factor: 3, want: FromShillings(300)},
+		{amount: FromShillings(100), factor: 0.5, want: FromShillings(50)},
+		{amount: 5, factor: 0.5, want: 3},
+		{amount: -5, factor: 0.5, want: -3},
+		{amount: FromShillings(1000), factor: 0, want: 0},
+	}
+
+	for _, tt := range tests {
+		if got := tt.amount.Mul(tt.factor); got != tt.want {
+			t.Errorf("%s.Mul(%g) = %d, want %d", tt.amount, tt.factor, got, tt.want)
+		}
+	}
+}
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		amount Amount
+		want   string
+	}{
+		{amount: 125050, want: "1250.50"},
+		{amount: 5, want: "0.05"},
+		{amount: -375, want: "-3.75"},
+		{amount: 0, want: "0.00"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.amount.String(); got != tt.want {
+			t.Errorf("Amount(%d).String() = %q, want %q", tt.amount, got, tt.want)
+		}
+	}
+}
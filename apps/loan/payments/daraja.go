@@ -0,0 +1,136 @@
+package payments
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/kifangamukundi/gm/loan/config"
+
+	"github.com/jwambugu/mpesa-golang-sdk"
+)
+
+// DarajaGateway talks to Safaricom's Daraja API
+type DarajaGateway struct {
+	app    *mpesa.Mpesa
+	client *http.Client
+	config *config.MpesaConfig
+}
+
+// NewDarajaGateway initializes a Daraja client for the configured environment
+func NewDarajaGateway(client *http.Client, cfg *config.MpesaConfig) *DarajaGateway {
+	environment := mpesa.EnvironmentSandbox
+	if cfg.Environment == "production" {
+		environment = mpesa.EnvironmentProduction
+	}
+
+	return &DarajaGateway{
+		app:    mpesa.NewApp(client, cfg.ConsumerKey, cfg.ConsumerSecret, environment),
+		client: client,
+		config: cfg,
+	}
+}
+
+func (g *DarajaGateway) B2C(ctx context.Context, req B2CRequest) (*mpesa.Response, error) {
+	return g.app.B2C(ctx, g.config.InitiatorPassword, mpesa.B2CRequest{
+		InitiatorName: g.config.InitiatorName,
+		// SalaryPaymentCommandID, BusinessPaymentCommandID, PromotionPaymentCommandID
+		CommandID:       mpesa.BusinessPaymentCommandID,
+		Amount:          req.Amount,
+		PartyA:          g.config.B2CShortCode,
+		PartyB:          req.PhoneNumber,
+		QueueTimeOutURL: CallbackURL(g.config, B2CTimeoutPath),
+		ResultURL:       CallbackURL(g.config, B2CResultPath),
+		Remarks:         req.Remarks,
+		Occasion:        req.Occasion,
+	})
+}
+
+func (g *DarajaGateway) STKPush(ctx context.Context, req STKPushRequest) (*mpesa.Response, error) {
+	return g.app.STKPush(ctx, g.config.Passkey, mpesa.STKPushRequest{
+		BusinessShortCode: g.config.ShortCode,
+		TransactionType:   mpesa.CustomerPayBillOnlineTransactionType,
+		Amount:            req.Amount,
+		PartyA:            uint(req.PhoneNumber),
+		PartyB:            g.config.ShortCode,
+		PhoneNumber:       req.PhoneNumber,
+		CallBackURL:       CallbackURL(g.config, STKCallbackPath),
+		AccountReference:  req.AccountReference,
+		TransactionDesc:   req.TransactionDesc,
+	})
+}
+
+func (g *DarajaGateway) STKQuery(ctx context.Context, checkoutRequestID string) (*mpesa.Response, error) {
+	return g.app.STKQuery(ctx, g.config.Passkey, mpesa.STKQueryRequest{
+		BusinessShortCode: g.config.ShortCode,
+		CheckoutRequestID: checkoutRequestID,
+	})
+}
+
+func (g *DarajaGateway) TransactionStatus(ctx context.Context, transactionID string) (*mpesa.Response, error) {
+	return g.app.GetTransactionStatus(ctx, g.config.InitiatorPassword, mpesa.TransactionStatusRequest{
+		Initiator:       g.config.InitiatorName,
+		PartyA:          g.config.B2CShortCode,
+		TransactionID:   transactionID,
+		QueueTimeOutURL: CallbackURL(g.config, TransactionStatusResultPath),
+		ResultURL:       CallbackURL(g.config, TransactionStatusResultPath),
+		Remarks:         "Transaction status",
+		Occasion:        "Loan",
+	})
+}
+
+// Reversal is not covered by the SDK so the request is made directly. Daraja expects the
+// initiator password already encrypted with its certificate, see MPESA_SECURITY_CREDENTIAL.
+func (g *DarajaGateway) Reversal(ctx context.Context, req ReversalRequest) (*mpesa.Response, error) {
+	if g.config.SecurityCredential == "" {
+		return nil, fmt.Errorf("mpesa: reversal requires a security credential")
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"Initiator":              g.config.InitiatorName,
+		"SecurityCredential":     g.config.SecurityCredential,
+		"CommandID":              "TransactionReversal",
+		"TransactionID":          req.TransactionID,
+		"Amount":                 req.Amount,
+		"ReceiverParty":          g.config.B2CShortCode,
+		"RecieverIdentifierType": "11",
+		"ResultURL":              CallbackURL(g.config, ReversalResultPath),
+		"QueueTimeOutURL":        CallbackURL(g.config, ReversalResultPath),
+		"Remarks":                req.Remarks,
+		"Occasion":               req.Occasion,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("mpesa: marshal request: %v", err)
+	}
+
+	accessToken, err := g.app.GenerateAccessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, g.app.Environment().BaseURL()+"/mpesa/reversal/v1/request", bytes.NewBuffer(body))
+	if err != nil {
+		return nil, fmt.Errorf("mpesa: create request: %v", err)
+	}
+	httpReq.Header.Add("Content-Type", "application/json")
+	httpReq.Header.Add("Authorization", "Bearer "+accessToken)
+
+	res, err := g.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("mpesa: make request: %v", err)
+	}
+	defer res.Body.Close()
+
+	var resp mpesa.Response
+	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("mpesa: decode response: %v", err)
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("mpesa: request %v failed with code %v: %v", resp.RequestID, resp.ErrorCode, resp.ErrorMessage)
+	}
+
+	return &resp, nil
+}
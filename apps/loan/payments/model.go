@@ -0,0 +1,73 @@
+package payments
+
+import (
+	"context"
+	"net/url"
+	"strings"
+
+	"github.com/kifangamukundi/gm/loan/config"
+
+	"github.com/jwambugu/mpesa-golang-sdk"
+)
+
+// Callback paths served by routes/mpesa.go. Gateways append them to the callback base URL.
+const (
+	B2CResultPath               = "/api/v1/mpesa/b2c-result"
+	B2CTimeoutPath              = "/api/v1/mpesa/b2c-timeout"
+	STKCallbackPath             = "/api/v1/mpesa/stk-callback"
+	TransactionStatusResultPath = "/api/v1/mpesa/status-result"
+	ReversalResultPath          = "/api/v1/mpesa/reversal-result"
+)
+
+// CallbackTokenParam is the query parameter that carries the callback token
+const CallbackTokenParam = "token"
+
+// CallbackURL returns the URL M-Pesa posts results for path to, carrying the callback token
+// that routes/mpesa.go checks before a callback is trusted
+func CallbackURL(cfg *config.MpesaConfig, path string) string {
+	return cfg.CallbackBaseURL + path + "?" + url.Values{CallbackTokenParam: {cfg.CallbackToken}}.Encode()
+}
+
+// IsAmbiguous reports whether a request failed after it was sent, when M-Pesa may still have
+// accepted it. The SDK does not wrap its errors so they are matched on their message.
+func IsAmbiguous(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := err.Error()
+	return strings.HasPrefix(msg, "mpesa: make request:") || strings.HasPrefix(msg, "mpesa: decode response:")
+}
+
+// B2CRequest pays money out to a customer's M-Pesa account
+type B2CRequest struct {
+	PhoneNumber uint64
+	Amount      uint
+	Remarks     string
+	Occasion    string
+}
+
+// STKPushRequest prompts a customer to pay into the repayment paybill
+type STKPushRequest struct {
+	PhoneNumber      uint64
+	Amount           uint
+	AccountReference string
+	TransactionDesc  string
+}
+
+// ReversalRequest reverses a completed M-Pesa transaction
+type ReversalRequest struct {
+	TransactionID string
+	Amount        uint
+	Remarks       string
+	Occasion      string
+}
+
+// PaymentGateway is everything the app needs from M-Pesa. Requests are acknowledged
+// synchronously and their outcome arrives later on the callback paths above.
+type PaymentGateway interface {
+	B2C(ctx context.Context, req B2CRequest) (*mpesa.Response, error)
+	STKPush(ctx context.Context, req STKPushRequest) (*mpesa.Response, error)
+	STKQuery(ctx context.Context, checkoutRequestID string) (*mpesa.Response, error)
+	TransactionStatus(ctx context.Context, transactionID string) (*mpesa.Response, error)
+	Reversal(ctx context.Context, req ReversalRequest) (*mpesa.Response, error)
+}
@@ -0,0 +1,393 @@
+// Package paymentstest provides an in-process M-Pesa simulator for exercising the payment
+// flows in tests.
+package paymentstest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kifangamukundi/gm/loan/config"
+	"github.com/kifangamukundi/gm/loan/payments"
+
+	"github.com/jwambugu/mpesa-golang-sdk"
+)
+
+// Result codes the simulator reports. They mirror the codes Daraja sends for the same outcomes.
+const (
+	SimulatorResultSuccess           = 0
+	SimulatorResultInsufficientFunds = 1
+	SimulatorResultCancelledByUser   = 1032
+	SimulatorResultInvalidReceiver   = 2001
+)
+
+var simulatorResultDescriptions = map[int]string{
+	SimulatorResultSuccess:           "The service request is processed successfully.",
+	SimulatorResultInsufficientFunds: "The balance is insufficient for the transaction.",
+	SimulatorResultCancelledByUser:   "Request cancelled by user",
+	SimulatorResultInvalidReceiver:   "The initiator information is invalid.",
+}
+
+// simulatedTransaction is what the simulator remembers about a request so it can answer queries
+type simulatedTransaction struct {
+	kind          string
+	amount        uint
+	phoneNumber   uint64
+	transactionID string
+	resultCode    int
+	completed     bool
+	reversed      bool
+}
+
+// Simulator is an in-process stand-in for Daraja. It acknowledges requests the way Daraja
+// does and then posts the matching result callback to our own callback routes, so the
+// disbursement and repayment flows can run end to end without network access.
+type Simulator struct {
+	// Handler receives the callbacks in-process. When nil they are posted over HTTP to
+	// the configured callback base URL instead.
+	Handler http.Handler
+	Client  *http.Client
+
+	// Delay before a callback is delivered. Daraja answers the request before the result
+	// arrives, which this mimics when it is above zero.
+	Delay time.Duration
+
+	// Manual holds callbacks until Flush is called instead of delivering them after Delay.
+	Manual bool
+
+	// Outcome decides the result code of a B2C payout or STK push. The default succeeds
+	// every request.
+	Outcome func(kind string, phoneNumber uint64, amount uint) int
+
+	config *config.MpesaConfig
+
+	mu           sync.Mutex
+	sequence     int
+	transactions map[string]*simulatedTransaction
+	queued       []func()
+	pending      sync.WaitGroup
+}
+
+// NewSimulator creates a simulator that delivers its callbacks to handler
+func NewSimulator(cfg *config.MpesaConfig, handler http.Handler) *Simulator {
+	return &Simulator{
+		Handler:      handler,
+		Client:       http.DefaultClient,
+		Delay:        2 * time.Second,
+		config:       cfg,
+		transactions: make(map[string]*simulatedTransaction),
+	}
+}
+
+func (s *Simulator) B2C(ctx context.Context, req payments.B2CRequest) (*mpesa.Response, error) {
+	if req.Amount == 0 {
+		return nil, fmt.Errorf("mpesa: request failed with code 400.002.02: Bad Request - Invalid Amount")
+	}
+
+	conversationID := s.newID("AG_" + time.Now().Format("20060102") + "_")
+	originatorConversationID := s.newID("29115-34620561-")
+	resultCode := s.outcome("b2c", req.PhoneNumber, req.Amount)
+	transactionID := newReceipt()
+
+	s.remember(originatorConversationID, &simulatedTransaction{
+		kind:          "b2c",
+		amount:        req.Amount,
+		phoneNumber:   req.PhoneNumber,
+		transactionID: transactionID,
+		resultCode:    resultCode,
+	})
+
+	result := mpesa.CallbackResult{
+		ConversationID:           conversationID,
+		OriginatorConversationID: originatorConversationID,
+		ResultCode:               resultCode,
+		ResultDesc:               simulatorResultDescriptions[resultCode],
+		ResultType:               0,
+		TransactionID:            transactionID,
+		ReferenceData: mpesa.ReferenceData{
+			ReferenceItem: mpesa.ReferenceItem{Key: "QueueTimeoutURL", Value: s.config.CallbackBaseURL + payments.B2CTimeoutPath},
+		},
+	}
+	if resultCode == SimulatorResultSuccess {
+		result.ResultParameters = mpesa.ResultParameters{ResultParameter: []mpesa.ResultParameter{
+			{Key: "TransactionAmount", Value: req.Amount},
+			{Key: "TransactionReceipt", Value: transactionID},
+			{Key: "ReceiverPartyPublicName", Value: fmt.Sprintf("%d - Simulated Customer", req.PhoneNumber)},
+			{Key: "TransactionCompletedDateTime", Value: time.Now().Format("02.01.2006 15:04:05")},
+			{Key: "B2CRecipientIsRegisteredCustomer", Value: "Y"},
+		}}
+	}
+
+	s.schedule(originatorConversationID, payments.B2CResultPath, mpesa.Callback{Result: result})
+
+	return &mpesa.Response{
+		ConversationID:           conversationID,
+		OriginatorConversationID: originatorConversationID,
+		ResponseCode:             "0",
+		ResponseDescription:      "Accept the service request successfully.",
+	}, nil
+}
+
+func (s *Simulator) STKPush(ctx context.Context, req payments.STKPushRequest) (*mpesa.Response, error) {
+	if req.Amount == 0 {
+		return nil, fmt.Errorf("mpesa: request failed with code 400.002.02: Bad Request - Invalid Amount")
+	}
+
+	merchantRequestID := s.newID("29115-34620561-")
+	checkoutRequestID := s.newID("ws_CO_" + time.Now().Format("02012006150405"))
+	resultCode := s.outcome("stk", req.PhoneNumber, req.Amount)
+	receipt := newReceipt()
+
+	s.remember(checkoutRequestID, &simulatedTransaction{
+		kind:          "stk",
+		amount:        req.Amount,
+		phoneNumber:   req.PhoneNumber,
+		transactionID: receipt,
+		resultCode:    resultCode,
+	})
+
+	callback := mpesa.STKCallback{
+		MerchantRequestID: merchantRequestID,
+		CheckoutRequestID: checkoutRequestID,
+		ResultCode:        resultCode,
+		ResultDesc:        simulatorResultDescriptions[resultCode],
+	}
+	if resultCode == SimulatorResultSuccess {
+		transactionDate, _ := strconv.ParseUint(time.Now().Format("20060102150405"), 10, 64)
+		callback.CallbackMetadata = mpesa.STKCallbackMetadata{Item: []mpesa.STKCallbackItem{
+			{Name: "Amount", Value: float64(req.Amount)},
+			{Name: "MpesaReceiptNumber", Value: receipt},
+			{Name: "TransactionDate", Value: transactionDate},
+			{Name: "PhoneNumber", Value: req.PhoneNumber},
+		}}
+	}
+
+	s.schedule(checkoutRequestID, payments.STKCallbackPath, mpesa.STKPushCallback{Body: mpesa.STKPushCallbackBody{STKCallback: callback}})
+
+	return &mpesa.Response{
+		MerchantRequestID:   merchantRequestID,
+		CheckoutRequestID:   checkoutRequestID,
+		ResponseCode:        "0",
+		ResponseDescription: "Success. Request accepted for processing",
+		CustomerMessage:     "Success. Request accepted for processing",
+	}, nil
+}
+
+func (s *Simulator) STKQuery(ctx context.Context, checkoutRequestID string) (*mpesa.Response, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	transaction, ok := s.transactions[checkoutRequestID]
+	if !ok || transaction.kind != "stk" {
+		return nil, fmt.Errorf("mpesa: request failed with code 400.002.02: Bad Request - Invalid CheckoutRequestID")
+	}
+	if !transaction.completed {
+		return nil, fmt.Errorf("mpesa: request failed with code 500.001.1001: The transaction is being processed")
+	}
+
+	return &mpesa.Response{
+		CheckoutRequestID:   checkoutRequestID,
+		ResponseCode:        "0",
+		ResponseDescription: "The service request has been accepted successsfully",
+		ResultCode:          strconv.Itoa(transaction.resultCode),
+		ResultDesc:          simulatorResultDescriptions[transaction.resultCode],
+	}, nil
+}
+
+func (s *Simulator) TransactionStatus(ctx context.Context, transactionID string) (*mpesa.Response, error) {
+	transaction, ok := s.findTransaction(transactionID)
+	if !ok {
+		return nil, fmt.Errorf("mpesa: request failed with code 400.002.02: Bad Request - Invalid TransactionID")
+	}
+
+	originatorConversationID := s.newID("29115-34620561-")
+	conversationID := s.newID("AG_" + time.Now().Format("20060102") + "_")
+
+	status := "Completed"
+	if transaction.reversed {
+		status = "Reversed"
+	}
+
+	s.schedule("", payments.TransactionStatusResultPath, mpesa.Callback{Result: mpesa.CallbackResult{
+		ConversationID:           conversationID,
+		OriginatorConversationID: originatorConversationID,
+		ResultCode:               SimulatorResultSuccess,
+		ResultDesc:               simulatorResultDescriptions[SimulatorResultSuccess],
+		TransactionID:            transactionID,
+		ResultParameters: mpesa.ResultParameters{ResultParameter: []mpesa.ResultParameter{
+			{Key: "ReceiptNo", Value: transactionID},
+			{Key: "Amount", Value: transaction.amount},
+			{Key: "TransactionStatus", Value: status},
+		}},
+	}})
+
+	return &mpesa.Response{
+		ConversationID:           conversationID,
+		OriginatorConversationID: originatorConversationID,
+		ResponseCode:             "0",
+		ResponseDescription:      "Accept the service request successfully.",
+	}, nil
+}
+
+func (s *Simulator) Reversal(ctx context.Context, req payments.ReversalRequest) (*mpesa.Response, error) {
+	transaction, ok := s.findTransaction(req.TransactionID)
+	if !ok {
+		return nil, fmt.Errorf("mpesa: request failed with code 400.002.02: Bad Request - Invalid TransactionID")
+	}
+
+	originatorConversationID := s.newID("29115-34620561-")
+	conversationID := s.newID("AG_" + time.Now().Format("20060102") + "_")
+
+	resultCode := SimulatorResultSuccess
+	s.mu.Lock()
+	if transaction.reversed || !transaction.completed || transaction.resultCode != SimulatorResultSuccess || req.Amount > transaction.amount {
+		resultCode = SimulatorResultInvalidReceiver
+	} else {
+		transaction.reversed = true
+	}
+	s.mu.Unlock()
+
+	s.schedule("", payments.ReversalResultPath, mpesa.Callback{Result: mpesa.CallbackResult{
+		ConversationID:           conversationID,
+		OriginatorConversationID: originatorConversationID,
+		ResultCode:               resultCode,
+		ResultDesc:               simulatorResultDescriptions[resultCode],
+		TransactionID:            newReceipt(),
+	}})
+
+	return &mpesa.Response{
+		ConversationID:           conversationID,
+		OriginatorConversationID: originatorConversationID,
+		ResponseCode:             "0",
+		ResponseDescription:      "Accept the service request successfully.",
+	}, nil
+}
+
+// Flush delivers every held callback and waits for all callbacks in flight to finish
+func (s *Simulator) Flush() {
+	s.mu.Lock()
+	queued := s.queued
+	s.queued = nil
+	s.mu.Unlock()
+
+	for _, deliver := range queued {
+		deliver()
+	}
+
+	s.pending.Wait()
+}
+
+func (s *Simulator) outcome(kind string, phoneNumber uint64, amount uint) int {
+	if s.Outcome == nil {
+		return SimulatorResultSuccess
+	}
+	return s.Outcome(kind, phoneNumber, amount)
+}
+
+func (s *Simulator) newID(prefix string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sequence++
+	return prefix + strconv.Itoa(s.sequence)
+}
+
+func (s *Simulator) remember(key string, transaction *simulatedTransaction) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.transactions[key] = transaction
+}
+
+func (s *Simulator) findTransaction(transactionID string) (*simulatedTransaction, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, transaction := range s.transactions {
+		if transaction.transactionID == transactionID {
+			return transaction, true
+		}
+	}
+	return nil, false
+}
+
+// schedule queues a callback for delivery. The remembered transaction (if any) is marked
+// completed just before, since M-Pesa has the result by the time it sends the callback and
+// the callback handler queries it.
+func (s *Simulator) schedule(key, path string, payload interface{}) {
+	deliver := func() {
+		if key != "" {
+			s.mu.Lock()
+			if transaction, ok := s.transactions[key]; ok {
+				transaction.completed = true
+			}
+			s.mu.Unlock()
+		}
+
+		s.deliver(path, payload)
+	}
+
+	if s.Manual {
+		s.mu.Lock()
+		s.queued = append(s.queued, deliver)
+		s.mu.Unlock()
+		return
+	}
+
+	s.pending.Add(1)
+	go func() {
+		defer s.pending.Done()
+		time.Sleep(s.Delay)
+		deliver()
+	}()
+}
+
+func (s *Simulator) deliver(path string, payload interface{}) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Simulator failed to encode callback for %s: %v\n", path, err)
+		return
+	}
+
+	target := payments.CallbackURL(s.config, path)
+
+	if s.Handler != nil {
+		req := httptest.NewRequest(http.MethodPost, strings.TrimPrefix(target, s.config.CallbackBaseURL), bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		recorder := httptest.NewRecorder()
+		s.Handler.ServeHTTP(recorder, req)
+
+		log.Printf("Simulator delivered %s: %d %s\n", path, recorder.Code, recorder.Body.String())
+		return
+	}
+
+	res, err := s.Client.Post(target, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Simulator failed to deliver %s: %v\n", path, err)
+		return
+	}
+	defer res.Body.Close()
+
+	log.Printf("Simulator delivered %s: %d\n", path, res.StatusCode)
+}
+
+// newReceipt returns a ten character M-Pesa style receipt number
+func newReceipt() string {
+	const alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+	receipt := make([]byte, 10)
+	for i := range receipt {
+		receipt[i] = alphabet[rand.Intn(len(alphabet))]
+	}
+	return string(receipt)
+}
@@ -2,14 +2,17 @@ package routes
 
 import (
 	"github.com/gin-gonic/gin"
+	"github.com/kifangamukundi/gm/loan/bureau"
+	"github.com/kifangamukundi/gm/loan/config"
 	"github.com/kifangamukundi/gm/loan/controllers"
 	"github.com/kifangamukundi/gm/loan/loanrepository"
 	"github.com/kifangamukundi/gm/loan/models"
+	"github.com/kifangamukundi/gm/loan/payments"
 	"github.com/kifangamukundi/gm/loan/services"
 	"gorm.io/gorm"
 )
 
-func InitializeRoutes(r *gin.Engine, db *gorm.DB) {
+func InitializeRoutes(r *gin.Engine, db *gorm.DB, gateway payments.PaymentGateway) {
 	// repo layer
 	loanRepo := loanrepository.NewLoanRepository(db)
 
@@ -39,6 +42,29 @@ func InitializeRoutes(r *gin.Engine, db *gorm.DB) {
 	memberModel := models.NewMemberModel(service)
 	loanModel := models.NewLoanModel(service)
 	disburseModel := models.NewDisburseModel(service)
+	scheduleModel := models.NewScheduleModel(service)
+	paymentModel := models.NewPaymentModel(service)
+	allocationModel := models.NewAllocationModel(service)
+	ledgerModel := models.NewLedgerModel(service)
+	loanProductModel := models.NewLoanProductModel(service)
+	disbursementJobModel := models.NewDisbursementJobModel(service)
+	approvalModel := models.NewApprovalModel(service)
+	restructureModel := models.NewRestructureModel(service)
+	refinanceModel := models.NewRefinanceModel(service)
+	settlementModel := models.NewSettlementModel(service)
+	writeOffModel := models.NewWriteOffModel(service)
+	guaranteeModel := models.NewGuaranteeModel(service)
+	collateralModel := models.NewCollateralModel(service)
+	eligibilityModel := models.NewEligibilityModel(service)
+	creditReportModel := models.NewCreditReportModel(service)
+	portfolioModel := models.NewPortfolioModel(service)
+	collectionModel := models.NewCollectionModel(service)
+	savingsModel := models.NewSavingsModel(service)
+
+	cloudConfig, cld := config.GetCloudinaryConfig()
+	bureauConfig := config.GetBureauConfig()
+	mpesaConfig := config.GetMpesaConfig()
+	creditBureau := bureau.NewBureau(bureauConfig)
 
 	// Controllers layer
 	userController := controllers.NewUserController(userModel)
@@ -59,7 +85,22 @@ func InitializeRoutes(r *gin.Engine, db *gorm.DB) {
 	groupController := controllers.NewGroupController(groupModel, userModel, agentModel)
 	officerController := controllers.NewOfficerController(officerModel, userModel)
 	memberController := controllers.NewMemberController(memberModel, userModel, groupModel)
-	loanController := controllers.NewLoanController(loanModel, disburseModel, userModel, officerModel, agentModel, groupModel, memberModel)
+	mpesaController := controllers.NewMpesaController(loanModel, disburseModel, scheduleModel, paymentModel, allocationModel, ledgerModel, savingsModel, gateway)
+	loanProductController := controllers.NewLoanProductController(loanProductModel)
+	ledgerController := controllers.NewLedgerController(ledgerModel)
+	approvalTierController := controllers.NewApprovalTierController(approvalModel, roleModel)
+	restructureController := controllers.NewRestructureController(restructureModel, loanModel)
+	refinanceController := controllers.NewRefinanceController(refinanceModel, loanModel, loanProductModel)
+	settlementController := controllers.NewSettlementController(settlementModel)
+	writeOffController := controllers.NewWriteOffController(writeOffModel, loanModel, officerModel)
+	guaranteeController := controllers.NewGuaranteeController(guaranteeModel, loanModel)
+	collateralController := controllers.NewCollateralController(collateralModel, loanModel, cloudConfig, cld)
+	eligibilityController := controllers.NewEligibilityController(eligibilityModel, memberModel, loanProductModel)
+	creditReportController := controllers.NewCreditReportController(creditReportModel, loanModel, creditBureau, bureauConfig)
+	portfolioController := controllers.NewPortfolioController(portfolioModel)
+	collectionController := controllers.NewCollectionController(collectionModel, userModel)
+	savingsController := controllers.NewSavingsController(savingsModel, memberModel, groupModel, userModel, gateway)
+	loanController := controllers.NewLoanController(loanModel, disburseModel, scheduleModel, paymentModel, loanProductModel, userModel, officerModel, agentModel, groupModel, memberModel, disbursementJobModel, approvalModel, gateway, cloudConfig, cld, creditBureau)
 
 	UserRoutes(r, userController, db)
 	RoleRoutes(r, roleController, db)
@@ -79,7 +120,22 @@ func InitializeRoutes(r *gin.Engine, db *gorm.DB) {
 	GroupRoutes(r, groupController, db)
 	OfficerRoutes(r, officerController, db)
 	MemberRoutes(r, memberController, db)
+	LoanProductRoutes(r, loanProductController, db)
 	LoanRoutes(r, loanController, db)
+	MpesaRoutes(r, mpesaController, mpesaConfig, db)
+	LedgerRoutes(r, ledgerController, db)
+	ApprovalTierRoutes(r, approvalTierController, db)
+	RestructureRoutes(r, restructureController, db)
+	RefinanceRoutes(r, refinanceController, db)
+	SettlementRoutes(r, settlementController, db)
+	WriteOffRoutes(r, writeOffController, db)
+	GuaranteeRoutes(r, guaranteeController, db)
+	CollateralRoutes(r, collateralController, db)
+	EligibilityRoutes(r, eligibilityController, db)
+	CreditReportRoutes(r, creditReportController, db)
+	PortfolioRoutes(r, portfolioController, db)
+	CollectionRoutes(r, collectionController, db)
+	SavingsRoutes(r, savingsController, db)
 
 	MediaRoutes(r, db)
 }
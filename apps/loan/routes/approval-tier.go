@@ -0,0 +1,26 @@
+package routes
+
+import (
+	"github.com/kifangamukundi/gm/libs/rates"
+	"github.com/kifangamukundi/gm/loan/controllers"
+	"github.com/kifangamukundi/gm/loan/middlewares"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func ApprovalTierRoutes(r *gin.Engine, approvalTierController *controllers.ApprovalTierController, db *gorm.DB) {
+	createApprovalTierLimiter := rates.CreateRateLimiter("100-H")
+	updateApprovalTierLimiter := rates.CreateRateLimiter("100-H")
+	deleteApprovalTierLimiter := rates.CreateRateLimiter("100-H")
+
+	api := r.Group("/api")
+
+	v1 := api.Group("/v1/approval-tiers")
+	{
+		v1.POST("/create", createApprovalTierLimiter, middlewares.AdvancedAuth(db, []string{"create_approval_tier"}), approvalTierController.CreateApprovalTierController)
+		v1.GET("/all", middlewares.AdvancedAuth(db, []string{"view_approval_tiers"}), approvalTierController.GetApprovalTiersController)
+		v1.PATCH("/by/:id", updateApprovalTierLimiter, middlewares.AdvancedAuth(db, []string{"edit_approval_tier"}), approvalTierController.UpdateApprovalTierController)
+		v1.DELETE("/by/:id", deleteApprovalTierLimiter, middlewares.AdvancedAuth(db, []string{"delete_approval_tier"}), approvalTierController.DeleteApprovalTierController)
+	}
+}
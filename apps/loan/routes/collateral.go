@@ -0,0 +1,27 @@
+package routes
+
+import (
+	"github.com/kifangamukundi/gm/libs/rates"
+	"github.com/kifangamukundi/gm/loan/controllers"
+	"github.com/kifangamukundi/gm/loan/middlewares"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func CollateralRoutes(r *gin.Engine, collateralController *controllers.CollateralController, db *gorm.DB) {
+	addCollateralLimiter := rates.CreateRateLimiter("100-H")
+	editCollateralLimiter := rates.CreateRateLimiter("100-H")
+	deleteCollateralLimiter := rates.CreateRateLimiter("100-H")
+
+	api := r.Group("/api")
+
+	v1 := api.Group("/v1/collateral")
+	{
+		v1.POST("/loan/:id", addCollateralLimiter, middlewares.AdvancedAuth(db, []string{"add_collateral"}), collateralController.AddCollateralController)
+		v1.GET("/loan/:id", middlewares.AdvancedAuth(db, []string{"view_loans"}), collateralController.GetLoanCollateralController)
+		v1.GET("/by/:id", middlewares.AdvancedAuth(db, []string{"view_loans"}), collateralController.GetCollateralController)
+		v1.PATCH("/by/:id", editCollateralLimiter, middlewares.AdvancedAuth(db, []string{"edit_collateral"}), collateralController.UpdateCollateralController)
+		v1.DELETE("/by/:id", deleteCollateralLimiter, middlewares.AdvancedAuth(db, []string{"delete_collateral"}), collateralController.DeleteCollateralController)
+	}
+}
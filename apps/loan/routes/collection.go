@@ -0,0 +1,23 @@
+package routes
+
+import (
+	"github.com/kifangamukundi/gm/libs/rates"
+	"github.com/kifangamukundi/gm/loan/controllers"
+	"github.com/kifangamukundi/gm/loan/middlewares"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func CollectionRoutes(r *gin.Engine, collectionController *controllers.CollectionController, db *gorm.DB) {
+	recordCollectionActionLimiter := rates.CreateRateLimiter("100-H")
+
+	api := r.Group("/api")
+
+	v1 := api.Group("/v1/collections")
+	{
+		v1.GET("/worklist", middlewares.AdvancedAuth(db, []string{"view_worklist"}), collectionController.GetWorklistController)
+		v1.POST("/loan/:id/actions", recordCollectionActionLimiter, middlewares.AdvancedAuth(db, []string{"record_collection_action"}), collectionController.RecordCollectionActionController)
+		v1.GET("/loan/:id/actions", middlewares.AdvancedAuth(db, []string{"view_loans"}), collectionController.GetLoanCollectionActionsController)
+	}
+}
@@ -0,0 +1,23 @@
+package routes
+
+import (
+	"github.com/kifangamukundi/gm/libs/rates"
+	"github.com/kifangamukundi/gm/loan/controllers"
+	"github.com/kifangamukundi/gm/loan/middlewares"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func CreditReportRoutes(r *gin.Engine, creditReportController *controllers.CreditReportController, db *gorm.DB) {
+	lookupLimiter := rates.CreateRateLimiter("100-H")
+
+	api := r.Group("/api")
+
+	v1 := api.Group("/v1/credit-reports")
+	{
+		v1.GET("/submission", middlewares.AdvancedAuth(db, []string{"export_bureau_submission"}), creditReportController.ExportBureauSubmissionController)
+		v1.POST("/loan/:id", lookupLimiter, middlewares.AdvancedAuth(db, []string{"bureau_lookup"}), creditReportController.LookupCreditReportController)
+		v1.GET("/loan/:id", middlewares.AdvancedAuth(db, []string{"view_loans"}), creditReportController.GetLoanCreditReportsController)
+	}
+}
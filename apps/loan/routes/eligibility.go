@@ -0,0 +1,18 @@
+package routes
+
+import (
+	"github.com/kifangamukundi/gm/loan/controllers"
+	"github.com/kifangamukundi/gm/loan/middlewares"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func EligibilityRoutes(r *gin.Engine, eligibilityController *controllers.EligibilityController, db *gorm.DB) {
+	api := r.Group("/api")
+
+	v1 := api.Group("/v1/eligibility")
+	{
+		v1.GET("/member/:id", middlewares.AdvancedAuth(db, []string{"check_eligibility"}), eligibilityController.GetMemberEligibilityController)
+	}
+}
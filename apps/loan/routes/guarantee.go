@@ -0,0 +1,28 @@
+package routes
+
+import (
+	"github.com/kifangamukundi/gm/libs/rates"
+	"github.com/kifangamukundi/gm/loan/controllers"
+	"github.com/kifangamukundi/gm/loan/middlewares"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func GuaranteeRoutes(r *gin.Engine, guaranteeController *controllers.GuaranteeController, db *gorm.DB) {
+	addGuaranteeLimiter := rates.CreateRateLimiter("100-H")
+	respondGuaranteeLimiter := rates.CreateRateLimiter("100-H")
+	recoverGuaranteeLimiter := rates.CreateRateLimiter("100-H")
+
+	api := r.Group("/api")
+
+	v1 := api.Group("/v1/guarantees")
+	{
+		v1.POST("/loan/:id", addGuaranteeLimiter, middlewares.AdvancedAuth(db, []string{"add_guarantor"}), guaranteeController.AddGuaranteeController)
+		v1.GET("/loan/:id", middlewares.AdvancedAuth(db, []string{"view_loans"}), guaranteeController.GetLoanGuaranteesController)
+		v1.DELETE("/by/:id", addGuaranteeLimiter, middlewares.AdvancedAuth(db, []string{"add_guarantor"}), guaranteeController.RemoveGuaranteeController)
+		v1.PATCH("/by/:id/accept", respondGuaranteeLimiter, middlewares.AdvancedAuth(db, []string{"respond_guarantee"}), guaranteeController.AcceptGuaranteeController)
+		v1.PATCH("/by/:id/decline", respondGuaranteeLimiter, middlewares.AdvancedAuth(db, []string{"respond_guarantee"}), guaranteeController.DeclineGuaranteeController)
+		v1.POST("/by/:id/recover", recoverGuaranteeLimiter, middlewares.AdvancedAuth(db, []string{"recover_guarantee"}), guaranteeController.RecoverGuaranteeController)
+	}
+}
@@ -0,0 +1,20 @@
+package routes
+
+import (
+	"github.com/kifangamukundi/gm/loan/controllers"
+	"github.com/kifangamukundi/gm/loan/middlewares"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func LedgerRoutes(r *gin.Engine, ledgerController *controllers.LedgerController, db *gorm.DB) {
+	api := r.Group("/api")
+
+	v1 := api.Group("/v1/ledger")
+	{
+		v1.GET("/accounts", middlewares.AdvancedAuth(db, []string{"view_ledger"}), ledgerController.GetAccountsController)
+		v1.GET("/accounts/:id/statement", middlewares.AdvancedAuth(db, []string{"view_ledger"}), ledgerController.GetAccountStatementController)
+		v1.GET("/trial-balance", middlewares.AdvancedAuth(db, []string{"view_ledger"}), ledgerController.GetTrialBalanceController)
+	}
+}
@@ -0,0 +1,43 @@
+package routes
+
+import (
+	"github.com/kifangamukundi/gm/libs/queryparams"
+	"github.com/kifangamukundi/gm/libs/rates"
+	"github.com/kifangamukundi/gm/loan/controllers"
+	"github.com/kifangamukundi/gm/loan/middlewares"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func LoanProductRoutes(r *gin.Engine, loanProductController *controllers.LoanProductController, db *gorm.DB) {
+	createLoanProductLimiter := rates.CreateRateLimiter("100-H")
+	updateLoanProductLimiter := rates.CreateRateLimiter("100-H")
+	deleteLoanProductLimiter := rates.CreateRateLimiter("100-H")
+
+	validSortOrders := []string{"asc", "desc"}
+	validSortCriteria := []string{"product_name"}
+	defaultSortCriteria := "product_name"
+	defaultPage := 1
+	defaultLimit := 9
+
+	api := r.Group("/api")
+
+	v1 := api.Group("/v1/loan-products")
+	{
+		v1.POST("/create", createLoanProductLimiter, middlewares.AdvancedAuth(db, []string{"create_loan_product"}), loanProductController.CreateLoanProductController)
+		v1.GET("/paginate",
+			middlewares.AdvancedAuth(db, []string{"view_loan_products"}),
+			queryparams.SortOrderMiddleware(validSortOrders),
+			queryparams.SortColumnMiddleware(validSortCriteria, defaultSortCriteria),
+			queryparams.PaginationMiddleware(defaultPage, defaultLimit),
+			queryparams.SearchMiddleware(),
+			queryparams.FilterMiddleware(),
+			loanProductController.GetLoanProductsController,
+		)
+		v1.GET("/by/:id", middlewares.AdvancedAuth(db, []string{"view_loan_products"}), loanProductController.GetLoanProductByIdController)
+		v1.PATCH("/by/:id", updateLoanProductLimiter, middlewares.AdvancedAuth(db, []string{"edit_loan_product"}), loanProductController.UpdateLoanProductController)
+		v1.DELETE("/by/:id", deleteLoanProductLimiter, middlewares.AdvancedAuth(db, []string{"delete_loan_product"}), loanProductController.DeleteLoanProductController)
+		v1.GET("/all", middlewares.AdvancedAuth(db, []string{"create_loan"}), loanProductController.GetAllLoanProductsController)
+	}
+}
@@ -14,6 +14,10 @@ func LoanRoutes(r *gin.Engine, loanController *controllers.LoanController, db *g
 	createLoanLimiter := rates.CreateRateLimiter("100-H")
 	approveLoanLimiter := rates.CreateRateLimiter("100-H")
 	rejectLoanLimiter := rates.CreateRateLimiter("100-H")
+	cancelLoanLimiter := rates.CreateRateLimiter("100-H")
+	defaultLoanLimiter := rates.CreateRateLimiter("100-H")
+	repayLoanLimiter := rates.CreateRateLimiter("100-H")
+	retryDisbursementLimiter := rates.CreateRateLimiter("100-H")
 
 	validSortOrders := []string{"asc", "desc"}
 	validSortCriteria := []string{"status"}
@@ -45,8 +49,15 @@ func LoanRoutes(r *gin.Engine, loanController *controllers.LoanController, db *g
 			loanController.GetLoansController,
 		)
 		v1.GET("/by/:id", middlewares.AdvancedAuth(db, []string{"view_loans"}), loanController.GetLoanByIdController)
+		v1.GET("/by/:id/schedule", middlewares.AdvancedAuth(db, []string{"view_loans"}), loanController.GetLoanScheduleController)
+		v1.GET("/by/:id/approvals", middlewares.AdvancedAuth(db, []string{"view_loans"}), loanController.GetLoanApprovalsController)
+		v1.GET("/by/:id/disbursements", middlewares.AdvancedAuth(db, []string{"view_loans"}), loanController.GetLoanDisbursementsController)
 		v1.PATCH("/by/approve/:id", approveLoanLimiter, middlewares.AdvancedAuth(db, []string{"edit_loan"}), loanController.ApproveLoanController)
 		v1.PATCH("/by/reject/:id", rejectLoanLimiter, middlewares.AdvancedAuth(db, []string{"edit_loan"}), loanController.RejectLoanController)
+		v1.PATCH("/by/cancel/:id", cancelLoanLimiter, middlewares.AdvancedAuth(db, []string{"cancel_loan"}), loanController.CancelLoanController)
+		v1.PATCH("/by/default/:id", defaultLoanLimiter, middlewares.AdvancedAuth(db, []string{"edit_loan"}), loanController.DefaultLoanController)
+		v1.POST("/by/:id/disbursements/retry", retryDisbursementLimiter, middlewares.AdvancedAuth(db, []string{"edit_loan"}), loanController.RetryDisbursementController)
+		v1.POST("/by/:id/repay", repayLoanLimiter, middlewares.AdvancedAuth(db, []string{"create_payment"}), loanController.RepayLoanController)
 	}
 
 	v2 := api.Group("/v2/loans")
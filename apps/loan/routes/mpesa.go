@@ -0,0 +1,29 @@
+package routes
+
+import (
+	"github.com/kifangamukundi/gm/libs/rates"
+	"github.com/kifangamukundi/gm/loan/config"
+	"github.com/kifangamukundi/gm/loan/controllers"
+	"github.com/kifangamukundi/gm/loan/middlewares"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Callback routes are called by Safaricom and therefore carry no user authentication, they are
+// authenticated by the token in the callback URL instead
+func MpesaRoutes(r *gin.Engine, mpesaController *controllers.MpesaController, mpesaConfig *config.MpesaConfig, db *gorm.DB) {
+	callbackLimiter := rates.CreateRateLimiter("1000-H")
+	callbackAuth := middlewares.MpesaCallbackAuth(mpesaConfig)
+
+	api := r.Group("/api")
+
+	v1 := api.Group("/v1/mpesa")
+	{
+		v1.POST("/b2c-result", callbackLimiter, callbackAuth, mpesaController.DisburseCallbackController)
+		v1.POST("/b2c-timeout", callbackLimiter, callbackAuth, mpesaController.DisburseTimeoutController)
+		v1.POST("/stk-callback", callbackLimiter, callbackAuth, mpesaController.PaymentCallbackController)
+		v1.POST("/status-result", callbackLimiter, callbackAuth, mpesaController.ResultCallbackController)
+		v1.POST("/reversal-result", callbackLimiter, callbackAuth, mpesaController.ResultCallbackController)
+	}
+}
@@ -0,0 +1,19 @@
+package routes
+
+import (
+	"github.com/kifangamukundi/gm/loan/controllers"
+	"github.com/kifangamukundi/gm/loan/middlewares"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func PortfolioRoutes(r *gin.Engine, portfolioController *controllers.PortfolioController, db *gorm.DB) {
+	api := r.Group("/api")
+
+	v1 := api.Group("/v1/portfolio-reports")
+	{
+		v1.GET("/par", middlewares.AdvancedAuth(db, []string{"view_portfolio_reports"}), portfolioController.GetParReportController)
+		v1.GET("/aging", middlewares.AdvancedAuth(db, []string{"view_portfolio_reports"}), portfolioController.GetAgingReportController)
+	}
+}
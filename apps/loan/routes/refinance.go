@@ -0,0 +1,22 @@
+package routes
+
+import (
+	"github.com/kifangamukundi/gm/libs/rates"
+	"github.com/kifangamukundi/gm/loan/controllers"
+	"github.com/kifangamukundi/gm/loan/middlewares"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func RefinanceRoutes(r *gin.Engine, refinanceController *controllers.RefinanceController, db *gorm.DB) {
+	topUpLoanLimiter := rates.CreateRateLimiter("100-H")
+
+	api := r.Group("/api")
+
+	v1 := api.Group("/v1/loans")
+	{
+		v1.POST("/by/:id/top-up", topUpLoanLimiter, middlewares.AdvancedAuth(db, []string{"create_loan"}), refinanceController.CreateTopUpController)
+		v1.GET("/by/:id/refinance-chain", middlewares.AdvancedAuth(db, []string{"view_loans"}), refinanceController.GetRefinanceChainController)
+	}
+}
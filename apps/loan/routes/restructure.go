@@ -0,0 +1,26 @@
+package routes
+
+import (
+	"github.com/kifangamukundi/gm/libs/rates"
+	"github.com/kifangamukundi/gm/loan/controllers"
+	"github.com/kifangamukundi/gm/loan/middlewares"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func RestructureRoutes(r *gin.Engine, restructureController *controllers.RestructureController, db *gorm.DB) {
+	requestRestructureLimiter := rates.CreateRateLimiter("100-H")
+	approveRestructureLimiter := rates.CreateRateLimiter("100-H")
+	rejectRestructureLimiter := rates.CreateRateLimiter("100-H")
+
+	api := r.Group("/api")
+
+	v1 := api.Group("/v1/restructures")
+	{
+		v1.POST("/loan/:id", requestRestructureLimiter, middlewares.AdvancedAuth(db, []string{"restructure_loan"}), restructureController.RequestRestructureController)
+		v1.GET("/loan/:id", middlewares.AdvancedAuth(db, []string{"view_loans"}), restructureController.GetLoanRestructuresController)
+		v1.PATCH("/by/:id/approve", approveRestructureLimiter, middlewares.AdvancedAuth(db, []string{"approve_restructure"}), restructureController.ApproveRestructureController)
+		v1.PATCH("/by/:id/reject", rejectRestructureLimiter, middlewares.AdvancedAuth(db, []string{"approve_restructure"}), restructureController.RejectRestructureController)
+	}
+}
@@ -0,0 +1,27 @@
+package routes
+
+import (
+	"github.com/kifangamukundi/gm/libs/rates"
+	"github.com/kifangamukundi/gm/loan/controllers"
+	"github.com/kifangamukundi/gm/loan/middlewares"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func SavingsRoutes(r *gin.Engine, savingsController *controllers.SavingsController, db *gorm.DB) {
+	depositSavingsLimiter := rates.CreateRateLimiter("100-H")
+	withdrawSavingsLimiter := rates.CreateRateLimiter("100-H")
+	setContributionScheduleLimiter := rates.CreateRateLimiter("100-H")
+
+	api := r.Group("/api")
+
+	v1 := api.Group("/v1/savings")
+	{
+		v1.GET("/member/:id", middlewares.AdvancedAuth(db, []string{"view_savings"}), savingsController.GetMemberSavingsController)
+		v1.POST("/member/:id/deposits", depositSavingsLimiter, middlewares.AdvancedAuth(db, []string{"record_savings_deposit"}), savingsController.DepositSavingsController)
+		v1.POST("/member/:id/withdrawals", withdrawSavingsLimiter, middlewares.AdvancedAuth(db, []string{"record_savings_withdrawal"}), savingsController.WithdrawSavingsController)
+		v1.PUT("/groups/:id/schedule", setContributionScheduleLimiter, middlewares.AdvancedAuth(db, []string{"manage_contribution_schedules"}), savingsController.SetContributionScheduleController)
+		v1.GET("/groups/:id/contributions", middlewares.AdvancedAuth(db, []string{"view_savings"}), savingsController.GetGroupContributionsController)
+	}
+}
@@ -0,0 +1,22 @@
+package routes
+
+import (
+	"github.com/kifangamukundi/gm/libs/rates"
+	"github.com/kifangamukundi/gm/loan/controllers"
+	"github.com/kifangamukundi/gm/loan/middlewares"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func SettlementRoutes(r *gin.Engine, settlementController *controllers.SettlementController, db *gorm.DB) {
+	quoteSettlementLimiter := rates.CreateRateLimiter("100-H")
+
+	api := r.Group("/api")
+
+	v1 := api.Group("/v1/loans")
+	{
+		v1.POST("/by/:id/settlement-quote", quoteSettlementLimiter, middlewares.AdvancedAuth(db, []string{"quote_settlement"}), settlementController.CreateSettlementQuoteController)
+		v1.GET("/by/:id/settlement-quotes", middlewares.AdvancedAuth(db, []string{"view_loans"}), settlementController.GetLoanSettlementQuotesController)
+	}
+}
@@ -0,0 +1,29 @@
+package routes
+
+import (
+	"github.com/kifangamukundi/gm/libs/rates"
+	"github.com/kifangamukundi/gm/loan/controllers"
+	"github.com/kifangamukundi/gm/loan/middlewares"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func WriteOffRoutes(r *gin.Engine, writeOffController *controllers.WriteOffController, db *gorm.DB) {
+	requestWriteOffLimiter := rates.CreateRateLimiter("100-H")
+	approveWriteOffLimiter := rates.CreateRateLimiter("100-H")
+	rejectWriteOffLimiter := rates.CreateRateLimiter("100-H")
+	recordRecoveryLimiter := rates.CreateRateLimiter("100-H")
+
+	api := r.Group("/api")
+
+	v1 := api.Group("/v1/write-offs")
+	{
+		v1.GET("/report", middlewares.AdvancedAuth(db, []string{"view_write_off_report"}), writeOffController.GetWriteOffReportController)
+		v1.POST("/loan/:id", requestWriteOffLimiter, middlewares.AdvancedAuth(db, []string{"write_off_loan"}), writeOffController.RequestWriteOffController)
+		v1.GET("/loan/:id", middlewares.AdvancedAuth(db, []string{"view_loans"}), writeOffController.GetLoanWriteOffsController)
+		v1.POST("/loan/:id/recoveries", recordRecoveryLimiter, middlewares.AdvancedAuth(db, []string{"record_recovery"}), writeOffController.RecordRecoveryController)
+		v1.PATCH("/by/:id/approve", approveWriteOffLimiter, middlewares.AdvancedAuth(db, []string{"approve_write_off"}), writeOffController.ApproveWriteOffController)
+		v1.PATCH("/by/:id/reject", rejectWriteOffLimiter, middlewares.AdvancedAuth(db, []string{"approve_write_off"}), writeOffController.RejectWriteOffController)
+	}
+}
@@ -0,0 +1,54 @@
+package seeds
+
+import (
+	"log"
+
+	"github.com/kifangamukundi/gm/loan/models"
+
+	"gorm.io/gorm"
+)
+
+// chartOfAccounts holds the accounts the ledger posts to automatically
+var chartOfAccounts = []models.Account{
+	{Code: models.AccountCash, Name: "M-Pesa Float", Type: models.AccountTypeAsset},
+	{Code: models.AccountCashOnHand, Name: "Cash on Hand", Type: models.AccountTypeAsset},
+	{Code: models.AccountPrincipalReceivable, Name: "Loan Principal Receivable", Type: models.AccountTypeAsset},
+	{Code: models.AccountInterestReceivable, Name: "Interest Receivable", Type: models.AccountTypeAsset},
+	{Code: models.AccountFeesReceivable, Name: "Fees Receivable", Type: models.AccountTypeAsset},
+	{Code: models.AccountPenaltyReceivable, Name: "Penalties Receivable", Type: models.AccountTypeAsset},
+	{Code: models.AccountCustomerDeposits, Name: "Customer Overpayments", Type: models.AccountTypeLiability},
+	{Code: models.AccountMemberSavings, Name: "Member Savings", Type: models.AccountTypeLiability},
+	{Code: models.AccountInterestIncome, Name: "Interest Income", Type: models.AccountTypeIncome},
+	{Code: models.AccountFeeIncome, Name: "Fee Income", Type: models.AccountTypeIncome},
+	{Code: models.AccountPenaltyIncome, Name: "Penalty Income", Type: models.AccountTypeIncome},
+	{Code: models.AccountRecoveries, Name: "Bad Debt Recoveries", Type: models.AccountTypeIncome},
+	{Code: models.AccountLoanLosses, Name: "Loan Losses", Type: models.AccountTypeExpense},
+	{Code: models.AccountWaivers, Name: "Waivers and Rebates", Type: models.AccountTypeExpense},
+}
+
+func SeedAccounts(db *gorm.DB) error {
+	var accountRecords []models.Account
+
+	for _, account := range chartOfAccounts {
+		var existingAccount models.Account
+		if err := db.Where("code = ?", account.Code).First(&existingAccount).Error; err != nil && err != gorm.ErrRecordNotFound {
+			log.Printf("Error checking if account exists: %v", err)
+			return err
+		}
+
+		if existingAccount.ID == 0 {
+			account.IsActive = true
+			accountRecords = append(accountRecords, account)
+		}
+	}
+
+	if len(accountRecords) > 0 {
+		if err := db.CreateInBatches(accountRecords, 100).Error; err != nil {
+			log.Printf("Error seeding accounts: %v", err)
+			return err
+		}
+	}
+
+	log.Println("Accounts seeded successfully.")
+	return nil
+}
@@ -14,6 +14,7 @@ func SeedAll(db *gorm.DB) error {
 		{Name: "Permissions", Func: SeedPermissions},
 		{Name: "Roles", Func: SeedRolesAndAssignPermissions},
 		{Name: "Users", Func: SeedUsers},
+		{Name: "Accounts", Func: SeedAccounts},
 	}
 
 	for _, seed := range seedFunctions {
@@ -8,7 +8,7 @@ import (
 	"gorm.io/gorm"
 )
 
-// assign these to agent field_overview, create_member, view_members, edit_members, delete_member, create_loan, view_loans, edit_loan, delete_loan, office_overview
+// assign these to agent field_overview, create_member, view_members, edit_members, delete_member, create_loan, view_loans, edit_loan, delete_loan, cancel_loan, add_collateral, edit_collateral, delete_collateral, check_eligibility, view_worklist, record_collection_action, view_savings, record_savings_deposit, office_overview
 var permissionNames = []string{
 	"create_permission",
 	"create_role", "data_collection_overview",
@@ -19,7 +19,19 @@ var permissionNames = []string{
 	"create_group", "view_groups", "edit_group", "delete_group",
 	"create_officer", "view_officers", "edit_officer", "delete_officer",
 	"create_member", "view_members", "edit_member", "delete_member",
-	"create_loan", "view_loans", "edit_loan", "delete_loan",
+	"create_loan", "view_loans", "edit_loan", "delete_loan", "cancel_loan",
+	"create_loan_product", "view_loan_products", "edit_loan_product", "delete_loan_product",
+	"create_payment",
+	"view_ledger",
+	"create_approval_tier", "view_approval_tiers", "edit_approval_tier", "delete_approval_tier",
+	"restructure_loan", "approve_restructure", "quote_settlement",
+	"write_off_loan", "approve_write_off", "record_recovery", "view_write_off_report",
+	"add_guarantor", "respond_guarantee", "recover_guarantee",
+	"add_collateral", "edit_collateral", "delete_collateral",
+	"check_eligibility", "bureau_lookup", "export_bureau_submission",
+	"view_portfolio_reports",
+	"view_worklist", "record_collection_action",
+	"view_savings", "record_savings_deposit", "record_savings_withdrawal", "manage_contribution_schedules",
 	"office_overview",
 }
 
@@ -25,6 +25,7 @@ import (
 var roleNames = []string{
 	"Admin",
 	"Officer",
+	"Branch Manager",
 	"Agent",
 	"Member",
 }
@@ -2,6 +2,7 @@ package services
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/kifangamukundi/gm/loan/loanrepository"
 )
@@ -27,6 +28,16 @@ type Service interface {
 	GetAllEntititiesByFieldWithPreload(model interface{}, field, value string, preload ...string) (interface{}, error)
 	GetEntitiesByFields(model interface{}, fieldValues map[string]interface{}) (interface{}, error)
 	EntityClearAssociation(model interface{}, association string) error
+	GetOverdueInstalments(model interface{}, asOf time.Time, loanStatuses []string, loanConditions map[string]interface{}, preload ...string) (interface{}, error)
+	GetLatestEntitiesByField(model interface{}, field string, values interface{}, preload ...string) (interface{}, error)
+	GetOrderedEntitiesByField(model interface{}, field string, value interface{}, order string, preload ...string) (interface{}, error)
+	SumPostingsByAccount(result interface{}, from, to *time.Time) error
+	GetAccountPostings(model interface{}, accountId uint, from, to *time.Time, preload ...string) (interface{}, error)
+	GetDueDisbursementJobs(model interface{}, status string, asOf time.Time) (interface{}, error)
+	SumByAgent(result interface{}, table, amountColumn, dateColumn string, conditions map[string]interface{}, from, to *time.Time) error
+	UpdateEntityIf(entity interface{}, conditions map[string]interface{}) (bool, error)
+	UpdateEntityColumns(entity interface{}, conditions map[string]interface{}, columns ...string) (bool, error)
+	WithTransaction(fn func(tx Service) error) error
 }
 
 type EntityServiceImpl struct {
@@ -69,6 +80,45 @@ func (s *EntityServiceImpl) GetAllEntititiesByFieldWithPreload(model interface{}
 	return result, nil
 }
 
+func (s *EntityServiceImpl) GetOverdueInstalments(model interface{}, asOf time.Time, loanStatuses []string, loanConditions map[string]interface{}, preload ...string) (interface{}, error) {
+	result, err := s.Repository.GetOverdueInstalments(model, asOf, loanStatuses, loanConditions, preload...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch overdue instalments: %v", err)
+	}
+	return result, nil
+}
+
+func (s *EntityServiceImpl) GetLatestEntitiesByField(model interface{}, field string, values interface{}, preload ...string) (interface{}, error) {
+	result, err := s.Repository.GetLatestByField(model, field, values, preload...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch latest entities by %s: %v", field, err)
+	}
+	return result, nil
+}
+
+func (s *EntityServiceImpl) GetOrderedEntitiesByField(model interface{}, field string, value interface{}, order string, preload ...string) (interface{}, error) {
+	result, err := s.Repository.GetAllByFieldOrdered(model, field, value, order, preload...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch entities by %s: %v", field, err)
+	}
+	return result, nil
+}
+
+func (s *EntityServiceImpl) SumPostingsByAccount(result interface{}, from, to *time.Time) error {
+	if err := s.Repository.SumPostingsByAccount(result, from, to); err != nil {
+		return fmt.Errorf("failed to sum postings: %v", err)
+	}
+	return nil
+}
+
+func (s *EntityServiceImpl) GetAccountPostings(model interface{}, accountId uint, from, to *time.Time, preload ...string) (interface{}, error) {
+	result, err := s.Repository.GetAccountPostings(model, accountId, from, to, preload...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch account postings: %v", err)
+	}
+	return result, nil
+}
+
 func (s *EntityServiceImpl) EntityClearAssociation(model interface{}, association string) error {
 	if err := s.Repository.ClearAssociations(model, association); err != nil {
 		return fmt.Errorf("error clearing association: %v", err)
@@ -198,6 +248,50 @@ func (s *EntityServiceImpl) UpdateEntity(entity interface{}) error {
 	return nil
 }
 
+func (s *EntityServiceImpl) GetDueDisbursementJobs(model interface{}, status string, asOf time.Time) (interface{}, error) {
+	result, err := s.Repository.GetDueDisbursementJobs(model, status, asOf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch due disbursement jobs: %v", err)
+	}
+	return result, nil
+}
+
+func (s *EntityServiceImpl) SumByAgent(result interface{}, table, amountColumn, dateColumn string, conditions map[string]interface{}, from, to *time.Time) error {
+	if err := s.Repository.SumByAgent(result, table, amountColumn, dateColumn, conditions, from, to); err != nil {
+		return fmt.Errorf("failed to sum %s by agent: %v", table, err)
+	}
+	return nil
+}
+
+// UpdateEntityIf saves entity only if its stored row still matches conditions, such as the
+// status it was read with. It reports false when the row had already changed.
+func (s *EntityServiceImpl) UpdateEntityIf(entity interface{}, conditions map[string]interface{}) (bool, error) {
+	updated, err := s.Repository.UpdateWhere(entity, conditions)
+	if err != nil {
+		return false, fmt.Errorf("failed to update entity: %v", err)
+	}
+	return updated > 0, nil
+}
+
+// UpdateEntityColumns saves only the given columns of entity, leaving the rest of its row to
+// other requests. With conditions it only saves while the row still matches them. It reports
+// false when no row was changed.
+func (s *EntityServiceImpl) UpdateEntityColumns(entity interface{}, conditions map[string]interface{}, columns ...string) (bool, error) {
+	updated, err := s.Repository.UpdateColumnsWhere(entity, conditions, columns)
+	if err != nil {
+		return false, fmt.Errorf("failed to update entity: %v", err)
+	}
+	return updated > 0, nil
+}
+
+// WithTransaction runs fn as one unit of work. Everything written through the Service
+// passed to fn is committed together when fn returns nil and rolled back when it fails.
+func (s *EntityServiceImpl) WithTransaction(fn func(tx Service) error) error {
+	return s.Repository.Transaction(func(repo loanrepository.LoanRepositoryInterface) error {
+		return fn(NewEntityService(repo))
+	})
+}
+
 func (s *EntityServiceImpl) GetEntityByField(field, value string, model interface{}) (interface{}, error) {
 	result, err := s.Repository.GetByField(model, field, value)
 	if err != nil {
@@ -32,13 +32,16 @@ func ExportToPDF(data interface{}, filename string, selectedFields []string, tit
 		return fmt.Errorf("no valid fields selected for export")
 	}
 
+	// Columns are 60mm wide unless that would run off the page
+	width := min(60, 190/float64(len(filteredFields)))
+
 	pdf.SetFont("Arial", "B", 16)
 	pdf.CellFormat(190, 12, title, "0", 1, "C", false, 0, "")
 	pdf.Ln(5)
 
 	pdf.SetFont("Arial", "B", 12)
 	for _, field := range filteredFields {
-		pdf.CellFormat(60, 10, field, "1", 0, "C", false, 0, "")
+		pdf.CellFormat(width, 10, field, "1", 0, "C", false, 0, "")
 	}
 	pdf.Ln(-1)
 
@@ -48,7 +51,7 @@ func ExportToPDF(data interface{}, filename string, selectedFields []string, tit
 		rowValues := getStructValues(row, filteredFields)
 
 		for _, value := range rowValues {
-			pdf.CellFormat(60, 10, fmt.Sprintf("%v", value), "1", 0, "C", false, 0, "")
+			pdf.CellFormat(width, 10, fmt.Sprintf("%v", value), "1", 0, "C", false, 0, "")
 		}
 		pdf.Ln(-1)
 	}